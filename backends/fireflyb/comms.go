@@ -3,11 +3,11 @@ package fireflyb
 import (
 	"errors"
 	"fmt"
-	"log/slog"
 	"net"
 	"net/netip"
 	"syscall"
 
+	"github.com/scitags/flowd-go/internal/telemetry"
 	glowdTypes "github.com/scitags/flowd-go/types"
 )
 
@@ -24,12 +24,14 @@ func (b *FireflyBackend) sendFirefly(flowID glowdTypes.FlowID, payload []byte) e
 	sendErrors := []error{}
 	if err := b.sendToDestination(flowID.Family, flowID.Dst.Addr(), payload); err != nil {
 		sendErrors = append(sendErrors, err)
-		slog.Error("couldn't send the firefly to the destination", "err", err)
+		b.logger.Error("couldn't send the firefly to the destination", "err", err)
+		telemetry.FireflySendErrors.WithLabelValues(b.name, "destination").Inc()
 	}
 
 	if b.SendToCollector {
 		if err := b.sendToCollector(payload); err != nil {
 			sendErrors = append(sendErrors, err)
+			telemetry.FireflySendErrors.WithLabelValues(b.name, "collector").Inc()
 		}
 	}
 
@@ -38,12 +40,12 @@ func (b *FireflyBackend) sendFirefly(flowID glowdTypes.FlowID, payload []byte) e
 }
 
 func (b *FireflyBackend) sendToCollector(payload []byte) error {
-	slog.Debug("sending firefly to the collector")
+	b.logger.Debug("sending firefly to the collector")
 
 	if _, err := b.collectorConn.Write(payload); err != nil {
 		// Be sure to check udp(7)
 		if errors.Is(err, syscall.ECONNREFUSED) {
-			slog.Warn("got ECONNREFUSED when sending, retrying once...")
+			b.logger.Warn("got ECONNREFUSED when sending, retrying once...")
 			if _, err := b.collectorConn.Write(payload); err != nil {
 				return fmt.Errorf("error sending the firefly to the collector: %w", err)
 			}
@@ -66,11 +68,11 @@ func (b *FireflyBackend) sendToDestination(family glowdTypes.Family, destIP neti
 	}
 	defer func() {
 		if err := conn.Close(); err != nil {
-			slog.Warn("error closing UDP socket", "err", err)
+			b.logger.Warn("error closing UDP socket", "err", err)
 		}
 	}()
 
-	slog.Debug("sending firefly", "dst", destIP, "size", len(payload))
+	b.logger.Debug("sending firefly", "dst", destIP, "size", len(payload))
 	if _, err = conn.Write(payload); err != nil {
 		return fmt.Errorf("couldn't send the firefly to the destination: %w", err)
 	}
@@ -1,13 +1,35 @@
 package fireflyb
 
 import (
+	"errors"
+	"fmt"
+
 	"github.com/goccy/go-yaml"
 	"github.com/scitags/flowd-go/internal/stun"
+	"github.com/scitags/flowd-go/types"
+)
+
+func init() {
+	types.RegisterBackend("firefly", func(name string, c any) (types.Backend, error) {
+		return NewFireflyBackend(name, c.(*Config))
+	}, types.YAMLDecoder[Config]())
+}
+
+const (
+	// A 1500 byte MTU minus the IPv6 (40 bytes) and UDP (8 bytes) headers.
+	defaultMaxPayloadSize int = 1452
+
+	// Fireflies without enrichment are well below this size.
+	minPayloadSize int = 512
+
+	// The largest payload an IPv4 UDP datagram can carry.
+	maxUDPPayloadSize int = 65507
 )
 
 type Config struct {
-	DestinationPort uint16 `yaml:"destinationPort"`
-	PrependSyslog   bool   `yaml:"prependSyslog"`
+	DestinationPort uint16                `yaml:"destinationPort"`
+	PrependSyslog   bool                  `yaml:"prependSyslog"`
+	Encoding        types.FireflyEncoding `yaml:"encoding"`
 
 	SendToCollector  bool   `yaml:"sendToCollector"`
 	CollectorAddress string `yaml:"collectorAddress"`
@@ -15,6 +37,7 @@ type Config struct {
 
 	Enrich         bool   `yaml:"enrich"`
 	EnrichmentMode string `yaml:"enrichmentMode"`
+	MaxPayloadSize int    `yaml:"maxPayloadSize"`
 
 	Stun *stun.Config `yaml:"stun"`
 }
@@ -26,6 +49,7 @@ func (c *Config) UnmarshalYAML(b []byte) error {
 	def := &config{
 		DestinationPort: 10514,
 		PrependSyslog:   true,
+		Encoding:        types.FireflyJSON,
 
 		SendToCollector:  false,
 		CollectorAddress: "127.0.0.1",
@@ -33,6 +57,7 @@ func (c *Config) UnmarshalYAML(b []byte) error {
 
 		Enrich:         false,
 		EnrichmentMode: "lean",
+		MaxPayloadSize: defaultMaxPayloadSize,
 	}
 
 	if err := yaml.Unmarshal(b, def); err != nil {
@@ -43,3 +68,33 @@ func (c *Config) UnmarshalYAML(b []byte) error {
 
 	return nil
 }
+
+// Validate implements types.Validator.
+func (c *Config) Validate() error {
+	errs := []error{}
+
+	if c.SendToCollector {
+		if !validCollectorAddress(c.CollectorAddress) {
+			errs = append(errs, fmt.Errorf("wrong collector address %q: it must be an IP address or a hostname", c.CollectorAddress))
+		}
+
+		if c.CollectorPort <= 0 || c.CollectorPort > 65535 {
+			errs = append(errs, fmt.Errorf("wrong collector port %d", c.CollectorPort))
+		}
+	}
+
+	if !c.Encoding.IsValid() {
+		errs = append(errs, fmt.Errorf("wrong encoding %q: it must be one of json or cbor", c.Encoding))
+	}
+
+	if !types.IsValidMode(c.EnrichmentMode) {
+		errs = append(errs, fmt.Errorf("wrong enrichment mode %q", c.EnrichmentMode))
+	}
+
+	if c.MaxPayloadSize < minPayloadSize || c.MaxPayloadSize > maxUDPPayloadSize {
+		errs = append(errs, fmt.Errorf("wrong maximum payload size %d: it must be between %d and %d bytes",
+			c.MaxPayloadSize, minPayloadSize, maxUDPPayloadSize))
+	}
+
+	return errors.Join(errs...)
+}
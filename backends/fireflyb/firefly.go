@@ -5,6 +5,7 @@ import (
 	"log/slog"
 	"net"
 	"net/netip"
+	"sync"
 
 	"github.com/scitags/flowd-go/internal/stun"
 	"github.com/scitags/flowd-go/types"
@@ -13,17 +14,33 @@ import (
 type FireflyBackend struct {
 	Config
 
+	name   string
+	logger *slog.Logger
+
 	collectorConn net.Conn
 	pubIpMap      map[netip.Addr]netip.Addr
+
+	// Tracks the goroutines sending periodic fireflies so that Run only
+	// returns once they're all done with the collector's socket.
+	periodic sync.WaitGroup
 }
 
 func (b *FireflyBackend) String() string {
-	return "Firefly"
+	return "Firefly (" + b.name + ")"
 }
 
-func NewFireflyBackend(c *Config) (*FireflyBackend, error) {
-	b := FireflyBackend{Config: *c}
-	slog.Debug("initialising the firefly backend")
+// Flavours implements types.EnrichmentConsumer: we'll only ask for enrichment
+// when we're going to send periodic fireflies.
+func (b *FireflyBackend) Flavours() []types.Flavour {
+	if !b.Enrich {
+		return nil
+	}
+	return []types.Flavour{types.Ebpf, types.Netlink}
+}
+
+func NewFireflyBackend(name string, c *Config) (*FireflyBackend, error) {
+	b := FireflyBackend{Config: *c, name: name, logger: slog.Default().With(types.LogKeyBackend, name)}
+	b.logger.Debug("initialising the firefly backend")
 
 	if b.SendToCollector {
 		conn, err := net.Dial("udp", parseCollectorAddress(b.CollectorAddress, b.CollectorPort))
@@ -34,7 +51,7 @@ func NewFireflyBackend(c *Config) (*FireflyBackend, error) {
 	}
 
 	if c.Stun != nil {
-		slog.Debug("mapping private addresses to public ones")
+		b.logger.Debug("mapping private addresses to public ones")
 		pubIpMap, err := stun.GetPublicAddresses(*c.Stun)
 		if err != nil {
 			return nil, fmt.Errorf("couldn't resolve private to public addresses: %w", err)
@@ -46,22 +63,23 @@ func NewFireflyBackend(c *Config) (*FireflyBackend, error) {
 }
 
 func (b *FireflyBackend) Run(done <-chan struct{}, inChan <-chan types.FlowID) {
-	slog.Debug("running the firefly backend")
+	b.logger.Debug("running the firefly backend")
+	defer b.periodic.Wait()
 
 	for {
 		select {
 		case flowID, ok := <-inChan:
 			if !ok {
-				slog.Warn("somebody closed the input channel!")
+				b.logger.Warn("somebody closed the input channel!")
 				return
 			}
-			slog.Debug("got a flowID", "flowID.Src", flowID.Src, "flowID.Dst", flowID.Dst)
+			b.logger.Debug("got a flowID", types.LogKeyFlow, flowID)
 
 			// Rewrite private source IP address
 			if len(b.pubIpMap) > 0 {
 				pubIp, ok := b.pubIpMap[flowID.Src.Addr()]
 				if ok {
-					slog.Debug("rewriting private ip", "privIp", flowID.Src.Addr(), "pubIp", pubIp)
+					b.logger.Debug("rewriting private ip", "privIp", flowID.Src.Addr(), "pubIp", pubIp)
 					flowID.Src = netip.AddrPortFrom(pubIp, flowID.Src.Port())
 				}
 			}
@@ -74,36 +92,42 @@ func (b *FireflyBackend) Run(done <-chan struct{}, inChan <-chan types.FlowID) {
 						if fc == nil {
 							continue
 						}
-						go b.periodicFFs(flowID, t, fc)
+						b.periodic.Add(1)
+						go b.periodicFFs(done, flowID, t, fc)
 					}
 				}
 
+				// The flow was already announced before restarting
+				if flowID.Replayed {
+					b.logger.Debug("not sending the START firefly of a replayed flow", types.LogKeyFlow, flowID)
+					continue
+				}
+
 			case types.END:
 			default:
-				slog.Warn("received flowID with wrong state", "state", flowID.State)
+				b.logger.Warn("received flowID with wrong state", "state", flowID.State)
 			}
 
 			// Send START and END FFs
-			ff := types.NewFirefly(flowID, nil, nil)
-			payload, err := ff.Payload(b.PrependSyslog)
+			payload, err := b.payload(types.NewFirefly(flowID, nil, nil))
 			if err != nil {
-				slog.Error("error building the firefly", "err", err)
+				b.logger.Error("error building the firefly", "err", err)
 				continue
 			}
 
-			slog.Debug("sending the firefly...")
+			b.logger.Debug("sending the firefly...")
 			if err := b.sendFirefly(flowID, payload); err != nil {
-				slog.Error("error sending the firefly", "err", err)
+				b.logger.Error("error sending the firefly", "err", err)
 			}
 		case <-done:
-			slog.Debug("cleanly exiting the firefly backend")
+			b.logger.Debug("cleanly exiting the firefly backend")
 			return
 		}
 	}
 }
 
 func (b *FireflyBackend) Cleanup() error {
-	slog.Debug("cleaning up the firefly backend")
+	b.logger.Debug("cleaning up the firefly backend")
 
 	var err error = nil
 	if b.SendToCollector {
@@ -105,6 +105,55 @@ func runIperf3(wg *sync.WaitGroup, dstIP string, srcPort string, dstPort string,
 	}
 }
 
+func TestReplayedFlow(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error creating the UDP server: %v", err)
+	}
+	defer conn.Close()
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+
+	b, err := NewFireflyBackend("firefly", &Config{DestinationPort: uint16(port), Encoding: types.FireflyJSON})
+	if err != nil {
+		t.Fatalf("error creating backend: %v", err)
+	}
+	defer b.Cleanup()
+
+	done := make(chan struct{})
+	defer close(done)
+	flowIDs := make(chan types.FlowID)
+	go b.Run(done, flowIDs)
+
+	flowID := types.FlowID{
+		State:    types.START,
+		Family:   types.IPv4,
+		Src:      netip.MustParseAddrPort("127.0.0.1:2345"),
+		Dst:      netip.MustParseAddrPort("127.0.0.1:5777"),
+		StartTs:  time.Now(),
+		Replayed: true,
+	}
+	flowIDs <- flowID
+
+	flowID.State, flowID.Replayed, flowID.EndTs = types.END, false, time.Now()
+	flowIDs <- flowID
+
+	// The END firefly must be the first one we get
+	buff := make([]byte, 2048)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buff)
+	if err != nil {
+		t.Fatalf("error reading the firefly: %v", err)
+	}
+
+	sFirefly := types.SlimFirefly{}
+	if err := sFirefly.Parse(buff[:n]); err != nil {
+		t.Fatalf("error parsing the firefly: %v", err)
+	}
+	if sFirefly.FlowID.State != types.END {
+		t.Errorf("got a %s firefly for a replayed flow; want the END one alone", sFirefly.FlowID.State)
+	}
+}
+
 func TestEnv(t *testing.T) {
 	slog.Debug("env vars", "dstIp", *dstIp, "srcPort", *srcPort, "dstPort", *dstPort, "runtimeSec", *runtimeSec, "congAlg", *congAlg)
 }
@@ -145,7 +194,7 @@ func TestPeriodicFirefly(t *testing.T) {
 	defer se.Cleanup()
 	go se.Run(doneChan)
 
-	fireflyBackend, err := NewFireflyBackend(&Config{
+	fireflyBackend, err := NewFireflyBackend("firefly", &Config{
 		PrependSyslog: false,
 
 		SendToCollector:  true,
@@ -1,36 +1,39 @@
 package fireflyb
 
 import (
-	"log/slog"
-
 	"github.com/scitags/flowd-go/types"
 )
 
-func (b *FireflyBackend) periodicFFs(f types.FlowID, flavour types.Flavour, fic chan *types.FlowInfo) {
-	slog.Debug("starting periodic firefly goroutine", "flowID", f, "flavour", flavour)
-	ff := types.Firefly{}
-	f.State = types.ONGOING
+// periodicFFs sends a firefly for every sample of the flow's enrichment until
+// the enrichment ends or the backend's stopped, whatever happens first.
+func (b *FireflyBackend) periodicFFs(done <-chan struct{}, f types.FlowID, flavour types.Flavour, fic chan *types.FlowInfo) {
+	defer b.periodic.Done()
 
-	for fi := range fic {
-		fi.Mode = b.EnrichmentMode
+	b.logger.Debug("starting periodic firefly goroutine", types.LogKeyFlow, f, types.LogKeyFlavour, flavour)
+	f.State = types.ONGOING
 
-		switch flavour {
-		case types.Ebpf:
-			ff = types.NewFirefly(f, nil, fi)
-		case types.Netlink:
-			ff = types.NewFirefly(f, fi, nil)
+	for {
+		var sample *types.FlowInfo
+		select {
+		case s, ok := <-fic:
+			if !ok {
+				b.logger.Debug("exiting periodic firefly goroutine", types.LogKeyFlow, f)
+				return
+			}
+			sample = s
+		case <-done:
+			b.logger.Debug("exiting periodic firefly goroutine", types.LogKeyFlow, f)
+			return
 		}
 
-		payload, err := ff.Payload(b.PrependSyslog)
+		payload, _, err := b.enrichedPayload(f, flavour, sample)
 		if err != nil {
-			slog.Error("error building periodic firefly", "err", err)
+			b.logger.Error("error building periodic firefly", "err", err)
 			continue
 		}
 
 		if err := b.sendFirefly(f, payload); err != nil {
-			slog.Error("error sending periodic firefly", "err", err)
+			b.logger.Error("error sending periodic firefly", "err", err)
 		}
 	}
-
-	slog.Debug("exiting periodic firefly goroutine", "flowID", f)
 }
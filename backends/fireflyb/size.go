@@ -0,0 +1,87 @@
+package fireflyb
+
+import (
+	"slices"
+
+	"github.com/scitags/flowd-go/internal/telemetry"
+	"github.com/scitags/flowd-go/types"
+)
+
+// noEnrichment is the mode reported when a periodic firefly had to be sent
+// without any enrichment at all.
+const noEnrichment string = "none"
+
+// enrichmentFallbacks are the enrichment modes periodic fireflies fall back to,
+// in order, when they don't fit within the maximum payload size. The empty
+// mode stands for the full (i.e. default) output. If none of them fits the
+// enrichment is dropped altogether.
+var enrichmentFallbacks = []string{"", "lean", "compatible"}
+
+// modeLabel returns the name of an enrichment mode as shown in metrics.
+func modeLabel(mode string) string {
+	if mode == "" {
+		return "full"
+	}
+	return mode
+}
+
+// payload builds the payload of a firefly. Fireflies which are too large are
+// still sent (they'll be fragmented along the way) but they're accounted for.
+func (b *FireflyBackend) payload(ff types.Firefly) ([]byte, error) {
+	payload, err := ff.Encode(b.Encoding, b.PrependSyslog)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(payload) > b.MaxPayloadSize {
+		b.logger.Warn("the firefly exceeds the maximum payload size", "size", len(payload), "max", b.MaxPayloadSize)
+		telemetry.FireflyOversized.WithLabelValues(b.name).Inc()
+	}
+
+	return payload, nil
+}
+
+// enrichedPayload builds the payload of a periodic firefly starting with the
+// configured enrichment mode and degrading it as needed so that it fits within
+// the maximum payload size. Every fallback is accounted for by the mode that
+// was eventually used, which is returned too.
+func (b *FireflyBackend) enrichedPayload(f types.FlowID, flavour types.Flavour, sample *types.FlowInfo) ([]byte, string, error) {
+	modes := []string{b.EnrichmentMode}
+	if i := slices.Index(enrichmentFallbacks, b.EnrichmentMode); i >= 0 {
+		modes = enrichmentFallbacks[i:]
+	}
+
+	for _, mode := range modes {
+		// Flow information is shared with other backends: work on a copy
+		fi := *sample
+		fi.Mode = mode
+
+		var ff types.Firefly
+		switch flavour {
+		case types.Ebpf:
+			ff = types.NewFirefly(f, nil, &fi)
+		case types.Netlink:
+			ff = types.NewFirefly(f, &fi, nil)
+		}
+
+		payload, err := ff.Encode(b.Encoding, b.PrependSyslog)
+		if err != nil {
+			return nil, "", err
+		}
+
+		if len(payload) <= b.MaxPayloadSize {
+			if mode != b.EnrichmentMode {
+				b.logger.Debug("degraded the enrichment to fit the firefly", types.LogKeyFlow, f,
+					"from", modeLabel(b.EnrichmentMode), "to", modeLabel(mode))
+				telemetry.FireflyEnrichmentFallbacks.WithLabelValues(b.name, modeLabel(mode)).Inc()
+			}
+			return payload, mode, nil
+		}
+	}
+
+	b.logger.Debug("dropped the enrichment to fit the firefly", types.LogKeyFlow, f, "from", modeLabel(b.EnrichmentMode))
+	telemetry.FireflyEnrichmentFallbacks.WithLabelValues(b.name, noEnrichment).Inc()
+
+	payload, err := b.payload(types.NewFirefly(f, nil, nil))
+	return payload, noEnrichment, err
+}
@@ -0,0 +1,90 @@
+package fireflyb
+
+import (
+	"log/slog"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/scitags/flowd-go/types"
+)
+
+func TestEnrichedPayload(t *testing.T) {
+	f := types.FlowID{
+		State:    types.ONGOING,
+		Protocol: types.TCP,
+		Family:   types.IPv6,
+		Src:      netip.MustParseAddrPort("[2001:db8::1]:2345"),
+		Dst:      netip.MustParseAddrPort("[2001:db8::2]:5777"),
+		StartTs:  time.Now(),
+	}
+
+	sample := &types.FlowInfo{
+		TCPInfo:   &types.TCPInfo{Pmtu: 1500, State: 1},
+		Cong:      &types.Cong{Algorithm: "bbr"},
+		BBRInfo:   &types.TCPBBRInfo{},
+		MemInfo:   &types.MemInfo{},
+		SkMemInfo: &types.SkMemInfo{},
+	}
+
+	b := &FireflyBackend{
+		Config: Config{Encoding: types.FireflyJSON, EnrichmentMode: "", MaxPayloadSize: maxUDPPayloadSize},
+		name:   "firefly",
+		logger: slog.Default(),
+	}
+
+	// Sizes of the payload in each mode, the last one carrying no enrichment
+	sizes := map[string]int{}
+	for _, mode := range enrichmentFallbacks {
+		fi := *sample
+		fi.Mode = mode
+		ff := types.NewFirefly(f, &fi, nil)
+		payload, err := ff.Encode(b.Encoding, b.PrependSyslog)
+		if err != nil {
+			t.Fatalf("error building the payload in mode %q: %v", mode, err)
+		}
+		sizes[mode] = len(payload)
+	}
+	ff := types.NewFirefly(f, nil, nil)
+	payload, err := ff.Encode(b.Encoding, b.PrependSyslog)
+	if err != nil {
+		t.Fatalf("error building the payload without enrichment: %v", err)
+	}
+	sizes[noEnrichment] = len(payload)
+	t.Logf("payload sizes: %v", sizes)
+
+	chain := append(enrichmentFallbacks, noEnrichment)
+	for _, max := range sizes {
+		b.MaxPayloadSize = max
+
+		payload, mode, err := b.enrichedPayload(f, types.Netlink, sample)
+		if err != nil {
+			t.Fatalf("error building the payload with a maximum of %d bytes: %v", max, err)
+		}
+
+		if len(payload) > max {
+			t.Errorf("got a %d byte payload in mode %q, want at most %d", len(payload), mode, max)
+		}
+
+		// Every mode before the chosen one must have been too large
+		for _, m := range chain {
+			if m == mode {
+				break
+			}
+			if sizes[m] <= max {
+				t.Errorf("fell back to mode %q with a maximum of %d bytes, but mode %q (%d bytes) fits", mode, max, m, sizes[m])
+			}
+		}
+	}
+
+	// Enrichment must never be upgraded beyond the configured mode
+	b.EnrichmentMode, b.MaxPayloadSize = "compatible", maxUDPPayloadSize
+	if _, mode, _ := b.enrichedPayload(f, types.Ebpf, sample); mode != "compatible" {
+		t.Errorf("got mode %q, want compatible", mode)
+	}
+
+	// The shared sample must be left alone
+	if sample.Mode != "" {
+		t.Errorf("the sample's mode was altered to %q", sample.Mode)
+	}
+}
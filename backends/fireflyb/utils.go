@@ -3,6 +3,7 @@ package fireflyb
 import (
 	"fmt"
 	"net"
+	"net/netip"
 	"strings"
 )
 
@@ -19,3 +20,28 @@ func parseCollectorAddress(rawAddress string, port int) string {
 
 	return fmt.Sprintf(addressFmt, rawAddress, port)
 }
+
+// Function validCollectorAddress checks whether the specified collector address
+// is either an IP address or a syntactically valid hostname without resolving it.
+func validCollectorAddress(rawAddress string) bool {
+	if _, err := netip.ParseAddr(rawAddress); err == nil {
+		return true
+	}
+
+	if len(rawAddress) == 0 || len(rawAddress) > 253 {
+		return false
+	}
+
+	for _, label := range strings.Split(strings.TrimSuffix(rawAddress, "."), ".") {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+				return false
+			}
+		}
+	}
+
+	return true
+}
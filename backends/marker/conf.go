@@ -1,24 +1,51 @@
 package marker
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/goccy/go-yaml"
+	"github.com/scitags/flowd-go/types"
 )
 
+func init() {
+	types.RegisterBackend("marker", func(name string, c any) (types.Backend, error) {
+		return NewMarkerBackend(name, c.(*Config))
+	}, types.YAMLDecoder[Config]())
+}
+
 //go:generate go tool golang.org/x/tools/cmd/stringer -type=Strategy
 
 type Config struct {
+	// Names or glob patterns (see path.Match) of the interfaces to mark.
 	TargetInterfaces   []string `yaml:"targetInterfaces"`
 	DiscoverInterfaces bool     `yaml:"discoverInterfaces"`
 
 	RemoveQdisc bool `yaml:"removeQdisc"`
 
+	// Whether to pin the program, its maps and its tcx links under PinPath so
+	// that marking carries on across restarts.
+	Pin     bool   `yaml:"pin"`
+	PinPath string `yaml:"pinPath"`
+
+	// How to attach the program: one of auto, tcx or tc.
+	AttachMode string `yaml:"attachMode"`
+
 	ProgramPath        string   `yaml:"programPath"`
 	RawMarkingStrategy string   `yaml:"markingStrategy"`
 	MarkingStrategy    Strategy `yaml:"-"` // Parsed strategy
 
+	// The DSCP values to mark flows with when leveraging the DSCP strategy.
+	DSCPMapping []DSCPRule `yaml:"dscpMapping"`
+
+	// Rules marking the flows no plugin signalled by their destination.
+	Rules []MarkRule `yaml:"rules"`
+
 	DebugMode bool `yaml:"debugMode"`
 	MatchAll  bool `yaml:"matchAll"`
 }
@@ -32,6 +59,9 @@ func (c *Config) UnmarshalYAML(b []byte) error {
 		DiscoverInterfaces: false,
 
 		RemoveQdisc: true,
+		Pin:         false,
+		PinPath:     DefaultPinPath,
+		AttachMode:  AttachAuto,
 		ProgramPath: "",
 
 		RawMarkingStrategy: "label",
@@ -54,6 +84,120 @@ func (c *Config) UnmarshalYAML(b []byte) error {
 	return nil
 }
 
+// Validate implements types.Validator. Interfaces are only looked up:
+// nothing is attached to them until the backend is created.
+func (c *Config) Validate() error {
+	errs := []error{}
+
+	if !slices.Contains([]string{AttachAuto, AttachTCX, AttachTC}, c.AttachMode) {
+		errs = append(errs, fmt.Errorf("wrong attach mode %q: must be one of %s, %s or %s", c.AttachMode, AttachAuto, AttachTCX, AttachTC))
+	}
+
+	if c.Pin && !filepath.IsAbs(c.PinPath) {
+		errs = append(errs, fmt.Errorf("the pin path %q must be absolute", c.PinPath))
+	}
+
+	if c.MarkingStrategy == DSCP && len(c.DSCPMapping) == 0 {
+		errs = append(errs, fmt.Errorf("the dscp marking strategy needs a dscpMapping"))
+	}
+
+	r := types.CurrentRegistry()
+	for i, rule := range c.DSCPMapping {
+		if rule.DSCP > maxDSCP {
+			errs = append(errs, fmt.Errorf("dscpMapping[%d]: DSCP value %d is larger than %d", i, rule.DSCP, maxDSCP))
+		}
+
+		exp, err := rule.Experiment.Resolve(r)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("dscpMapping[%d]: %w", i, err))
+			continue
+		}
+
+		if rule.Activity != nil {
+			if _, err := rule.Activity.Resolve(r, exp); err != nil {
+				errs = append(errs, fmt.Errorf("dscpMapping[%d]: %w", i, err))
+			}
+		}
+	}
+
+	for i, rule := range c.Rules {
+		exp, act, err := rule.resolve(r)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("rules[%d]: %w", i, err))
+			continue
+		}
+
+		if c.MarkingStrategy == DSCP {
+			if _, ok := c.dscp(exp, act); !ok {
+				errs = append(errs, fmt.Errorf("rules[%d]: no DSCP value configured for the rule's experiment and activity", i))
+			}
+		}
+	}
+
+	if _, err := c.ruleSets(); err != nil {
+		errs = append(errs, fmt.Errorf("wrong rules: %w", err))
+	}
+
+	if !c.DiscoverInterfaces {
+		if len(c.TargetInterfaces) == 0 {
+			errs = append(errs, fmt.Errorf("no target interfaces and interface discovery is disabled"))
+		}
+
+		// Interfaces needn't exist yet: they're attached to as they're created
+		for _, iface := range c.TargetInterfaces {
+			if !isPattern(iface) {
+				continue
+			}
+			if _, err := path.Match(iface, ""); err != nil {
+				errs = append(errs, fmt.Errorf("wrong target interface pattern %q: %w", iface, err))
+			}
+		}
+	}
+
+	if c.ProgramPath != "" {
+		if _, err := os.Stat(c.ProgramPath); err != nil {
+			errs = append(errs, fmt.Errorf("can't access the eBPF program: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// isPattern checks whether a target interface is a glob pattern (see path.Match)
+// rather than the name of an interface.
+func isPattern(iface string) bool {
+	return strings.ContainsAny(iface, `*?[\`)
+}
+
+// matchInterface checks whether the program should be attached to the given
+// interface. When discovering interfaces only those with a public IPv6 address
+// are targeted, otherwise the interface must match one of the target interfaces.
+// Addresses are only looked up if needed.
+func (c *Config) matchInterface(name string, hasPublicIPv6 func() bool) bool {
+	if c.DiscoverInterfaces {
+		return hasPublicIPv6()
+	}
+
+	for _, pattern := range c.TargetInterfaces {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultPinPath is where objects are pinned by default. Each instance pins
+// its objects on a directory of its own named after it.
+const DefaultPinPath = "/sys/fs/bpf/flowd-go"
+
+// Available attach modes. The auto mode leverages tcx if the kernel supports
+// it, falling back to a clsact qdisc otherwise.
+const (
+	AttachAuto = "auto"
+	AttachTCX  = "tcx"
+	AttachTC   = "tc"
+)
+
 type Strategy int
 
 const (
@@ -61,6 +205,7 @@ const (
 	HopByHop
 	Destination
 	HopByHopDestination
+	DSCP
 )
 
 func ParseStrategy(s string) (Strategy, bool) {
@@ -71,8 +216,36 @@ func ParseStrategy(s string) (Strategy, bool) {
 // strategy map associates available strategies to their string representation.
 var strategyMap = func() map[string]Strategy {
 	m := make(map[string]Strategy)
-	for i := Label; i <= HopByHopDestination; i++ {
+	for i := Label; i <= DSCP; i++ {
 		m[strings.ToLower(i.String())] = i
 	}
 	return m
 }()
+
+// maxDSCP is the largest value the 6-bit DSCP field can hold.
+const maxDSCP uint8 = 0x3F
+
+// DSCPRule assigns a DSCP value to the flows of an experiment. If an activity
+// is given the rule only applies to the experiment's flows carrying it.
+type DSCPRule struct {
+	Experiment types.ExperimentRef `yaml:"experiment"`
+	Activity   *types.ActivityRef  `yaml:"activity,omitempty"`
+	DSCP       uint8               `yaml:"dscp"`
+}
+
+// dscp returns the DSCP value flows of the given experiment and activity are
+// to be marked with. Rules are checked in order, so the first matching one
+// wins: more specific rules should come first.
+func (c *Config) dscp(exp, act uint32) (uint8, bool) {
+	r := types.CurrentRegistry()
+	for _, rule := range c.DSCPMapping {
+		if !rule.Experiment.Matches(r, exp) {
+			continue
+		}
+		if rule.Activity != nil && !rule.Activity.Matches(r, exp, act) {
+			continue
+		}
+		return rule.DSCP, true
+	}
+	return 0, false
+}
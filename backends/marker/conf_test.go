@@ -0,0 +1,179 @@
+package marker
+
+import (
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-yaml"
+	"github.com/scitags/flowd-go/types"
+)
+
+func TestDSCPMapping(t *testing.T) {
+	r, err := types.LoadRegistry("../../types/testdata/scitags.json")
+	if err != nil {
+		t.Fatalf("error loading the registry: %v", err)
+	}
+	types.SetRegistry(r)
+	defer types.SetRegistry(nil)
+
+	raw := `
+markingStrategy: dscp
+dscpMapping:
+  - experiment: atlas
+    activity: datachallenge
+    dscp: 10
+  - experiment: atlas
+    dscp: 18
+  - experiment: 3
+    dscp: 46
+`
+
+	c := Config{}
+	if err := yaml.Unmarshal([]byte(raw), &c); err != nil {
+		t.Fatalf("error unmarshaling the configuration: %v", err)
+	}
+
+	if c.MarkingStrategy != DSCP {
+		t.Errorf("got strategy %s, want %s", c.MarkingStrategy, DSCP)
+	}
+
+	if err := c.Validate(); err != nil {
+		t.Errorf("unexpected validation error: %v", err)
+	}
+
+	tests := []struct {
+		exp, act uint32
+		want     uint8
+		ok       bool
+	}{
+		{2, 3, 10, true},
+		{2, 1, 18, true},
+		{3, 7, 46, true},
+		{1, 1, 0, false},
+	}
+
+	for _, test := range tests {
+		got, ok := c.dscp(test.exp, test.act)
+		if got != test.want || ok != test.ok {
+			t.Errorf("dscp(%d, %d): got (%d, %t), want (%d, %t)", test.exp, test.act, got, ok, test.want, test.ok)
+		}
+	}
+
+	for raw, want := range map[string]string{
+		"markingStrategy: dscp":                                  "needs a dscpMapping",
+		"dscpMapping: [{experiment: atlas, dscp: 64}]":           "larger than 63",
+		"dscpMapping: [{experiment: lhcb, dscp: 8}]":             "unknown experiment",
+		"dscpMapping: [{experiment: 2, activity: foo, dscp: 8}]": "unknown activity",
+		"attachMode: xdp":                                        "wrong attach mode",
+		"{pin: true, pinPath: flowd-go}":                         "must be absolute",
+	} {
+		c := Config{}
+		if err := yaml.Unmarshal([]byte(raw), &c); err != nil {
+			t.Fatalf("error unmarshaling %q: %v", raw, err)
+		}
+		if err := c.Validate(); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: got error %v, want %q", raw, err, want)
+		}
+	}
+}
+
+func TestMatchInterface(t *testing.T) {
+	c := Config{TargetInterfaces: []string{"eno1", "bond*", "eno1.[0-9]*"}}
+	public := func() bool { return true }
+
+	for iface, want := range map[string]bool{
+		"eno1":     true,
+		"eno2":     false,
+		"bond0":    true,
+		"eno1.100": true,
+		"eno1.foo": false,
+	} {
+		if got := c.matchInterface(iface, public); got != want {
+			t.Errorf("matchInterface(%q): got %t, want %t", iface, got, want)
+		}
+	}
+
+	// Discovery overrides the target interfaces
+	c.DiscoverInterfaces = true
+	if c.matchInterface("eno1", func() bool { return false }) {
+		t.Errorf("matched an interface without a public IPv6 address")
+	}
+	if !c.matchInterface("eno2", public) {
+		t.Errorf("didn't match an interface with a public IPv6 address")
+	}
+
+	// Interfaces created after flowd-go starts are attached to as they appear
+	c = Config{}
+	if err := yaml.Unmarshal([]byte(`targetInterfaces: [flowd-go-nope0]`), &c); err != nil {
+		t.Fatalf("error unmarshaling the configuration: %v", err)
+	}
+	if err := c.Validate(); err != nil {
+		t.Errorf("got error %v for an interface yet to be created", err)
+	}
+
+	c = Config{TargetInterfaces: []string{"bond[0-"}}
+	if err := c.Validate(); err == nil || !strings.Contains(err.Error(), "wrong target interface pattern") {
+		t.Errorf("got error %v for a malformed pattern", err)
+	}
+}
+
+func TestRuleSets(t *testing.T) {
+	r, err := types.LoadRegistry("../../types/testdata/scitags.json")
+	if err != nil {
+		t.Fatalf("error loading the registry: %v", err)
+	}
+	types.SetRegistry(r)
+	defer types.SetRegistry(nil)
+
+	raw := `
+rules:
+  - {destination: "2001:db8:1::/48", ports: [1094], experiment: atlas}
+  - {destination: "2001:db8::/32", experiment: cms}
+  - {destination: "192.0.2.0/24", ports: ["2811-2899"], experiment: 2, activity: datachallenge}
+`
+
+	c := Config{}
+	if err := yaml.Unmarshal([]byte(raw), &c); err != nil {
+		t.Fatalf("error unmarshaling the configuration: %v", err)
+	}
+
+	if err := c.Validate(); err != nil {
+		t.Errorf("unexpected validation error: %v", err)
+	}
+
+	sets, err := c.ruleSets()
+	if err != nil {
+		t.Fatalf("error arranging the rules: %v", err)
+	}
+
+	allPorts := types.PortRange{First: 0, Last: 0xFFFF}
+	want := []ruleSet{
+		{netip.MustParsePrefix("2001:db8:1::/48"), []rulePorts{{types.PortRange{First: 1094, Last: 1094}, 0}, {allPorts, 1}}},
+		{netip.MustParsePrefix("2001:db8::/32"), []rulePorts{{allPorts, 1}}},
+		{netip.MustParsePrefix("::ffff:192.0.2.0/120"), []rulePorts{{types.PortRange{First: 2811, Last: 2899}, 2}}},
+	}
+	if !reflect.DeepEqual(sets, want) {
+		t.Errorf("got rule sets %v, want %v", sets, want)
+	}
+
+	for raw, want := range map[string]string{
+		"rules: [{destination: 2001:db8::/32, experiment: lhcb}]":                                                                  "unknown experiment",
+		"{markingStrategy: dscp, rules: [{destination: 2001:db8::/32, experiment: atlas}]}":                                        "needs a dscpMapping",
+		"rules: [{destination: 2001:db8::/32, experiment: 2, ports: [1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17]}]": "at most 16",
+	} {
+		c := Config{}
+		if err := yaml.Unmarshal([]byte(raw), &c); err != nil {
+			t.Fatalf("error unmarshaling %q: %v", raw, err)
+		}
+		if err := c.Validate(); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: got error %v, want %q", raw, err, want)
+		}
+	}
+
+	c = Config{}
+	if err := yaml.Unmarshal([]byte("rules: [{experiment: atlas}]"), &c); err == nil {
+		t.Errorf("no error decoding a rule without a destination")
+	}
+}
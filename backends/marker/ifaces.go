@@ -11,39 +11,51 @@ import (
 	"github.com/scitags/flowd-go/types"
 )
 
-// Function discoverInterfaces will inspect all the available interfaces
-// on the machine and return those with an associated public IPv6 address.
-func discoverInterfaces() ([]string, error) {
-	iFaces, err := net.Interfaces()
+// Function hasPublicIPv6 checks whether the interface has an associated
+// public IPv6 address.
+func hasPublicIPv6(iFace net.Interface) bool {
+	addrs, err := iFace.Addrs()
 	if err != nil {
-		return nil, fmt.Errorf("error getting the system's interfaces: %w", err)
+		slog.Warn("couldn't get interface addresses", "interface", iFace.Name, "err", err)
+		return false
 	}
 
-	targetInterfaces := []string{}
-	for _, iFace := range iFaces {
-		addrs, err := iFace.Addrs()
+	for _, addr := range addrs {
+		slog.Debug("interface addr", "interface", iFace.Name, "addr", addr)
+		cidr, err := netip.ParsePrefix(addr.String())
 		if err != nil {
-			slog.Warn("couldn't get interface addresses", "interface", iFace.Name, "err", err)
+			slog.Warn("error parsing CIDR", "interface", iFace.Name, "cidr", addr.String())
 			continue
 		}
-		for _, addr := range addrs {
-			slog.Debug("interface addr", "interface", iFace.Name, "addr", addr)
-			cidr, err := netip.ParsePrefix(addr.String())
-			if err != nil {
-				slog.Warn("error parsing CIDR", "interface", iFace.Name, "cidr", addr.String())
-			}
-
-			if cidr.Addr().Is4() {
-				slog.Debug("address is IPv4", "interface", iFace.Name, "cidr", cidr)
-				continue
-			}
-
-			if !types.IsIPPrivate(cidr.Addr()) {
-				targetInterfaces = append(targetInterfaces, iFace.Name)
-				break
-			}
+
+		if cidr.Addr().Is4() {
+			slog.Debug("address is IPv4", "interface", iFace.Name, "cidr", cidr)
+			continue
+		}
+
+		if !types.IsIPPrivate(cidr.Addr()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Function targetInterfaces will inspect all the available interfaces on the
+// machine and return those the program should be attached to together with
+// their indices.
+func (b *MarkerBackend) targetInterfaces() (map[string]int, error) {
+	iFaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("error getting the system's interfaces: %w", err)
+	}
+
+	targets := map[string]int{}
+	for _, iFace := range iFaces {
+		if b.matchInterface(iFace.Name, func() bool { return hasPublicIPv6(iFace) }) {
+			targets[iFace.Name] = iFace.Index
 		}
 	}
 
-	return targetInterfaces, nil
+	return targets, nil
 }
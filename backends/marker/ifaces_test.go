@@ -31,7 +31,8 @@ func init() {
 }
 
 func TestInterfaceDiscovery(t *testing.T) {
-	targetInterfaces, err := discoverInterfaces()
+	b := MarkerBackend{Config: Config{DiscoverInterfaces: true}}
+	targetInterfaces, err := b.targetInterfaces()
 	if err != nil {
 		fmt.Printf("error: %v\n", err)
 	}
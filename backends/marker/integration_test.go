@@ -26,7 +26,7 @@ func TestBootstrap(t *testing.T) {
 		t.Fatalf("error reading the raw eBPF program: %v", err)
 	}
 
-	coll, err := loadProg(rawProg)
+	coll, err := loadProg(rawProg, "")
 	if err != nil {
 		t.Errorf("error loading the eBPF program into the kernel: %v", err)
 	}
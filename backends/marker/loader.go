@@ -4,8 +4,10 @@ package marker
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"log/slog"
+	"os"
 	"strings"
 
 	"github.com/cilium/ebpf"
@@ -14,8 +16,10 @@ import (
 )
 
 const (
-	PROG_NAME string = "marker"
-	MAP_NAME  string = "flowLabels"
+	PROG_NAME      string = "marker"
+	MAP_NAME       string = "flowLabels"
+	STATS_MAP_NAME string = "flowStats"
+	RULES_MAP_NAME string = "markRules"
 )
 
 func craftProgramPath(strategy Strategy, matchAll bool, debug bool) string {
@@ -47,7 +51,10 @@ func chooseProgram(strategy Strategy, matchAll bool, debug bool) ([]byte, error)
 	return progs.GetMarkerProgram(craftProgramPath(strategy, matchAll, debug))
 }
 
-func loadProg(rawProg []byte) (*ebpf.Collection, error) {
+// loadProg loads the program into the kernel. If pinDir is not empty the maps
+// are pinned there, reusing those pinned by a previous run if they're compatible
+// with the program's.
+func loadProg(rawProg []byte, pinDir string) (*ebpf.Collection, error) {
 	progSpec, err := ebpf.LoadCollectionSpecFromReader(bytes.NewReader(rawProg))
 	if err != nil {
 		return nil, fmt.Errorf("error parsing the eBPF program: %w", err)
@@ -56,8 +63,25 @@ func loadProg(rawProg []byte) (*ebpf.Collection, error) {
 	// We can modify the spec as we want: nothing's been loaded into the kernel yet
 	// progSpec.RewriteConstants()
 
+	// Pinning by name makes cilium/ebpf check the pinned maps are compatible
+	// with the spec before reusing them
+	if pinDir != "" {
+		if err := os.MkdirAll(pinDir, 0o700); err != nil {
+			return nil, fmt.Errorf("error creating the pin directory: %w", err)
+		}
+
+		for _, name := range []string{MAP_NAME, STATS_MAP_NAME, RULES_MAP_NAME} {
+			if mapSpec, ok := progSpec.Maps[name]; ok {
+				mapSpec.Pinning = ebpf.PinByName
+			}
+		}
+	}
+
 	// Time to load the program and assorted resources!
 	coll, err := ebpf.NewCollectionWithOptions(progSpec, ebpf.CollectionOptions{
+		Maps: ebpf.MapOptions{
+			PinPath: pinDir,
+		},
 		Programs: ebpf.ProgramOptions{
 			// bits ebpf.LogLevelBranch | ebpf.LogLevelInstruction make the output very verbose!
 			LogLevel: ebpf.LogLevelStats,
@@ -66,6 +90,9 @@ func loadProg(rawProg []byte) (*ebpf.Collection, error) {
 			// This is particularly useful for containers and such...
 		},
 	})
+	if errors.Is(err, ebpf.ErrMapIncompatible) {
+		return nil, fmt.Errorf("the maps pinned on %q don't suit the program, remove them with 'flowd-go marker clean': %w", pinDir, err)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("error loading the eBPF program: %w", err)
 	}
@@ -82,6 +109,12 @@ func loadProg(rawProg []byte) (*ebpf.Collection, error) {
 		return nil, fmt.Errorf("map %q hasn't been loaded", MAP_NAME)
 	}
 
+	// Programs predating the per-flow counters lack the map, but they can mark
+	// flows all the same
+	if _, ok := coll.Maps[STATS_MAP_NAME]; !ok {
+		slog.Warn("the eBPF program doesn't count marked packets", "map", STATS_MAP_NAME)
+	}
+
 	for n, prog := range coll.Programs {
 		slog.Debug("loaded program", "name", n, "type", prog.Type(), "descr", prog.String(), "fd", prog.FD())
 		for i, l := range strings.Split(prog.VerifierLog, "\n") {
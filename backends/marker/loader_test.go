@@ -34,6 +34,11 @@ func TestEmbeddedProgs(t *testing.T) {
 		{HopByHopDestination, false, true, "marker-hbhdo-dbg.bpf.o"},
 		{HopByHopDestination, true, false, "marker-hbhdo-all.bpf.o"},
 		{HopByHopDestination, true, true, "marker-hbhdo-all-dbg.bpf.o"},
+
+		{DSCP, false, false, "marker-dscp.bpf.o"},
+		{DSCP, false, true, "marker-dscp-dbg.bpf.o"},
+		{DSCP, true, false, "marker-dscp-all.bpf.o"},
+		{DSCP, true, true, "marker-dscp-all-dbg.bpf.o"},
 	}
 
 	for _, test := range tests {
@@ -57,7 +62,7 @@ func TestLoadProg(t *testing.T) {
 		t.Fatalf("error reading the raw eBPF program: %v", err)
 	}
 
-	coll, err := loadProg(rawProg)
+	coll, err := loadProg(rawProg, "")
 	if err != nil {
 		t.Fatalf("error loading the eBPF program into the kernel: %v", err)
 	}
@@ -0,0 +1,112 @@
+//go:build linux && ebpf
+
+package marker
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+
+	"github.com/cilium/ebpf"
+	"github.com/scitags/flowd-go/types"
+)
+
+// RuleKey mirrors the eBPF program's struct ruleKey: the prefix length followed
+// by the address in network byte order, which is just what netip.Addr.As16 yields.
+type RuleKey struct {
+	PrefixLen uint32
+	Addr      [16]byte
+}
+
+// RulePorts mirrors the eBPF program's struct rulePorts_t.
+type RulePorts struct {
+	First   uint16
+	Last    uint16
+	FlowTag uint32
+}
+
+// RuleSet mirrors the eBPF program's struct ruleSet_t.
+type RuleSet struct {
+	NPorts uint32
+	Ports  [maxRulePorts]RulePorts
+}
+
+func newRuleKey(p netip.Prefix) RuleKey {
+	return RuleKey{PrefixLen: uint32(p.Bits()), Addr: p.Addr().As16()}
+}
+
+// loadRules stores the marking rules on the LPM trie. Each rule gets a single
+// flow tag for every flow it marks. Given the trie may be a pinned one holding
+// the rules of a previous run we first overwrite what's there and then drop
+// whatever's left over so that there's no time without rules.
+func (b *MarkerBackend) loadRules() error {
+	rulesMap, ok := b.coll.Maps[RULES_MAP_NAME]
+	if !ok {
+		if len(b.Rules) != 0 {
+			return fmt.Errorf("the eBPF program doesn't support marking rules")
+		}
+		return nil
+	}
+
+	sets, err := b.ruleSets()
+	if err != nil {
+		return err
+	}
+
+	r := types.CurrentRegistry()
+	flowTags := make([]uint32, len(b.Rules))
+	for i, rule := range b.Rules {
+		exp, act, err := rule.resolve(r)
+		if err != nil {
+			return fmt.Errorf("rules[%d]: %w", i, err)
+		}
+
+		if b.MarkingStrategy == DSCP {
+			dscp, ok := b.dscp(exp, act)
+			if !ok {
+				return fmt.Errorf("rules[%d]: no DSCP value configured for the rule's experiment and activity", i)
+			}
+			flowTags[i] = uint32(dscp)
+			continue
+		}
+		flowTags[i] = b.genFlowTag(exp, act)
+	}
+
+	keys := map[RuleKey]bool{}
+	for _, set := range sets {
+		key := newRuleKey(set.Prefix)
+		keys[key] = true
+
+		value := RuleSet{NPorts: uint32(len(set.Ports))}
+		for i, rp := range set.Ports {
+			value.Ports[i] = RulePorts{First: rp.Ports.First, Last: rp.Ports.Last, FlowTag: flowTags[rp.Rule]}
+		}
+
+		if err := rulesMap.Update(key, value, ebpf.UpdateAny); err != nil {
+			return fmt.Errorf("error storing the rules for %s: %w", set.Prefix, err)
+		}
+		b.logger.Debug("stored marking rules", "prefix", set.Prefix, "nPorts", value.NPorts)
+	}
+
+	var (
+		key   RuleKey
+		stale []RuleKey
+	)
+	iter := rulesMap.Iterate()
+	for iter.Next(&key, new(RuleSet)) {
+		if !keys[key] {
+			stale = append(stale, key)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return fmt.Errorf("error listing the stored rules: %w", err)
+	}
+
+	for _, key := range stale {
+		if err := rulesMap.Delete(key); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+			return fmt.Errorf("error removing stale rules: %w", err)
+		}
+	}
+
+	return nil
+}
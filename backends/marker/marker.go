@@ -3,54 +3,112 @@
 package marker
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
+	"net/netip"
 	"os"
+	"sync"
 	"time"
 
 	"math/rand"
 
 	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+	"github.com/mdlayher/netlink"
+	"github.com/scitags/flowd-go/internal/telemetry"
 	glowdTypes "github.com/scitags/flowd-go/types"
 )
 
-// Note ports are 32 bits because the struct (and its eBPF counterpart)
-// are 8 bytes aligned given the internal uint64s!
+// Note the struct (and its eBPF counterpart) is 8 bytes aligned given the
+// internal uint64s, hence the explicit padding! The protocol is the IANA
+// protocol number (i.e. 6 for TCP) so that TCP and UDP flows sharing their
+// addresses and ports are told apart.
 type FlowFourTuple struct {
-	IPv6Hi  uint64
-	IPv6Lo  uint64
-	DstPort uint32
-	SrcPort uint32
+	IPv6Hi   uint64
+	IPv6Lo   uint64
+	DstPort  uint16
+	SrcPort  uint16
+	Protocol uint8
+	_        [3]uint8
+}
+
+func newFlowFourTuple(flowID glowdTypes.FlowID) FlowFourTuple {
+	// Programs built to match every datagram look flows up with an all-zero
+	// key, protocol included, so the match-all flowID (i.e. the one signalled
+	// by the perfsonar plugin) must map to it.
+	if isMatchAll(flowID) {
+		return FlowFourTuple{}
+	}
+
+	// IPv4 addresses are looked up as IPv4-mapped IPv6 addresses
+	rawDstIPHi, rawDstIPLo := extractHalves(netip.AddrFrom16(flowID.Dst.Addr().As16()))
+	return FlowFourTuple{
+		IPv6Hi:   rawDstIPHi,
+		IPv6Lo:   rawDstIPLo,
+		DstPort:  flowID.Dst.Port(),
+		SrcPort:  flowID.Src.Port(),
+		Protocol: flowID.Protocol.Number(),
+	}
+}
+
+// FlowStats mirrors the eBPF program's struct flowStats_t. The map holding
+// them is a per-CPU one, so each lookup yields one value per possible CPU.
+type FlowStats struct {
+	Packets    uint64
+	Bytes      uint64
+	MTUSkipped uint64
+}
+
+// isMatchAll checks whether the flowID is the one leveraged to mark every
+// datagram: unspecified addresses and ports alike.
+func isMatchAll(flowID glowdTypes.FlowID) bool {
+	return flowID.Dst.Addr().IsUnspecified() && flowID.Src.Port() == 0 && flowID.Dst.Port() == 0
 }
 
 type MarkerBackend struct {
 	Config
 
+	name   string
+	logger *slog.Logger
+
 	coll *ebpf.Collection
 	nl   *NetlinkClient
 	rGen *rand.Rand
+
+	// The tcx links the program is attached through keyed by interface.
+	links map[string]link.Link
+
+	// The interfaces the program is attached to together with their indices,
+	// kept up to date with the changes signalled through ifaceEvents.
+	attached    map[string]int
+	ifaceConn   *netlink.Conn
+	ifaceEvents <-chan struct{}
+
+	// Flow tags to reuse on the next START of each flow. They're handed
+	// to us from outside the Run goroutine, hence the mutex.
+	restoredMu sync.Mutex
+	restored   map[glowdTypes.FlowKey]uint32
 }
 
 func (b *MarkerBackend) String() string {
-	return "marker"
+	return "marker (" + b.name + ")"
 }
 
-func NewMarkerBackend(c *Config) (*MarkerBackend, error) {
-	slog.Debug("initialising the marker backend")
-	b := MarkerBackend{Config: *c}
+// Flavours implements glowdTypes.EnrichmentConsumer: marking packets doesn't
+// need any enrichment at all.
+func (b *MarkerBackend) Flavours() []glowdTypes.Flavour {
+	return nil
+}
 
-	// If we need to discover interfaces with public IPv6 addresses simply
-	// pull the rug form underneath the configuration.
-	if b.DiscoverInterfaces {
-		if len(b.TargetInterfaces) != 0 {
-			slog.Warn("specified target interfaces will be overridden", "originalTargetInterfaces", b.TargetInterfaces)
-		}
+func NewMarkerBackend(name string, c *Config) (*MarkerBackend, error) {
+	b := MarkerBackend{Config: *c, name: name, logger: slog.Default().With(glowdTypes.LogKeyBackend, name)}
+	b.logger.Debug("initialising the marker backend")
 
-		targetInterfaces, err := discoverInterfaces()
-		if err != nil {
-			return nil, fmt.Errorf("couldn't discover target interfaces: %w", err)
-		}
-		b.TargetInterfaces = targetInterfaces
+	// If we need to discover interfaces with public IPv6 addresses the
+	// configured target interfaces are ignored.
+	if b.DiscoverInterfaces && len(b.TargetInterfaces) != 0 {
+		b.logger.Warn("specified target interfaces will be overridden", "originalTargetInterfaces", b.TargetInterfaces)
 	}
 
 	nl, err := NewNetlinkClient()
@@ -61,7 +119,7 @@ func NewMarkerBackend(c *Config) (*MarkerBackend, error) {
 
 	var prog []byte
 	if b.ProgramPath != "" {
-		slog.Debug("loading the provided eBPF program", "path", b.ProgramPath)
+		b.logger.Debug("loading the provided eBPF program", "path", b.ProgramPath)
 		prog, err = os.ReadFile(b.ProgramPath)
 		if err != nil {
 			return nil, fmt.Errorf("error reading user provided program: %w", err)
@@ -74,99 +132,236 @@ func NewMarkerBackend(c *Config) (*MarkerBackend, error) {
 	}
 
 	// Time to load the program into the kernel
-	coll, err := loadProg(prog)
+	coll, err := loadProg(prog, b.pinDir())
 	if err != nil {
 		return nil, fmt.Errorf("error loading the eBPF program: %w", err)
 	}
 	b.coll = coll
 
-	// Time to create the qdiscs and attach the program.
-	for _, iface := range b.TargetInterfaces {
-		if err := b.nl.CreateFilterQdisc(iface); err != nil {
-			b.Cleanup()
-			return nil, fmt.Errorf("error creating the clsact qdisc for interface %q: %w", iface, err)
-		}
+	// Initialise the random number generator
+	b.logger.Debug("initialising the random number generator")
+	b.rGen = rand.New(rand.NewSource(time.Now().UnixNano()))
 
-		if err := b.nl.AttachEbpfProgram(iface, coll.Programs[PROG_NAME], true); err != nil {
-			b.Cleanup()
-			return nil, fmt.Errorf("error attaching the eBPF program to %q: %w", iface, err)
+	if err := b.loadRules(); err != nil {
+		coll.Close()
+		return nil, fmt.Errorf("error loading the marking rules: %w", err)
+	}
+
+	if b.Pin {
+		if err := b.pinProgram(); err != nil {
+			coll.Close()
+			return nil, err
 		}
 	}
 
-	// Initialise the random number generator
-	slog.Debug("initialising the random number generator")
-	b.rGen = rand.New(rand.NewSource(time.Now().UnixNano()))
+	// Time to attach the program. Subscribe to interface changes first so
+	// that we don't miss any in between.
+	b.links = map[string]link.Link{}
+	b.attached = map[string]int{}
+	ifaceConn, err := dialInterfaceEvents()
+	if err != nil {
+		b.Cleanup()
+		return nil, fmt.Errorf("error subscribing to interface changes: %w", err)
+	}
+	b.ifaceConn = ifaceConn
+	b.ifaceEvents = watchInterfaces(ifaceConn, b.logger)
+
+	if err := b.syncInterfaces(); err != nil {
+		b.Cleanup()
+		return nil, err
+	}
+
+	if len(b.attached) == 0 {
+		b.logger.Warn("no interface to attach the eBPF program to yet")
+	}
+
+	if b.Pin {
+		b.dropStaleLinks()
+	}
+
+	b.restored = map[glowdTypes.FlowKey]uint32{}
 
 	return &b, nil
 }
 
 func (b *MarkerBackend) Run(done <-chan struct{}, inChan <-chan glowdTypes.FlowID) {
-	slog.Debug("running the marker backend")
+	b.logger.Debug("running the marker backend")
 
 	for {
 		select {
 		case flowID, ok := <-inChan:
 			if !ok {
-				slog.Warn("somebody closed the input channel!")
+				b.logger.Warn("somebody closed the input channel!")
 				return
 			}
-			slog.Debug("got a flowID", "flowID", flowID)
+			b.logger.Debug("got a flowID", glowdTypes.LogKeyFlow, flowID)
 
-			if flowID.Family != glowdTypes.IPv6 {
-				slog.Debug("ignoring IPv4 flow")
+			if !b.marks(flowID) {
+				b.logger.Debug("ignoring IPv4 flow")
 				continue
 			}
 
-			rawDstIPHi, rawDstIPLo := extractHalves(flowID.Dst.Addr())
-			flowHash := FlowFourTuple{
-				IPv6Hi:  rawDstIPHi,
-				IPv6Lo:  rawDstIPLo,
-				DstPort: uint32(flowID.Dst.Port()),
-				SrcPort: uint32(flowID.Src.Port()),
-			}
+			flowHash := newFlowFourTuple(flowID)
 
 			switch flowID.State {
 			case glowdTypes.START:
-				flowTag := b.genFlowTag(flowID.Experiment, flowID.Activity)
+				flowTag, ok := b.popRestoredFlowTag(flowID.Key())
+				if ok {
+					b.logger.Debug("reusing restored flow tag", "flowHash", flowHash, "flowTag", flowTag)
+				} else if b.MarkingStrategy == DSCP {
+					dscp, ok := b.dscp(flowID.Experiment, flowID.Activity)
+					if !ok {
+						b.logger.Debug("no DSCP value configured for the flow", glowdTypes.LogKeyFlow, flowID)
+						continue
+					}
+					flowTag = uint32(dscp)
+				} else {
+					flowTag = b.genFlowTag(flowID.Experiment, flowID.Activity)
+				}
 
 				if err := b.coll.Maps[MAP_NAME].Update(flowHash, flowTag, ebpf.UpdateAny); err != nil {
-					slog.Error("error inserting map value", "err", err, "flowHash", flowHash, "flowTag", flowTag)
+					b.logger.Error("error inserting map value", "err", err, "flowHash", flowHash, "flowTag", flowTag)
+					telemetry.MarkerMapErrors.WithLabelValues(b.name, "update").Inc()
 					continue
 				}
-				slog.Debug("inserted map value", "flowHash", flowHash, "flowTag", flowTag)
+				b.logger.Debug("inserted map value", "flowHash", flowHash, "flowTag", flowTag)
 
-				// We need to ingest flow information not to block broadcasting!
-				for t, fc := range flowID.FlowInfoChans {
-					if fc == nil {
-						continue
-					}
-					go func() {
-						for fi := range fc {
-							slog.Debug("got flow info", "fi.Cong", fi.Cong, "t", t)
-						}
-					}()
-				}
 			case glowdTypes.END:
+				b.popRestoredFlowTag(flowID.Key())
 				if err := b.coll.Maps[MAP_NAME].Delete(flowHash); err != nil {
-					slog.Error("error deleting map key", "err", err, "flowHash", flowHash)
+					b.logger.Error("error deleting map key", "err", err, "flowHash", flowHash)
+					telemetry.MarkerMapErrors.WithLabelValues(b.name, "delete").Inc()
 					continue
 				}
-				slog.Debug("deleted map value", "flowHash", flowHash)
+				b.logger.Debug("deleted map value", "flowHash", flowHash)
+
+				b.forgetStats(flowID, flowHash)
 			default:
-				slog.Error("wrong flow state made it here", "flowID.State", flowID.State)
+				b.logger.Error("wrong flow state made it here", "state", flowID.State)
+			}
+		case _, ok := <-b.ifaceEvents:
+			if !ok {
+				b.logger.Warn("stopped tracking interface changes")
+				b.ifaceEvents = nil
+				continue
+			}
+
+			if err := b.syncInterfaces(); err != nil {
+				b.logger.Error("error updating the interfaces the eBPF program is attached to", "err", err)
 			}
 		case <-done:
-			slog.Debug("cleanly exiting the ebpf backend")
+			b.logger.Debug("cleanly exiting the ebpf backend")
 			return
 		}
 	}
 }
 
+// FlowTag implements glowdTypes.FlowTagger by looking the flow up on the eBPF map.
+func (b *MarkerBackend) FlowTag(flowID glowdTypes.FlowID) (uint32, bool) {
+	if !b.marks(flowID) {
+		return 0, false
+	}
+
+	var flowTag uint32
+	if err := b.coll.Maps[MAP_NAME].Lookup(newFlowFourTuple(flowID), &flowTag); err != nil {
+		return 0, false
+	}
+
+	return flowTag, true
+}
+
+// RestoreFlowTag implements glowdTypes.FlowTagger.
+func (b *MarkerBackend) RestoreFlowTag(flowID glowdTypes.FlowID, flowTag uint32) {
+	if !b.marks(flowID) {
+		return
+	}
+
+	b.restoredMu.Lock()
+	defer b.restoredMu.Unlock()
+
+	b.restored[flowID.Key()] = flowTag
+}
+
+// MarkingStats implements glowdTypes.MarkingReporter by adding up the
+// per-CPU counters of the flow.
+func (b *MarkerBackend) MarkingStats(flowID glowdTypes.FlowID) (glowdTypes.MarkingStats, bool) {
+	statsMap, ok := b.coll.Maps[STATS_MAP_NAME]
+	if !ok || !b.marks(flowID) {
+		return glowdTypes.MarkingStats{}, false
+	}
+
+	var perCPUStats []FlowStats
+	if err := statsMap.Lookup(newFlowFourTuple(flowID), &perCPUStats); err != nil {
+		return glowdTypes.MarkingStats{}, false
+	}
+
+	stats := glowdTypes.MarkingStats{}
+	for _, s := range perCPUStats {
+		stats.Add(glowdTypes.MarkingStats{Packets: s.Packets, Bytes: s.Bytes, MTUSkipped: s.MTUSkipped})
+	}
+
+	return stats, true
+}
+
+// forgetStats reports the counters of an ENDing flow before removing them.
+func (b *MarkerBackend) forgetStats(flowID glowdTypes.FlowID, flowHash FlowFourTuple) {
+	if _, ok := b.coll.Maps[STATS_MAP_NAME]; !ok {
+		return
+	}
+
+	stats, ok := b.MarkingStats(flowID)
+	if !ok {
+		b.logger.Info("flow ended without marked packets", glowdTypes.LogKeyFlow, flowID)
+		return
+	}
+
+	b.logger.Info("flow ended", glowdTypes.LogKeyFlow, flowID, "packets", stats.Packets,
+		"bytes", stats.Bytes, "mtuSkipped", stats.MTUSkipped, "complete", stats.Complete())
+	telemetry.MarkerPackets.WithLabelValues(b.name).Add(float64(stats.Packets))
+	telemetry.MarkerBytes.WithLabelValues(b.name).Add(float64(stats.Bytes))
+	telemetry.MarkerMTUSkipped.WithLabelValues(b.name).Add(float64(stats.MTUSkipped))
+
+	if err := b.coll.Maps[STATS_MAP_NAME].Delete(flowHash); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+		b.logger.Error("error deleting stats map key", "err", err, "flowHash", flowHash)
+		telemetry.MarkerMapErrors.WithLabelValues(b.name, "delete").Inc()
+	}
+}
+
+// marks checks whether the flow can be marked at all: every strategy but DSCP
+// relies on IPv6 features, so only the latter can mark IPv4 flows.
+func (b *MarkerBackend) marks(flowID glowdTypes.FlowID) bool {
+	return flowID.Family == glowdTypes.IPv6 || b.MarkingStrategy == DSCP
+}
+
+func (b *MarkerBackend) popRestoredFlowTag(flowKey glowdTypes.FlowKey) (uint32, bool) {
+	b.restoredMu.Lock()
+	defer b.restoredMu.Unlock()
+
+	flowTag, ok := b.restored[flowKey]
+	delete(b.restored, flowKey)
+
+	return flowTag, ok
+}
+
 func (b *MarkerBackend) Cleanup() error {
-	slog.Debug("cleaning up the marker backend")
+	b.logger.Debug("cleaning up the marker backend")
+
+	// Stop tracking interface changes
+	if b.ifaceConn != nil {
+		b.ifaceConn.Close()
+	}
+
+	// Detach the program from the interfaces it's been attached to through tcx.
+	// Pinned links are left in place so that marking carries on.
+	for iface, l := range b.links {
+		if err := l.Close(); err != nil {
+			b.logger.Warn("error closing the tcx link", "interface", iface, "err", err)
+		}
+	}
 
-	// Remove all the qdiscs and filters
-	b.nl.Close(b.RemoveQdisc)
+	// Remove all the qdiscs and filters unless we're pinning: the filters
+	// keep the program attached until we're back.
+	b.nl.Close(b.RemoveQdisc && !b.Pin)
 
 	// Unload the eBPF program
 	b.coll.Close()
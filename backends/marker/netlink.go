@@ -71,8 +71,9 @@ func (nl *NetlinkClient) Close(tearQdiscs bool) error {
 }
 
 // Kernels 6.6+ bring support for the TC eBPF Fast Path (tcx) [0] which adds support for
-// attaching programs with links [1]. Given we're targetting the 5.14 kernel series that's
-// shipped with AlmaLinux we're 'stuck' with interacting with netlink to:
+// attaching programs with links [1]. We do leverage it when available (see tcx.go), but
+// given we're targetting the 5.14 kernel series that's shipped with AlmaLinux too we
+// can't do without interacting with netlink to:
 //
 //   1. Create a qdisc on which to attach our eBPF program.
 //   2. Attach the eBPF program.
@@ -214,6 +215,13 @@ func (nl *NetlinkClient) RemoveFilterQdisc(interfaceName string) error {
 	return nil
 }
 
+// Forget stops tracking the qdisc and filter of the interface. It's meant for
+// interfaces that have vanished together with them.
+func (nl *NetlinkClient) Forget(interfaceName string) {
+	nl.qdiscs = slices.DeleteFunc(nl.qdiscs, func(iface string) bool { return iface == interfaceName })
+	nl.filters = slices.DeleteFunc(nl.filters, func(iface string) bool { return iface == interfaceName })
+}
+
 func (nl *NetlinkClient) CreateFilterQdisc(interfaceName string) error {
 	// Craft the qdisc description for the interface
 	qdisc, err := craftQdiscDescription(interfaceName)
@@ -235,6 +243,28 @@ func (nl *NetlinkClient) CreateFilterQdisc(interfaceName string) error {
 	return nil
 }
 
+// ReplaceEbpfProgram behaves just like AttachEbpfProgram, but it atomically swaps
+// the program of a filter left behind by a previous run instead of failing.
+func (nl *NetlinkClient) ReplaceEbpfProgram(interfaceName string, prog *ebpf.Program, egress bool) error {
+	fd := uint32(prog.FD())
+	filterDescr, err := craftFilterDescription(interfaceName, &fd, egress)
+	if err != nil {
+		return fmt.Errorf("error crafting filter description: %w", err)
+	}
+
+	// The message type is RTM_NEWTFILTER too, but with the NLM_F_REPLACE flag set.
+	if err := nl.conn.Filter().Replace(&filterDescr); err != nil {
+		return fmt.Errorf("could not replace filter for eBPF program: %v", err)
+	}
+
+	// If the interface's not being tracked, add it
+	if slices.Index(nl.filters, interfaceName) == -1 {
+		nl.filters = append(nl.filters, interfaceName)
+	}
+
+	return nil
+}
+
 func (nl *NetlinkClient) AttachEbpfProgram(interfaceName string, prog *ebpf.Program, egress bool) error {
 	fd := uint32(prog.FD())
 	filterDescr, err := craftFilterDescription(interfaceName, &fd, egress)
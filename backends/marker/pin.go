@@ -0,0 +1,190 @@
+//go:build linux && ebpf
+
+package marker
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+)
+
+// The program, its maps and its tcx links only live as long as the file descriptors
+// referencing them are open, so they're gone as soon as flowd-go exits. That's
+// unless they're pinned on the BPF filesystem (bpffs), which keeps them around until
+// they're unpinned (i.e. removed). When pinning is enabled each instance pins its
+// objects on a directory of its own:
+//
+//   <pinPath>/<instance>/marker          The program
+//   <pinPath>/<instance>/flowLabels      The map holding the flow tags
+//   <pinPath>/<instance>/flowStats       The map holding the per-flow counters
+//   <pinPath>/<instance>/link-<iface>    The tcx link attaching the program to <iface>
+//
+// Restarting flowd-go then reuses the pinned maps and links so that flows are marked
+// all along: the program is only swapped (atomically) if it's changed. Bear in mind
+// programs attached through a clsact qdisc are kept alive by the filter instead.
+// Check https://docs.kernel.org/bpf/maps.html and https://docs.ebpf.io/linux/concepts/pinning/.
+
+const linkPinPrefix = "link-"
+
+func (b *MarkerBackend) pinDir() string {
+	if !b.Pin {
+		return ""
+	}
+	return filepath.Join(b.PinPath, b.name)
+}
+
+func linkPinPath(pinDir, iface string) string {
+	return filepath.Join(pinDir, linkPinPrefix+iface)
+}
+
+// pinProgram reuses the program pinned by a previous run if it's compatible with
+// the one we've just loaded: it must be the very same program (i.e. have the same
+// tag) and use the very same pinned maps. Otherwise the loaded program is pinned
+// in its place and links are updated to it as they're reused.
+func (b *MarkerBackend) pinProgram() error {
+	progPath := filepath.Join(b.pinDir(), PROG_NAME)
+	prog := b.coll.Programs[PROG_NAME]
+
+	pinned, err := ebpf.LoadPinnedProgram(progPath, nil)
+	if err == nil {
+		if compatiblePrograms(pinned, prog, b.coll.Maps[MAP_NAME]) {
+			b.logger.Info("reusing the pinned eBPF program", "path", progPath)
+			prog.Close()
+			b.coll.Programs[PROG_NAME] = pinned
+			return nil
+		}
+
+		b.logger.Info("the pinned eBPF program differs from the loaded one, replacing it", "path", progPath)
+		pinned.Close()
+		if err := os.Remove(progPath); err != nil {
+			return fmt.Errorf("error removing the pinned eBPF program: %w", err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error loading the pinned eBPF program: %w", err)
+	}
+
+	if err := prog.Pin(progPath); err != nil {
+		return fmt.Errorf("error pinning the eBPF program: %w", err)
+	}
+
+	return nil
+}
+
+// compatiblePrograms checks whether the pinned program can stand in for the loaded one.
+func compatiblePrograms(pinned, loaded *ebpf.Program, flowLabels *ebpf.Map) bool {
+	pinnedInfo, err := pinned.Info()
+	if err != nil {
+		return false
+	}
+	loadedInfo, err := loaded.Info()
+	if err != nil {
+		return false
+	}
+
+	if pinnedInfo.Type != loadedInfo.Type || pinnedInfo.Tag != loadedInfo.Tag {
+		return false
+	}
+
+	mapInfo, err := flowLabels.Info()
+	if err != nil {
+		return false
+	}
+	mapID, ok := mapInfo.ID()
+	if !ok {
+		return false
+	}
+	mapIDs, ok := pinnedInfo.MapIDs()
+
+	return ok && slices.Contains(mapIDs, mapID)
+}
+
+// reusePinnedLink reuses the tcx link pinned for the interface, if any, updating it
+// to the current program. Links left behind by an interface that's been recreated
+// since they were pinned are no longer attached to it, so they're unpinned instead.
+func (b *MarkerBackend) reusePinnedLink(iface string) (link.Link, bool) {
+	linkPath := linkPinPath(b.pinDir(), iface)
+
+	l, err := link.LoadPinnedLink(linkPath, nil)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			b.logger.Warn("error loading the pinned tcx link", "interface", iface, "err", err)
+		}
+		return nil, false
+	}
+
+	if !linkedTo(l, iface) {
+		b.logger.Debug("dropping the stale pinned tcx link", "interface", iface)
+		unpinLink(l)
+		return nil, false
+	}
+
+	if err := l.Update(b.coll.Programs[PROG_NAME]); err != nil {
+		b.logger.Warn("error updating the pinned tcx link", "interface", iface, "err", err)
+		unpinLink(l)
+		return nil, false
+	}
+
+	return l, true
+}
+
+// linkedTo checks whether the link is attached to the interface.
+func linkedTo(l link.Link, iface string) bool {
+	netIface, err := net.InterfaceByName(iface)
+	if err != nil {
+		return false
+	}
+
+	info, err := l.Info()
+	if err != nil || info.TCX() == nil {
+		return false
+	}
+
+	return info.TCX().Ifindex == uint32(netIface.Index)
+}
+
+func unpinLink(l link.Link) {
+	l.Unpin()
+	l.Close()
+}
+
+// dropStaleLinks unpins the tcx links pinned for interfaces the program is no longer
+// attached to (i.e. because the configuration changed), which detaches it from them.
+func (b *MarkerBackend) dropStaleLinks() {
+	entries, err := os.ReadDir(b.pinDir())
+	if err != nil {
+		b.logger.Warn("error listing the pinned objects", "err", err)
+		return
+	}
+
+	for _, entry := range entries {
+		iface, ok := strings.CutPrefix(entry.Name(), linkPinPrefix)
+		if !ok {
+			continue
+		}
+		if _, ok := b.links[iface]; ok {
+			continue
+		}
+
+		b.logger.Info("detaching the eBPF program from a no longer targeted interface", "interface", iface)
+		if err := os.Remove(filepath.Join(b.pinDir(), entry.Name())); err != nil {
+			b.logger.Warn("error unpinning the tcx link", "interface", iface, "err", err)
+		}
+	}
+}
+
+// RemovePinned removes every object pinned under pinPath. Removing pinned tcx links
+// detaches the program and removing the rest lets the kernel free them once unused.
+func RemovePinned(pinPath string) error {
+	if _, err := os.Stat(pinPath); err != nil {
+		return err
+	}
+
+	return os.RemoveAll(pinPath)
+}
@@ -0,0 +1,129 @@
+package marker
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+
+	"github.com/goccy/go-yaml"
+	"github.com/scitags/flowd-go/types"
+)
+
+// maxRulePorts is how many port ranges can apply to a single destination prefix.
+// It must match MAX_RULE_PORTS on the eBPF program, which loops over them.
+const maxRulePorts = 16
+
+// MarkRule marks every flow towards a destination prefix (and, optionally, a set
+// of destination port ranges) with the given experiment and activity. Rules only
+// apply to flows no plugin has signalled, so sites can mark all the traffic bound
+// for a peer's storage without announcing each connection.
+type MarkRule struct {
+	Destination netip.Prefix        `yaml:"destination"`
+	Ports       []types.PortRange   `yaml:"ports,omitempty"`
+	Experiment  types.ExperimentRef `yaml:"experiment"`
+	Activity    *types.ActivityRef  `yaml:"activity,omitempty"`
+}
+
+func (r *MarkRule) UnmarshalYAML(b []byte) error {
+	// Needed to break recursive calls into UnmarshalYAML
+	type rule MarkRule
+
+	def := &rule{}
+	if err := yaml.Unmarshal(b, def); err != nil {
+		return err
+	}
+
+	if !def.Destination.IsValid() {
+		return fmt.Errorf("marking rules must specify a destination prefix")
+	}
+	def.Destination = def.Destination.Masked()
+
+	*r = MarkRule(*def)
+
+	return nil
+}
+
+// resolve returns the experiment and activity IDs of the rule. Rules without
+// an activity carry activity 0.
+func (r *MarkRule) resolve(reg *types.Registry) (uint32, uint32, error) {
+	exp, err := r.Experiment.Resolve(reg)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if r.Activity == nil {
+		return exp, 0, nil
+	}
+
+	act, err := r.Activity.Resolve(reg, exp)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return exp, act, nil
+}
+
+// prefix returns the rule's destination as looked up on the eBPF program: IPv4
+// prefixes become IPv4-mapped IPv6 ones just like flows' addresses do.
+func (r *MarkRule) prefix() netip.Prefix {
+	if !r.Destination.Addr().Is4() {
+		return r.Destination
+	}
+	return netip.PrefixFrom(netip.AddrFrom16(r.Destination.Addr().As16()), r.Destination.Bits()+96)
+}
+
+// rulePorts is a port range together with the index of the rule it belongs to.
+type rulePorts struct {
+	Ports types.PortRange
+	Rule  int
+}
+
+// ruleSet holds the port ranges to check, in order, for the flows whose longest
+// matching destination prefix is Prefix.
+type ruleSet struct {
+	Prefix netip.Prefix
+	Ports  []rulePorts
+}
+
+// ruleSets arranges the rules as they're stored on the eBPF program's LPM trie.
+// The trie only yields the longest prefix matching a flow's destination, so the
+// set of each prefix includes the port ranges of every rule whose destination
+// contains it. Ranges are kept in the order rules are configured, so the first
+// matching rule wins just like with dscpMapping. Rules without ports match any.
+func (c *Config) ruleSets() ([]ruleSet, error) {
+	sets := []ruleSet{}
+	seen := map[netip.Prefix]bool{}
+	errs := []error{}
+
+	for _, rule := range c.Rules {
+		p := rule.prefix()
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+
+		set := ruleSet{Prefix: p}
+		for j, other := range c.Rules {
+			op := other.prefix()
+			if op.Bits() > p.Bits() || !op.Contains(p.Addr()) {
+				continue
+			}
+
+			if len(other.Ports) == 0 {
+				set.Ports = append(set.Ports, rulePorts{Ports: types.PortRange{First: 0, Last: 0xFFFF}, Rule: j})
+				continue
+			}
+			for _, pr := range other.Ports {
+				set.Ports = append(set.Ports, rulePorts{Ports: pr, Rule: j})
+			}
+		}
+
+		if len(set.Ports) > maxRulePorts {
+			errs = append(errs, fmt.Errorf("%d port ranges apply to destination %s, but at most %d can", len(set.Ports), rule.Destination, maxRulePorts))
+			continue
+		}
+		sets = append(sets, set)
+	}
+
+	return sets, errors.Join(errs...)
+}
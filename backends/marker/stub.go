@@ -11,7 +11,7 @@ type MarkerBackend struct {
 	Config
 }
 
-func NewMarkerBackend(c *Config) (*MarkerBackend, error) {
+func NewMarkerBackend(name string, c *Config) (*MarkerBackend, error) {
 	return nil, nil
 }
 
@@ -35,8 +35,10 @@ func (b *MarkerBackend) Cleanup() error {
 // complain. Should we maybe relocate this definition someplace
 // else?
 type FlowFourTuple struct {
-	IPv6Hi  uint64
-	IPv6Lo  uint64
-	DstPort uint16
-	SrcPort uint16
+	IPv6Hi   uint64
+	IPv6Lo   uint64
+	DstPort  uint16
+	SrcPort  uint16
+	Protocol uint8
+	_        [3]uint8
 }
@@ -0,0 +1,104 @@
+//go:build linux && ebpf
+
+package marker
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+	"golang.org/x/sys/unix"
+)
+
+// Kernels 6.6+ support the TC eBPF Fast Path (tcx) which lets us attach the program
+// to the egress path of an interface through a BPF link instead of a clsact qdisc and
+// a filter. The program is then owned by the link rather than by a filter with a fixed
+// priority and handle: the kernel detaches it as soon as the link's last reference is
+// gone (i.e. when flowd-go exits, even if it crashes) and it's chained together with
+// other programs attached through tcx (i.e. Cilium's) instead of clashing with them.
+// Check https://docs.ebpf.io/linux/syscall/BPF_LINK_CREATE/#tcx for the details.
+
+// attachTCX attaches prog to the egress path of the interface through a tcx link. If
+// the kernel doesn't support tcx the returned error wraps ebpf.ErrNotSupported.
+func attachTCX(interfaceName string, prog *ebpf.Program) (link.Link, error) {
+	iface, err := net.InterfaceByName(interfaceName)
+	if err != nil {
+		return nil, fmt.Errorf("could not get interface id: %w", err)
+	}
+
+	l, err := link.AttachTCX(link.TCXOptions{
+		Interface: iface.Index,
+		Program:   prog,
+		Attach:    ebpf.AttachTCXEgress,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not attach the tcx link: %w", err)
+	}
+
+	return l, nil
+}
+
+// attach hooks the program to the egress path of the interface. Unless configured
+// otherwise tcx is tried first, falling back to a clsact qdisc and a bpf filter set
+// up through netlink on kernels lacking support for it. Once we know tcx is not
+// available we won't bother trying again. When pinning, links pinned by a previous
+// run are reused and qdiscs and filters left behind are taken over.
+func (b *MarkerBackend) attach(iface string) error {
+	prog := b.coll.Programs[PROG_NAME]
+
+	if b.AttachMode != AttachTC {
+		if b.Pin {
+			if l, ok := b.reusePinnedLink(iface); ok {
+				b.logger.Debug("reusing the pinned tcx link", "interface", iface)
+				b.links[iface] = l
+				return nil
+			}
+		}
+
+		l, err := attachTCX(iface, prog)
+		if err == nil {
+			b.logger.Debug("attached the eBPF program through tcx", "interface", iface)
+			b.links[iface] = l
+
+			if b.Pin {
+				if err := l.Pin(linkPinPath(b.pinDir(), iface)); err != nil {
+					b.logger.Warn("error pinning the tcx link", "interface", iface, "err", err)
+				}
+			}
+			return nil
+		}
+
+		if !errors.Is(err, ebpf.ErrNotSupported) || b.AttachMode == AttachTCX {
+			return err
+		}
+
+		b.logger.Info("tcx is not supported by the kernel, falling back to a clsact qdisc", "interface", iface)
+		b.AttachMode = AttachTC
+	}
+
+	if b.Pin {
+		if err := b.nl.CreateFilterQdisc(iface); err != nil && !errors.Is(err, unix.EEXIST) {
+			return fmt.Errorf("error creating the clsact qdisc: %w", err)
+		}
+
+		if err := b.nl.ReplaceEbpfProgram(iface, prog, true); err != nil {
+			return err
+		}
+		b.logger.Debug("attached the eBPF program through a clsact qdisc", "interface", iface)
+
+		return nil
+	}
+
+	if err := b.nl.CreateFilterQdisc(iface); err != nil {
+		return fmt.Errorf("error creating the clsact qdisc: %w", err)
+	}
+
+	if err := b.nl.AttachEbpfProgram(iface, prog, true); err != nil {
+		return err
+	}
+	b.logger.Debug("attached the eBPF program through a clsact qdisc", "interface", iface)
+
+	return nil
+}
@@ -0,0 +1,138 @@
+//go:build linux && ebpf
+
+package marker
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+
+	"github.com/mdlayher/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// Interfaces come and go: bonds and VLANs can be created after flowd-go starts,
+// public IPv6 addresses can be assigned at any time and recreating an interface
+// takes the program attached to it along. Instead of resolving the target
+// interfaces once we subscribe to rtnetlink's link and IPv6 address notifications
+// (see rtnetlink(7)) and reconcile the interfaces the program is attached to with
+// the ones it should be attached to on every change. Given reconciling is cheap
+// we don't bother decoding the notifications at all.
+
+// dialInterfaceEvents opens an rtnetlink socket subscribed to the notifications
+// of interest. Be sure to close it to avoid leaking fds.
+func dialInterfaceEvents() (*netlink.Conn, error) {
+	conn, err := netlink.Dial(unix.NETLINK_ROUTE, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not open rtnetlink socket: %w", err)
+	}
+
+	for _, group := range []uint32{unix.RTNLGRP_LINK, unix.RTNLGRP_IPV6_IFADDR} {
+		if err := conn.JoinGroup(group); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("could not join rtnetlink group %d: %w", group, err)
+		}
+	}
+
+	return conn, nil
+}
+
+// watchInterfaces signals interface changes on the returned channel, which is
+// closed once conn is. Bursts of changes are coalesced into a single signal.
+func watchInterfaces(conn *netlink.Conn, logger *slog.Logger) <-chan struct{} {
+	events := make(chan struct{}, 1)
+	signal := func() {
+		select {
+		case events <- struct{}{}:
+		default:
+		}
+	}
+
+	go func() {
+		defer close(events)
+		for {
+			msgs, err := conn.Receive()
+			if err != nil {
+				// We've missed notifications because the socket's buffer filled up: just reconcile
+				if errors.Is(err, unix.ENOBUFS) {
+					logger.Warn("missed interface notifications")
+					signal()
+					continue
+				}
+
+				// Closing the socket interrupts the ongoing read
+				if !errors.Is(err, os.ErrClosed) && !errors.Is(err, unix.EBADF) {
+					logger.Error("error receiving interface notifications", "err", err)
+				}
+				return
+			}
+
+			for _, msg := range msgs {
+				logger.Debug("got an interface notification", "type", msg.Header.Type)
+			}
+			signal()
+		}
+	}()
+
+	return events
+}
+
+// syncInterfaces attaches the program to the target interfaces it's not attached
+// to yet and detaches it from those no longer targeted. Interfaces recreated under
+// the same name are told apart by their index.
+func (b *MarkerBackend) syncInterfaces() error {
+	targets, err := b.targetInterfaces()
+	if err != nil {
+		return err
+	}
+
+	for iface, index := range b.attached {
+		if targets[iface] != index {
+			b.detach(iface)
+		}
+	}
+
+	errs := []error{}
+	for iface, index := range targets {
+		if _, ok := b.attached[iface]; ok {
+			continue
+		}
+
+		if err := b.attach(iface); err != nil {
+			errs = append(errs, fmt.Errorf("error attaching the eBPF program to %q: %w", iface, err))
+			continue
+		}
+		b.attached[iface] = index
+		b.logger.Info("attached the eBPF program", "interface", iface)
+	}
+
+	return errors.Join(errs...)
+}
+
+// detach undoes attach. Bear in mind the interface might be long gone, in
+// which case the kernel has already taken care of everything for us.
+func (b *MarkerBackend) detach(iface string) {
+	delete(b.attached, iface)
+	b.logger.Info("detaching the eBPF program", "interface", iface)
+
+	if l, ok := b.links[iface]; ok {
+		// Pinned links would otherwise keep the program attached
+		if err := l.Unpin(); err != nil {
+			b.logger.Warn("error unpinning the tcx link", "interface", iface, "err", err)
+		}
+		if err := l.Close(); err != nil {
+			b.logger.Warn("error closing the tcx link", "interface", iface, "err", err)
+		}
+		delete(b.links, iface)
+		return
+	}
+
+	if _, err := net.InterfaceByName(iface); err == nil {
+		if err := b.nl.RemoveFilterQdisc(iface); err != nil {
+			b.logger.Warn("error removing qdisc", "interface", iface, "err", err)
+		}
+	}
+	b.nl.Forget(iface)
+}
@@ -4,7 +4,6 @@ package marker
 
 import (
 	"fmt"
-	"log/slog"
 	"net/netip"
 )
 
@@ -44,7 +43,7 @@ func (b *MarkerBackend) genFlowTag(experimentId, activityId uint32) uint32 {
 
 	var flowTag uint32 = (rNum & (0x3 << 18)) | ((experimentIdRev & 0x1FF) << 9) | (rNum & (0x1 << 8)) | ((activityId & 0x3F) << 2) | (rNum & 0x3)
 
-	slog.Debug("genFlowTag", "experimentId", fmt.Sprintf("%b", experimentId), "experimentIdRev", fmt.Sprintf("%b", experimentIdRev),
+	b.logger.Debug("genFlowTag", "experimentId", fmt.Sprintf("%b", experimentId), "experimentIdRev", fmt.Sprintf("%b", experimentIdRev),
 		"activityId", fmt.Sprintf("%b", activityId), "flowTag", fmt.Sprintf("%b", flowTag))
 
 	return flowTag
@@ -3,9 +3,13 @@
 package marker
 
 import (
+	"bytes"
+	"encoding/binary"
 	"net"
 	"net/netip"
 	"testing"
+
+	glowdTypes "github.com/scitags/flowd-go/types"
 )
 
 func extractHalvesOrig(ip net.IP) (uint64, uint64) {
@@ -41,3 +45,40 @@ func TestExtractHalves(t *testing.T) {
 		}
 	}
 }
+
+// TestFlowFourTupleLayout checks keys are laid out just like the eBPF program's
+// struct fourTuple, which is 24 bytes long with the protocol at offset 20.
+func TestFlowFourTupleLayout(t *testing.T) {
+	encode := func(k FlowFourTuple) []byte {
+		buf := bytes.Buffer{}
+		if err := binary.Write(&buf, binary.NativeEndian, k); err != nil {
+			t.Fatalf("error encoding the key: %v", err)
+		}
+		return buf.Bytes()
+	}
+
+	// The match-all flowID signalled by the perfsonar plugin
+	matchAll := glowdTypes.FlowID{
+		State:  glowdTypes.START,
+		Family: glowdTypes.IPv6,
+		Src:    netip.AddrPortFrom(netip.IPv6Unspecified(), 0),
+		Dst:    netip.AddrPortFrom(netip.IPv6Unspecified(), 0),
+	}
+	if raw := encode(newFlowFourTuple(matchAll)); !bytes.Equal(raw, make([]byte, 24)) {
+		t.Errorf("the match-all key isn't all zeros: %x", raw)
+	}
+
+	flowID := glowdTypes.FlowID{
+		Protocol: glowdTypes.UDP,
+		Family:   glowdTypes.IPv6,
+		Src:      netip.MustParseAddrPort("[2001:db8::1]:2345"),
+		Dst:      netip.MustParseAddrPort("[2001:db8::2]:5777"),
+	}
+	raw := encode(newFlowFourTuple(flowID))
+	if len(raw) != 24 {
+		t.Fatalf("got a %d byte key, want 24 bytes", len(raw))
+	}
+	if raw[20] != 17 || binary.NativeEndian.Uint16(raw[16:]) != 5777 || binary.NativeEndian.Uint16(raw[18:]) != 2345 {
+		t.Errorf("wrong key layout: %x", raw)
+	}
+}
@@ -1,9 +1,18 @@
 package prometheus
 
 import (
+	"fmt"
+
 	"github.com/goccy/go-yaml"
+	"github.com/scitags/flowd-go/types"
 )
 
+func init() {
+	types.RegisterBackend("prometheus", func(name string, c any) (types.Backend, error) {
+		return NewPrometheusBackend(name, c.(*Config))
+	}, types.YAMLDecoder[Config]())
+}
+
 type Config struct {
 	Log         bool   `yaml:"log"`
 	BindAddress string `yaml:"bindAddress"`
@@ -30,3 +39,11 @@ func (c *Config) UnmarshalYAML(b []byte) error {
 
 	return nil
 }
+
+// Validate implements types.Validator.
+func (c *Config) Validate() error {
+	if c.NetlinkPort != 0 && c.NetlinkPort == c.SkopsPort {
+		return fmt.Errorf("the netlink and skops metrics can't be served on the same port %d", c.NetlinkPort)
+	}
+	return nil
+}
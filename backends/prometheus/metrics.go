@@ -3,6 +3,7 @@ package prometheus
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"reflect"
 	"strconv"
 
@@ -12,14 +13,17 @@ import (
 
 // Metric labels (note these are **always** strings):
 //
-//	act: activity id and/or name
-//	exp: experiment id and/or name
+//	act: activity id
+//	exp: experiment id
+//	act_name: activity name as defined in the SciTags registry (empty if unknown)
+//	exp_name: experiment name as defined in the SciTags registry (empty if unknown)
 //	src: source IPv{4,6} address
 //	dst: destination IPv{4,6} address
 //	flow: source and destination ports formatted as <src:dst>
+//	proto: transport protocol (i.e. tcp or udp)
 //
 // We haven't included the 'opts' label which would include used TCP/IP options
-var baseLabels = []string{"act", "exp", "src", "dst", "flow", "flavour"}
+var baseLabels = []string{"act", "exp", "act_name", "exp_name", "src", "dst", "flow", "proto", "flavour"}
 
 // TODO: Add skmem_* to skOps-gathered structs!
 // TODO: flow_tcp_skmem_rmem_alloc
@@ -241,24 +245,37 @@ func (m *metrics) register(req prometheus.Registerer) error {
 			return fmt.Errorf("error registering index %d: %w", i, err)
 		}
 	}
-	logger.Log(context.Background(), types.LevelTrace, "registered collectors", "i", i)
+	slog.Log(context.Background(), types.LevelTrace, "registered collectors", "i", i)
 
 	return nil
 }
 
 func (m *metrics) newLabels(f types.FlowID, t types.Flavour) prometheus.Labels {
+	r := types.CurrentRegistry()
+	actName, _ := r.ActivityName(f.Experiment, f.Activity)
+	expName, _ := r.ExperimentName(f.Experiment)
+
 	return prometheus.Labels{
-		"act":     strconv.FormatUint(uint64(f.Activity), 10),
-		"exp":     strconv.FormatUint(uint64(f.Experiment), 10),
-		"src":     f.Src.Addr().String(),
-		"dst":     f.Dst.Addr().String(),
-		"flow":    fmt.Sprintf("<%d:%d>", f.Src.Port, f.Dst.Port),
-		"flavour": t.String(),
+		"act":      strconv.FormatUint(uint64(f.Activity), 10),
+		"exp":      strconv.FormatUint(uint64(f.Experiment), 10),
+		"act_name": actName,
+		"exp_name": expName,
+		"src":      f.Src.Addr().String(),
+		"dst":      f.Dst.Addr().String(),
+		"flow":     fmt.Sprintf("<%d:%d>", f.Src.Port, f.Dst.Port),
+		"proto":    f.Protocol.String(),
+		"flavour":  t.String(),
 	}
 }
 
 // Maybe capture labels in a closure?
 func (m *metrics) update(labels prometheus.Labels, fi *types.FlowInfo) {
+	// Only TCP sockets carry the information we export: there's
+	// nothing to update for UDP flows.
+	if fi.TCPInfo == nil {
+		return
+	}
+
 	m.Retrans.With(labels).Add(float64(fi.TCPInfo.Retrans))
 
 	m.Rto.With(labels).Set(float64(fi.TCPInfo.Rto))
@@ -302,6 +319,10 @@ func (m *metrics) update(labels prometheus.Labels, fi *types.FlowInfo) {
 
 	m.SndWnd.With(labels).Set(float64(fi.TCPInfo.Snd_wnd))
 
+	if fi.Cong == nil {
+		return
+	}
+
 	// Embed additional CA information in the label
 	// Note the underlying map is shared by all With() calls; if
 	// we swap it from underneath them we'll run into cardinality
@@ -13,34 +13,48 @@ import (
 	"github.com/scitags/flowd-go/types"
 )
 
-var logger *slog.Logger
-
 type PrometheusBackend struct {
 	Config
 
+	name   string
+	logger *slog.Logger
+
 	m       map[types.Flavour]*metrics
 	servers []*http.Server
 }
 
 func (b *PrometheusBackend) String() string {
-	return "Prometheus"
+	return "Prometheus (" + b.name + ")"
+}
+
+// Flavours implements types.EnrichmentConsumer: we're only interested in the
+// flavours we're exposing metrics for.
+func (b *PrometheusBackend) Flavours() []types.Flavour {
+	flavours := []types.Flavour{}
+	if b.SkopsPort != 0 {
+		flavours = append(flavours, types.Ebpf)
+	}
+	if b.NetlinkPort != 0 {
+		flavours = append(flavours, types.Netlink)
+	}
+	return flavours
 }
 
-func NewPrometheusBackend(c *Config) (*PrometheusBackend, error) {
+func NewPrometheusBackend(name string, c *Config) (*PrometheusBackend, error) {
+	b := PrometheusBackend{Config: *c, name: name}
+
 	if c.Log {
-		logger = slog.Default().With("t", "prometheus")
+		b.logger = slog.Default().With(types.LogKeyBackend, name)
 	} else {
-		logger = slog.New(slog.DiscardHandler)
+		b.logger = slog.New(slog.DiscardHandler)
 	}
 
-	logger.Debug("initialising the prometheus backend")
-
-	b := PrometheusBackend{Config: *c}
+	b.logger.Debug("initialising the prometheus backend")
 
 	b.m = map[types.Flavour]*metrics{}
 
 	if b.NetlinkPort == 0 && b.SkopsPort == 0 {
-		slog.Warn("both metric flavours are disabled")
+		b.logger.Warn("both metric flavours are disabled")
 	}
 
 	if b.NetlinkPort != 0 {
@@ -85,13 +99,13 @@ func NewPrometheusBackend(c *Config) (*PrometheusBackend, error) {
 }
 
 func (b *PrometheusBackend) Run(done <-chan struct{}, inChan <-chan types.FlowID) {
-	logger.Debug("running the prometheus backend")
+	b.logger.Debug("running the prometheus backend")
 
 	// Start the servers!
 	for _, server := range b.servers {
 		go func() {
 			if err := server.ListenAndServe(); err != nil {
-				logger.Info("stopped listening", "err", err)
+				b.logger.Info("stopped listening", "err", err)
 			}
 		}()
 	}
@@ -100,10 +114,10 @@ func (b *PrometheusBackend) Run(done <-chan struct{}, inChan <-chan types.FlowID
 		select {
 		case flowID, ok := <-inChan:
 			if !ok {
-				logger.Warn("somebody closed the input channel!")
+				b.logger.Warn("somebody closed the input channel!")
 				return
 			}
-			logger.Debug("got a flowID", "flowID", flowID)
+			b.logger.Debug("got a flowID", types.LogKeyFlow, flowID)
 
 			switch flowID.State {
 			case types.START:
@@ -115,14 +129,14 @@ func (b *PrometheusBackend) Run(done <-chan struct{}, inChan <-chan types.FlowID
 				}
 			}
 		case <-done:
-			logger.Debug("cleanly exiting the prometheus backend")
+			b.logger.Debug("cleanly exiting the prometheus backend")
 			return
 		}
 	}
 }
 
 func (b *PrometheusBackend) Cleanup() error {
-	logger.Debug("cleaning up the prometheus backend")
+	b.logger.Debug("cleaning up the prometheus backend")
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -138,7 +152,7 @@ func (b *PrometheusBackend) Cleanup() error {
 }
 
 func (b *PrometheusBackend) periodicUpdate(f types.FlowID, flavour types.Flavour, fic chan *types.FlowInfo) {
-	logger.Debug("starting periodic prometheus goroutine", "flowID", f, "flavour", flavour)
+	b.logger.Debug("starting periodic prometheus goroutine", types.LogKeyFlow, f, types.LogKeyFlavour, flavour)
 
 	labels := b.m[flavour].newLabels(f, flavour)
 
@@ -146,8 +160,8 @@ func (b *PrometheusBackend) periodicUpdate(f types.FlowID, flavour types.Flavour
 		b.m[flavour].update(labels, fi)
 	}
 
-	logger.Debug("removing metrics", "flowID", f, "flavour", flavour)
+	b.logger.Debug("removing metrics", types.LogKeyFlow, f, types.LogKeyFlavour, flavour)
 	b.m[flavour].delete(labels)
 
-	logger.Debug("exiting periodic prometheus goroutine", "flowID", f)
+	b.logger.Debug("exiting periodic prometheus goroutine", types.LogKeyFlow, f)
 }
@@ -3,49 +3,169 @@ package main
 import (
 	"fmt"
 	"log/slog"
+	"time"
 
-	"github.com/scitags/flowd-go/backends/fireflyb"
-	"github.com/scitags/flowd-go/backends/marker"
-	"github.com/scitags/flowd-go/backends/prometheus"
+	"github.com/scitags/flowd-go/internal/telemetry"
 	"github.com/scitags/flowd-go/types"
 )
 
-func createBackends(c *Config) ([]types.Backend, error) {
-	backends := []types.Backend{}
+// How long to wait for a backend to stop before cleaning it up regardless.
+const backendStopTimeout = 5 * time.Second
 
-	if c.Backends != nil {
-		if c.Backends.Marker != nil {
-			b, err := marker.NewMarkerBackend(c.Backends.Marker)
-			if err != nil {
-				return nil, fmt.Errorf("error initialising the marker backend: %w", err)
-			}
-			backends = append(backends, b)
-		}
+// backendHandle ties a running backend to the configuration it was created
+// with and the channel it's reading flowIDs from so that it can be stopped
+// on its own when reloading the configuration.
+type backendHandle struct {
+	name    string
+	conf    *instance
+	backend types.Backend
 
-		if c.Backends.Firefly != nil {
-			b, err := fireflyb.NewFireflyBackend(c.Backends.Firefly)
-			if err != nil {
-				return nil, fmt.Errorf("error initialising the firefly backend: %w", err)
-			}
-			backends = append(backends, b)
+	// Queue the backend receives flowIDs through.
+	queue *backendQueue
+
+	// Stops exposing the queue's metrics.
+	unregisterQueue func()
+
+	// Closed to signal the backend to stop. Enrichment fan-outs will
+	// stop sending information to the backend once it's closed too.
+	done chan struct{}
+
+	// Closed once the backend's Run returns, after which it can be
+	// safely cleaned up.
+	stopped chan struct{}
+}
+
+// matches checks whether a flowID goes to the backend given the flow's entry
+// on the flow table, if any. Filters are only matched against STARTs, which
+// carry the flow's context as the plugin signalled it: the decision is kept
+// on the entry so that the rest of the flow's flowIDs follow the START's.
+func (h *backendHandle) matches(flowID types.FlowID, e *flowEntry) bool {
+	if flowID.State != types.START {
+		if matched, ok := e.backendMatched(h.name); ok {
+			return matched
 		}
+		return h.conf.Filters.matches(flowID)
+	}
+
+	matched := h.conf.Filters.matches(flowID)
+	if e != nil {
+		e.setMatched(h.name, matched)
+	}
+	return matched
+}
+
+// run runs the backend together with the pump feeding it its queue.
+func (h *backendHandle) run() {
+	go h.queue.pump()
+	go func() {
+		defer close(h.stopped)
+		h.backend.Run(h.done, h.queue.ch)
+	}()
+}
+
+// backendConfs returns the configuration of every configured backend instance
+// keyed by the instance's name.
+func backendConfs(c *Config) map[string]*instance {
+	return c.Backends
+}
+
+func newBackend(name string, inst *instance) (types.Backend, error) {
+	r, ok := types.LookupBackend(inst.Type)
+	if !ok {
+		return nil, fmt.Errorf("unknown backend %q", inst.Type)
+	}
+
+	if err := validateInstance(inst); err != nil {
+		return nil, fmt.Errorf("wrong configuration for the %s backend %q: %w", inst.Type, name, err)
+	}
 
-		if c.Backends.Prometheus != nil {
-			b, err := prometheus.NewPrometheusBackend(c.Backends.Prometheus)
-			if err != nil {
-				return nil, fmt.Errorf("error initialising the prometheus backend: %w", err)
+	b, err := r.Factory(name, inst.Config)
+	if err != nil {
+		return nil, fmt.Errorf("error initialising the %s backend %q: %w", inst.Type, name, err)
+	}
+
+	return b, nil
+}
+
+// startBackend creates and runs a backend. Every currently active flow is
+// replayed to it as a START (flagged as Replayed) so that it can pick up from
+// where its previous incarnation (if any) left off. Replayed flows carry the enrichment of
+// the enrichers that were already watching them and the flow tags the
+// previous incarnation had assigned.
+func (d *daemon) startBackend(name string, conf *instance) error {
+	b, err := newBackend(name, conf)
+	if err != nil {
+		return err
+	}
+
+	// The overflow policy has already been validated when parsing the configuration
+	policy, _ := parseOverflowPolicy(conf.OverflowPolicy)
+
+	h := &backendHandle{
+		name:    name,
+		conf:    conf,
+		backend: b,
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	h.queue = newBackendQueue(name, conf.QueueSize, policy, h.done)
+	h.unregisterQueue = telemetry.RegisterQueue(name,
+		func() float64 { return float64(h.queue.depth()) },
+		func() float64 { return float64(h.queue.drops()) },
+		func() float64 { return float64(h.queue.lifecycleDrops()) },
+	)
+
+	h.run()
+
+	if d.flows.len() > 0 {
+		slog.Info("replaying active flows", types.LogKeyBackend, name, "nFlows", d.flows.len())
+		for _, flowID := range d.flows.active() {
+			if !h.matches(flowID, d.flows.entry(flowID)) {
+				continue
 			}
-			backends = append(backends, b)
+			d.restoreTag(h, flowID)
+			flowID.Replayed = true
+			h.queue.push(subscribe(flowID, d.flows.fanOut(flowID), h))
 		}
 	}
 
-	return backends, nil
+	d.backends = append(d.backends, h)
+
+	return nil
 }
 
-func cleanupBackends(backends []types.Backend) {
-	for _, backend := range backends {
-		if err := backend.Cleanup(); err != nil {
-			slog.Error("error cleaning up backend", "backend", backend, "err", err)
+// stopBackend stops and cleans up the backend with the given name, if any.
+func (d *daemon) stopBackend(name string) {
+	for i, h := range d.backends {
+		if h.name != name {
+			continue
+		}
+
+		// Hold on to the flow tags so that a restarted backend reuses them
+		d.collectTags(h)
+
+		// Let the backend finish with the flowID at hand (if any) before
+		// cleaning it up: it could otherwise use what Cleanup releases.
+		close(h.done)
+		select {
+		case <-h.stopped:
+		case <-time.After(backendStopTimeout):
+			slog.Warn("backend didn't stop in time, cleaning it up anyway", types.LogKeyBackend, h.name,
+				"timeout", backendStopTimeout)
+		}
+
+		if err := h.backend.Cleanup(); err != nil {
+			slog.Error("error cleaning up backend", types.LogKeyBackend, h.name, "err", err)
+		}
+
+		if n := h.queue.drops(); n > 0 {
+			slog.Warn("backend dropped flowIDs while running", types.LogKeyBackend, name, "dropped", n)
 		}
+
+		h.unregisterQueue()
+		telemetry.ForgetBackend(name)
+
+		d.backends = append(d.backends[:i], d.backends[i+1:]...)
+		return
 	}
 }
@@ -0,0 +1,18 @@
+package main
+
+// Plugins, backends and enrichers register themselves when their packages are
+// imported. Wiring in an additional (i.e. out of tree) component is just a
+// matter of adding its package below or blank importing it in another file
+// of this package.
+import (
+	_ "github.com/scitags/flowd-go/backends/fireflyb"
+	_ "github.com/scitags/flowd-go/backends/marker"
+	_ "github.com/scitags/flowd-go/backends/prometheus"
+	_ "github.com/scitags/flowd-go/enrichment/netlink"
+	_ "github.com/scitags/flowd-go/enrichment/skops"
+	_ "github.com/scitags/flowd-go/plugins/api"
+	_ "github.com/scitags/flowd-go/plugins/fireflyp"
+	_ "github.com/scitags/flowd-go/plugins/iperf3"
+	_ "github.com/scitags/flowd-go/plugins/np"
+	_ "github.com/scitags/flowd-go/plugins/perfsonar"
+)
@@ -1,48 +1,343 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"log/slog"
 	"os"
+	"slices"
 
 	"github.com/goccy/go-yaml"
-	"github.com/scitags/flowd-go/backends/fireflyb"
+	"github.com/goccy/go-yaml/ast"
+	"github.com/goccy/go-yaml/parser"
 	"github.com/scitags/flowd-go/backends/marker"
-	"github.com/scitags/flowd-go/backends/prometheus"
-	"github.com/scitags/flowd-go/enrichment/netlink"
-	"github.com/scitags/flowd-go/enrichment/skops"
-	"github.com/scitags/flowd-go/plugins/api"
-	"github.com/scitags/flowd-go/plugins/fireflyp"
-	"github.com/scitags/flowd-go/plugins/iperf3"
-	"github.com/scitags/flowd-go/plugins/np"
-	"github.com/scitags/flowd-go/plugins/perfsonar"
+	"github.com/scitags/flowd-go/enrichment"
+	"github.com/scitags/flowd-go/internal/telemetry"
+	"github.com/scitags/flowd-go/types"
 )
 
 type Config struct {
 	PidPath string `yaml:"pidPath"`
 	WorkDir string `yaml:"workDir"`
 
-	Plugins *struct {
-		Np        *np.Config        `yaml:"namedPipe"`
-		Firefly   *fireflyp.Config  `yaml:"firefly"`
-		Api       *api.Config       `yaml:"api"`
-		Perfsonar *perfsonar.Config `yaml:"perfsonar"`
-		Iperf3    *iperf3.Config    `yaml:"iperf3"`
-	} `yaml:"plugins"`
+	// Time (in seconds) after which flows that haven't received an END are
+	// expired. A value of 0 disables flow expiry.
+	FlowTTL int `yaml:"flowTTL"`
 
-	Backends *struct {
-		Marker     *marker.Config     `yaml:"marker"`
-		Firefly    *fireflyb.Config   `yaml:"firefly"`
-		Prometheus *prometheus.Config `yaml:"prometheus"`
-	} `yaml:"backends"`
+	// Period (in seconds) with which active flows are saved to the working
+	// directory so that they can be restored after a restart. A value of 0
+	// disables both saving and restoring them.
+	SnapshotPeriod int `yaml:"snapshotPeriod"`
 
-	Enrichers *enrichers `yaml:"enrichers"`
+	// Where to serve flowd-go's own metrics. If nil they're not served.
+	Telemetry *telemetry.Config `yaml:"telemetry"`
+
+	// The SciTags registry experiment and activity names are resolved
+	// against. If nil only IDs are understood.
+	Registry *registryConf `yaml:"registry"`
+
+	Plugins   pluginsConf  `yaml:"plugins"`
+	Backends  backendsConf `yaml:"backends"`
+	Enrichers *enrichers   `yaml:"enrichers"`
+}
+
+// rawConf captures the raw YAML configuration of a component so that it can
+// be handed over to the decoder it was registered with.
+type rawConf []byte
+
+func (r *rawConf) UnmarshalYAML(b []byte) error {
+	*r = append((*r)[:0], b...)
+	return nil
+}
+
+// instance is a named instance of a plugin or backend.
+type instance struct {
+	// The name the plugin or backend was registered with.
+	Type string `yaml:"type"`
+
+	// Size and overflow policy of the dispatch queue feeding the instance.
+	// Only meaningful for backends.
+	QueueSize      int    `yaml:"queueSize,omitempty"`
+	OverflowPolicy string `yaml:"overflowPolicy,omitempty"`
+
+	// Selects the flowIDs the instance deals with.
+	Filters *flowFilter `yaml:"filters,omitempty"`
+
+	// The decoded configuration.
+	Config any `yaml:"config"`
+}
+
+// envelope holds those instance settings handled by flowd-go itself rather than
+// by the plugin or backend. Their keys are stripped from the configuration
+// before handing it over to the plugin's or backend's decoder.
+type envelope struct {
+	Name    string      `yaml:"name"`
+	Filters *flowFilter `yaml:"filters"`
+
+	// Backends only.
+	QueueSize      *int   `yaml:"queueSize"`
+	OverflowPolicy string `yaml:"overflowPolicy"`
+}
+
+var (
+	pluginEnvelopeKeys  = []string{"name", "filters"}
+	backendEnvelopeKeys = []string{"name", "filters", "queueSize", "overflowPolicy"}
+)
+
+const defaultQueueSize = 1000
+
+// pluginsConf holds the configuration of every plugin instance keyed by the
+// instance's name.
+type pluginsConf map[string]*instance
+
+func (pc *pluginsConf) UnmarshalYAML(b []byte) error {
+	instances, err := decodeInstances("plugin", pluginEnvelopeKeys, b, func(typ string) (types.ConfigDecoder, bool) {
+		r, ok := types.LookupPlugin(typ)
+		return r.Decode, ok
+	}, types.RegisteredPlugins)
+	if err != nil {
+		return err
+	}
+
+	*pc = instances
+	return nil
+}
+
+// backendsConf holds the configuration of every backend instance keyed by
+// the instance's name.
+type backendsConf map[string]*instance
+
+func (bc *backendsConf) UnmarshalYAML(b []byte) error {
+	instances, err := decodeInstances("backend", backendEnvelopeKeys, b, func(typ string) (types.ConfigDecoder, bool) {
+		r, ok := types.LookupBackend(typ)
+		return r.Decode, ok
+	}, types.RegisteredBackends)
+	if err != nil {
+		return err
+	}
+
+	*bc = instances
+	return nil
+}
+
+// decodeInstances decodes the plugins or backends section of the configuration.
+// Its keys are the names plugins and backends are registered with and the values
+// configure their instances. Check splitInstances for the available layouts.
+func decodeInstances(kind string, envelopeKeys []string, b []byte, lookup func(string) (types.ConfigDecoder, bool), available func() []string) (map[string]*instance, error) {
+	raws := map[string]rawConf{}
+	if err := yaml.Unmarshal(b, &raws); err != nil {
+		return nil, err
+	}
+
+	instances := map[string]*instance{}
+	for _, typ := range sortedKeys(raws) {
+		decode, ok := lookup(typ)
+		if !ok {
+			slog.Warn("ignoring unknown "+kind, "name", typ, "available", available())
+			continue
+		}
+
+		rawInstances, err := splitInstances(typ, raws[typ], envelopeKeys)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing the instances of the %s %s: %w", typ, kind, err)
+		}
+
+		for _, ri := range rawInstances {
+			if other, ok := instances[ri.Name]; ok {
+				return nil, fmt.Errorf("%s instance name %q is used by both a %s and a %s", kind, ri.Name, other.Type, typ)
+			}
+
+			inst, err := newInstance(kind, typ, ri, decode)
+			if err != nil {
+				return nil, err
+			}
+
+			instances[ri.Name] = inst
+		}
+	}
+
+	return instances, nil
+}
+
+// newInstance decodes the configuration of a single instance of a plugin or
+// backend applying the defaults of the settings in its envelope.
+func newInstance(kind, typ string, ri rawInstance, decode types.ConfigDecoder) (*instance, error) {
+	c, err := decode(ri.raw)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding the configuration of the %s %s: %w", ri.Name, kind, err)
+	}
+
+	inst := &instance{Type: typ, Filters: ri.Filters, Config: c}
+	if kind == "backend" {
+		inst.QueueSize = defaultQueueSize
+		if ri.QueueSize != nil {
+			// Unbuffered queues would make the dropping policies spin
+			if *ri.QueueSize < 1 {
+				return nil, fmt.Errorf("the queue size of the %s backend must be positive, got %d", ri.Name, *ri.QueueSize)
+			}
+			inst.QueueSize = *ri.QueueSize
+		}
+
+		inst.OverflowPolicy = block.String()
+		if ri.OverflowPolicy != "" {
+			if _, ok := parseOverflowPolicy(ri.OverflowPolicy); !ok {
+				return nil, fmt.Errorf("wrong overflow policy %q for the %s backend", ri.OverflowPolicy, ri.Name)
+			}
+			inst.OverflowPolicy = ri.OverflowPolicy
+		}
+	}
+
+	return inst, nil
 }
 
+// rawInstance holds the raw configuration of a single instance.
+type rawInstance struct {
+	envelope
+	raw []byte
+}
+
+// splitInstances splits the raw configuration of a plugin or backend into that
+// of each of its instances. A mapping configures a single instance named after
+// the plugin or backend itself unless a name key is provided, whilst a sequence
+// of mappings configures one instance per item. Each of these must be named
+// through the name key. Envelope keys are always stripped before handing the
+// configuration over to the decoder. Null configurations (i.e. `plugin:`)
+// configure no instances at all.
+func splitInstances(typ string, raw []byte, envelopeKeys []string) ([]rawInstance, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+
+	f, err := parser.ParseBytes(raw, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(f.Docs) == 0 {
+		return nil, nil
+	}
+
+	seq, ok := f.Docs[0].Body.(*ast.SequenceNode)
+	if !ok {
+		env := envelope{}
+		if err := yaml.NodeToValue(f.Docs[0].Body, &env); err != nil {
+			return nil, err
+		}
+		if env.Name == "" {
+			env.Name = typ
+		}
+		return []rawInstance{{envelope: env, raw: stripKeys(f.Docs[0].Body, envelopeKeys)}}, nil
+	}
+
+	instances := make([]rawInstance, 0, len(seq.Values))
+	for i, item := range seq.Values {
+		env := envelope{}
+		if err := yaml.NodeToValue(item, &env); err != nil {
+			return nil, fmt.Errorf("instance #%d is not a mapping: %w", i, err)
+		}
+
+		if env.Name == "" {
+			return nil, fmt.Errorf("instance #%d has no name", i)
+		}
+
+		instances = append(instances, rawInstance{envelope: env, raw: stripKeys(item, envelopeKeys)})
+	}
+
+	return instances, nil
+}
+
+// stripKeys returns the raw YAML of a mapping without the given keys.
+func stripKeys(node ast.Node, keys []string) []byte {
+	switch n := node.(type) {
+	case *ast.MappingValueNode:
+		if slices.Contains(keys, n.Key.String()) {
+			return []byte("{}")
+		}
+
+	case *ast.MappingNode:
+		values := make([]*ast.MappingValueNode, 0, len(n.Values))
+		for _, v := range n.Values {
+			if !slices.Contains(keys, v.Key.String()) {
+				values = append(values, v)
+			}
+		}
+		if len(values) == 0 {
+			return []byte("{}")
+		}
+		n.Values = values
+	}
+
+	return []byte(node.String())
+}
+
+// enrichers holds the decoded configuration of every enricher keyed by the
+// name the enricher was registered with (see enrichment.RegisterEnricher)
+// together with the enrichment period shared by all of them.
 type enrichers struct {
-	Period  *int            `yaml:"period"`
-	Netlink *netlink.Config `yaml:"netlink"`
-	SkOps   *skops.Config   `yaml:"skops"`
+	Period    *int
+	Enrichers map[string]any
+}
+
+func (e *enrichers) UnmarshalYAML(b []byte) error {
+	raws := map[string]rawConf{}
+	if err := yaml.Unmarshal(b, &raws); err != nil {
+		return err
+	}
+
+	period := 1000
+	if raw, ok := raws["period"]; ok {
+		if err := yaml.Unmarshal(raw, &period); err != nil {
+			return fmt.Errorf("error decoding the enrichment period: %w", err)
+		}
+		delete(raws, "period")
+	}
+
+	*e = enrichers{Period: &period, Enrichers: map[string]any{}}
+
+	flavours := map[types.Flavour]string{}
+	for name, raw := range raws {
+		r, ok := enrichment.LookupEnricher(name)
+		if !ok {
+			slog.Warn("ignoring unknown enricher", "name", name, "available", enrichment.RegisteredEnrichers())
+			continue
+		}
+
+		// Null configurations (i.e. `enricher:`) leave the enricher disabled
+		if len(raw) == 0 {
+			continue
+		}
+
+		if other, ok := flavours[r.Flavour]; ok {
+			return fmt.Errorf("enrichers %s and %s provide the same flavour of information", other, name)
+		}
+		flavours[r.Flavour] = name
+
+		c, err := r.Decode(raw, period)
+		if err != nil {
+			return fmt.Errorf("error decoding the configuration of the %s enricher: %w", name, err)
+		}
+		e.Enrichers[name] = c
+	}
+
+	return nil
+}
+
+// flatten lays the enrichers out just like they're configured.
+func (e enrichers) flatten() map[string]any {
+	flat := make(map[string]any, len(e.Enrichers)+1)
+	for name, c := range e.Enrichers {
+		flat[name] = c
+	}
+	flat["period"] = e.Period
+	return flat
+}
+
+func (e enrichers) MarshalYAML() (any, error) {
+	return e.flatten(), nil
+}
+
+func (e enrichers) MarshalJSON() ([]byte, error) {
+	return json.Marshal(e.flatten())
 }
 
 func (c Config) String() string {
@@ -60,34 +355,20 @@ func (c *Config) UnmarshalYAML(b []byte) error {
 	def := &config{
 		PidPath: "/var/run/flowd-go.pid",
 		WorkDir: "/var/cache/flowd-go",
+
+		SnapshotPeriod: 30,
 	}
 
 	if err := yaml.Unmarshal(b, def); err != nil {
 		return err
 	}
 
-	if def.Enrichers != nil {
-		if def.Enrichers.Period == nil {
-			f := 1000
-			def.Enrichers.Period = &f
-		}
-		if def.Enrichers.SkOps != nil {
-			if def.Enrichers.SkOps.RawStrategy == "" {
-				def.Enrichers.SkOps.RawStrategy = skops.DefaultConfig.Strategy.String()
-			}
-
-			s, ok := skops.ParseStrategy(def.Enrichers.SkOps.RawStrategy)
-			if !ok {
-				return fmt.Errorf("wrong enrichment strategy %q", def.Enrichers.SkOps.RawStrategy)
-			}
-			def.Enrichers.SkOps.Strategy = s
-
-			def.Enrichers.SkOps.PollingInterval = uint64(*def.Enrichers.Period) * skops.NS_PER_MS
-		}
+	if def.FlowTTL < 0 {
+		return fmt.Errorf("the flow TTL can't be negative, got %d", def.FlowTTL)
+	}
 
-		if def.Enrichers.Netlink != nil {
-			def.Enrichers.Netlink.Period = *def.Enrichers.Period
-		}
+	if def.SnapshotPeriod < 0 {
+		return fmt.Errorf("the snapshot period can't be negative, got %d", def.SnapshotPeriod)
 	}
 
 	*c = Config(*def)
@@ -97,23 +378,33 @@ func (c *Config) UnmarshalYAML(b []byte) error {
 
 // Are there any plugin-backend dependencies we should be aware of?
 func pluginBackendDependencies(c *Config) {
-	if c.Plugins == nil || c.Backends == nil {
-		return
-	}
-
 	// If using the perfsonar plugin, override the marking strategy
-	if c.Plugins.Perfsonar != nil {
-		if c.Backends.Marker != nil {
-			slog.Warn("overriding marking criteria to match all for the marker backend")
-			c.Backends.Marker.MatchAll = true
+	for _, p := range c.Plugins {
+		if p.Type != "perfsonar" {
+			continue
+		}
+
+		for name, b := range c.Backends {
+			if mc, ok := b.Config.(*marker.Config); ok {
+				slog.Warn("overriding marking criteria to match all for the marker backend", types.LogKeyBackend, name)
+				mc.MatchAll = true
+			}
 		}
+		return
 	}
 }
 
+// ReadConf reads the configuration at path layering the drop-in directory and
+// the environment on top of it. Check loadLayeredConf for the details.
 func ReadConf(path string) (*Config, error) {
-	r, err := os.ReadFile(path)
+	lc, err := loadLayeredConf(path, dropInDir(path), os.Environ())
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := lc.marshal()
 	if err != nil {
-		return nil, fmt.Errorf("error reading the configuration file: %w", err)
+		return nil, fmt.Errorf("error marshaling the layered configuration: %w", err)
 	}
 
 	conf := Config{}
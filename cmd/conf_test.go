@@ -2,11 +2,15 @@ package main
 
 import (
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/scitags/flowd-go/backends/fireflyb"
 	"github.com/scitags/flowd-go/enrichment/netlink"
 	"github.com/scitags/flowd-go/enrichment/skops"
+	"github.com/scitags/flowd-go/plugins/api"
+	"github.com/scitags/flowd-go/plugins/np"
 )
 
 func TestYAMLAndJSON(t *testing.T) {
@@ -95,12 +99,14 @@ func TestEnrichment(t *testing.T) {
 			t.Fatalf("got no want for %q", f.Name())
 		}
 
-		if !cmp.Equal(got.Enrichers.SkOps, want.s) {
-			t.Fatalf("%s: got %v; want %v for skops", f.Name(), got.Enrichers.SkOps, want.s)
+		gotS, _ := got.Enrichers.Enrichers["skops"].(*skops.Config)
+		if !cmp.Equal(gotS, want.s) {
+			t.Fatalf("%s: got %v; want %v for skops", f.Name(), gotS, want.s)
 		}
 
-		if !cmp.Equal(got.Enrichers.Netlink, want.n) {
-			t.Fatalf("%s: got %v; want %v for netlink", f.Name(), got.Enrichers.Netlink, want.n)
+		gotN, _ := got.Enrichers.Enrichers["netlink"].(*netlink.Config)
+		if !cmp.Equal(gotN, want.n) {
+			t.Fatalf("%s: got %v; want %v for netlink", f.Name(), gotN, want.n)
 		}
 
 		if *got.Enrichers.Period != want.p {
@@ -119,3 +125,91 @@ func TestNoEnrichment(t *testing.T) {
 		t.Errorf("got %v; want nil", got.Enrichers)
 	}
 }
+
+func TestInstances(t *testing.T) {
+	got, err := ReadConf("testdata/instances.yaml")
+	if err != nil {
+		t.Fatalf("error parsing instances.yaml: %v", err)
+	}
+
+	t.Logf("\n%s", got)
+
+	wantPlugins := map[string]*instance{
+		"namedPipe": {Type: "namedPipe", Config: &np.Config{MaxReaders: 5, BuffSize: 1000, PipePath: "np"}},
+		"localApi":  {Type: "api", Config: &api.Config{BindAddress: "127.0.0.1", BindPort: 7777}},
+		"publicApi": {Type: "api", Config: &api.Config{BindAddress: "::", BindPort: 7778}},
+	}
+	if diff := cmp.Diff(wantPlugins, map[string]*instance(got.Plugins)); diff != "" {
+		t.Errorf("wrong plugin instances (-want +got):\n%s", diff)
+	}
+
+	wantBackends := map[string]struct {
+		typ              string
+		queueSize        int
+		overflowPolicy   string
+		collectorAddress string
+		enrichmentMode   string
+	}{
+		"metrics": {typ: "prometheus", queueSize: defaultQueueSize, overflowPolicy: "block"},
+		"marker":  {typ: "marker", queueSize: 10, overflowPolicy: "dropOldest"},
+		"site":    {typ: "firefly", queueSize: defaultQueueSize, overflowPolicy: "block", collectorAddress: "192.168.0.1", enrichmentMode: "lean"},
+		"wlcg":    {typ: "firefly", queueSize: defaultQueueSize, overflowPolicy: "block", collectorAddress: "2001:db8::1", enrichmentMode: "compatible"},
+	}
+	if len(got.Backends) != len(wantBackends) {
+		t.Fatalf("got %d backend instances; want %d", len(got.Backends), len(wantBackends))
+	}
+	for name, want := range wantBackends {
+		inst, ok := got.Backends[name]
+		if !ok {
+			t.Fatalf("missing backend instance %q", name)
+		}
+
+		if inst.Type != want.typ {
+			t.Errorf("%s: got type %q; want %q", name, inst.Type, want.typ)
+		}
+
+		if inst.QueueSize != want.queueSize || inst.OverflowPolicy != want.overflowPolicy {
+			t.Errorf("%s: got a queue of %d with policy %q; want %d and %q", name, inst.QueueSize,
+				inst.OverflowPolicy, want.queueSize, want.overflowPolicy)
+		}
+
+		if c, ok := inst.Config.(*fireflyb.Config); ok {
+			if c.CollectorAddress != want.collectorAddress || c.EnrichmentMode != want.enrichmentMode {
+				t.Errorf("%s: got %q and %q; want %q and %q", name, c.CollectorAddress, c.EnrichmentMode,
+					want.collectorAddress, want.enrichmentMode)
+			}
+		}
+	}
+}
+
+func TestInstancesErrors(t *testing.T) {
+	tests := map[string]string{
+		"unnamed":   "- bindPort: 1234\n",
+		"scalar":    "- foo\n",
+		"duplicate": "- name: a\n- name: a\n",
+	}
+
+	for name, raw := range tests {
+		pc := pluginsConf{}
+		if err := pc.UnmarshalYAML([]byte("api:\n" + indent(raw))); err == nil {
+			t.Errorf("%s: expected an error but got %v", name, pc)
+		}
+	}
+
+	backendTests := map[string]string{
+		"negativeQueue": "queueSize: -1\n",
+		"zeroQueue":     "queueSize: 0\n",
+		"wrongPolicy":   "overflowPolicy: dropAll\n",
+	}
+
+	for name, raw := range backendTests {
+		bc := backendsConf{}
+		if err := bc.UnmarshalYAML([]byte("prometheus:\n" + indent(raw))); err == nil {
+			t.Errorf("%s: expected an error but got %v", name, bc)
+		}
+	}
+}
+
+func indent(s string) string {
+	return "  " + strings.ReplaceAll(strings.TrimSuffix(s, "\n"), "\n", "\n  ") + "\n"
+}
@@ -5,110 +5,111 @@ import (
 	"log/slog"
 
 	"github.com/scitags/flowd-go/enrichment"
-	"github.com/scitags/flowd-go/enrichment/netlink"
-	"github.com/scitags/flowd-go/enrichment/skops"
 	"github.com/scitags/flowd-go/types"
 )
 
-func createEnrichers(c *Config) (map[types.Flavour]enrichment.Enricher, error) {
-	enrichers := map[types.Flavour]enrichment.Enricher{}
+// enricherHandle ties a running enricher to the configuration it was created
+// with so that it can be stopped on its own when reloading the configuration.
+type enricherHandle struct {
+	name     string
+	flavour  types.Flavour
+	conf     any
+	enricher enrichment.Enricher
+
+	// Closed to signal the enricher to stop.
+	done chan struct{}
+}
+
+// enricherConfs returns the configuration of every configured enricher keyed
+// by the enricher's name. Bear in mind the enrichment period is already folded
+// into each of these configurations.
+func enricherConfs(c *Config) map[string]any {
 	if c.Enrichers == nil {
-		return enrichers, nil
+		return map[string]any{}
 	}
 
-	if c.Enrichers.SkOps != nil {
-		slog.Debug("initialising the skOps enricher")
+	return c.Enrichers.Enrichers
+}
 
-		enricher, err := skops.NewEnricher(c.Enrichers.SkOps)
-		if err != nil {
-			return nil, fmt.Errorf("couldn't get an eBPF enricher: %w", err)
-		}
+// startEnricher creates and runs an enricher. Flows which are already active
+// won't be enriched by it: they were handed to the backends before it existed.
+func (d *daemon) startEnricher(name string, conf any) error {
+	r, ok := enrichment.LookupEnricher(name)
+	if !ok {
+		return fmt.Errorf("unknown enricher %q", name)
+	}
 
-		enrichers[types.Ebpf] = enricher
+	if err := validateConfig(conf); err != nil {
+		return fmt.Errorf("wrong configuration for the %s enricher: %w", name, err)
 	}
 
-	if c.Enrichers.Netlink != nil {
-		slog.Debug("initialising the netlink enricher")
+	slog.Debug("initialising enricher", "name", name)
+	e, err := r.Factory(conf)
+	if err != nil {
+		return fmt.Errorf("couldn't get a %s enricher: %w", name, err)
+	}
 
-		enricher, err := netlink.NewEnricher(c.Enrichers.Netlink)
-		if err != nil {
-			return nil, fmt.Errorf("couldn't get a netlink enricher: %w", err)
-		}
+	h := &enricherHandle{name: name, flavour: r.Flavour, conf: conf, enricher: e, done: make(chan struct{})}
+	go e.Run(h.done)
 
-		enrichers[types.Netlink] = enricher
-	}
+	d.enrichers[name] = h
 
-	return enrichers, nil
+	return nil
 }
 
-func cleanupEnrichers(ee map[types.Flavour]enrichment.Enricher) {
-	for t, e := range ee {
-		slog.Debug("cleaning enricher", "type", t)
-		if err := e.Cleanup(); err != nil {
-			slog.Warn("error cleaning up enricher", "type", t, "err", err)
+// stopEnricher stops and cleans up the enricher with the given name, if any.
+// Every active flow is forgotten beforehand so that the enrichment fan-outs
+// feeding on it are wound down and backends learn no more information will
+// come their way.
+func (d *daemon) stopEnricher(name string) {
+	h, ok := d.enrichers[name]
+	if !ok {
+		return
+	}
+
+	for _, flowID := range d.flows.active() {
+		if enrichment.Supports(h.enricher, flowID.Protocol) {
+			h.enricher.ForgetFlow(flowID)
 		}
 	}
+
+	close(h.done)
+	slog.Debug("cleaning enricher", "name", name)
+	if err := h.enricher.Cleanup(); err != nil {
+		slog.Warn("error cleaning up enricher", "name", name, "err", err)
+	}
+
+	delete(d.enrichers, name)
 }
 
-// broadcastEnrichment broadcasts enrichment information to the various available
-// backends. Information is received through the several sourceChannels and is
-// then dispatched to the dispatchChannels. This allows for the independent treatment
-// of the flow information by the various backends. Given the concurrent nature of
-// the design, it's paramount that:
-//
-//  1. Once a source channel is closed **every** associated dispatch channel (i.e. with the
-//     same types.Flavour) should be closed as well. The source channel is then set to nil
-//     to signal no more data will be received on that channel anymore.
-//
-//  2. Every backend should read its associated dispatch channel even if it is not
-//     leveraging the information for anything. This greatly simplifies setting up the
-//     broadcast infrastructure, but requires that we be careful to avoid blocking when
-//     sending information on each dispatch channel.
-//
-// Be careful: this function's a big-time offender when it comes to deadlocks!
-func broadcastEnrichment(sourceChannels map[types.Flavour]chan *types.FlowInfo, dispatchChannels map[types.Flavour][]chan *types.FlowInfo) {
-	slog.Debug("starting enrichment broadcast")
-	for {
-		select {
-		case fi, ok := <-sourceChannels[types.Ebpf]:
-			slog.Debug("got skOps flow information to broadcast")
-			if !ok {
-				// Make the chan always block
-				slog.Debug("stopping skOps flow information broadcast")
-				sourceChannels[types.Ebpf] = nil
-				for i, c := range dispatchChannels[types.Ebpf] {
-					slog.Debug("closing broadcast skOps flow information channel", "i", i)
-					close(c)
-				}
-				break
-			}
-			for i, c := range dispatchChannels[types.Ebpf] {
-				slog.Debug("broadcasting skOps flow information", "i", i)
-				c <- fi
-			}
-
-		case fi, ok := <-sourceChannels[types.Netlink]:
-			slog.Debug("got netlink flow information to broadcast")
-			if !ok {
-				// Make the chan always block
-				slog.Debug("stopping netlink flow information broadcast")
-				sourceChannels[types.Netlink] = nil
-				for i, c := range dispatchChannels[types.Netlink] {
-					slog.Debug("closing broadcast netlink flow information channel", "i", i)
-					close(c)
-				}
-				break
-			}
-			for i, c := range dispatchChannels[types.Netlink] {
-				slog.Debug("broadcasting netlink flow information", "i", i)
-				c <- fi
-			}
-		}
+// Enrichment is buffered on a latest-value basis for every backend: a slow
+// backend will simply miss stale samples.
+const enrichmentBufferSize = 1
+
+// subscribe returns the flowID to hand over to a backend with its enrichment
+// channels set up. Backends are only subscribed to the flavours they're
+// interested in as per types.EnrichmentConsumer.
+func subscribe(flowID types.FlowID, fo *enrichment.FanOut, h *backendHandle) types.FlowID {
+	flowID.FlowInfoChans = nil
+	if fo == nil {
+		return flowID
+	}
+
+	flavours := fo.Flavours()
+	if c, ok := h.backend.(types.EnrichmentConsumer); ok {
+		flavours = c.Flavours()
+	}
 
-		// Bail when both channels are closed
-		if sourceChannels[types.Ebpf] == nil && sourceChannels[types.Netlink] == nil {
-			slog.Debug("stopping enrichment broadcast")
-			return
+	for _, t := range flavours {
+		ch, ok := fo.Subscribe(t, enrichmentBufferSize, h.done)
+		if !ok {
+			continue
 		}
+		if flowID.FlowInfoChans == nil {
+			flowID.FlowInfoChans = map[types.Flavour]chan *types.FlowInfo{}
+		}
+		flowID.FlowInfoChans[t] = ch
 	}
+
+	return flowID
 }
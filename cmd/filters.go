@@ -0,0 +1,269 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-yaml"
+	"github.com/scitags/flowd-go/types"
+)
+
+// flowFilter selects the flowIDs a plugin or backend instance deals with. A
+// flowID is selected if it matches any of the include rules (or if there are
+// none) and it matches none of the exclude rules. A nil filter selects every
+// flowID.
+type flowFilter struct {
+	Include []flowRule `yaml:"include,omitempty"`
+	Exclude []flowRule `yaml:"exclude,omitempty"`
+}
+
+// flowRule matches a flowID if every criterion it specifies does. Criteria
+// specifying several values match if any of them does.
+type flowRule struct {
+	Experiments []types.ExperimentRef `yaml:"experiments,omitempty"`
+	Activities  []types.ActivityRef   `yaml:"activities,omitempty"`
+	Protocols   []filterProtocol      `yaml:"protocols,omitempty"`
+	SrcPrefixes []netip.Prefix        `yaml:"srcPrefixes,omitempty"`
+	DstPrefixes []netip.Prefix        `yaml:"dstPrefixes,omitempty"`
+	SrcPorts    []types.PortRange     `yaml:"srcPorts,omitempty"`
+	DstPorts    []types.PortRange     `yaml:"dstPorts,omitempty"`
+}
+
+func (r *flowRule) UnmarshalYAML(b []byte) error {
+	// Needed to break recursive calls into UnmarshalYAML
+	type rule flowRule
+
+	def := &rule{}
+	if err := yaml.Unmarshal(b, def); err != nil {
+		return err
+	}
+
+	// An empty rule matches everything, which is surely a mistake
+	if len(def.Experiments) == 0 && len(def.Activities) == 0 && len(def.Protocols) == 0 &&
+		len(def.SrcPrefixes) == 0 && len(def.DstPrefixes) == 0 && len(def.SrcPorts) == 0 && len(def.DstPorts) == 0 {
+		return fmt.Errorf("filter rules must specify at least one criterion")
+	}
+
+	for i, p := range def.SrcPrefixes {
+		def.SrcPrefixes[i] = p.Masked()
+	}
+	for i, p := range def.DstPrefixes {
+		def.DstPrefixes[i] = p.Masked()
+	}
+
+	*r = flowRule(*def)
+
+	return nil
+}
+
+// validate checks that every experiment and activity name the filter refers
+// to can be resolved against the current registry. Note activity names are
+// only checked to exist in some experiment given the experiment is only known
+// once matching a flowID.
+func (f *flowFilter) validate() error {
+	if f == nil {
+		return nil
+	}
+
+	r := types.CurrentRegistry()
+	errs := []error{}
+	for _, rule := range slices.Concat(f.Include, f.Exclude) {
+		for _, e := range rule.Experiments {
+			if _, err := e.Resolve(r); err != nil {
+				errs = append(errs, fmt.Errorf("wrong filter: %w", err))
+			}
+		}
+
+		for _, a := range rule.Activities {
+			if a.Name == "" {
+				continue
+			}
+			if r == nil {
+				errs = append(errs, fmt.Errorf("wrong filter: can't resolve activity %q without a SciTags registry", a.Name))
+				continue
+			}
+			if !r.HasActivity(a.Name) {
+				errs = append(errs, fmt.Errorf("wrong filter: unknown activity %q", a.Name))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (f *flowFilter) matches(flowID types.FlowID) bool {
+	if f == nil {
+		return true
+	}
+
+	matches := func(r flowRule) bool { return r.matches(flowID) }
+
+	if len(f.Include) > 0 && !slices.ContainsFunc(f.Include, matches) {
+		return false
+	}
+
+	return !slices.ContainsFunc(f.Exclude, matches)
+}
+
+func (r flowRule) matches(flowID types.FlowID) bool {
+	registry := types.CurrentRegistry()
+
+	if len(r.Experiments) > 0 && !slices.ContainsFunc(r.Experiments, func(e types.ExperimentRef) bool {
+		return e.Matches(registry, flowID.Experiment)
+	}) {
+		return false
+	}
+
+	if len(r.Activities) > 0 && !slices.ContainsFunc(r.Activities, func(a types.ActivityRef) bool {
+		return a.Matches(registry, flowID.Experiment, flowID.Activity)
+	}) {
+		return false
+	}
+
+	if len(r.Protocols) > 0 && !slices.Contains(r.Protocols, filterProtocol(flowID.Protocol)) {
+		return false
+	}
+
+	if !prefixesContain(r.SrcPrefixes, flowID.Src.Addr()) || !prefixesContain(r.DstPrefixes, flowID.Dst.Addr()) {
+		return false
+	}
+
+	return portRangesContain(r.SrcPorts, flowID.Src.Port()) && portRangesContain(r.DstPorts, flowID.Dst.Port())
+}
+
+// pluginFilter applies a flowFilter to the flowIDs coming from a plugin. Only
+// STARTs are matched against the filter given plugins needn't include the
+// flow's context (i.e. experiment and activity) on anything else. STARTs are
+// also checked against the registry through registered, if non-nil. We simply
+// remember which flows were rejected so that their remaining flowIDs are
+// discarded too. Rejected flows never make it to the flow table, so those not
+// heard of in the flow TTL are forgotten here just like the flow table expires
+// flows whose END never arrives. Given the TTL can be 0 we also bound how many
+// rejected flows we remember, forgetting the least recently heard of ones
+// first. It's only meant to be used from the plugin's funnel.
+type pluginFilter struct {
+	filter     *flowFilter
+	registered func(types.FlowID) bool
+
+	// When we last heard of each rejected flow. A TTL of 0 disables expiry.
+	rejected   map[types.FlowKey]time.Time
+	ttl        func() time.Duration
+	lastExpiry time.Time
+}
+
+// maxRejectedFlows bounds how many rejected flows a plugin filter remembers.
+const maxRejectedFlows = 10000
+
+func newPluginFilter(f *flowFilter, registered func(types.FlowID) bool, ttl func() time.Duration) *pluginFilter {
+	return &pluginFilter{filter: f, registered: registered, rejected: map[types.FlowKey]time.Time{}, ttl: ttl}
+}
+
+func (pf *pluginFilter) pass(flowID types.FlowID, now time.Time) bool {
+	if pf.filter == nil && pf.registered == nil {
+		return true
+	}
+	pf.expire(now)
+
+	k := flowID.Key()
+	switch flowID.State {
+	case types.START:
+		if pf.filter.matches(flowID) && (pf.registered == nil || pf.registered(flowID)) {
+			delete(pf.rejected, k)
+			return true
+		}
+		pf.reject(k, now)
+		return false
+
+	case types.END:
+		if _, ok := pf.rejected[k]; ok {
+			delete(pf.rejected, k)
+			return false
+		}
+	}
+
+	if _, rejected := pf.rejected[k]; rejected {
+		pf.rejected[k] = now
+		return false
+	}
+	return true
+}
+
+// reject remembers a rejected flow, forgetting the least recently heard of
+// one if we're already remembering too many.
+func (pf *pluginFilter) reject(k types.FlowKey, now time.Time) {
+	if _, ok := pf.rejected[k]; !ok && len(pf.rejected) >= maxRejectedFlows {
+		var (
+			oldest     types.FlowKey
+			oldestSeen time.Time
+		)
+		for rk, lastSeen := range pf.rejected {
+			if oldestSeen.IsZero() || lastSeen.Before(oldestSeen) {
+				oldest, oldestSeen = rk, lastSeen
+			}
+		}
+		delete(pf.rejected, oldest)
+	}
+	pf.rejected[k] = now
+}
+
+// expire forgets the rejected flows that haven't been heard of in the TTL. Just
+// like the flow table, they're only checked once per expiry period.
+func (pf *pluginFilter) expire(now time.Time) {
+	ttl := pf.ttl()
+	if ttl == 0 || now.Sub(pf.lastExpiry) < expiryPeriod(ttl) {
+		return
+	}
+	pf.lastExpiry = now
+
+	maps.DeleteFunc(pf.rejected, func(_ types.FlowKey, lastSeen time.Time) bool {
+		return now.Sub(lastSeen) >= ttl
+	})
+}
+
+// prefixesContain checks whether any of the prefixes contains the address. No
+// prefixes at all are taken as a wildcard.
+func prefixesContain(prefixes []netip.Prefix, addr netip.Addr) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+
+	addr = addr.Unmap()
+	return slices.ContainsFunc(prefixes, func(p netip.Prefix) bool { return p.Contains(addr) })
+}
+
+// portRangesContain checks whether any of the ranges contains the port. No
+// ranges at all are taken as a wildcard.
+func portRangesContain(ranges []types.PortRange, port uint16) bool {
+	if len(ranges) == 0 {
+		return true
+	}
+
+	return slices.ContainsFunc(ranges, func(pr types.PortRange) bool { return pr.Contains(port) })
+}
+
+// filterProtocol is a types.Protocol configured by name (i.e. tcp or udp).
+type filterProtocol types.Protocol
+
+func (p *filterProtocol) UnmarshalYAML(b []byte) error {
+	var raw string
+	if err := yaml.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+
+	proto, ok := types.ParseProtocol(raw)
+	if !ok {
+		return fmt.Errorf("unknown protocol %q", raw)
+	}
+	*p = filterProtocol(proto)
+
+	return nil
+}
+
+func (p filterProtocol) MarshalText() ([]byte, error) {
+	return []byte(strings.ToLower(types.Protocol(p).String())), nil
+}
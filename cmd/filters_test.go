@@ -0,0 +1,195 @@
+package main
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-yaml"
+	"github.com/scitags/flowd-go/types"
+)
+
+func TestFilters(t *testing.T) {
+	conf, err := ReadConf("testdata/filters.yaml")
+	if err != nil {
+		t.Fatalf("error parsing filters.yaml: %v", err)
+	}
+
+	t.Logf("\n%s", conf)
+
+	flowID := func(exp uint32, dst string, srcPort uint16) types.FlowID {
+		f := testFlowID(types.START, srcPort)
+		f.Experiment = exp
+		f.Dst = netip.MustParseAddrPort(dst)
+		return f
+	}
+
+	tests := []struct {
+		flowID types.FlowID
+		want   map[string]bool
+	}{
+		{flowID(2, "[2001:db8::2]:5777", 2345), map[string]bool{"atlas": true, "others": false, "marker": true}},
+		{flowID(3, "[2001:db8::2]:5777", 2345), map[string]bool{"atlas": false, "others": true, "marker": true}},
+		{flowID(2, "[2001:db8:dead::2]:5777", 2345), map[string]bool{"atlas": true, "others": false, "marker": false}},
+		{flowID(3, "10.1.2.3:5777", 2345), map[string]bool{"atlas": false, "others": true, "marker": false}},
+		{flowID(3, "[2001:db8::2]:5777", 22), map[string]bool{"atlas": false, "others": true, "marker": false}},
+		{flowID(3, "[2001:db8::2]:5777", 6050), map[string]bool{"atlas": false, "others": true, "marker": false}},
+		{flowID(3, "[2001:db8::2]:5777", 6101), map[string]bool{"atlas": false, "others": true, "marker": true}},
+	}
+
+	for i, test := range tests {
+		for name, want := range test.want {
+			if got := conf.Backends[name].Filters.matches(test.flowID); got != want {
+				t.Errorf("#%d: %s got %t; want %t", i, name, got, want)
+			}
+		}
+	}
+
+	udp := flowID(2, "[2001:db8::2]:5777", 2345)
+	udp.Protocol = types.UDP
+	if conf.Backends["atlas"].Filters.matches(udp) {
+		t.Errorf("atlas matched a UDP flow")
+	}
+}
+
+func TestFiltersErrors(t *testing.T) {
+	tests := map[string]string{
+		"emptyRule":   "filters:\n  include:\n    - {}\n",
+		"badProtocol": "filters:\n  include:\n    - protocols: [\"sctp\"]\n",
+		"badPrefix":   "filters:\n  include:\n    - dstPrefixes: [\"10.0.0.0/33\"]\n",
+		"badPort":     "filters:\n  include:\n    - dstPorts: [65536]\n",
+		"badRange":    "filters:\n  include:\n    - dstPorts: [\"200-100\"]\n",
+	}
+
+	for name, raw := range tests {
+		bc := backendsConf{}
+		if err := bc.UnmarshalYAML([]byte("firefly:\n" + indent(raw))); err == nil {
+			t.Errorf("%s: expected an error but got %v", name, bc["firefly"].Filters)
+		}
+	}
+}
+
+func TestPluginFilter(t *testing.T) {
+	now := time.Now()
+	pf := newPluginFilter(&flowFilter{Exclude: []flowRule{{Activities: []types.ActivityRef{{ID: 9}}}}}, nil, newFlowTable(0).ttl)
+
+	start := testFlowID(types.START, 2345)
+	start.Activity = 9
+	if pf.pass(start, now) {
+		t.Errorf("an excluded START went through")
+	}
+
+	// Plugins needn't include the context on ENDs
+	end := testFlowID(types.END, 2345)
+	end.Activity = 0
+	if pf.pass(end, now) {
+		t.Errorf("the END of a filtered out flow went through")
+	}
+
+	// The flow is gone: the same 5-tuple can now be used by a different flow
+	if !pf.pass(testFlowID(types.START, 2345), now) || !pf.pass(end, now) {
+		t.Errorf("a flow with a different context was filtered out")
+	}
+
+	if !newPluginFilter(nil, nil, newFlowTable(0).ttl).pass(start, now) {
+		t.Errorf("a nil filter rejected a flowID")
+	}
+
+	// Flows in an unregistered context are rejected just like filtered ones
+	unregistered := newPluginFilter(nil, func(f types.FlowID) bool { return f.Activity != 9 }, newFlowTable(0).ttl)
+	if unregistered.pass(start, now) || unregistered.pass(end, now) {
+		t.Errorf("a flow in an unregistered context went through")
+	}
+}
+
+func TestPluginFilterExpiry(t *testing.T) {
+	ttl := time.Minute
+	now := time.Now()
+
+	// The TTL is only set once the filter's running, just like on a reload
+	ft := newFlowTable(0)
+	pf := newPluginFilter(&flowFilter{Exclude: []flowRule{{Activities: []types.ActivityRef{{ID: 9}}}}}, nil, ft.ttl)
+	ft.setTTL(ttl)
+
+	for _, port := range []uint16{1, 2} {
+		start := testFlowID(types.START, port)
+		start.Activity = 9
+		pf.pass(start, now)
+	}
+
+	// Flows we keep on hearing about are kept around
+	if pf.pass(testFlowID(types.ONGOING, 1), now.Add(ttl/2)) {
+		t.Errorf("an ONGOING flowID of a filtered out flow went through")
+	}
+
+	// The END of the second flow never arrives
+	pf.pass(testFlowID(types.ONGOING, 3), now.Add(ttl+ttl/4))
+	if _, ok := pf.rejected[testFlowID(types.START, 2).Key()]; ok || len(pf.rejected) != 1 {
+		t.Errorf("got %d rejected flows after expiry, want 1", len(pf.rejected))
+	}
+}
+
+func TestPluginFilterBound(t *testing.T) {
+	now := time.Now()
+	pf := newPluginFilter(&flowFilter{Exclude: []flowRule{{Activities: []types.ActivityRef{{ID: 9}}}}}, nil, newFlowTable(0).ttl)
+
+	for i := range maxRejectedFlows + 1 {
+		start := testFlowID(types.START, uint16(i+1))
+		start.Activity = 9
+		pf.pass(start, now.Add(time.Duration(i)*time.Millisecond))
+	}
+
+	if len(pf.rejected) != maxRejectedFlows {
+		t.Errorf("got %d rejected flows, want %d", len(pf.rejected), maxRejectedFlows)
+	}
+	if _, ok := pf.rejected[testFlowID(types.START, 1).Key()]; ok {
+		t.Errorf("the least recently heard of rejected flow wasn't forgotten")
+	}
+}
+
+func TestFiltersNames(t *testing.T) {
+	r, err := types.LoadRegistry("testdata/registry/scitags.json")
+	if err != nil {
+		t.Fatalf("error loading the registry: %v", err)
+	}
+	types.SetRegistry(r)
+	defer types.SetRegistry(nil)
+
+	f := flowFilter{}
+	if err := yaml.Unmarshal([]byte("include:\n  - experiments: [atlas, 3]\n    activities: [datachallenge]\n"), &f); err != nil {
+		t.Fatalf("error unmarshaling the filter: %v", err)
+	}
+
+	if err := f.validate(); err != nil {
+		t.Errorf("unexpected error validating the filter: %v", err)
+	}
+
+	flowID := func(exp, act uint32) types.FlowID {
+		f := testFlowID(types.START, 2345)
+		f.Experiment, f.Activity = exp, act
+		return f
+	}
+
+	// Activity names are resolved within the flow's experiment
+	for _, test := range []struct {
+		flowID types.FlowID
+		want   bool
+	}{
+		{flowID(2, 3), true},
+		{flowID(3, 7), true},
+		{flowID(3, 3), false},
+		{flowID(2, 2), false},
+		{flowID(1, 1), false},
+	} {
+		if got := f.matches(test.flowID); got != test.want {
+			t.Errorf("(%d, %d): got %t; want %t", test.flowID.Experiment, test.flowID.Activity, got, test.want)
+		}
+	}
+
+	if err := yaml.Unmarshal([]byte("include:\n  - experiments: [lhcb]\n    activities: [ci]\n"), &f); err != nil {
+		t.Fatalf("error unmarshaling the filter: %v", err)
+	}
+	if err := f.validate(); err == nil {
+		t.Errorf("a filter with unknown names validated")
+	}
+}
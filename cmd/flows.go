@@ -0,0 +1,228 @@
+package main
+
+import (
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/scitags/flowd-go/enrichment"
+	"github.com/scitags/flowd-go/types"
+)
+
+// flowEntry holds the state we keep for each active flow.
+type flowEntry struct {
+	// The flowID the flow was STARTed with. Its timestamps and context (i.e.
+	// experiment and activity) are the ones END flowIDs will carry.
+	flowID types.FlowID
+
+	// The last time we heard about this flow through any plugin. See
+	// lastActive too.
+	lastSeen time.Time
+
+	// The fan-out distributing the flow's enrichment, if any. Backends
+	// started after the flow can subscribe to it too.
+	fanOut *enrichment.FanOut
+
+	// The flow tags backends have assigned to the flow keyed by backend
+	// name so that they can be handed back after a restart.
+	tags map[string]uint32
+
+	// Whether each backend's filters matched the flow's START keyed by
+	// backend name. The rest of the flow's flowIDs follow that decision.
+	matched map[string]bool
+}
+
+// lastActive returns the last time the flow was known to be alive: either a
+// plugin told us about it or an enricher sampled it. Plugins seldom send
+// anything between a flow's START and END, so it's usually the samples that
+// keep long-running flows from being expired.
+func (e *flowEntry) lastActive() time.Time {
+	if e.fanOut != nil {
+		if sampled := e.fanOut.LastSample(); sampled.After(e.lastSeen) {
+			return sampled
+		}
+	}
+	return e.lastSeen
+}
+
+// setMatched records whether a backend's filters matched the flow's START.
+func (e *flowEntry) setMatched(backend string, matched bool) {
+	if e.matched == nil {
+		e.matched = map[string]bool{}
+	}
+	e.matched[backend] = matched
+}
+
+// backendMatched returns whether a backend's filters matched the flow's START,
+// if they were ever matched against it. A nil entry knows of no backend.
+func (e *flowEntry) backendMatched(backend string) (bool, bool) {
+	if e == nil {
+		return false, false
+	}
+	matched, ok := e.matched[backend]
+	return matched, ok
+}
+
+// flowTable keeps track of every active flow, that is, every flow for which
+// we have received a START but not an END. It allows us to reject duplicate
+// STARTs, drop ENDs for flows we never knew about and expire flows whose END
+// never made it to us. It is only ever accessed from the main dispatch loop,
+// so there's no need for any locking. The only exception is the TTL, which
+// plugin filters read too.
+type flowTable struct {
+	// Flows not heard of in the TTL (in nanoseconds) will be expired. A TTL
+	// of 0 disables expiry.
+	ttlNanos atomic.Int64
+
+	flows map[types.FlowKey]*flowEntry
+}
+
+func newFlowTable(ttl time.Duration) *flowTable {
+	ft := &flowTable{flows: map[types.FlowKey]*flowEntry{}}
+	ft.setTTL(ttl)
+	return ft
+}
+
+func (ft *flowTable) ttl() time.Duration {
+	return time.Duration(ft.ttlNanos.Load())
+}
+
+func (ft *flowTable) setTTL(ttl time.Duration) {
+	ft.ttlNanos.Store(int64(ttl))
+}
+
+// start registers a new flow. If the flow is already active the START is
+// merged into the existing entry (i.e. its expiry is pushed back) and false
+// is returned so that the caller can avoid dispatching it again.
+func (ft *flowTable) start(flowID types.FlowID, now time.Time) bool {
+	k := flowID.Key()
+
+	if e, ok := ft.flows[k]; ok {
+		e.lastSeen = now
+		if e.flowID.Experiment != flowID.Experiment || e.flowID.Activity != flowID.Activity {
+			slog.Warn("rejecting duplicate START with a different context", types.LogKeyFlow, flowID,
+				"experiment", e.flowID.Experiment, "activity", e.flowID.Activity,
+				"newExperiment", flowID.Experiment, "newActivity", flowID.Activity)
+			return false
+		}
+		slog.Debug("merging duplicate START", types.LogKeyFlow, flowID)
+		return false
+	}
+
+	ft.flows[k] = &flowEntry{flowID: flowID, lastSeen: now}
+
+	return true
+}
+
+// touch pushes back the expiry of an active flow. It returns false if the flow
+// is unknown.
+func (ft *flowTable) touch(flowID types.FlowID, now time.Time) bool {
+	e, ok := ft.flows[flowID.Key()]
+	if ok {
+		e.lastSeen = now
+	}
+	return ok
+}
+
+// end removes a flow from the table returning its entry. If the flow is
+// unknown false is returned instead.
+func (ft *flowTable) end(flowID types.FlowID) (*flowEntry, bool) {
+	k := flowID.Key()
+
+	e, ok := ft.flows[k]
+	if !ok {
+		return nil, false
+	}
+	delete(ft.flows, k)
+
+	return e, true
+}
+
+// expire removes every flow that hasn't been heard of (nor sampled) in the
+// configured TTL and returns their entries.
+func (ft *flowTable) expire(now time.Time) []*flowEntry {
+	ttl := ft.ttl()
+	if ttl == 0 {
+		return nil
+	}
+
+	expired := []*flowEntry{}
+	for k, e := range ft.flows {
+		if now.Sub(e.lastActive()) < ttl {
+			continue
+		}
+		expired = append(expired, e)
+		delete(ft.flows, k)
+	}
+
+	return expired
+}
+
+// entry returns the entry of an active flow, if any.
+func (ft *flowTable) entry(flowID types.FlowID) *flowEntry {
+	return ft.flows[flowID.Key()]
+}
+
+// setFanOut records the enrichment fan-out of an active flow.
+func (ft *flowTable) setFanOut(flowID types.FlowID, fo *enrichment.FanOut) {
+	if e, ok := ft.flows[flowID.Key()]; ok {
+		e.fanOut = fo
+	}
+}
+
+// fanOut returns the enrichment fan-out of an active flow, if any.
+func (ft *flowTable) fanOut(flowID types.FlowID) *enrichment.FanOut {
+	if e, ok := ft.flows[flowID.Key()]; ok {
+		return e.fanOut
+	}
+	return nil
+}
+
+// setTag records the tag a backend has assigned to an active flow.
+func (ft *flowTable) setTag(flowID types.FlowID, backend string, tag uint32) {
+	e, ok := ft.flows[flowID.Key()]
+	if !ok {
+		return
+	}
+	if e.tags == nil {
+		e.tags = map[string]uint32{}
+	}
+	e.tags[backend] = tag
+}
+
+// tag returns the tag a backend has assigned to an active flow, if any.
+func (ft *flowTable) tag(flowID types.FlowID, backend string) (uint32, bool) {
+	e, ok := ft.flows[flowID.Key()]
+	if !ok {
+		return 0, false
+	}
+	tag, ok := e.tags[backend]
+	return tag, ok
+}
+
+// active returns the flowIDs every active flow was STARTed with.
+func (ft *flowTable) active() []types.FlowID {
+	active := make([]types.FlowID, 0, len(ft.flows))
+	for _, e := range ft.flows {
+		active = append(active, e.flowID)
+	}
+	return active
+}
+
+func (ft *flowTable) len() int {
+	return len(ft.flows)
+}
+
+// expiryPeriod returns how often the flow table should be checked for expired
+// flows.
+func (ft *flowTable) expiryPeriod() time.Duration {
+	return expiryPeriod(ft.ttl())
+}
+
+// expiryPeriod returns how often flows should be checked against the TTL. We
+// check four times per TTL so that flows don't linger for much longer than they
+// should, but we bound the period to avoid spinning with short TTLs or taking
+// forever with long ones.
+func expiryPeriod(ttl time.Duration) time.Duration {
+	return min(max(ttl/4, time.Second), time.Minute)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/scitags/flowd-go/enrichment"
+	"github.com/scitags/flowd-go/types"
+)
+
+func testFlowID(state types.FlowState, srcPort uint16) types.FlowID {
+	return types.FlowID{
+		State:      state,
+		Protocol:   types.TCP,
+		Family:     types.IPv6,
+		Src:        netip.AddrPortFrom(netip.MustParseAddr("2001:db8::1"), srcPort),
+		Dst:        netip.AddrPortFrom(netip.MustParseAddr("2001:db8::2"), 5777),
+		Experiment: 2,
+		Activity:   3,
+	}
+}
+
+func TestFlowTableLifecycle(t *testing.T) {
+	ft := newFlowTable(0)
+	now := time.Now()
+
+	if !ft.start(testFlowID(types.START, 2345), now) {
+		t.Fatalf("the first START was rejected")
+	}
+
+	if ft.start(testFlowID(types.START, 2345), now) {
+		t.Errorf("a duplicate START was accepted")
+	}
+
+	dup := testFlowID(types.START, 2345)
+	dup.Experiment = 10
+	if ft.start(dup, now) {
+		t.Errorf("a duplicate START with a different context was accepted")
+	}
+
+	if ft.len() != 1 {
+		t.Errorf("got %d flows, want 1", ft.len())
+	}
+
+	if _, ok := ft.end(testFlowID(types.END, 1111)); ok {
+		t.Errorf("an orphan END was accepted")
+	}
+
+	e, ok := ft.end(testFlowID(types.END, 2345))
+	if !ok {
+		t.Fatalf("the END of an active flow was rejected")
+	}
+	if e.flowID.Experiment != 2 {
+		t.Errorf("got experiment %d, want the START's experiment (2)", e.flowID.Experiment)
+	}
+
+	if _, ok := ft.end(testFlowID(types.END, 2345)); ok {
+		t.Errorf("a repeated END was accepted")
+	}
+
+	if ft.len() != 0 {
+		t.Errorf("got %d flows, want 0", ft.len())
+	}
+}
+
+func TestFlowTableExpiry(t *testing.T) {
+	ttl := 10 * time.Second
+	ft := newFlowTable(ttl)
+	now := time.Now()
+
+	ft.start(testFlowID(types.START, 1), now)
+	ft.start(testFlowID(types.START, 2), now)
+
+	// Duplicate STARTs and ONGOING flowIDs should push back the expiry
+	ft.start(testFlowID(types.START, 2), now.Add(ttl/2))
+
+	expired := ft.expire(now.Add(ttl))
+	if len(expired) != 1 || expired[0].flowID.Src.Port() != 1 {
+		t.Fatalf("got expired flows %v, want the flow with source port 1", expired)
+	}
+
+	if !ft.touch(testFlowID(types.ONGOING, 2), now.Add(ttl)) {
+		t.Fatalf("couldn't touch an active flow")
+	}
+
+	if expired := ft.expire(now.Add(ttl + ttl/2)); len(expired) != 0 {
+		t.Errorf("got expired flows %v, want none", expired)
+	}
+
+	if expired := ft.expire(now.Add(2 * ttl)); len(expired) != 1 {
+		t.Errorf("got %d expired flows, want 1", len(expired))
+	}
+}
+
+func TestFlowTableEnrichedExpiry(t *testing.T) {
+	ttl := time.Minute
+	ft := newFlowTable(ttl)
+
+	flowID := testFlowID(types.START, 1)
+	ft.start(flowID, time.Now().Add(-2*ttl))
+
+	src := make(chan *types.FlowInfo)
+	fo := enrichment.NewFanOut(map[types.Flavour]chan *types.FlowInfo{types.Netlink: src})
+	ft.setFanOut(flowID, fo)
+	go fo.Run()
+	defer close(src)
+
+	// Samples prove the flow is still alive
+	src <- &types.FlowInfo{}
+	for fo.LastSample().IsZero() {
+		time.Sleep(time.Millisecond)
+	}
+
+	if expired := ft.expire(time.Now()); len(expired) != 0 {
+		t.Errorf("expired a flow which is still being sampled")
+	}
+	if expired := ft.expire(time.Now().Add(ttl)); len(expired) != 1 {
+		t.Errorf("got %d expired flows, want 1", len(expired))
+	}
+}
+
+func TestFlowTableNoExpiry(t *testing.T) {
+	ft := newFlowTable(0)
+	now := time.Now()
+
+	ft.start(testFlowID(types.START, 1), now)
+
+	if expired := ft.expire(now.Add(1000 * time.Hour)); len(expired) != 0 {
+		t.Errorf("got expired flows %v with expiry disabled", expired)
+	}
+}
+
+func TestEndFlowID(t *testing.T) {
+	now := time.Now()
+
+	start := testFlowID(types.START, 1)
+	start.StartTs = now.Add(-time.Minute)
+	start.Application = "fts"
+
+	end := endFlowID(start, types.FlowID{State: types.END}, now)
+	if end.State != types.END || !end.EndTs.Equal(now) || !end.StartTs.Equal(start.StartTs) {
+		t.Errorf("got an inconsistent END flowID: %+v", end)
+	}
+	if end.Experiment != start.Experiment || end.Activity != start.Activity || end.Application != start.Application {
+		t.Errorf("the END flowID lacks the START's context: %+v", end)
+	}
+}
+
+func TestMarkingStats(t *testing.T) {
+	want := types.MarkingStats{Packets: 10, Bytes: 15000, MTUSkipped: 1}
+	d := testSnapshotDaemon(t.TempDir(), 0, &taggingBackend{marking: map[uint16]types.MarkingStats{1: want}})
+
+	for i, port := range []uint16{1, 2} {
+		d.dispatch(endFlowID(testFlowID(types.START, port), types.FlowID{}, time.Now()), nil)
+
+		flowID := d.backends[0].queue.items[i]
+		switch {
+		case port == 1 && (flowID.Marking == nil || *flowID.Marking != want):
+			t.Errorf("got marking stats %v for flow %d; want %v", flowID.Marking, port, want)
+		case port == 2 && flowID.Marking != nil:
+			t.Errorf("got marking stats %v for flow %d which wasn't marked", flowID.Marking, port)
+		}
+	}
+}
+
+func TestBackendFilterFollowsStart(t *testing.T) {
+	d := testSnapshotDaemon(t.TempDir(), 0, &taggingBackend{})
+	d.backends[0].conf.Filters = &flowFilter{Include: []flowRule{{Activities: []types.ActivityRef{{ID: 3}}}}}
+	now := time.Now()
+
+	for port, activity := range map[uint16]uint32{1: 3, 2: 4} {
+		start := testFlowID(types.START, port)
+		start.Activity = activity
+		d.flows.start(start, now)
+		d.dispatch(start, d.flows.entry(start))
+
+		// The plugin supplies a different context on the END
+		end := testFlowID(types.END, port)
+		end.Activity = 7 - activity
+		e, _ := d.flows.end(end)
+		d.dispatch(endFlowID(e.flowID, end, now), e)
+	}
+
+	q := d.backends[0].queue.items
+	if len(q) != 2 {
+		t.Fatalf("got %d flowIDs, want the START and END of the matching flow", len(q))
+	}
+	for i, state := range []types.FlowState{types.START, types.END} {
+		if flowID := q[i]; flowID.State != state || flowID.Src.Port() != 1 {
+			t.Errorf("got flowID %v, want the %s of flow 1", flowID, state)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Consider the main loop stalled if it misses this many heartbeats.
+const missedHeartbeats = 3
+
+// health is the state backing the /healthz and /readyz endpoints. Given these
+// are served from outside the main loop, the main loop publishes everything
+// they need here rather than having them look into the daemon.
+type health struct {
+	// Whether every component has been started and no reload is underway.
+	ready atomic.Bool
+
+	// The last time the main loop went through a heartbeat.
+	beat atomic.Int64
+
+	// The period between heartbeats.
+	period time.Duration
+
+	components atomic.Pointer[[]componentHealth]
+}
+
+// componentHealth reports on a running plugin, backend or enricher.
+type componentHealth struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	Type string `json:"type"`
+
+	// Backends only.
+	QueueDepth *int    `json:"queueDepth,omitempty"`
+	QueueSize  *int    `json:"queueSize,omitempty"`
+	Drops      *uint64 `json:"drops,omitempty"`
+}
+
+type healthReport struct {
+	Status        string            `json:"status"`
+	LastHeartbeat time.Time         `json:"lastHeartbeat"`
+	Components    []componentHealth `json:"components"`
+}
+
+// heartbeat records the main loop is alive and publishes the state of every
+// running component. It must be called from the main loop.
+func (d *daemon) heartbeat(now time.Time) {
+	components := []componentHealth{}
+	for _, h := range d.plugins {
+		components = append(components, componentHealth{Kind: "plugin", Name: h.name, Type: h.conf.Type})
+	}
+	for _, h := range d.backends {
+		depth, size, drops := h.queue.depth(), h.queue.size, h.queue.drops()
+		components = append(components, componentHealth{
+			Kind: "backend", Name: h.name, Type: h.conf.Type,
+			QueueDepth: &depth, QueueSize: &size, Drops: &drops,
+		})
+	}
+	for _, name := range sortedKeys(d.enrichers) {
+		components = append(components, componentHealth{Kind: "enricher", Name: name, Type: name})
+	}
+
+	d.health.components.Store(&components)
+	d.health.beat.Store(now.UnixNano())
+}
+
+func (hs *health) report(status string) healthReport {
+	r := healthReport{Status: status, LastHeartbeat: time.Unix(0, hs.beat.Load()).UTC(), Components: []componentHealth{}}
+	if c := hs.components.Load(); c != nil {
+		r.Components = *c
+	}
+	return r
+}
+
+// alive checks whether the main loop has gone through a heartbeat recently.
+func (hs *health) alive(now time.Time) bool {
+	return now.Sub(time.Unix(0, hs.beat.Load())) < missedHeartbeats*hs.period
+}
+
+func writeHealth(w http.ResponseWriter, ok bool, r healthReport) {
+	w.Header().Set("Content-Type", "application/json")
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(r)
+}
+
+// healthz reports whether the main loop is alive.
+func (hs *health) healthz(w http.ResponseWriter, req *http.Request) {
+	if !hs.alive(time.Now()) {
+		writeHealth(w, false, hs.report("stalled"))
+		return
+	}
+	writeHealth(w, true, hs.report("ok"))
+}
+
+// readyz reports whether flowd-go is up and running and not reloading.
+func (hs *health) readyz(w http.ResponseWriter, req *http.Request) {
+	if !hs.ready.Load() {
+		writeHealth(w, false, hs.report("unavailable"))
+		return
+	}
+	writeHealth(w, true, hs.report("ready"))
+}
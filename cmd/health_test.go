@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealth(t *testing.T) {
+	d := testSnapshotDaemon(t.TempDir(), 0, &taggingBackend{})
+	d.health.period = time.Second
+
+	check := func(handler http.HandlerFunc, wantCode int, wantStatus string) healthReport {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		var r healthReport
+		if err := json.Unmarshal(rec.Body.Bytes(), &r); err != nil {
+			t.Fatalf("error parsing the report: %v", err)
+		}
+
+		if rec.Code != wantCode || r.Status != wantStatus {
+			t.Errorf("got %d (%s); want %d (%s)", rec.Code, r.Status, wantCode, wantStatus)
+		}
+
+		return r
+	}
+
+	// No heartbeat yet and not ready
+	check(d.health.healthz, http.StatusServiceUnavailable, "stalled")
+	check(d.health.readyz, http.StatusServiceUnavailable, "unavailable")
+
+	d.heartbeat(time.Now())
+	d.health.ready.Store(true)
+
+	r := check(d.health.healthz, http.StatusOK, "ok")
+	if len(r.Components) != 1 || r.Components[0].Name != "marker" || *r.Components[0].QueueSize != 10 {
+		t.Errorf("got components %+v; want the marker backend alone", r.Components)
+	}
+	check(d.health.readyz, http.StatusOK, "ready")
+
+	// The main loop has missed too many heartbeats
+	d.heartbeat(time.Now().Add(-missedHeartbeats * d.health.period))
+	check(d.health.healthz, http.StatusServiceUnavailable, "stalled")
+}
@@ -0,0 +1,483 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+	"github.com/goccy/go-yaml/ast"
+	"github.com/goccy/go-yaml/parser"
+)
+
+// envPrefix is the prefix of the environment variables overriding settings.
+const envPrefix = "FLOWD_GO_"
+
+// origin is where a configuration value comes from.
+type origin struct {
+	file string
+	line int
+
+	// The index of the layer the value comes from so that origins can be
+	// sorted by precedence.
+	layer int
+
+	// The environment variable overriding the value, if any.
+	env string
+}
+
+func (o origin) String() string {
+	switch {
+	case o.env != "":
+		return "$" + o.env
+	case o.line > 0:
+		return fmt.Sprintf("%s:%d", o.file, o.line)
+	default:
+		return o.file
+	}
+}
+
+// layeredConf is the configuration assembled from several layers. These are,
+// in order, the configuration file, the YAML files in the drop-in directory
+// in lexical order and the environment. Mappings are merged key by key whilst
+// any other value (i.e. sequences) replaces the one in previous layers.
+type layeredConf struct {
+	// The merged configuration.
+	tree yaml.MapSlice
+
+	// The origin of every value in the merged configuration keyed by its
+	// dotted path (i.e. backends.firefly.collectorAddress).
+	origins map[string]origin
+}
+
+// dropInDir returns the drop-in directory accompanying a configuration file
+// unless one has been explicitly provided.
+func dropInDir(path string) string {
+	if confDirPath != "" {
+		return confDirPath
+	}
+	return filepath.Join(filepath.Dir(path), "conf.d")
+}
+
+// loadLayeredConf assembles the configuration from the configuration file at
+// path, the *.yaml files in dir (if it exists) and those variables in environ
+// (as returned by os.Environ) beginning with FLOWD_GO_.
+func loadLayeredConf(path, dir string, environ []string) (*layeredConf, error) {
+	lc := &layeredConf{origins: map[string]origin{}}
+
+	files := []string{path}
+	dropIns, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		return nil, fmt.Errorf("error listing the drop-in directory: %w", err)
+	}
+	files = append(files, dropIns...)
+
+	for i, f := range files {
+		raw, err := os.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("error reading the configuration file: %w", err)
+		}
+
+		if err := lc.mergeFile(i, f, raw); err != nil {
+			return nil, err
+		}
+	}
+
+	envVars := []string{}
+	for _, kv := range environ {
+		if strings.HasPrefix(kv, envPrefix) {
+			envVars = append(envVars, kv)
+		}
+	}
+	slices.Sort(envVars)
+
+	for _, kv := range envVars {
+		name, value, _ := strings.Cut(kv, "=")
+		if err := lc.setEnv(len(files), name, value); err != nil {
+			return nil, err
+		}
+	}
+
+	return lc, nil
+}
+
+// mergeFile merges the configuration in a file into the current one.
+func (lc *layeredConf) mergeFile(layer int, name string, raw []byte) error {
+	f, err := parser.ParseBytes(raw, 0)
+	if err != nil {
+		return fmt.Errorf("error parsing %s: %w", name, err)
+	}
+
+	var v any
+	if err := yaml.UnmarshalWithOptions(raw, &v, yaml.UseOrderedMap()); err != nil {
+		return fmt.Errorf("error parsing %s: %w", name, err)
+	}
+
+	// Empty files (i.e. with comments alone) contribute nothing
+	if v == nil {
+		return nil
+	}
+
+	m, ok := v.(yaml.MapSlice)
+	if !ok {
+		return fmt.Errorf("error parsing %s: the configuration must be a mapping", name)
+	}
+
+	var root ast.Node
+	if len(f.Docs) > 0 {
+		root = f.Docs[0].Body
+	}
+
+	lc.tree = lc.merge(lc.tree, m, nil, func(path []string) origin {
+		return origin{file: name, line: yamlLine(root, path), layer: layer}
+	}).(yaml.MapSlice)
+
+	return nil
+}
+
+// merge merges src into dst recording the origin of whatever ends up coming
+// from src.
+func (lc *layeredConf) merge(dst, src any, path []string, originOf func([]string) origin) any {
+	dstMap, dstOk := dst.(yaml.MapSlice)
+	srcMap, srcOk := src.(yaml.MapSlice)
+	if !dstOk || !srcOk {
+		lc.record(src, path, originOf)
+		return src
+	}
+
+	for _, item := range srcMap {
+		key := fmt.Sprint(item.Key)
+		itemPath := append(slices.Clone(path), key)
+
+		i := slices.IndexFunc(dstMap, func(mi yaml.MapItem) bool { return fmt.Sprint(mi.Key) == key })
+		if i < 0 {
+			dstMap = append(dstMap, yaml.MapItem{Key: key, Value: item.Value})
+			lc.record(item.Value, itemPath, originOf)
+			continue
+		}
+
+		dstMap[i].Value = lc.merge(dstMap[i].Value, item.Value, itemPath, originOf)
+	}
+
+	// Merged mappings stem from the layer defining them first
+	if _, ok := lc.origins[joinPath(path)]; !ok {
+		lc.origins[joinPath(path)] = originOf(path)
+	}
+
+	return dstMap
+}
+
+// record sets the origin of a value and everything within it.
+func (lc *layeredConf) record(v any, path []string, originOf func([]string) origin) {
+	lc.origins[joinPath(path)] = originOf(path)
+
+	switch v := v.(type) {
+	case yaml.MapSlice:
+		for _, item := range v {
+			lc.record(item.Value, append(slices.Clone(path), fmt.Sprint(item.Key)), originOf)
+		}
+	case []any:
+		for i, item := range v {
+			lc.record(item, append(slices.Clone(path), strconv.Itoa(i)), originOf)
+		}
+	}
+}
+
+// setEnv overrides a setting through an environment variable. The variable's
+// name is made up of the keys leading to the setting joined by underscores
+// and matched regardless of their case (i.e. FLOWD_GO_PLUGINS_NAMEDPIPE_PIPEPATH)
+// against those in the configuration's schema. Instances configured through
+// sequences are selected by index or by name. The value is decoded as YAML
+// unless the setting is a string.
+func (lc *layeredConf) setEnv(layer int, name, value string) error {
+	segments := strings.Split(strings.TrimPrefix(name, envPrefix), "_")
+
+	s := confSchema()
+	path := []string{}
+	for _, seg := range segments {
+		// Pick an instance out of a sequence
+		if seq, ok := lc.lookup(path).([]any); ok && s["items"] != nil {
+			i := slices.IndexFunc(seq, func(item any) bool {
+				m, _ := item.(yaml.MapSlice)
+				for _, mi := range m {
+					if fmt.Sprint(mi.Key) == "name" && strings.EqualFold(fmt.Sprint(mi.Value), seg) {
+						return true
+					}
+				}
+				return false
+			})
+			if n, err := strconv.Atoi(seg); err == nil && n >= 0 && n < len(seq) {
+				i = n
+			}
+			if i < 0 {
+				return fmt.Errorf("error applying %s: no instance of %s is named %s", name, joinPath(path), seg)
+			}
+
+			path = append(path, strconv.Itoa(i))
+			s, _ = s["items"].(schema)
+			continue
+		}
+
+		props, _ := s["properties"].(schema)
+		key := ""
+		for k := range props {
+			if strings.EqualFold(k, seg) {
+				key = k
+				break
+			}
+		}
+		if key == "" {
+			return fmt.Errorf("error applying %s: unknown setting %s under %q", name, seg, joinPath(path))
+		}
+
+		path = append(path, key)
+		s, _ = props[key].(schema)
+	}
+
+	var v any = value
+	if !onlyStrings(s) {
+		if err := yaml.UnmarshalWithOptions([]byte(value), &v, yaml.UseOrderedMap()); err != nil {
+			return fmt.Errorf("error applying %s: %w", name, err)
+		}
+	}
+
+	tree := setIn(lc.tree, path, v)
+	lc.tree, _ = tree.(yaml.MapSlice)
+	lc.record(v, path, func([]string) origin { return origin{env: name, layer: layer} })
+
+	return nil
+}
+
+// onlyStrings returns whether a schema only accepts strings (and null).
+func onlyStrings(s schema) bool {
+	switch typ := s["type"].(type) {
+	case string:
+		return typ == "string"
+	case []string:
+		return slices.Contains(typ, "string") && !slices.ContainsFunc(typ, func(t string) bool {
+			return t != "string" && t != "null"
+		})
+	}
+	return false
+}
+
+// setIn sets the value at path creating any missing mappings along the way.
+func setIn(node any, path []string, v any) any {
+	if len(path) == 0 {
+		return v
+	}
+
+	if seq, ok := node.([]any); ok {
+		i, _ := strconv.Atoi(path[0])
+		seq[i] = setIn(seq[i], path[1:], v)
+		return seq
+	}
+
+	m, _ := node.(yaml.MapSlice)
+	for i, mi := range m {
+		if fmt.Sprint(mi.Key) == path[0] {
+			m[i].Value = setIn(mi.Value, path[1:], v)
+			return m
+		}
+	}
+
+	return append(m, yaml.MapItem{Key: path[0], Value: setIn(nil, path[1:], v)})
+}
+
+// lookup returns the merged value at path, if any.
+func (lc *layeredConf) lookup(path []string) any {
+	var node any = lc.tree
+	for _, k := range path {
+		switch n := node.(type) {
+		case yaml.MapSlice:
+			i := slices.IndexFunc(n, func(mi yaml.MapItem) bool { return fmt.Sprint(mi.Key) == k })
+			if i < 0 {
+				return nil
+			}
+			node = n[i].Value
+		case []any:
+			i, err := strconv.Atoi(k)
+			if err != nil || i < 0 || i >= len(n) {
+				return nil
+			}
+			node = n[i]
+		default:
+			return nil
+		}
+	}
+	return node
+}
+
+// locate returns the origin of the value at path or, if unknown, that of the
+// closest value containing it.
+func (lc *layeredConf) locate(path []string) origin {
+	for i := len(path); i >= 0; i-- {
+		if o, ok := lc.origins[joinPath(path[:i])]; ok {
+			return o
+		}
+	}
+	return origin{}
+}
+
+// marshal returns the merged configuration as YAML.
+func (lc *layeredConf) marshal() ([]byte, error) {
+	if len(lc.tree) == 0 {
+		return []byte("{}"), nil
+	}
+	return yaml.Marshal(lc.tree)
+}
+
+// annotate renders the merged configuration as YAML with a trailing comment on
+// each value pointing at its origin.
+func (lc *layeredConf) annotate() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := lc.annotateNode(&buf, lc.tree, nil, ""); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (lc *layeredConf) annotateNode(buf *bytes.Buffer, node any, path []string, indent string) error {
+	switch n := node.(type) {
+	case yaml.MapSlice:
+		for _, mi := range n {
+			itemPath := append(slices.Clone(path), fmt.Sprint(mi.Key))
+			key, err := yaml.Marshal(mi.Key)
+			if err != nil {
+				return err
+			}
+
+			if isCollection(mi.Value) {
+				fmt.Fprintf(buf, "%s%s: # %s\n", indent, bytes.TrimSpace(key), lc.locate(itemPath))
+				if err := lc.annotateNode(buf, mi.Value, itemPath, indent+"  "); err != nil {
+					return err
+				}
+				continue
+			}
+
+			value, err := yaml.MarshalWithOptions(mi.Value, yaml.Flow(true))
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(buf, "%s%s: %s # %s\n", indent, bytes.TrimSpace(key), bytes.TrimSpace(value), lc.locate(itemPath))
+		}
+
+	case []any:
+		for i, item := range n {
+			itemPath := append(slices.Clone(path), strconv.Itoa(i))
+			fmt.Fprintf(buf, "%s- # %s\n", indent, lc.locate(itemPath))
+			if err := lc.annotateNode(buf, item, itemPath, indent+"  "); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// isCollection returns whether a value is a non-empty mapping or a sequence of
+// mappings, which are rendered in block style.
+func isCollection(v any) bool {
+	switch v := v.(type) {
+	case yaml.MapSlice:
+		return len(v) > 0
+	case []any:
+		return slices.ContainsFunc(v, func(item any) bool {
+			_, ok := item.(yaml.MapSlice)
+			return ok
+		})
+	}
+	return false
+}
+
+func joinPath(path []string) string {
+	return strings.Join(path, ".")
+}
+
+// yamlNode returns the node at the given location, if any. The location is
+// made up of mapping keys and sequence indices.
+func yamlNode(node ast.Node, loc []string) ast.Node {
+	n, _ := walkYAML(node, loc)
+	return n
+}
+
+// yamlLine returns the line the value at the given location is defined at or
+// 0 if it can't be found. Mapping values are reported at the line of their key.
+func yamlLine(node ast.Node, loc []string) int {
+	if node == nil {
+		return 0
+	}
+
+	n, key := walkYAML(node, loc)
+	if key != nil {
+		n = key
+	}
+	if n == nil {
+		return 0
+	}
+	return n.GetToken().Position.Line
+}
+
+// walkYAML follows a location returning the node found there together with
+// the key of the mapping value it belongs to, if any. If the location can't be
+// followed all the way through the deepest node found is returned instead.
+func walkYAML(node ast.Node, loc []string) (ast.Node, ast.Node) {
+	var key ast.Node
+
+	for _, tok := range loc {
+		switch n := unwrapYAML(node).(type) {
+		case *ast.MappingNode:
+			v := mappingValue(n.Values, tok)
+			if v == nil {
+				return node, key
+			}
+			node, key = v.Value, v.Key
+
+		case *ast.MappingValueNode:
+			v := mappingValue([]*ast.MappingValueNode{n}, tok)
+			if v == nil {
+				return node, key
+			}
+			node, key = v.Value, v.Key
+
+		case *ast.SequenceNode:
+			i, err := strconv.Atoi(tok)
+			if err != nil || i < 0 || i >= len(n.Values) {
+				return node, key
+			}
+			node, key = n.Values[i], nil
+
+		default:
+			return node, key
+		}
+	}
+
+	return node, key
+}
+
+func mappingValue(values []*ast.MappingValueNode, key string) *ast.MappingValueNode {
+	for _, v := range values {
+		if v.Key.GetToken().Value == key {
+			return v
+		}
+	}
+	return nil
+}
+
+// unwrapYAML looks through anchors and tags.
+func unwrapYAML(node ast.Node) ast.Node {
+	for {
+		switch n := node.(type) {
+		case *ast.AnchorNode:
+			node = n.Value
+		case *ast.TagNode:
+			node = n.Value
+		default:
+			return node
+		}
+	}
+}
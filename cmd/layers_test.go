@@ -0,0 +1,93 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-yaml"
+	"github.com/scitags/flowd-go/backends/fireflyb"
+	"github.com/scitags/flowd-go/backends/marker"
+	"github.com/scitags/flowd-go/plugins/np"
+)
+
+func TestLayeredConf(t *testing.T) {
+	environ := []string{
+		"HOME=/root",
+		"FLOWD_GO_SNAPSHOTPERIOD=0",
+		"FLOWD_GO_PLUGINS_NAMEDPIPE_PIPEPATH=/tmp/np",
+		"FLOWD_GO_BACKENDS_FIREFLY_FF2_COLLECTORADDRESS=10.0.0.1",
+		"FLOWD_GO_BACKENDS_FIREFLY_0_DESTINATIONPORT=10516",
+		"FLOWD_GO_BACKENDS_MARKER_TARGETINTERFACES=[lo, eth0]",
+	}
+
+	lc, err := loadLayeredConf("testdata/layers/conf.yaml", "testdata/layers/conf.d", environ)
+	if err != nil {
+		t.Fatalf("error loading the configuration: %v", err)
+	}
+
+	raw, err := lc.marshal()
+	if err != nil {
+		t.Fatalf("error marshaling the configuration: %v", err)
+	}
+
+	conf := Config{}
+	if err := yaml.Unmarshal(raw, &conf); err != nil {
+		t.Fatalf("error decoding the configuration: %v", err)
+	}
+
+	if conf.PidPath != "/run/flowd-go.pid" || conf.FlowTTL != 120 || conf.SnapshotPeriod != 0 {
+		t.Errorf("got pidPath %q, flowTTL %d and snapshotPeriod %d", conf.PidPath, conf.FlowTTL, conf.SnapshotPeriod)
+	}
+
+	npConf := conf.Plugins["namedPipe"].Config.(*np.Config)
+	if npConf.PipePath != "/tmp/np" || npConf.MaxReaders != 2 {
+		t.Errorf("got namedPipe configuration %+v", npConf)
+	}
+
+	ff1 := conf.Backends["ff1"].Config.(*fireflyb.Config)
+	ff2 := conf.Backends["ff2"].Config.(*fireflyb.Config)
+	if ff1.DestinationPort != 10516 || ff2.CollectorAddress != "10.0.0.1" || !ff2.SendToCollector {
+		t.Errorf("got firefly configurations %+v and %+v", ff1, ff2)
+	}
+
+	mConf := conf.Backends["marker"].Config.(*marker.Config)
+	if strings.Join(mConf.TargetInterfaces, ",") != "lo,eth0" {
+		t.Errorf("got target interfaces %v", mConf.TargetInterfaces)
+	}
+
+	origins := map[string]string{
+		"pidPath":                             "testdata/layers/conf.yaml:1",
+		"flowTTL":                             "testdata/layers/conf.d/20-ttl.yaml:2",
+		"snapshotPeriod":                      "$FLOWD_GO_SNAPSHOTPERIOD",
+		"plugins.namedPipe":                   "testdata/layers/conf.yaml:5",
+		"plugins.namedPipe.maxReaders":        "testdata/layers/conf.d/20-ttl.yaml:5",
+		"backends.firefly.1.sendToCollector":  "testdata/layers/conf.yaml:13",
+		"backends.firefly.1.collectorAddress": "$FLOWD_GO_BACKENDS_FIREFLY_FF2_COLLECTORADDRESS",
+		"backends.marker":                     "testdata/layers/conf.d/10-collector.yaml:2",
+	}
+	for path, want := range origins {
+		if got := lc.locate(strings.Split(path, ".")).String(); got != want {
+			t.Errorf("got origin %q for %s; want %q", got, path, want)
+		}
+	}
+
+	annotated, err := lc.annotate()
+	if err != nil {
+		t.Fatalf("error annotating the configuration: %v", err)
+	}
+	if !strings.Contains(string(annotated), "    targetInterfaces: [lo, eth0] # $FLOWD_GO_BACKENDS_MARKER_TARGETINTERFACES\n") {
+		t.Errorf("unexpected annotated configuration:\n%s", annotated)
+	}
+}
+
+func TestLayeredConfWrongEnv(t *testing.T) {
+	for _, env := range []string{
+		"FLOWD_GO_PIDPTH=/run/flowd-go.pid",
+		"FLOWD_GO_BACKENDS_FIREFLY_FF3_ENRICH=true",
+		"FLOWD_GO_FLOWTTL=[",
+	} {
+		if _, err := loadLayeredConf("testdata/layers/conf.yaml", "testdata/layers/conf.d", []string{env}); err == nil {
+			t.Errorf("%s: expected an error", env)
+		}
+	}
+}
@@ -1,11 +1,16 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/netip"
+	"os"
 	"path/filepath"
 
+	"github.com/coreos/go-systemd/v22/journal"
+
 	"github.com/scitags/flowd-go/backends/marker"
 	"github.com/scitags/flowd-go/types"
 )
@@ -23,6 +28,46 @@ var logLevelMap = map[string]slog.Level{
 	"error": types.LevelError,
 }
 
+// newLogHandler builds the handler every log record goes through. Records are
+// rendered as either text (i.e. logfmt) or JSON and are written to one of
+// stderr, syslog or journald. Note journald ignores the format as it stores
+// every attribute as a separate field.
+func newLogHandler(format, sink, syslogAddress string, level slog.Level) (slog.Handler, error) {
+	opts := &slog.HandlerOptions{
+		AddSource:   true,
+		Level:       level,
+		ReplaceAttr: logReplacements,
+	}
+
+	var newInner func(w io.Writer) slog.Handler
+	switch format {
+	case "text":
+		newInner = func(w io.Writer) slog.Handler { return slog.NewTextHandler(w, opts) }
+	case "json":
+		newInner = func(w io.Writer) slog.Handler { return slog.NewJSONHandler(w, opts) }
+	default:
+		return nil, fmt.Errorf("wrong log format %q: it must be one of text or json", format)
+	}
+
+	switch sink {
+	case "stderr":
+		return newInner(os.Stderr), nil
+	case "syslog":
+		w, err := newSyslogWriter(syslogAddress)
+		if err != nil {
+			return nil, err
+		}
+		return newFramedHandler(func(b *bytes.Buffer) slog.Handler { return newInner(b) }, w.write), nil
+	case "journald":
+		if !journal.Enabled() {
+			return nil, fmt.Errorf("journald is not available on this machine")
+		}
+		return newJournaldHandler(opts), nil
+	default:
+		return nil, fmt.Errorf("wrong log sink %q: it must be one of stderr, syslog or journald", sink)
+	}
+}
+
 func logReplacements(groups []string, a slog.Attr) slog.Attr {
 	// Remove time.
 	if a.Key == slog.TimeKey && len(groups) == 0 && !logTimeFlag {
@@ -50,7 +95,7 @@ func logReplacements(groups []string, a slog.Attr) slog.Attr {
 		flowHash, ok := a.Value.Any().(marker.FlowFourTuple)
 		if ok {
 			return slog.Attr{Key: a.Key, Value: slog.StringValue(
-				fmt.Sprintf("%s(%#x|%#x);%d;%d", func() netip.Addr {
+				fmt.Sprintf("%s(%#x|%#x);%d;%d;%d", func() netip.Addr {
 					r, _ := netip.AddrFromSlice([]byte{
 						byte(flowHash.IPv6Hi & (0xFF << 7) >> 7),
 						byte(flowHash.IPv6Hi & (0xFF << 6) >> 6),
@@ -70,7 +115,7 @@ func logReplacements(groups []string, a slog.Attr) slog.Attr {
 						byte(flowHash.IPv6Lo & 0xFF),
 					})
 					return r
-				}(), flowHash.IPv6Hi, flowHash.IPv6Lo, flowHash.SrcPort, flowHash.DstPort),
+				}(), flowHash.IPv6Hi, flowHash.IPv6Lo, flowHash.SrcPort, flowHash.DstPort, flowHash.Protocol),
 			)}
 		}
 	}
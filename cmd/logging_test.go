@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/netip"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/journal"
+	"github.com/scitags/flowd-go/types"
+)
+
+var testLogFlowID = types.FlowID{
+	State:      types.START,
+	Protocol:   types.TCP,
+	Src:        netip.MustParseAddrPort("[2001:db8::1]:2345"),
+	Dst:        netip.MustParseAddrPort("[2001:db8::2]:5777"),
+	Experiment: 2,
+	Activity:   3,
+}
+
+func TestLogJSON(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{ReplaceAttr: logReplacements}))
+
+	logger.Info("dispatching", types.LogKeyFlow, testLogFlowID, types.LogKeyBackend, "marker", FlowTagKey, uint32(0xa))
+
+	var entry struct {
+		Flow struct {
+			State      string
+			Src        string
+			Experiment int
+		}
+		Backend string
+		FlowTag string
+	}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("error parsing %q: %v", buf.String(), err)
+	}
+
+	if entry.Flow.State != "start" || entry.Flow.Src != "[2001:db8::1]:2345" || entry.Flow.Experiment != 2 {
+		t.Errorf("got flow %+v from %q", entry.Flow, buf.String())
+	}
+	if entry.Backend != "marker" || entry.FlowTag != "0xa;(0b00000000000000001010)" {
+		t.Errorf("got backend %q and flowTag %q", entry.Backend, entry.FlowTag)
+	}
+}
+
+func TestLogSyslog(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer conn.Close()
+
+	handler, err := newLogHandler("json", "syslog", "udp://"+conn.LocalAddr().String(), slog.LevelInfo)
+	if err != nil {
+		t.Fatalf("error creating the handler: %v", err)
+	}
+	logger := slog.New(handler).With(types.LogKeyPlugin, "np")
+
+	logger.Debug("should be filtered out")
+	logger.Warn("bad flowID", "err", "oops")
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	b := make([]byte, 4096)
+	n, _, err := conn.ReadFrom(b)
+	if err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+
+	// Facility daemon (3) and severity warning (4) yield a priority of 28
+	re := regexp.MustCompile(`^<28>1 \S+ \S+ flowd-go \d+ - - (\{.*\})$`)
+	m := re.FindSubmatch(b[:n])
+	if m == nil {
+		t.Fatalf("got unexpected message %q", b[:n])
+	}
+
+	var entry map[string]any
+	if err := json.Unmarshal(m[1], &entry); err != nil {
+		t.Fatalf("error parsing %q: %v", m[1], err)
+	}
+	if entry["msg"] != "bad flowID" || entry["plugin"] != "np" || entry["err"] != "oops" {
+		t.Errorf("got unexpected entry %v", entry)
+	}
+}
+
+func TestLogJournald(t *testing.T) {
+	var (
+		gotMsg      string
+		gotPriority journal.Priority
+		gotFields   map[string]string
+	)
+
+	h := newJournaldHandler(&slog.HandlerOptions{AddSource: true, ReplaceAttr: logReplacements})
+	h.send = func(msg string, priority journal.Priority, fields map[string]string) error {
+		gotMsg, gotPriority, gotFields = msg, priority, fields
+		return nil
+	}
+
+	logger := slog.New(h).With(types.LogKeyBackend, "marker")
+	logger.Error("error marking", types.LogKeyFlow, testLogFlowID, FlowTagKey, uint32(0xa))
+
+	if gotMsg != "error marking" || gotPriority != journal.PriErr {
+		t.Errorf("got message %q with priority %d", gotMsg, gotPriority)
+	}
+
+	want := map[string]string{
+		"SYSLOG_IDENTIFIER": "flowd-go",
+		"BACKEND":           "marker",
+		"FLOW_STATE":        "start",
+		"FLOW_SRC":          "[2001:db8::1]:2345",
+		"FLOW_EXPERIMENT":   "2",
+		"FLOWTAG":           "0xa;(0b00000000000000001010)",
+	}
+	for k, v := range want {
+		if gotFields[k] != v {
+			t.Errorf("got %s=%q; want %q", k, gotFields[k], v)
+		}
+	}
+	if gotFields["CODE_LINE"] == "" {
+		t.Errorf("missing source information in %v", gotFields)
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/journal"
+)
+
+const appName = "flowd-go"
+
+// framedHandler renders records through an inner text or JSON handler and
+// hands each of them over to a sink together with the record's level, which
+// sinks such as syslog need to know about.
+type framedHandler struct {
+	inner slog.Handler
+	out   *framedOutput
+}
+
+// framedOutput is shared by every handler derived from the same framedHandler
+// (i.e. through WithAttrs or WithGroup), just like the writer inner handlers
+// render records to.
+type framedOutput struct {
+	mu    sync.Mutex
+	buf   bytes.Buffer
+	write func(level slog.Level, msg []byte) error
+}
+
+func newFramedHandler(newInner func(w *bytes.Buffer) slog.Handler, write func(slog.Level, []byte) error) *framedHandler {
+	out := &framedOutput{write: write}
+	return &framedHandler{inner: newInner(&out.buf), out: out}
+}
+
+func (h *framedHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+func (h *framedHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.out.mu.Lock()
+	defer h.out.mu.Unlock()
+
+	h.out.buf.Reset()
+	if err := h.inner.Handle(ctx, r); err != nil {
+		return err
+	}
+
+	return h.out.write(r.Level, bytes.TrimRight(h.out.buf.Bytes(), "\n"))
+}
+
+func (h *framedHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &framedHandler{inner: h.inner.WithAttrs(attrs), out: h.out}
+}
+
+func (h *framedHandler) WithGroup(name string) slog.Handler {
+	return &framedHandler{inner: h.inner.WithGroup(name), out: h.out}
+}
+
+// syslogWriter sends RFC 5424 messages to a syslog daemon. Messages sent over
+// TCP are framed through octet counting as per RFC 6587.
+type syslogWriter struct {
+	network  string
+	address  string
+	hostname string
+	conn     net.Conn
+}
+
+// The facility every message is logged with: system daemons.
+const syslogFacility = 3
+
+// newSyslogWriter connects to the syslog daemon at the given address, which
+// can be one of unix:///path, udp://host:port or tcp://host:port. An empty
+// address implies the local daemon listening on /dev/log.
+func newSyslogWriter(address string) (*syslogWriter, error) {
+	w := &syslogWriter{network: "unixgram", address: "/dev/log"}
+
+	if address != "" {
+		u, err := url.Parse(address)
+		if err != nil {
+			return nil, fmt.Errorf("wrong syslog address %q: %w", address, err)
+		}
+
+		switch u.Scheme {
+		case "unix":
+			w.address = u.Path
+		case "udp", "tcp":
+			w.network, w.address = u.Scheme, u.Host
+		default:
+			return nil, fmt.Errorf("wrong syslog address %q: the scheme must be one of unix, udp or tcp", address)
+		}
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "-"
+	}
+	w.hostname = hostname
+
+	if err := w.connect(); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func (w *syslogWriter) connect() error {
+	conn, err := net.Dial(w.network, w.address)
+	if err != nil {
+		return fmt.Errorf("error connecting to syslog on %s://%s: %w", w.network, w.address, err)
+	}
+	w.conn = conn
+	return nil
+}
+
+func syslogSeverity(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return 3
+	case level >= slog.LevelWarn:
+		return 4
+	case level >= slog.LevelInfo:
+		return 6
+	default:
+		return 7
+	}
+}
+
+func (w *syslogWriter) format(level slog.Level, now time.Time, msg []byte) []byte {
+	// <PRI>VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+	m := fmt.Appendf(nil, "<%d>1 %s %s %s %d - - %s", syslogFacility*8+syslogSeverity(level),
+		now.Format("2006-01-02T15:04:05.000000Z07:00"), w.hostname, appName, os.Getpid(), msg)
+
+	if w.network == "tcp" {
+		m = append([]byte(strconv.Itoa(len(m))+" "), m...)
+	}
+
+	return m
+}
+
+// write sends a message reconnecting once if needed so that a restarted
+// syslog daemon doesn't leave us logging into the void.
+func (w *syslogWriter) write(level slog.Level, msg []byte) error {
+	m := w.format(level, time.Now(), msg)
+	if _, err := w.conn.Write(m); err == nil {
+		return nil
+	}
+
+	w.conn.Close()
+	if err := w.connect(); err != nil {
+		return err
+	}
+
+	_, err := w.conn.Write(m)
+	return err
+}
+
+// journaldHandler sends records to journald through its native protocol so
+// that every attribute ends up as a field of its own. Attribute keys are
+// upper-cased and grouped keys are joined with underscores such that, for
+// instance, the source address of a flow ends up as FLOW_SRC.
+type journaldHandler struct {
+	opts slog.HandlerOptions
+
+	// Fields added through WithAttrs and the prefix of the current group.
+	fields map[string]string
+	prefix string
+
+	send func(msg string, priority journal.Priority, fields map[string]string) error
+}
+
+func newJournaldHandler(opts *slog.HandlerOptions) *journaldHandler {
+	return &journaldHandler{opts: *opts, fields: map[string]string{}, send: journal.Send}
+}
+
+func (h *journaldHandler) Enabled(_ context.Context, level slog.Level) bool {
+	minLevel := slog.LevelInfo
+	if h.opts.Level != nil {
+		minLevel = h.opts.Level.Level()
+	}
+	return level >= minLevel
+}
+
+func journaldPriority(level slog.Level) journal.Priority {
+	switch {
+	case level >= slog.LevelError:
+		return journal.PriErr
+	case level >= slog.LevelWarn:
+		return journal.PriWarning
+	case level >= slog.LevelInfo:
+		return journal.PriInfo
+	default:
+		return journal.PriDebug
+	}
+}
+
+func (h *journaldHandler) Handle(_ context.Context, r slog.Record) error {
+	fields := make(map[string]string, len(h.fields)+r.NumAttrs()+4)
+	for k, v := range h.fields {
+		fields[k] = v
+	}
+
+	fields["SYSLOG_IDENTIFIER"] = appName
+	if h.opts.AddSource && r.PC != 0 {
+		frame, _ := runtime.CallersFrames([]uintptr{r.PC}).Next()
+		fields["CODE_FILE"] = frame.File
+		fields["CODE_LINE"] = strconv.Itoa(frame.Line)
+		fields["CODE_FUNC"] = frame.Function
+	}
+
+	r.Attrs(func(a slog.Attr) bool {
+		h.addField(fields, h.prefix, nil, a)
+		return true
+	})
+
+	return h.send(r.Message, journaldPriority(r.Level), fields)
+}
+
+// addField flattens an attribute into journald fields applying the
+// configured ReplaceAttr function along the way.
+func (h *journaldHandler) addField(fields map[string]string, prefix string, groups []string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Value.Kind() != slog.KindGroup && h.opts.ReplaceAttr != nil {
+		a = h.opts.ReplaceAttr(groups, a)
+		a.Value = a.Value.Resolve()
+	}
+
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += journaldFieldName(a.Key) + "_"
+			groups = append(groups, a.Key)
+		}
+		for _, ga := range a.Value.Group() {
+			h.addField(fields, prefix, groups, ga)
+		}
+		return
+	}
+
+	fields[prefix+journaldFieldName(a.Key)] = a.Value.String()
+}
+
+// journaldFieldName turns an attribute key into a valid journald field name,
+// which can only contain upper-case letters, digits and underscores.
+func journaldFieldName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9'):
+			return r
+		default:
+			return '_'
+		}
+	}, key)
+
+	// Fields beginning with an underscore are reserved for journald itself
+	return strings.TrimLeft(name, "_")
+}
+
+func (h *journaldHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	nh := *h
+	nh.fields = make(map[string]string, len(h.fields)+len(attrs))
+	for k, v := range h.fields {
+		nh.fields[k] = v
+	}
+	for _, a := range attrs {
+		h.addField(nh.fields, h.prefix, nil, a)
+	}
+	return &nh
+}
+
+func (h *journaldHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	nh := *h
+	nh.prefix += journaldFieldName(name) + "_"
+	return &nh
+}
@@ -13,8 +13,12 @@ import (
 
 func init() {
 	rootCmd.PersistentFlags().StringVar(&confPath, "conf", "/etc/flowd-go/conf.yaml", "path of the JSON configuration file")
+	rootCmd.PersistentFlags().StringVar(&confDirPath, "conf-dir", "", "path of the drop-in configuration directory (defaults to conf.d next to the configuration file)")
 	rootCmd.PersistentFlags().StringVar(&logLevelFlag, "log-level", "info", "log level: one of debug, info, warn, error")
 	rootCmd.PersistentFlags().BoolVar(&logTimeFlag, "log-time", false, "whether to include timestamps in the log")
+	rootCmd.PersistentFlags().StringVar(&logFormatFlag, "log-format", "text", "log format: one of text, json")
+	rootCmd.PersistentFlags().StringVar(&logSinkFlag, "log-sink", "stderr", "log destination: one of stderr, syslog, journald")
+	rootCmd.PersistentFlags().StringVar(&logSyslogAddressFlag, "log-syslog-address", "", "syslog address as unix:///path, udp://host:port or tcp://host:port (defaults to /dev/log)")
 }
 
 var (
@@ -26,18 +30,19 @@ var (
 		// Note we need to configure logging here as flags are not parsed before
 		// calling rootCmd.Execute on main... As this PreRun function is persistent,
 		// it'll be inherited by subcommands
-		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
 			logLevel, ok := logLevelMap[logLevelFlag]
 			if !ok {
 				logLevel = slog.LevelInfo
 			}
 
-			logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
-				AddSource:   true,
-				Level:       logLevel,
-				ReplaceAttr: logReplacements,
-			}))
-			slog.SetDefault(logger)
+			handler, err := newLogHandler(logFormatFlag, logSinkFlag, logSyslogAddressFlag, logLevel)
+			if err != nil {
+				return fmt.Errorf("error configuring logging: %w", err)
+			}
+			slog.SetDefault(slog.New(handler))
+
+			return nil
 		},
 	}
 
@@ -52,17 +57,73 @@ var (
 	confCmd = &cobra.Command{
 		Use:   "conf",
 		Short: "Dump the configuration we're running with.",
+		Long: "Dump the configuration assembled from the configuration file, the drop-in directory and the " +
+			"environment pointing at where each value comes from. Unset values take their defaults, which " +
+			"can be shown by dumping the decoded configuration instead.",
 		Run: func(cmd *cobra.Command, args []string) {
-			conf, err := ReadConf(confPath)
+			if confDecodedFlag {
+				conf, err := ReadConf(confPath)
+				if err != nil {
+					slog.Error("couldn't read the configuration", "err", err)
+					return
+				}
+				jsonConf, err := json.MarshalIndent(conf, "", "    ")
+				if err != nil {
+					fmt.Printf("couldn't marshall the configuration: %v", err)
+				}
+				fmt.Printf("%s\n", jsonConf)
+				return
+			}
+
+			lc, err := loadLayeredConf(confPath, dropInDir(confPath), os.Environ())
 			if err != nil {
 				slog.Error("couldn't read the configuration", "err", err)
 				return
 			}
-			jsonConf, err := json.MarshalIndent(conf, "", "    ")
+			annotated, err := lc.annotate()
 			if err != nil {
 				fmt.Printf("couldn't marshall the configuration: %v", err)
 			}
-			fmt.Printf("%s\n", jsonConf)
+			fmt.Printf("%s", annotated)
+		},
+	}
+
+	confValidateCmd = &cobra.Command{
+		Use:   "validate",
+		Short: "Check the configuration without running anything.",
+		Long: "Check the configuration is well formed and that every plugin, backend and enricher is happy with it " +
+			"without touching the kernel. Every problem found is reported together with the line it was found at.",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			confErrs, err := ValidateConf(confPath)
+			if err != nil {
+				return err
+			}
+
+			for _, e := range confErrs {
+				fmt.Println(e)
+			}
+
+			if len(confErrs) > 0 {
+				return fmt.Errorf("found %d problem(s) in %s", len(confErrs), confPath)
+			}
+
+			fmt.Printf("%s: the configuration is valid\n", confPath)
+			return nil
+		},
+	}
+
+	confSchemaCmd = &cobra.Command{
+		Use:   "schema",
+		Short: "Print the JSON Schema of the configuration.",
+		Run: func(cmd *cobra.Command, args []string) {
+			s, err := marshalConfSchema()
+			if err != nil {
+				slog.Error("couldn't marshal the schema", "err", err)
+				return
+			}
+			fmt.Printf("%s", s)
 		},
 	}
 
@@ -83,17 +144,28 @@ var (
 	}
 
 	confPath     string
+	confDirPath  string
 	logLevelFlag string
 	logTimeFlag  bool
 	builtCommit  string
 	baseVersion  string
+
+	confDecodedFlag bool
+
+	logFormatFlag        string
+	logSinkFlag          string
+	logSyslogAddressFlag string
 )
 
 func init() {
 	// Disable completion please!
 	rootCmd.CompletionOptions.DisableDefaultCmd = true
 
+	confCmd.Flags().BoolVar(&confDecodedFlag, "decoded", false, "dump the decoded configuration including default values as JSON")
+
 	// Add sub2-commands
+	confCmd.AddCommand(confValidateCmd)
+	confCmd.AddCommand(confSchemaCmd)
 	markerCmd.AddCommand(subcmd.MarkerClean)
 	stunCmd.AddCommand(subcmd.StunSample)
 
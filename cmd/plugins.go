@@ -3,67 +3,128 @@ package main
 import (
 	"fmt"
 	"log/slog"
+	"time"
 
-	"github.com/scitags/flowd-go/plugins/api"
-	"github.com/scitags/flowd-go/plugins/fireflyp"
-	"github.com/scitags/flowd-go/plugins/iperf3"
-	"github.com/scitags/flowd-go/plugins/np"
-	"github.com/scitags/flowd-go/plugins/perfsonar"
+	"github.com/scitags/flowd-go/internal/telemetry"
 	"github.com/scitags/flowd-go/types"
 )
 
-func createPlugins(c *Config) ([]types.Plugin, error) {
-	plugins := []types.Plugin{}
+// pluginHandle ties a running plugin to the configuration it was created
+// with and the channels it's been handed so that it can be stopped on its
+// own when reloading the configuration.
+type pluginHandle struct {
+	name   string
+	conf   *instance
+	plugin types.Plugin
 
-	if c.Plugins != nil {
-		if c.Plugins.Api != nil {
-			p, err := api.NewApiPlugin(c.Plugins.Api)
-			if err != nil {
-				return nil, fmt.Errorf("error initialising the api plugin: %w", err)
-			}
-			plugins = append(plugins, p)
-		}
+	// Closed to signal the plugin (and its funnel) to stop.
+	done chan struct{}
 
-		if c.Plugins.Firefly != nil {
-			p, err := fireflyp.NewFireflyPlugin(c.Plugins.Firefly)
-			if err != nil {
-				return nil, fmt.Errorf("error initialising the firefly plugin: %w", err)
-			}
-			plugins = append(plugins, p)
-		}
+	// Closed once the plugin has been cleaned up so that the funnel can
+	// stop draining its channel.
+	stopped chan struct{}
+}
 
-		if c.Plugins.Np != nil {
-			p, err := np.NewNamedPipePlugin(c.Plugins.Np)
-			if err != nil {
-				return nil, fmt.Errorf("error initialising the perfsonar plugin: %w", err)
-			}
-			plugins = append(plugins, p)
-		}
+// pluginConfs returns the configuration of every configured plugin instance
+// keyed by the instance's name.
+func pluginConfs(c *Config) map[string]*instance {
+	return c.Plugins
+}
 
-		if c.Plugins.Perfsonar != nil {
-			p, err := perfsonar.NewPerfsonarPlugin(c.Plugins.Perfsonar)
-			if err != nil {
-				return nil, fmt.Errorf("error initialising the perfsonar plugin: %w", err)
-			}
-			plugins = append(plugins, p)
-		}
+func newPlugin(name string, inst *instance) (types.Plugin, error) {
+	r, ok := types.LookupPlugin(inst.Type)
+	if !ok {
+		return nil, fmt.Errorf("unknown plugin %q", inst.Type)
+	}
+
+	if err := validateInstance(inst); err != nil {
+		return nil, fmt.Errorf("wrong configuration for the %s plugin %q: %w", inst.Type, name, err)
+	}
+
+	p, err := r.Factory(name, inst.Config)
+	if err != nil {
+		return nil, fmt.Errorf("error initialising the %s plugin %q: %w", inst.Type, name, err)
+	}
 
-		if c.Plugins.Iperf3 != nil {
-			p, err := iperf3.NewIperf3Plugin(c.Plugins.Iperf3)
-			if err != nil {
-				return nil, fmt.Errorf("error initialising the iperf3 plugin: %w", err)
+	return p, nil
+}
+
+// startPlugin creates and runs a plugin, funneling the flowIDs it generates
+// into the aggregate channel.
+func (d *daemon) startPlugin(name string, conf *instance) error {
+	p, err := newPlugin(name, conf)
+	if err != nil {
+		return err
+	}
+
+	h := &pluginHandle{
+		name:    name,
+		conf:    conf,
+		plugin:  p,
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	ch := make(chan types.FlowID)
+	go p.Run(h.done, ch)
+
+	// Funnel plugin flowIDs into the aggregate channel. Once the plugin is
+	// stopped we'll keep on draining its channel (discarding flowIDs) until
+	// it's been cleaned up so that it can never block on us.
+	// Flows are only checked against the registry if there's one so that
+	// plugins without filters needn't keep track of anything.
+	var registered func(types.FlowID) bool
+	if types.CurrentRegistry() != nil {
+		registered = func(flowID types.FlowID) bool { return d.registered(name, flowID) }
+	}
+	filter := newPluginFilter(conf.Filters, registered, d.flows.ttl)
+	go func() {
+		slog.Debug("began listening for plugin flowIDs", types.LogKeyPlugin, name)
+		for {
+			select {
+			case flowID, ok := <-ch:
+				if !ok {
+					return
+				}
+				telemetry.PluginFlowIDs.WithLabelValues(name, flowID.State.String()).Inc()
+				if !filter.pass(flowID, time.Now()) {
+					slog.Debug("flowID filtered out", types.LogKeyPlugin, name, types.LogKeyFlow, flowID)
+					continue
+				}
+				slog.Debug("funneling flowID", types.LogKeyPlugin, name)
+				select {
+				case d.aggFlowIDs <- flowID:
+				case <-h.done:
+					slog.Debug("discarding flowID from a stopped plugin", types.LogKeyPlugin, name, types.LogKeyFlow, flowID)
+				}
+
+			case <-h.stopped:
+				return
 			}
-			plugins = append(plugins, p)
 		}
-	}
+	}()
 
-	return plugins, nil
+	d.plugins = append(d.plugins, h)
+
+	return nil
 }
 
-func cleanupPlugins(plugins []types.Plugin) {
-	for _, plugin := range plugins {
-		if err := plugin.Cleanup(); err != nil {
-			slog.Error("error cleaning up plugin", "plugin", plugin, "err", err)
+// stopPlugin stops and cleans up the plugin with the given name, if any.
+func (d *daemon) stopPlugin(name string) {
+	for i, h := range d.plugins {
+		if h.name != name {
+			continue
 		}
+
+		close(h.done)
+		if err := h.plugin.Cleanup(); err != nil {
+			slog.Error("error cleaning up plugin", types.LogKeyPlugin, h.name, "err", err)
+		}
+		close(h.stopped)
+
+		telemetry.ForgetPlugin(name)
+
+		d.plugins = append(d.plugins[:i], d.plugins[i+1:]...)
+		return
 	}
 }
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/scitags/flowd-go/internal/telemetry"
+	"github.com/scitags/flowd-go/types"
+)
+
+// overflowPolicy determines what happens when a flowID is dispatched to a
+// backend whose queue is full.
+type overflowPolicy int
+
+const (
+	// Wait until the backend makes room in the queue. This stalls the
+	// dispatch of flowIDs to every other backend!
+	block overflowPolicy = iota
+
+	// Drop the oldest ONGOING flowID in the queue to make room for the new one.
+	dropOldest
+
+	// Drop the ONGOING flowID being dispatched.
+	dropNewest
+)
+
+var overflowPolicies = map[overflowPolicy]string{
+	block:      "block",
+	dropOldest: "dropOldest",
+	dropNewest: "dropNewest",
+}
+
+func (op overflowPolicy) String() string {
+	return overflowPolicies[op]
+}
+
+func parseOverflowPolicy(s string) (overflowPolicy, bool) {
+	for op, name := range overflowPolicies {
+		if strings.EqualFold(s, name) {
+			return op, true
+		}
+	}
+	return block, false
+}
+
+// Log a warning every dropWarnPeriod drops so that a persistently overflowing
+// queue doesn't flood the log.
+const dropWarnPeriod = 1000
+
+// backendQueue is the bounded queue sitting between the dispatch loop and a
+// backend. The dispatch loop pushes flowIDs through push so that the overflow
+// policy is honoured and pump hands them over to the backend, in order, through
+// ch. Note the dispatch loop is the only producer.
+//
+// The drop policies only ever drop ONGOING flowIDs. Dropping the START or END
+// of a flow would leave the backend (i.e. the marker's map) with state nothing
+// could ever clean up given the flow table has long forgotten about the flow,
+// so lifecycle flowIDs wait for room in the queue whatever the policy.
+type backendQueue struct {
+	backend string
+	policy  overflowPolicy
+	size    int
+
+	// The backend reads flowIDs from ch, which pump feeds from items.
+	ch    chan types.FlowID
+	mu    sync.Mutex
+	items []types.FlowID
+
+	// Signal pump there's something queued and push there's room, respectively.
+	queued chan struct{}
+	room   chan struct{}
+
+	// Closed when the backend is stopped so that we never block on it.
+	done <-chan struct{}
+
+	dropped          atomic.Uint64
+	droppedLifecycle atomic.Uint64
+}
+
+func newBackendQueue(backend string, size int, policy overflowPolicy, done <-chan struct{}) *backendQueue {
+	return &backendQueue{
+		backend: backend,
+		policy:  policy,
+		size:    size,
+		ch:      make(chan types.FlowID),
+		items:   make([]types.FlowID, 0, size),
+		queued:  make(chan struct{}, 1),
+		room:    make(chan struct{}, 1),
+		done:    done,
+	}
+}
+
+// push enqueues a flowID according to the queue's overflow policy.
+func (q *backendQueue) push(flowID types.FlowID) {
+	telemetry.BackendFlowIDs.WithLabelValues(q.backend, flowID.State.String()).Inc()
+
+	for {
+		q.mu.Lock()
+		if len(q.items) < q.size {
+			q.items = append(q.items, flowID)
+			q.mu.Unlock()
+			notify(q.queued)
+			return
+		}
+
+		if flowID.State == types.ONGOING && q.policy != block {
+			dropped := flowID
+			if i := slices.IndexFunc(q.items, isOngoing); q.policy == dropOldest && i != -1 {
+				dropped = q.items[i]
+				q.items = append(slices.Delete(q.items, i, i+1), flowID)
+			}
+			q.mu.Unlock()
+			q.drop(dropped)
+			return
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-q.room:
+		case <-q.done:
+			if flowID.State != types.ONGOING {
+				q.droppedLifecycle.Add(1)
+			}
+			return
+		}
+	}
+}
+
+func isOngoing(flowID types.FlowID) bool {
+	return flowID.State == types.ONGOING
+}
+
+// notify wakes up whoever's waiting on ch without ever blocking.
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// pump hands the queued flowIDs over to the backend until it's stopped. It
+// must be run on its own goroutine alongside the backend's Run.
+func (q *backendQueue) pump() {
+	for {
+		q.mu.Lock()
+		if len(q.items) == 0 {
+			q.mu.Unlock()
+			select {
+			case <-q.queued:
+				continue
+			case <-q.done:
+				return
+			}
+		}
+		flowID := q.items[0]
+		q.items[0] = types.FlowID{}
+		q.items = q.items[1:]
+		q.mu.Unlock()
+		notify(q.room)
+
+		select {
+		case q.ch <- flowID:
+		case <-q.done:
+			return
+		}
+	}
+}
+
+// drop accounts for a dropped flowID. Any enrichment channels it carries can
+// simply be forgotten: enrichment fan-outs never block on them.
+func (q *backendQueue) drop(flowID types.FlowID) {
+	n := q.dropped.Add(1)
+	slog.Debug("dropped flowID", types.LogKeyBackend, q.backend, types.LogKeyFlow, flowID, "policy", q.policy)
+	if n%dropWarnPeriod == 1 {
+		slog.Warn("backend queue is overflowing, dropping flowIDs", types.LogKeyBackend, q.backend,
+			"policy", q.policy, "size", q.size, "dropped", n)
+	}
+}
+
+// depth returns the number of flowIDs waiting to be processed by the backend.
+func (q *backendQueue) depth() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+// drops returns the number of (ONGOING) flowIDs dropped so far.
+func (q *backendQueue) drops() uint64 {
+	return q.dropped.Load()
+}
+
+// lifecycleDrops returns the number of START and END flowIDs discarded so far.
+// They're only ever discarded when the backend is stopped whilst they wait for
+// room in the queue: the flows are replayed if the backend is started again.
+func (q *backendQueue) lifecycleDrops() uint64 {
+	return q.droppedLifecycle.Load()
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/scitags/flowd-go/types"
+)
+
+func drain(q *backendQueue) []uint16 {
+	ports := []uint16{}
+	for _, flowID := range q.items {
+		ports = append(ports, flowID.Src.Port())
+	}
+	return ports
+}
+
+func TestBackendQueueDrops(t *testing.T) {
+	tests := map[overflowPolicy][]uint16{
+		dropNewest: {1, 2},
+		dropOldest: {3, 4},
+	}
+
+	for policy, want := range tests {
+		q := newBackendQueue("test", 2, policy, make(chan struct{}))
+		for port := uint16(1); port <= 4; port++ {
+			q.push(testFlowID(types.ONGOING, port))
+		}
+
+		if q.drops() != 2 {
+			t.Errorf("%s: got %d drops; want 2", policy, q.drops())
+		}
+
+		got := drain(q)
+		if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+			t.Errorf("%s: got %v; want %v", policy, got, want)
+		}
+	}
+}
+
+func TestBackendQueueLifecycle(t *testing.T) {
+	tests := map[overflowPolicy][]types.FlowState{
+		dropNewest: {types.ONGOING, types.START, types.END},
+		dropOldest: {types.START, types.ONGOING, types.END},
+	}
+
+	for policy, want := range tests {
+		q := newBackendQueue("test", 2, policy, make(chan struct{}))
+		q.push(testFlowID(types.ONGOING, 1))
+		q.push(testFlowID(types.START, 2))
+
+		// Only ONGOING flowIDs are ever dropped
+		q.push(testFlowID(types.ONGOING, 3))
+		if q.drops() != 1 || q.depth() != 2 {
+			t.Errorf("%s: got %d drops and %d flowIDs; want 1 and 2", policy, q.drops(), q.depth())
+		}
+
+		pushed := make(chan struct{})
+		go func() {
+			q.push(testFlowID(types.END, 4))
+			close(pushed)
+		}()
+
+		select {
+		case <-pushed:
+			t.Fatalf("%s: pushing an END onto a full queue didn't wait for room", policy)
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		go q.pump()
+		for _, state := range want {
+			if flowID := <-q.ch; flowID.State != state {
+				t.Errorf("%s: got a %s flowID; want %s", policy, flowID.State, state)
+			}
+		}
+		<-pushed
+
+		if q.drops() != 1 || q.lifecycleDrops() != 0 {
+			t.Errorf("%s: got %d drops and %d lifecycle drops; want 1 and 0", policy, q.drops(), q.lifecycleDrops())
+		}
+	}
+}
+
+func TestBackendQueueBlock(t *testing.T) {
+	done := make(chan struct{})
+	q := newBackendQueue("test", 1, block, done)
+
+	q.push(testFlowID(types.ONGOING, 1))
+
+	pushed := make(chan struct{})
+	go func() {
+		q.push(testFlowID(types.ONGOING, 2))
+		close(pushed)
+	}()
+
+	select {
+	case <-pushed:
+		t.Fatalf("pushing onto a full queue didn't block")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	go q.pump()
+	if flowID := <-q.ch; flowID.Src.Port() != 1 {
+		t.Errorf("got port %d; want 1", flowID.Src.Port())
+	}
+
+	select {
+	case <-pushed:
+	case <-time.After(time.Second):
+		t.Fatalf("pushing didn't unblock after making room in the queue")
+	}
+
+	if q.drops() != 0 {
+		t.Errorf("got %d drops; want 0", q.drops())
+	}
+
+	// Pushing onto a full queue of a stopped backend must not block
+	close(done)
+	q = newBackendQueue("test", 1, dropOldest, done)
+	q.push(testFlowID(types.START, 1))
+	q.push(testFlowID(types.END, 1))
+
+	if q.drops() != 0 || q.lifecycleDrops() != 1 {
+		t.Errorf("got %d drops and %d lifecycle drops; want 0 and 1", q.drops(), q.lifecycleDrops())
+	}
+}
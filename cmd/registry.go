@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/goccy/go-yaml"
+	"github.com/scitags/flowd-go/types"
+)
+
+const (
+	registryFile = "scitags.json"
+
+	// Bounds on fetching the registry so that a misbehaving server can't
+	// hold us up or make us run out of memory.
+	registryFetchTimeout = 30 * time.Second
+	registryMaxSize      = 4 << 20
+)
+
+// registryConf configures the SciTags registry experiment and activity names
+// are resolved against. The registry is read from a local file which can
+// optionally be refreshed from a URL.
+type registryConf struct {
+	// Where the registry is stored. It defaults to scitags.json within the
+	// working directory.
+	Path string `yaml:"path"`
+
+	// Where to fetch the registry from (i.e. https://www.scitags.org/api.json).
+	// If empty the registry is only ever read from Path.
+	URL string `yaml:"url"`
+
+	// Period (in seconds) with which the registry is refreshed from URL. A
+	// value of 0 fetches it on startup alone.
+	RefreshPeriod int `yaml:"refreshPeriod"`
+
+	// Whether to drop flows whose experiment or activity aren't registered
+	// rather than simply warning about them.
+	Strict bool `yaml:"strict"`
+}
+
+func (c *registryConf) UnmarshalYAML(b []byte) error {
+	// Needed to break recursive calls into UnmarshalYAML
+	type conf registryConf
+
+	def := &conf{
+		RefreshPeriod: 86400,
+	}
+
+	if err := yaml.Unmarshal(b, def); err != nil {
+		return err
+	}
+
+	if def.RefreshPeriod < 0 {
+		return fmt.Errorf("the registry refresh period can't be negative, got %d", def.RefreshPeriod)
+	}
+
+	if def.URL != "" {
+		u, err := url.Parse(def.URL)
+		if err != nil {
+			return fmt.Errorf("wrong registry URL: %w", err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("wrong registry URL %q: only http and https are supported", def.URL)
+		}
+	}
+
+	*c = registryConf(*def)
+
+	return nil
+}
+
+// path returns where the registry is stored.
+func (c *registryConf) path(workDir string) string {
+	if c.Path != "" {
+		return c.Path
+	}
+	return filepath.Join(workDir, registryFile)
+}
+
+// loadRegistry reads the registry from its local file. If it's not there yet
+// and it can be fetched we'll do so right away as components configured with
+// experiment and activity names depend on it.
+func loadRegistry(conf *registryConf, workDir string) (*types.Registry, error) {
+	path := conf.path(workDir)
+
+	r, err := types.LoadRegistry(path)
+	if err == nil || !errors.Is(err, fs.ErrNotExist) || conf.URL == "" {
+		return r, err
+	}
+
+	slog.Info("fetching the missing SciTags registry", "path", path, "url", conf.URL)
+	return fetchRegistry(context.Background(), conf.URL, path)
+}
+
+// fetchRegistry downloads the registry and stores it at path. The file is
+// replaced atomically so that a failed download never leaves a truncated
+// registry behind.
+func fetchRegistry(ctx context.Context, rawURL, path string) (*types.Registry, error) {
+	ctx, cancel := context.WithTimeout(ctx, registryFetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching the registry: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching the registry: got status %q", resp.Status)
+	}
+
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, registryMaxSize))
+	if err != nil {
+		return nil, fmt.Errorf("error reading the registry: %w", err)
+	}
+
+	r, err := types.ParseRegistry(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("error creating the registry's directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return nil, fmt.Errorf("error storing the registry: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return nil, fmt.Errorf("error storing the registry: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, fmt.Errorf("error storing the registry: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return nil, fmt.Errorf("error storing the registry: %w", err)
+	}
+
+	return r, nil
+}
+
+// startRegistry loads the registry making it the current one and, if it's to
+// be refreshed, kicks off a goroutine refreshing it in the background. A nil
+// configuration unsets the current registry. The registry currently in use, if
+// any, is only replaced (and no longer refreshed) once the new one is loaded:
+// should loading it fail the current one is left untouched.
+func (d *daemon) startRegistry(conf *registryConf, workDir string) error {
+	var r *types.Registry
+	if conf != nil {
+		var err error
+		if r, err = loadRegistry(conf, workDir); err != nil {
+			return err
+		}
+	}
+
+	d.stopRegistry()
+	d.strictContext.Store(conf != nil && conf.Strict)
+	types.SetRegistry(r)
+
+	if conf == nil {
+		return nil
+	}
+
+	slog.Info("loaded the SciTags registry", "path", conf.path(workDir), "nExperiments", r.Len())
+
+	if conf.URL == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	d.stopRegistryRefresh = func() {
+		cancel()
+		<-stopped
+	}
+
+	go func() {
+		defer close(stopped)
+
+		var ticks <-chan time.Time
+		if conf.RefreshPeriod > 0 {
+			ticker := time.NewTicker(time.Duration(conf.RefreshPeriod) * time.Second)
+			defer ticker.Stop()
+			ticks = ticker.C
+		}
+
+		// Refresh the registry right away: the local copy might be stale.
+		for {
+			r, err := fetchRegistry(ctx, conf.URL, conf.path(workDir))
+			if err != nil && ctx.Err() == nil {
+				slog.Warn("couldn't refresh the SciTags registry, keeping the current one", "url", conf.URL, "err", err)
+			}
+			if err == nil {
+				types.SetRegistry(r)
+				slog.Debug("refreshed the SciTags registry", "url", conf.URL, "nExperiments", r.Len())
+			}
+
+			select {
+			case <-ticks:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (d *daemon) stopRegistry() {
+	if d.stopRegistryRefresh == nil {
+		return
+	}
+
+	d.stopRegistryRefresh()
+	d.stopRegistryRefresh = nil
+}
+
+// registered checks the context (i.e. experiment and activity) of a START
+// flowID against the current registry, if any. Flows in an unregistered
+// context are warned about and only rejected if the registry is strict.
+func (d *daemon) registered(plugin string, flowID types.FlowID) bool {
+	r := types.CurrentRegistry()
+	if r == nil {
+		return true
+	}
+
+	err := r.Validate(flowID.Experiment, flowID.Activity)
+	if err == nil {
+		return true
+	}
+
+	if d.strictContext.Load() {
+		slog.Warn("dropping flow in an unregistered context", types.LogKeyPlugin, plugin, types.LogKeyFlow, flowID, "err", err)
+		return false
+	}
+
+	slog.Warn("got a flow in an unregistered context", types.LogKeyPlugin, plugin, types.LogKeyFlow, flowID, "err", err)
+	return true
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/scitags/flowd-go/types"
+)
+
+func TestLoadRegistry(t *testing.T) {
+	raw, err := os.ReadFile("testdata/registry/scitags.json")
+	if err != nil {
+		t.Fatalf("error reading the registry: %v", err)
+	}
+
+	fail := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			http.Error(w, "nope", http.StatusInternalServerError)
+			return
+		}
+		w.Write(raw)
+	}))
+	defer srv.Close()
+
+	// A missing registry is fetched right away
+	workDir := t.TempDir()
+	conf := &registryConf{URL: srv.URL}
+	r, err := loadRegistry(conf, workDir)
+	if err != nil {
+		t.Fatalf("error loading the registry: %v", err)
+	}
+	if id, ok := r.ExperimentID("atlas"); !ok || id != 2 {
+		t.Errorf("got experiment ID %d (%t), want 2", id, ok)
+	}
+
+	stored, err := os.ReadFile(filepath.Join(workDir, registryFile))
+	if err != nil || string(stored) != string(raw) {
+		t.Errorf("the fetched registry wasn't stored (%v)", err)
+	}
+
+	// From then on the local copy is used
+	fail = true
+	if _, err := loadRegistry(conf, workDir); err != nil {
+		t.Errorf("error loading the stored registry: %v", err)
+	}
+
+	if _, err := loadRegistry(conf, t.TempDir()); err == nil {
+		t.Errorf("loaded a registry that couldn't be fetched")
+	}
+
+	if _, err := loadRegistry(&registryConf{}, t.TempDir()); err == nil {
+		t.Errorf("loaded a missing registry without a URL")
+	}
+}
+
+func TestReloadRegistry(t *testing.T) {
+	raw, err := os.ReadFile("testdata/registry/scitags.json")
+	if err != nil {
+		t.Fatalf("error reading the registry: %v", err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write(raw) }))
+	defer srv.Close()
+	defer types.SetRegistry(nil)
+
+	d := newDaemon(&Config{})
+	if err := d.startRegistry(&registryConf{URL: srv.URL, RefreshPeriod: 3600}, t.TempDir()); err != nil {
+		t.Fatalf("error starting the registry: %v", err)
+	}
+	defer d.stopRegistry()
+
+	// A registry that can't be loaded leaves the current one as is
+	if err := d.startRegistry(&registryConf{Strict: true}, t.TempDir()); err == nil {
+		t.Fatalf("loaded a missing registry without a URL")
+	}
+	if types.CurrentRegistry() == nil || d.stopRegistryRefresh == nil || d.strictContext.Load() {
+		t.Errorf("a failed reload replaced the current registry or stopped refreshing it")
+	}
+}
+
+func TestRegistered(t *testing.T) {
+	r, err := types.LoadRegistry("testdata/registry/scitags.json")
+	if err != nil {
+		t.Fatalf("error loading the registry: %v", err)
+	}
+	types.SetRegistry(r)
+	defer types.SetRegistry(nil)
+
+	d := newDaemon(&Config{})
+
+	flowID := testFlowID(types.START, 2345)
+	flowID.Experiment, flowID.Activity = 2, 9
+	if !d.registered("test", flowID) {
+		t.Errorf("a flow in an unregistered context was dropped without a strict registry")
+	}
+
+	d.strictContext.Store(true)
+	if d.registered("test", flowID) {
+		t.Errorf("a flow in an unregistered context wasn't dropped with a strict registry")
+	}
+
+	flowID.Activity = 3
+	if !d.registered("test", flowID) {
+		t.Errorf("a flow in a registered context was dropped")
+	}
+}
+
+func TestRegistryNames(t *testing.T) {
+	defer types.SetRegistry(nil)
+
+	confErrs, err := ValidateConf("testdata/registry/conf.yaml")
+	if err != nil {
+		t.Fatalf("error validating the configuration: %v", err)
+	}
+
+	want := []struct {
+		line int
+		msg  string
+	}{
+		{9, `plugins.iperf3: wrong experiment at index 2: unknown experiment "lhcb"`},
+		{14, `backends.firefly: wrong filter: unknown activity "ci"`},
+	}
+
+	if len(confErrs) != len(want) {
+		for _, e := range confErrs {
+			t.Log(e)
+		}
+		t.Fatalf("got %d errors; want %d", len(confErrs), len(want))
+	}
+
+	for i, w := range want {
+		if confErrs[i].origin.line != w.line || !strings.Contains(confErrs[i].Error(), w.msg) {
+			t.Errorf("got %q; want %q at line %d", confErrs[i], w.msg, w.line)
+		}
+	}
+
+	// Validating the configuration loaded the registry names are resolved against
+	conf, err := ReadConf("testdata/registry/conf.yaml")
+	if err != nil {
+		t.Fatalf("error reading the configuration: %v", err)
+	}
+
+	p, err := newPlugin("perfsonar", conf.Plugins["perfsonar"])
+	if err != nil {
+		t.Fatalf("error creating the perfsonar plugin: %v", err)
+	}
+
+	flowIDs := make(chan types.FlowID, 1)
+	done := make(chan struct{})
+	go p.Run(done, flowIDs)
+	defer close(done)
+
+	if f := <-flowIDs; f.Experiment != 2 || f.Activity != 3 {
+		t.Errorf("got context (%d, %d), want (2, 3)", f.Experiment, f.Activity)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"cmp"
+	"log/slog"
+	"maps"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/scitags/flowd-go/types"
+)
+
+// reload re-reads the configuration and applies it to the running daemon.
+// Only those components whose configuration has changed are restarted: the
+// rest are left untouched. Active flows are preserved and replayed to every
+// restarted backend so that, for instance, marking resumes where it left off.
+// If the new configuration can't be read the current one is kept as is.
+func (d *daemon) reload(path string) {
+	slog.Info("reloading the configuration", "path", path)
+
+	conf, err := ReadConf(path)
+	if err != nil {
+		slog.Error("couldn't reload the configuration, keeping the current one", "err", err)
+		return
+	}
+
+	if conf.PidPath != d.conf.PidPath {
+		slog.Warn("changes to the PID path require a restart", "current", d.conf.PidPath, "new", conf.PidPath)
+	}
+	if conf.WorkDir != d.conf.WorkDir {
+		slog.Warn("changes to the working directory require a restart", "current", d.conf.WorkDir, "new", conf.WorkDir)
+	}
+
+	if conf.FlowTTL != d.conf.FlowTTL {
+		slog.Info("updating the flow TTL", "current", d.conf.FlowTTL, "new", conf.FlowTTL)
+		d.flows.setTTL(time.Duration(conf.FlowTTL) * time.Second)
+		d.resetExpiry()
+	}
+
+	if conf.SnapshotPeriod != d.conf.SnapshotPeriod {
+		slog.Info("updating the snapshot period", "current", d.conf.SnapshotPeriod, "new", conf.SnapshotPeriod)
+		d.resetSnapshots(time.Duration(conf.SnapshotPeriod) * time.Second)
+	}
+
+	if !reflect.DeepEqual(conf.Telemetry, d.conf.Telemetry) {
+		slog.Info("restarting the telemetry server")
+		d.stopTelemetry()
+		if err := d.startTelemetry(conf.Telemetry); err != nil {
+			slog.Error("couldn't restart the telemetry server", "err", err)
+		}
+	}
+
+	// Reload the registry before restarting any component: their names might
+	// need resolving against it. Plugins only check flows against a registry
+	// if there was one when they started, so they're all restarted if we go
+	// from having none to having one or the other way round.
+	if !reflect.DeepEqual(conf.Registry, d.conf.Registry) {
+		slog.Info("reloading the SciTags registry")
+		hadRegistry := types.CurrentRegistry() != nil
+		if err := d.startRegistry(conf.Registry, conf.WorkDir); err != nil {
+			slog.Error("couldn't reload the SciTags registry, keeping the current one", "err", err)
+		}
+		if hasRegistry := types.CurrentRegistry() != nil; hasRegistry != hadRegistry {
+			for _, name := range slices.Sorted(maps.Keys(d.pluginConfs())) {
+				slog.Info("stopping component", "kind", "plugin", "name", name)
+				d.stopPlugin(name)
+			}
+		}
+	}
+
+	applyConfs("backend", d.backendConfs(), backendConfs(conf), d.stopBackend, d.startBackend)
+	applyConfs("enricher", d.enricherConfs(), enricherConfs(conf), d.stopEnricher, d.startEnricher)
+	applyConfs("plugin", d.pluginConfs(), pluginConfs(conf), d.stopPlugin, d.startPlugin)
+
+	d.conf = conf
+
+	slog.Info("configuration reloaded", "nPlugins", len(d.plugins), "nBackends", len(d.backends),
+		"nEnrichers", len(d.enrichers), "nFlows", d.flows.len())
+}
+
+// applyConfs brings the running components of a given kind in line with the
+// wanted configuration. If a component can't be started with its new
+// configuration we try to bring it back with the one it was running with.
+func applyConfs[K cmp.Ordered, V any](kind string, running, wanted map[K]V, stop func(K), start func(K, V) error) {
+	toStop, toStart := diffConfs(running, wanted)
+
+	for _, k := range toStop {
+		slog.Info("stopping component", "kind", kind, "name", k)
+		stop(k)
+	}
+
+	for _, k := range toStart {
+		slog.Info("starting component", "kind", kind, "name", k)
+		err := start(k, wanted[k])
+		if err == nil {
+			continue
+		}
+
+		slog.Error("couldn't start component", "kind", kind, "name", k, "err", err)
+
+		old, ok := running[k]
+		if !ok {
+			continue
+		}
+
+		slog.Warn("restoring the previous configuration", "kind", kind, "name", k)
+		if err := start(k, old); err != nil {
+			slog.Error("couldn't restore component", "kind", kind, "name", k, "err", err)
+		}
+	}
+}
+
+// diffConfs compares the configuration of the running components against the
+// wanted one. It returns the components to stop (i.e. removed or changed ones)
+// and those to start (i.e. added or changed ones), both sorted.
+func diffConfs[K cmp.Ordered, V any](running, wanted map[K]V) (toStop, toStart []K) {
+	for k, rc := range running {
+		if wc, ok := wanted[k]; !ok || !reflect.DeepEqual(rc, wc) {
+			toStop = append(toStop, k)
+		}
+	}
+
+	for k, wc := range wanted {
+		if rc, ok := running[k]; !ok || !reflect.DeepEqual(rc, wc) {
+			toStart = append(toStart, k)
+		}
+	}
+
+	slices.Sort(toStop)
+	slices.Sort(toStart)
+
+	return toStop, toStart
+}
+
+func (d *daemon) pluginConfs() map[string]*instance {
+	confs := make(map[string]*instance, len(d.plugins))
+	for _, h := range d.plugins {
+		confs[h.name] = h.conf
+	}
+	return confs
+}
+
+func (d *daemon) backendConfs() map[string]*instance {
+	confs := make(map[string]*instance, len(d.backends))
+	for _, h := range d.backends {
+		confs[h.name] = h.conf
+	}
+	return confs
+}
+
+func (d *daemon) enricherConfs() map[string]any {
+	confs := make(map[string]any, len(d.enrichers))
+	for name, h := range d.enrichers {
+		confs[name] = h.conf
+	}
+	return confs
+}
+
+func sortedKeys[K cmp.Ordered, V any](m map[K]V) []K {
+	return slices.Sorted(maps.Keys(m))
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/scitags/flowd-go/types"
+)
+
+// slowBackend takes a while to stop once told to, just like a backend in the
+// middle of handling a flowID would.
+type slowBackend struct {
+	running      atomic.Bool
+	cleanedEarly atomic.Bool
+}
+
+func (b *slowBackend) Run(done <-chan struct{}, _ <-chan types.FlowID) {
+	b.running.Store(true)
+	<-done
+	time.Sleep(50 * time.Millisecond)
+	b.running.Store(false)
+}
+
+func (b *slowBackend) Cleanup() error {
+	b.cleanedEarly.Store(b.running.Load())
+	return nil
+}
+
+func (b *slowBackend) String() string { return "slow" }
+
+func TestStopBackend(t *testing.T) {
+	b := &slowBackend{}
+	h := &backendHandle{
+		name:    "slow",
+		conf:    &instance{Type: "slow"},
+		backend: b,
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	h.queue = newBackendQueue("slow", 1, block, h.done)
+	h.unregisterQueue = func() {}
+	h.run()
+
+	for !b.running.Load() {
+		time.Sleep(time.Millisecond)
+	}
+
+	d := newDaemon(&Config{})
+	d.backends = []*backendHandle{h}
+	d.stopBackend("slow")
+
+	if b.cleanedEarly.Load() {
+		t.Errorf("the backend was cleaned up before it stopped running")
+	}
+	if len(d.backends) != 0 {
+		t.Errorf("the backend wasn't forgotten")
+	}
+}
+
+func TestDiffConfs(t *testing.T) {
+	before, err := ReadConf("testdata/reload/before.yaml")
+	if err != nil {
+		t.Fatalf("error parsing the initial configuration: %v", err)
+	}
+
+	after, err := ReadConf("testdata/reload/after.yaml")
+	if err != nil {
+		t.Fatalf("error parsing the reloaded configuration: %v", err)
+	}
+
+	toStop, toStart := diffConfs(pluginConfs(before), pluginConfs(after))
+	if diff := cmp.Diff([]string{"api"}, toStop); diff != "" {
+		t.Errorf("wrong plugins to stop (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"firefly"}, toStart); diff != "" {
+		t.Errorf("wrong plugins to start (-want +got):\n%s", diff)
+	}
+
+	toStop, toStart = diffConfs(backendConfs(before), backendConfs(after))
+	if diff := cmp.Diff([]string{"firefly"}, toStop); diff != "" {
+		t.Errorf("wrong backends to stop (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"firefly"}, toStart); diff != "" {
+		t.Errorf("wrong backends to start (-want +got):\n%s", diff)
+	}
+
+	toStop, toStart = diffConfs(enricherConfs(before), enricherConfs(after))
+	if diff := cmp.Diff([]string{"netlink"}, toStop); diff != "" {
+		t.Errorf("wrong enrichers to stop (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"netlink"}, toStart); diff != "" {
+		t.Errorf("wrong enrichers to start (-want +got):\n%s", diff)
+	}
+
+	toStop, toStart = diffConfs(pluginConfs(before), pluginConfs(before))
+	if len(toStop) != 0 || len(toStart) != 0 {
+		t.Errorf("an unchanged configuration yielded changes: stop %v, start %v", toStop, toStart)
+	}
+}
@@ -5,29 +5,168 @@ import (
 	"log/slog"
 	"os"
 	"os/signal"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	sd "github.com/coreos/go-systemd/v22/daemon"
+	"github.com/scitags/flowd-go/enrichment"
+	"github.com/scitags/flowd-go/internal/telemetry"
 	"github.com/scitags/flowd-go/types"
 	"github.com/spf13/cobra"
 )
 
-type channels struct {
-	// Channels for transmitting flowIDs from plugins to backends.
-	// There's one per channel and one per backend
-	plugins  []chan types.FlowID
-	backends []chan types.FlowID
+// daemon holds the running state of flowd-go: the components it's been
+// configured with and the flows it's currently keeping track of. Components
+// are kept on their own so that they can be started and stopped
+// independently when reloading the configuration.
+type daemon struct {
+	conf *Config
+
+	plugins   []*pluginHandle
+	backends  []*backendHandle
+	enrichers map[string]*enricherHandle
+
+	// Keep track of active flows so that we can weed out duplicate STARTs and
+	// orphan ENDs as well as expire flows whose END never arrives. They also
+	// let us carry on where we left off when reloading the configuration.
+	flows *flowTable
 
 	// FlowIDs generated by any plugin will be funneled through an
-	// aggregate plugin to make the main for-select loop that much
+	// aggregate channel to make the main for-select loop that much
 	// easier to write.
 	aggFlowIDs chan types.FlowID
 
-	// Broadcast channel for exiting plugins and backends cleanly
-	done chan struct{}
+	// Ticks on which to expire flows. A nil channel blocks forever,
+	// which is exactly what we want if flows are never to be expired.
+	expiryTicker *time.Ticker
+	expiryTicks  <-chan time.Time
+
+	// Ticks on which to snapshot the active flows to the working directory.
+	snapshotTicker *time.Ticker
+	snapshotTicks  <-chan time.Time
+
+	// Whether we've already tried restoring the previous snapshot. Until
+	// we have, saving a snapshot would overwrite it.
+	restored bool
+
+	// Serves flowd-go's own metrics, if configured.
+	telemetry *telemetry.Server
+
+	// Stops refreshing the SciTags registry, if it's being refreshed.
+	stopRegistryRefresh func()
+
+	// Whether flows in a context missing from the SciTags registry are
+	// dropped. It's read from every plugin's funnel.
+	strictContext atomic.Bool
+
+	// Backs the health endpoints served alongside the telemetry.
+	health health
+
+	// Whether SystemD expects us to ping its watchdog on every heartbeat.
+	watchdog bool
+}
+
+func newDaemon(conf *Config) *daemon {
+	d := &daemon{
+		conf:       conf,
+		enrichers:  map[string]*enricherHandle{},
+		flows:      newFlowTable(time.Duration(conf.FlowTTL) * time.Second),
+		aggFlowIDs: make(chan types.FlowID),
+	}
+	d.resetExpiry()
+	d.resetSnapshots(time.Duration(conf.SnapshotPeriod) * time.Second)
+
+	d.health.period = heartbeatPeriod
+	if interval := watchdogInterval(); interval > 0 {
+		d.health.period = min(heartbeatPeriod, interval/2)
+		d.watchdog = true
+	}
+
+	return d
+}
+
+// resetExpiry (re)configures the expiry ticker based on the flow table's TTL.
+func (d *daemon) resetExpiry() {
+	if d.expiryTicker != nil {
+		d.expiryTicker.Stop()
+		d.expiryTicker, d.expiryTicks = nil, nil
+	}
 
-	// Channel on which to receive OS signals
-	signals chan os.Signal
+	if d.flows.ttl() > 0 {
+		d.expiryTicker = time.NewTicker(d.flows.expiryPeriod())
+		d.expiryTicks = d.expiryTicker.C
+	}
+}
+
+// start brings every configured component up. Backends and enrichers are
+// started before plugins so that no flowID goes unnoticed.
+func (d *daemon) start() error {
+	// Components configured with experiment and activity names need the
+	// registry to resolve them.
+	if err := d.startRegistry(d.conf.Registry, d.conf.WorkDir); err != nil {
+		return fmt.Errorf("couldn't load the SciTags registry: %w", err)
+	}
+
+	if err := d.startTelemetry(d.conf.Telemetry); err != nil {
+		return fmt.Errorf("couldn't start the telemetry server: %w", err)
+	}
+
+	slog.Debug("creating backends")
+	for _, name := range sortedKeys(backendConfs(d.conf)) {
+		if err := d.startBackend(name, backendConfs(d.conf)[name]); err != nil {
+			return fmt.Errorf("couldn't create the backends: %w", err)
+		}
+	}
+
+	slog.Debug("creating enrichers")
+	for _, name := range sortedKeys(enricherConfs(d.conf)) {
+		if err := d.startEnricher(name, enricherConfs(d.conf)[name]); err != nil {
+			return fmt.Errorf("couldn't initialise the enrichers: %w", err)
+		}
+	}
+
+	slog.Debug("creating plugins")
+	for _, name := range sortedKeys(pluginConfs(d.conf)) {
+		if err := d.startPlugin(name, pluginConfs(d.conf)[name]); err != nil {
+			return fmt.Errorf("couldn't create the plugins: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// stop tears down every running component.
+func (d *daemon) stop() {
+	for len(d.plugins) > 0 {
+		d.stopPlugin(d.plugins[0].name)
+	}
+
+	// No more flowIDs are coming our way: save the flows whilst backends
+	// are still around to tell us about their flow tags.
+	if d.restored && d.conf.SnapshotPeriod > 0 {
+		if err := d.saveSnapshot(); err != nil {
+			slog.Error("couldn't save the snapshot", "err", err)
+		}
+	}
+
+	for name := range d.enrichers {
+		d.stopEnricher(name)
+	}
+
+	for len(d.backends) > 0 {
+		d.stopBackend(d.backends[0].name)
+	}
+
+	if d.expiryTicker != nil {
+		d.expiryTicker.Stop()
+	}
+	if d.snapshotTicker != nil {
+		d.snapshotTicker.Stop()
+	}
+
+	d.stopTelemetry()
+	d.stopRegistry()
 }
 
 func run(cmd *cobra.Command, args []string) {
@@ -46,131 +185,218 @@ func run(cmd *cobra.Command, args []string) {
 		defer os.Remove(conf.PidPath)
 	}
 
-	slog.Debug("creating plugins")
-	plugins, err := createPlugins(conf)
-	if err != nil {
-		slog.Error("couldn't create the plugins", "err", err)
-		return
-	}
-	defer cleanupPlugins(plugins)
-
-	slog.Debug("creating backends")
-	backends, err := createBackends(conf)
-	if err != nil {
-		slog.Error("couldn't create the backends", "err", err)
-		return
-	}
-	defer cleanupBackends(backends)
+	d := newDaemon(conf)
+	defer d.stop()
+	defer sdNotify(sd.SdNotifyStopping)
 
-	slog.Debug("creating enrichers")
-	enrichers, err := createEnrichers(conf)
-	if err != nil {
-		slog.Error("couldn't initialise the enrichers", "err", err)
+	if err := d.start(); err != nil {
+		slog.Error("couldn't start flowd-go", "err", err)
 		return
 	}
-	defer cleanupEnrichers(enrichers)
 
-	chs := channels{
-		plugins:    make([]chan types.FlowID, 0, len(plugins)),
-		backends:   make([]chan types.FlowID, 0, len(backends)),
-		aggFlowIDs: make(chan types.FlowID),
-		done:       make(chan struct{}),
-		signals:    make(chan os.Signal, 1),
-	}
-
-	for i, plugin := range plugins {
-		chs.plugins = append(chs.plugins, make(chan types.FlowID))
-		go plugin.Run(chs.done, chs.plugins[i])
-	}
-
-	for i, backend := range backends {
-		chs.backends = append(chs.backends, make(chan types.FlowID))
-		go backend.Run(chs.done, chs.backends[i])
+	if conf.SnapshotPeriod > 0 {
+		if err := d.restoreSnapshot(); err != nil {
+			slog.Error("couldn't restore the snapshot", "err", err)
+		}
 	}
+	d.restored = true
 
-	for _, enricher := range enrichers {
-		go enricher.Run(chs.done)
-	}
+	heartbeats := time.NewTicker(d.health.period)
+	defer heartbeats.Stop()
 
-	// Funnel plugin flowIDs into an aggregate channel.
-	// Buffer the channel so that consumers (i.e. backends)
-	// can have some wiggle room if under pressure.
-	for i, ch := range chs.plugins {
-		go func(c chan types.FlowID, i int) {
-			slog.Debug("began listening for plugin flowIDs", "i", i)
-			for flowID := range c {
-				slog.Debug("funneling flowID", "i", i)
-				chs.aggFlowIDs <- flowID
-			}
-		}(ch, i)
-	}
+	// Let SystemD know we're up and running: plugins, backends and enrichers
+	// have all been initialised by now.
+	d.heartbeat(time.Now())
+	d.health.ready.Store(true)
+	sdNotify(sd.SdNotifyReady, d.sdStatus())
 
 	// Set up the machinery for catching SIGINT (i.e. os.Interrupt) and SIGTERM
-	// which will be sent by SystemD when stopping/restarting the service.
-	signal.Notify(chs.signals, os.Interrupt, syscall.SIGTERM)
+	// which will be sent by SystemD when stopping/restarting the service. SIGHUP
+	// triggers a reload of the configuration instead.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
 
 	// Simply listen for events on the aggregated channel and dispatch
 	// them to the backends. Another option could be reflect.Select,
 	// although it's much less performing... Could a point-to-point
 	// (i.e. mesh) architecture be better?
-	slog.Info("let's go!", "nPlugins", len(plugins), "nBackends", len(backends), "nEnrichers", len(enrichers))
+	slog.Info("let's go!", "nPlugins", len(d.plugins), "nBackends", len(d.backends), "nEnrichers", len(d.enrichers))
 	for {
 		select {
-		case flowID, ok := <-chs.aggFlowIDs:
+		case flowID, ok := <-d.aggFlowIDs:
 			if !ok {
 				slog.Warn("somebody closed the aggregated channel!")
 				return
 			}
 
-			dispatchChans := make(map[types.Flavour][]chan *types.FlowInfo)
+			now := time.Now().UTC()
+
+			var e *flowEntry
 			switch flowID.State {
 			case types.START:
-				if len(enrichers) > 0 {
-					sourceChans := map[types.Flavour]chan *types.FlowInfo{}
-					for t, e := range enrichers {
-						p, err := e.WatchFlow(flowID)
-						if err != nil {
-							slog.Error("error watching flow", "flavour", t, "err", err)
-							continue
-						}
-
-						sourceChans[t] = p.DataChan
-						for i := 0; i < len(backends); i++ {
-							dispatchChans[t] = append(dispatchChans[t], make(chan *types.FlowInfo))
-						}
-					}
-
-					go broadcastEnrichment(sourceChans, dispatchChans)
+				if flowID.StartTs.IsZero() {
+					flowID.StartTs = now
+				}
+				if !d.flows.start(flowID, now) {
+					continue
 				}
+				e = d.flows.entry(flowID)
 
 			case types.END:
-				flowID.EndTs = time.Now().UTC()
-
-				for t, e := range enrichers {
-					ts, ok := e.ForgetFlow(flowID)
-					if !ok {
-						slog.Warn("tried to forget a non-existent flow", "flavour", t, "flowID", flowID)
-						continue
-					}
+				var ok bool
+				e, ok = d.flows.end(flowID)
+				if !ok {
+					slog.Warn("dropping END for a non-existent flow", types.LogKeyFlow, flowID)
+					continue
+				}
+				flowID = endFlowID(e.flowID, flowID, now)
 
-					// If running with 2 enrichers, the start timestamps should be the same...
-					flowID.StartTs = ts
+			case types.ONGOING:
+				if !d.flows.touch(flowID, now) {
+					slog.Debug("got an ONGOING flowID for a non-existent flow", types.LogKeyFlow, flowID)
 				}
+				e = d.flows.entry(flowID)
 			}
 
-			slog.Debug("dispatching flowID to backends")
-			for i, ch := range chs.backends {
-				if flowID.State == types.START && len(enrichers) > 0 {
-					flowID.FlowInfoChans = make(map[types.Flavour]chan *types.FlowInfo, len(enrichers))
-					for t := range enrichers {
-						flowID.FlowInfoChans[t] = dispatchChans[t][i]
-					}
-				}
-				ch <- flowID
+			d.dispatch(flowID, e)
+
+		case now := <-d.expiryTicks:
+			for _, e := range d.flows.expire(now) {
+				slog.Info("expiring flow without an END", types.LogKeyFlow, e.flowID, "ttl", d.flows.ttl())
+				d.dispatch(endFlowID(e.flowID, types.FlowID{}, now.UTC()), e)
+			}
+
+		case now := <-heartbeats.C:
+			// Going through here proves the main loop isn't stuck
+			d.heartbeat(now)
+			if d.watchdog {
+				sdNotify(sd.SdNotifyWatchdog, d.sdStatus())
+			} else {
+				sdNotify(d.sdStatus())
+			}
+
+		case <-d.snapshotTicks:
+			if err := d.saveSnapshot(); err != nil {
+				slog.Error("couldn't save the snapshot", "err", err)
+			}
+
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				d.health.ready.Store(false)
+				sdNotify(sdReloading())
+				d.reload(confPath)
+				d.heartbeat(time.Now())
+				d.health.ready.Store(true)
+				sdNotify(sd.SdNotifyReady, d.sdStatus())
+				continue
 			}
-		case <-chs.signals:
-			close(chs.done)
 			return
 		}
 	}
 }
+
+// endFlowID crafts the END flowID to dispatch based on the START flowID we
+// kept in the flow table and the END flowID we got from a plugin, if any.
+// Context missing from the END flowID is filled in from the START so that
+// backends see a consistent view of the flow.
+func endFlowID(startFlowID, flowID types.FlowID, now time.Time) types.FlowID {
+	end := startFlowID
+	end.State = types.END
+	end.EndTs = now
+	end.FlowInfoChans = nil
+
+	if flowID.Experiment != 0 || flowID.Activity != 0 {
+		end.Experiment = flowID.Experiment
+		end.Activity = flowID.Activity
+	}
+
+	if flowID.Application != "" {
+		end.Application = flowID.Application
+	}
+
+	return end
+}
+
+// dispatch hands a flowID over to every backend, taking care of
+// setting up and tearing down the enrichment of the flow as needed.
+// The flow's entry on the flow table, if any, must be given too: ENDs
+// are dispatched once it's been removed from the table.
+func (d *daemon) dispatch(flowID types.FlowID, e *flowEntry) {
+	defer func(start time.Time) {
+		telemetry.DispatchLatency.Observe(time.Since(start).Seconds())
+	}(time.Now())
+	telemetry.ActiveFlows.Set(float64(d.flows.len()))
+
+	var fo *enrichment.FanOut
+	switch flowID.State {
+	case types.START:
+		if len(d.enrichers) > 0 {
+			sourceChans := map[types.Flavour]chan *types.FlowInfo{}
+			for _, h := range d.enrichers {
+				if !enrichment.Supports(h.enricher, flowID.Protocol) {
+					slog.Debug("enricher can't watch flow", "enricher", h.name, types.LogKeyFlow, flowID)
+					continue
+				}
+				p, err := h.enricher.WatchFlow(flowID)
+				if err != nil {
+					slog.Error("error watching flow", "enricher", h.name, "err", err)
+					continue
+				}
+				sourceChans[h.flavour] = p.DataChan
+			}
+
+			if len(sourceChans) > 0 {
+				fo = enrichment.NewFanOut(sourceChans)
+				d.flows.setFanOut(flowID, fo)
+				go fo.Run()
+			}
+		}
+
+	case types.END:
+		flowID.Marking = d.markingStats(flowID, e)
+
+		for _, h := range d.enrichers {
+			if !enrichment.Supports(h.enricher, flowID.Protocol) {
+				continue
+			}
+			if _, ok := h.enricher.ForgetFlow(flowID); !ok {
+				slog.Warn("tried to forget a non-existent flow", "enricher", h.name, types.LogKeyFlow, flowID)
+			}
+		}
+	}
+
+	slog.Debug("dispatching flowID to backends")
+	for _, b := range d.backends {
+		if !b.matches(flowID, e) {
+			slog.Debug("flowID filtered out", types.LogKeyBackend, b.name, types.LogKeyFlow, flowID)
+			continue
+		}
+		b.queue.push(subscribe(flowID, fo, b))
+	}
+}
+
+// markingStats gathers how every backend marking packets marked the flow so
+// that the rest (i.e. the firefly backend) can report it on the flow's END.
+// Note the flowID is dispatched after the stats are gathered, so they're
+// read before the markers forget about the flow.
+func (d *daemon) markingStats(flowID types.FlowID, e *flowEntry) *types.MarkingStats {
+	var stats *types.MarkingStats
+	for _, b := range d.backends {
+		mr, ok := b.backend.(types.MarkingReporter)
+		if !ok || !b.matches(flowID, e) {
+			continue
+		}
+
+		s, ok := mr.MarkingStats(flowID)
+		if !ok {
+			continue
+		}
+
+		if stats == nil {
+			stats = &types.MarkingStats{}
+		}
+		stats.Add(s)
+	}
+
+	return stats
+}
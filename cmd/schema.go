@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"net/netip"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/scitags/flowd-go/enrichment"
+	"github.com/scitags/flowd-go/types"
+)
+
+// schema is a JSON Schema as marshaled to JSON.
+type schema = map[string]any
+
+// jsonSchemer is implemented by configuration types whose schema can't be
+// derived from their Go type, usually because they decode themselves (i.e.
+// implement yaml.BytesUnmarshaler) from something other than a mapping.
+type jsonSchemer interface {
+	JSONSchema() schema
+}
+
+var jsonSchemerType = reflect.TypeFor[jsonSchemer]()
+
+// confSchema returns the JSON Schema of the whole configuration including
+// that of every registered plugin, backend and enricher.
+func confSchema() schema {
+	// An empty configuration (i.e. one with comments alone) is fine too
+	s := nullable(schemaOf(reflect.TypeFor[Config]()))
+	s["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	s["$id"] = "https://github.com/scitags/flowd-go/rpm/conf.schema.json"
+	s["title"] = "flowd-go configuration"
+	return s
+}
+
+// marshalConfSchema returns the indented JSON of the configuration's schema.
+func marshalConfSchema() ([]byte, error) {
+	b, err := json.MarshalIndent(confSchema(), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// schemaOf derives the schema of the values of a type as decoded from YAML.
+// Struct fields are named after their yaml tag or, lacking one, after their
+// lower-cased name just like the YAML decoder does. Unknown keys are rejected.
+func schemaOf(t reflect.Type) schema {
+	if t.Kind() == reflect.Pointer {
+		return nullable(schemaOf(t.Elem()))
+	}
+
+	if t.Implements(jsonSchemerType) || reflect.PointerTo(t).Implements(jsonSchemerType) {
+		return reflect.New(t).Interface().(jsonSchemer).JSONSchema()
+	}
+
+	if t == reflect.TypeFor[netip.Prefix]() {
+		return schema{"type": "string", "description": "An IPv4 or IPv6 prefix in CIDR notation."}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return schema{"type": "boolean"}
+
+	case reflect.Int, reflect.Int64:
+		return schema{"type": "integer"}
+
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		bits := t.Bits()
+		return schema{"type": "integer", "minimum": -(1 << (bits - 1)), "maximum": 1<<(bits-1) - 1}
+
+	case reflect.Uint, reflect.Uint64:
+		return schema{"type": "integer", "minimum": 0}
+
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return schema{"type": "integer", "minimum": 0, "maximum": uint64(1)<<t.Bits() - 1}
+
+	case reflect.Float32, reflect.Float64:
+		return schema{"type": "number"}
+
+	case reflect.String:
+		return schema{"type": "string"}
+
+	case reflect.Slice, reflect.Array:
+		return schema{"type": "array", "items": schemaOf(t.Elem())}
+
+	case reflect.Map:
+		return schema{"type": "object", "additionalProperties": schemaOf(t.Elem())}
+
+	case reflect.Struct:
+		props := schema{}
+		for _, f := range reflect.VisibleFields(t) {
+			if !f.IsExported() || f.Anonymous {
+				continue
+			}
+
+			name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = strings.ToLower(f.Name)
+			}
+
+			props[name] = schemaOf(f.Type)
+		}
+		return schema{"type": "object", "properties": props, "additionalProperties": false}
+	}
+
+	// Anything goes (i.e. interfaces)
+	return schema{}
+}
+
+// nullable makes a schema accept null too, which is what empty YAML values
+// (i.e. `key:`) are decoded as.
+func nullable(s schema) schema {
+	switch typ := s["type"].(type) {
+	case string:
+		s["type"] = []string{typ, "null"}
+	case []string:
+		if !slices.Contains(typ, "null") {
+			s["type"] = append(typ, "null")
+		}
+	}
+	return s
+}
+
+// decodedType returns the type of the configuration a decoder yields given an
+// empty configuration, which must be fine as defaults are always applied.
+func decodedType(decode func() (any, error)) (reflect.Type, bool) {
+	c, err := decode()
+	if err != nil || c == nil {
+		return nil, false
+	}
+	return reflect.TypeOf(c), true
+}
+
+// instancesSchema returns the schema of the configuration of a plugin or
+// backend: either a single instance (i.e. a mapping), a sequence of named
+// instances or null (see splitInstances). Note properties only apply to
+// mappings and items only apply to sequences, which spares us from a oneOf
+// and the rather confusing errors it brings along.
+func instancesSchema(t reflect.Type, ok bool, envelopeKeys []string) schema {
+	props := schema{}
+	if ok {
+		for t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+		if p, ok := schemaOf(t)["properties"].(schema); ok {
+			props = p
+		}
+	}
+
+	envelope := schemaOf(reflect.TypeFor[envelope]())["properties"].(schema)
+	for _, k := range envelopeKeys {
+		props[k] = envelope[k]
+	}
+
+	named := schema{"type": "object", "properties": props, "required": []string{"name"}}
+	s := schema{"type": []string{"object", "array", "null"}, "properties": props, "items": named}
+
+	// Configurations we know nothing about can hold anything
+	if ok {
+		named["additionalProperties"] = false
+		s["additionalProperties"] = false
+	}
+
+	return s
+}
+
+func (pluginsConf) JSONSchema() schema {
+	props := schema{}
+	for _, name := range types.RegisteredPlugins() {
+		r, _ := types.LookupPlugin(name)
+		t, ok := decodedType(func() (any, error) { return r.Decode(nil) })
+		props[name] = instancesSchema(t, ok, pluginEnvelopeKeys)
+	}
+	return schema{"type": []string{"object", "null"}, "properties": props, "additionalProperties": false}
+}
+
+func (backendsConf) JSONSchema() schema {
+	props := schema{}
+	for _, name := range types.RegisteredBackends() {
+		r, _ := types.LookupBackend(name)
+		t, ok := decodedType(func() (any, error) { return r.Decode(nil) })
+		props[name] = instancesSchema(t, ok, backendEnvelopeKeys)
+	}
+	return schema{"type": []string{"object", "null"}, "properties": props, "additionalProperties": false}
+}
+
+func (enrichers) JSONSchema() schema {
+	props := schema{
+		"period": schema{"type": "integer", "minimum": 1, "description": "The enrichment period in ms."},
+	}
+	for _, name := range enrichment.RegisteredEnrichers() {
+		r, _ := enrichment.LookupEnricher(name)
+		t, ok := decodedType(func() (any, error) { return r.Decode(nil, 1000) })
+		if !ok {
+			props[name] = schema{}
+			continue
+		}
+		props[name] = schemaOf(t)
+	}
+	return schema{"type": "object", "properties": props, "additionalProperties": false}
+}
+
+func (filterProtocol) JSONSchema() schema {
+	return schema{"type": "string", "description": "A protocol name such as tcp or udp."}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/scitags/flowd-go/types"
+)
+
+const (
+	// Name of the snapshot file within the working directory.
+	snapshotFile = "flows.json"
+
+	// Bump whenever the snapshot format changes in an incompatible way.
+	snapshotVersion = 1
+)
+
+// snapshot is the on-disk representation of the active flows. It lets us pick
+// up where we left off after a restart (e.g. when upgrading flowd-go) so that
+// ongoing flows keep on being marked with the same flow tags and still get
+// their END.
+type snapshot struct {
+	Version int            `json:"version"`
+	Taken   time.Time      `json:"taken"`
+	Flows   []snapshotFlow `json:"flows"`
+}
+
+type snapshotFlow struct {
+	Protocol    types.Protocol    `json:"protocol"`
+	Family      types.Family      `json:"family"`
+	Src         netip.AddrPort    `json:"src"`
+	Dst         netip.AddrPort    `json:"dst"`
+	Experiment  uint32            `json:"experiment"`
+	Activity    uint32            `json:"activity"`
+	Application string            `json:"application,omitempty"`
+	StartTs     time.Time         `json:"startTs"`
+	LastSeen    time.Time         `json:"lastSeen"`
+	Tags        map[string]uint32 `json:"tags,omitempty"`
+}
+
+func (sf snapshotFlow) flowID() types.FlowID {
+	return types.FlowID{
+		State:       types.START,
+		Protocol:    sf.Protocol,
+		Family:      sf.Family,
+		Src:         sf.Src,
+		Dst:         sf.Dst,
+		Experiment:  sf.Experiment,
+		Activity:    sf.Activity,
+		Application: sf.Application,
+		StartTs:     sf.StartTs,
+	}
+}
+
+func (d *daemon) snapshotPath() string {
+	return filepath.Join(d.conf.WorkDir, snapshotFile)
+}
+
+// resetSnapshots (re)configures the snapshot ticker. A period of 0 disables
+// periodic snapshots.
+func (d *daemon) resetSnapshots(period time.Duration) {
+	if d.snapshotTicker != nil {
+		d.snapshotTicker.Stop()
+		d.snapshotTicker, d.snapshotTicks = nil, nil
+	}
+
+	if period > 0 {
+		d.snapshotTicker = time.NewTicker(period)
+		d.snapshotTicks = d.snapshotTicker.C
+	}
+}
+
+// collectTags records the flow tags a backend has assigned to every active
+// flow, if it assigns any at all.
+func (d *daemon) collectTags(h *backendHandle) {
+	ft, ok := h.backend.(types.FlowTagger)
+	if !ok {
+		return
+	}
+
+	for _, flowID := range d.flows.active() {
+		if tag, ok := ft.FlowTag(flowID); ok {
+			d.flows.setTag(flowID, h.name, tag)
+		}
+	}
+}
+
+// restoreTag hands a backend back the tag it assigned to a flow, if any.
+func (d *daemon) restoreTag(h *backendHandle, flowID types.FlowID) {
+	ft, ok := h.backend.(types.FlowTagger)
+	if !ok {
+		return
+	}
+
+	if tag, ok := d.flows.tag(flowID, h.name); ok {
+		ft.RestoreFlowTag(flowID, tag)
+	}
+}
+
+// saveSnapshot writes every active flow to the working directory. The file is
+// replaced atomically so that a crash can never leave a truncated snapshot
+// behind.
+func (d *daemon) saveSnapshot() error {
+	for _, h := range d.backends {
+		d.collectTags(h)
+	}
+
+	s := snapshot{Version: snapshotVersion, Taken: time.Now().UTC(), Flows: []snapshotFlow{}}
+	for _, e := range d.flows.flows {
+		s.Flows = append(s.Flows, snapshotFlow{
+			Protocol:    e.flowID.Protocol,
+			Family:      e.flowID.Family,
+			Src:         e.flowID.Src,
+			Dst:         e.flowID.Dst,
+			Experiment:  e.flowID.Experiment,
+			Activity:    e.flowID.Activity,
+			Application: e.flowID.Application,
+			StartTs:     e.flowID.StartTs,
+			LastSeen:    e.lastActive(),
+			Tags:        e.tags,
+		})
+	}
+
+	raw, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("error marshalling the snapshot: %w", err)
+	}
+
+	if err := os.MkdirAll(d.conf.WorkDir, 0755); err != nil {
+		return fmt.Errorf("error creating the working directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(d.conf.WorkDir, snapshotFile+".*")
+	if err != nil {
+		return fmt.Errorf("error creating the snapshot: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing the snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing the snapshot: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), d.snapshotPath()); err != nil {
+		return fmt.Errorf("error replacing the snapshot: %w", err)
+	}
+
+	slog.Debug("saved snapshot", "path", d.snapshotPath(), "nFlows", len(s.Flows))
+
+	return nil
+}
+
+// restoreSnapshot reads the snapshot left behind by a previous run and
+// dispatches every flow in it as a START so that backends and enrichers pick
+// them up again. Flows which would have already expired are skipped.
+func (d *daemon) restoreSnapshot() error {
+	raw, err := os.ReadFile(d.snapshotPath())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("error reading the snapshot: %w", err)
+	}
+
+	var s snapshot
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return fmt.Errorf("error parsing the snapshot: %w", err)
+	}
+
+	if s.Version != snapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d, expected %d", s.Version, snapshotVersion)
+	}
+
+	now := time.Now().UTC()
+	restored := 0
+	for _, sf := range s.Flows {
+		if ttl := d.flows.ttl(); ttl > 0 && now.Sub(sf.LastSeen) >= ttl {
+			slog.Debug("skipping expired flow", types.LogKeyFlow, sf.flowID(), "lastSeen", sf.LastSeen)
+			continue
+		}
+
+		flowID := sf.flowID()
+		if !d.flows.start(flowID, sf.LastSeen) {
+			continue
+		}
+
+		for backend, tag := range sf.Tags {
+			d.flows.setTag(flowID, backend, tag)
+		}
+		for _, h := range d.backends {
+			d.restoreTag(h, flowID)
+		}
+
+		// Only the dispatched START is flagged: the END will be a regular one
+		replayed := flowID
+		replayed.Replayed = true
+		d.dispatch(replayed, d.flows.entry(flowID))
+		restored++
+	}
+
+	slog.Info("restored active flows", "path", d.snapshotPath(), "taken", s.Taken, "nFlows", restored)
+
+	return nil
+}
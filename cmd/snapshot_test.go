@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/scitags/flowd-go/types"
+)
+
+// taggingBackend is a types.Backend assigning tags to flows and reporting
+// how they were marked just like the marker would.
+type taggingBackend struct {
+	tags     map[uint16]uint32
+	restored map[uint16]uint32
+	marking  map[uint16]types.MarkingStats
+}
+
+func (b *taggingBackend) Run(<-chan struct{}, <-chan types.FlowID) {}
+func (b *taggingBackend) Cleanup() error                           { return nil }
+func (b *taggingBackend) String() string                           { return "tagging" }
+
+func (b *taggingBackend) FlowTag(flowID types.FlowID) (uint32, bool) {
+	tag, ok := b.tags[flowID.Src.Port()]
+	return tag, ok
+}
+
+func (b *taggingBackend) RestoreFlowTag(flowID types.FlowID, tag uint32) {
+	b.restored[flowID.Src.Port()] = tag
+}
+
+func (b *taggingBackend) MarkingStats(flowID types.FlowID) (types.MarkingStats, bool) {
+	stats, ok := b.marking[flowID.Src.Port()]
+	return stats, ok
+}
+
+func testSnapshotDaemon(workDir string, ttl int, b *taggingBackend) *daemon {
+	d := newDaemon(&Config{WorkDir: workDir, FlowTTL: ttl})
+	d.backends = []*backendHandle{{
+		name:    "marker",
+		conf:    &instance{Type: "marker"},
+		backend: b,
+		queue:   newBackendQueue("marker", 10, block, make(chan struct{})),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}}
+	return d
+}
+
+func TestSnapshot(t *testing.T) {
+	workDir := t.TempDir()
+	startTs := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := time.Now()
+
+	d := testSnapshotDaemon(workDir, 60, &taggingBackend{tags: map[uint16]uint32{1: 0xabcde}})
+	for port, lastSeen := range map[uint16]time.Time{1: now, 2: now, 3: now.Add(-time.Hour)} {
+		flowID := testFlowID(types.START, port)
+		flowID.StartTs = startTs
+		d.flows.start(flowID, lastSeen)
+	}
+
+	if err := d.saveSnapshot(); err != nil {
+		t.Fatalf("error saving the snapshot: %v", err)
+	}
+
+	b := &taggingBackend{restored: map[uint16]uint32{}}
+	r := testSnapshotDaemon(workDir, 60, b)
+	if err := r.restoreSnapshot(); err != nil {
+		t.Fatalf("error restoring the snapshot: %v", err)
+	}
+
+	// The flow last seen an hour ago has already expired
+	if r.flows.len() != 2 {
+		t.Errorf("restored %d flows; want 2", r.flows.len())
+	}
+
+	if len(b.restored) != 1 || b.restored[1] != 0xabcde {
+		t.Errorf("got restored tags %v; want the tag of flow 1 alone", b.restored)
+	}
+
+	queued := r.backends[0].queue.items
+	if len(queued) != 2 {
+		t.Errorf("dispatched %d flows; want 2", len(queued))
+	}
+	for _, flowID := range queued {
+		if flowID.State != types.START || !flowID.StartTs.Equal(startTs) {
+			t.Errorf("got %v starting at %v; want a START starting at %v", flowID, flowID.StartTs, startTs)
+		}
+		if !flowID.Replayed {
+			t.Errorf("the START of restored flow %v isn't flagged as replayed", flowID)
+		}
+	}
+}
+
+func TestSnapshotMissing(t *testing.T) {
+	d := testSnapshotDaemon(t.TempDir(), 0, &taggingBackend{})
+	if err := d.restoreSnapshot(); err != nil {
+		t.Errorf("a missing snapshot isn't an error, got %v", err)
+	}
+}
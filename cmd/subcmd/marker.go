@@ -3,7 +3,9 @@
 package subcmd
 
 import (
+	"errors"
 	"log/slog"
+	"os"
 
 	"github.com/scitags/flowd-go/backends/marker"
 	"github.com/spf13/cobra"
@@ -12,16 +14,25 @@ import (
 func init() {
 	MarkerClean.PersistentFlags().StringVar(&targetInterface, "target-interface", "lo", "interface to delete the eBPF hook from")
 	MarkerClean.PersistentFlags().BoolVar(&removeQdisc, "remove-qdisc", true, "whether to remove the backing qdisc")
+	MarkerClean.PersistentFlags().StringVar(&pinPath, "pin-path", marker.DefaultPinPath, "path to remove the pinned eBPF objects from")
 }
 
 var (
 	targetInterface string
 	removeQdisc     bool
+	pinPath         string
 
 	MarkerClean = &cobra.Command{
 		Use:   "clean",
-		Short: "Clean up flowd-go's backing eBPF hooks and qdisc.",
+		Short: "Clean up flowd-go's backing eBPF hooks, qdisc and pinned objects.",
 		Run: func(cmd *cobra.Command, args []string) {
+			// Removing the pinned tcx links detaches the program on its own
+			if err := marker.RemovePinned(pinPath); err == nil {
+				slog.Info("removed the pinned eBPF objects", "path", pinPath)
+			} else if !errors.Is(err, os.ErrNotExist) {
+				slog.Error("couldn't remove the pinned eBPF objects", "path", pinPath, "err", err)
+			}
+
 			c, err := marker.NewNetlinkClient()
 			if err != nil {
 				slog.Error("couldn't get a netlink client", "err", err)
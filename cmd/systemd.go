@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	sd "github.com/coreos/go-systemd/v22/daemon"
+	"golang.org/x/sys/unix"
+)
+
+// How often the main loop signals it's alive when not running under a SystemD
+// watchdog. Otherwise we'll signal it twice per watchdog interval as suggested
+// on sd_watchdog_enabled(3).
+const heartbeatPeriod = 5 * time.Second
+
+// sdNotify sends the given states to SystemD as per sd_notify(3). It's a noop
+// when not running under SystemD.
+func sdNotify(states ...string) {
+	if _, err := sd.SdNotify(false, strings.Join(states, "\n")); err != nil {
+		slog.Debug("error notifying SystemD", "states", states, "err", err)
+	}
+}
+
+// watchdogInterval returns the SystemD watchdog interval or 0 if the watchdog
+// is disabled.
+func watchdogInterval() time.Duration {
+	interval, err := sd.SdWatchdogEnabled(false)
+	if err != nil {
+		slog.Warn("wrong SystemD watchdog configuration, ignoring it", "err", err)
+		return 0
+	}
+	return interval
+}
+
+// sdReloading crafts the notification SystemD expects when reloading.
+func sdReloading() string {
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err != nil {
+		return sd.SdNotifyReloading
+	}
+	return fmt.Sprintf("%s\nMONOTONIC_USEC=%d", sd.SdNotifyReloading, ts.Nano()/1000)
+}
+
+// sdStatus crafts a STATUS= message summarising what we're up to.
+func (d *daemon) sdStatus() string {
+	return fmt.Sprintf("STATUS=tracking %d active flows with %d plugins, %d backends and %d enrichers",
+		d.flows.len(), len(d.plugins), len(d.backends), len(d.enrichers))
+}
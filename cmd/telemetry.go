@@ -0,0 +1,39 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/scitags/flowd-go/internal/telemetry"
+)
+
+// startTelemetry starts serving flowd-go's own metrics together with the
+// health endpoints. A nil configuration leaves them unserved.
+func (d *daemon) startTelemetry(conf *telemetry.Config) error {
+	if conf == nil {
+		return nil
+	}
+
+	s := telemetry.NewServer(conf)
+	s.Handle("/healthz", http.HandlerFunc(d.health.healthz))
+	s.Handle("/readyz", http.HandlerFunc(d.health.readyz))
+	if err := s.Start(); err != nil {
+		return err
+	}
+	d.telemetry = s
+
+	slog.Info("serving telemetry", "addr", s.Addr())
+
+	return nil
+}
+
+func (d *daemon) stopTelemetry() {
+	if d.telemetry == nil {
+		return
+	}
+
+	if err := d.telemetry.Shutdown(); err != nil {
+		slog.Warn("error shutting down the telemetry server", "err", err)
+	}
+	d.telemetry = nil
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+	"github.com/goccy/go-yaml/ast"
+	"github.com/goccy/go-yaml/parser"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"github.com/santhosh-tekuri/jsonschema/v6/kind"
+	"github.com/scitags/flowd-go/enrichment"
+	"github.com/scitags/flowd-go/types"
+)
+
+// validateConfig runs the validation of a component's configuration, if any.
+// Check types.Validator for the details.
+func validateConfig(c any) error {
+	if v, ok := c.(types.Validator); ok {
+		return v.Validate()
+	}
+	return nil
+}
+
+// validateInstance validates the configuration of a plugin or backend instance
+// together with the filters wrapping it.
+func validateInstance(inst *instance) error {
+	return errors.Join(validateConfig(inst.Config), inst.Filters.validate())
+}
+
+// confError is a problem found when validating the configuration.
+type confError struct {
+	// Where the problem was found.
+	origin origin
+
+	// The location of the offending value (i.e. plugins.namedPipe.buffSize).
+	path string
+
+	err error
+
+	// Whether the schema rejected the value itself rather than a key.
+	wrongValue bool
+}
+
+func (e confError) Error() string {
+	var sb strings.Builder
+	if o := e.origin.String(); o != "" {
+		fmt.Fprintf(&sb, "%s: ", o)
+	}
+	if e.path != "" {
+		fmt.Fprintf(&sb, "%s: ", e.path)
+	}
+	sb.WriteString(e.err.Error())
+	return sb.String()
+}
+
+// ValidateConf checks the configuration at path without creating any plugin,
+// backend or enricher. On top of decoding it just like ReadConf, unknown keys
+// and values of the wrong type are caught by validating it against the schema
+// returned by confSchema and configurations implementing types.Validator are
+// validated too. The configuration is layered just like ReadConf does, so every
+// problem found is returned sorted by the layer and line it was found at. The
+// returned error is only non-nil if the validation itself failed.
+func ValidateConf(path string) ([]confError, error) {
+	lc, err := loadLayeredConf(path, dropInDir(path), os.Environ())
+	if err != nil {
+		return []confError{{origin: origin{file: path}, err: err}}, nil
+	}
+
+	raw, err := lc.marshal()
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling the configuration: %w", err)
+	}
+
+	confErrs, err := validateSchema(raw, lc)
+	if err != nil {
+		return nil, err
+	}
+
+	// Values of the wrong type would be reported twice otherwise: skip
+	// decoding whatever is at a location the schema already complained about.
+	// A nil location stands for any setting outside confSections.
+	skip := func(loc []string) bool {
+		for _, e := range confErrs {
+			if !e.wrongValue {
+				continue
+			}
+
+			first, _, _ := strings.Cut(e.path, ".")
+			if loc == nil && e.path != "" && !slices.Contains(confSections, first) {
+				return true
+			}
+
+			prefix := strings.Join(loc, ".")
+			if loc != nil && (e.path == prefix || strings.HasPrefix(e.path, prefix+".")) {
+				return true
+			}
+		}
+		return false
+	}
+
+	conf, settingsErrs := decodeSettings(raw, skip)
+	confErrs = append(confErrs, settingsErrs...)
+
+	// Names within components are resolved against the registry
+	confErrs = append(confErrs, loadConfRegistry(conf, lc)...)
+	confErrs = append(confErrs, decodeComponents(raw, lc, skip)...)
+
+	// Problems we can't pin down are blamed on the configuration file
+	for i := range confErrs {
+		if confErrs[i].origin == (origin{}) {
+			confErrs[i].origin = origin{file: path}
+		}
+	}
+
+	slices.SortStableFunc(confErrs, func(a, b confError) int {
+		if a.origin.layer != b.origin.layer {
+			return a.origin.layer - b.origin.layer
+		}
+		return a.origin.line - b.origin.line
+	})
+
+	return confErrs, nil
+}
+
+// validateSchema validates the raw configuration against the schema of the
+// configuration, mapping every error back to the line it was found at.
+func validateSchema(raw []byte, lc *layeredConf) ([]confError, error) {
+	rawSchema, err := marshalConfSchema()
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling the schema: %w", err)
+	}
+
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(rawSchema))
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling the schema: %w", err)
+	}
+
+	c := jsonschema.NewCompiler()
+	if err := c.AddResource("conf.schema.json", doc); err != nil {
+		return nil, fmt.Errorf("error loading the schema: %w", err)
+	}
+	sch, err := c.Compile("conf.schema.json")
+	if err != nil {
+		return nil, fmt.Errorf("error compiling the schema: %w", err)
+	}
+
+	rawJSON, err := yaml.YAMLToJSON(raw)
+	if err != nil {
+		return []confError{{err: err}}, nil
+	}
+
+	inst, err := jsonschema.UnmarshalJSON(bytes.NewReader(rawJSON))
+	if err != nil {
+		return []confError{{err: err}}, nil
+	}
+
+	var ve *jsonschema.ValidationError
+	if err := sch.Validate(inst); !errors.As(err, &ve) {
+		return nil, err
+	}
+
+	return schemaErrors(ve, lc, nil), nil
+}
+
+// schemaErrors flattens a validation error into the errors at its leaves.
+func schemaErrors(ve *jsonschema.ValidationError, lc *layeredConf, confErrs []confError) []confError {
+	if len(ve.Causes) > 0 {
+		for _, cause := range ve.Causes {
+			confErrs = schemaErrors(cause, lc, confErrs)
+		}
+		return confErrs
+	}
+
+	// Point at each offending key rather than at the mapping holding them
+	if ap, ok := ve.ErrorKind.(*kind.AdditionalProperties); ok {
+		for _, p := range ap.Properties {
+			loc := append(slices.Clone(ve.InstanceLocation), p)
+			confErrs = append(confErrs, confError{
+				origin: lc.locate(loc),
+				path:   strings.Join(ve.InstanceLocation, "."),
+				err:    fmt.Errorf("unknown key %q", p),
+			})
+		}
+		return confErrs
+	}
+
+	return append(confErrs, confError{
+		origin: lc.locate(ve.InstanceLocation),
+		path:   strings.Join(ve.InstanceLocation, "."),
+		err:    errors.New(ve.BasicOutput().Error.String()),
+
+		wrongValue: true,
+	})
+}
+
+// confSections are the keys of the configuration holding that of plugins,
+// backends and enrichers.
+var confSections = []string{"plugins", "backends", "enrichers"}
+
+// decodeSettings decodes the configuration sans plugins, backends and
+// enrichers, which are handled by decodeComponents. The decoded settings are
+// nil if they couldn't be decoded.
+func decodeSettings(raw []byte, skip func([]string) bool) (*Config, []confError) {
+	// Parse the configuration again as stripKeys modifies the AST
+	f, err := parser.ParseBytes(raw, 0)
+	if err != nil || len(f.Docs) == 0 || f.Docs[0].Body == nil {
+		return nil, nil
+	}
+
+	if skip(nil) {
+		return nil, nil
+	}
+
+	conf := Config{}
+	if err := yaml.Unmarshal(stripKeys(f.Docs[0].Body, confSections), &conf); err != nil {
+		return nil, []confError{{err: err}}
+	}
+
+	return &conf, nil
+}
+
+// loadConfRegistry loads the registry configured in the settings, if any, so
+// that names can be resolved when decoding components. A registry yet to be
+// fetched for the first time is not a problem, but note names can't be
+// resolved without it.
+func loadConfRegistry(conf *Config, lc *layeredConf) []confError {
+	types.SetRegistry(nil)
+	if conf == nil || conf.Registry == nil {
+		return nil
+	}
+
+	r, err := types.LoadRegistry(conf.Registry.path(conf.WorkDir))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) && conf.Registry.URL != "" {
+			return nil
+		}
+		return []confError{{origin: lc.locate([]string{"registry", "path"}), path: "registry.path", err: err}}
+	}
+	types.SetRegistry(r)
+
+	return nil
+}
+
+// decodeComponents decodes and validates the configuration of every plugin,
+// backend and enricher on its own so that a problem with one of them doesn't
+// hide those of the rest. Unknown components are left for the schema to
+// report.
+func decodeComponents(raw []byte, lc *layeredConf, skip func([]string) bool) []confError {
+	sections := struct {
+		Plugins   map[string]rawConf `yaml:"plugins"`
+		Backends  map[string]rawConf `yaml:"backends"`
+		Enrichers map[string]rawConf `yaml:"enrichers"`
+	}{}
+	if err := yaml.Unmarshal(raw, &sections); err != nil {
+		return nil
+	}
+
+	confErrs := []confError{}
+	var report func(loc []string, err error)
+	report = func(loc []string, err error) {
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			for _, err := range joined.Unwrap() {
+				report(loc, err)
+			}
+			return
+		}
+
+		confErrs = append(confErrs, confError{origin: lc.locate(loc), path: strings.Join(loc, "."), err: err})
+	}
+
+	for _, s := range []struct {
+		section      string
+		kind         string
+		envelopeKeys []string
+		raws         map[string]rawConf
+		lookup       func(string) (types.ConfigDecoder, bool)
+	}{
+		{"plugins", "plugin", pluginEnvelopeKeys, sections.Plugins, func(typ string) (types.ConfigDecoder, bool) {
+			r, ok := types.LookupPlugin(typ)
+			return r.Decode, ok
+		}},
+		{"backends", "backend", backendEnvelopeKeys, sections.Backends, func(typ string) (types.ConfigDecoder, bool) {
+			r, ok := types.LookupBackend(typ)
+			return r.Decode, ok
+		}},
+	} {
+		names := map[string]string{}
+		for _, typ := range sortedKeys(s.raws) {
+			decode, ok := s.lookup(typ)
+			if !ok {
+				continue
+			}
+
+			rawInstances := []rawInstance{}
+			for i, item := range rawItems(s.raws[typ]) {
+				loc := []string{s.section, typ}
+				if i >= 0 {
+					loc = append(loc, strconv.Itoa(i))
+				}
+
+				ris, err := splitInstances(typ, item, s.envelopeKeys)
+				if err != nil {
+					if !skip(loc) {
+						report(loc, err)
+					}
+					continue
+				}
+
+				rawInstances = append(rawInstances, ris...)
+			}
+
+			for _, ri := range rawInstances {
+				loc := instanceLocation(lc, s.section, typ, ri.Name)
+				if skip(loc) {
+					continue
+				}
+
+				if other, ok := names[ri.Name]; ok {
+					report(loc, fmt.Errorf("%s instance name %q is also used by a %s", s.kind, ri.Name, other))
+				}
+				names[ri.Name] = typ
+
+				inst, err := newInstance(s.kind, typ, ri, decode)
+				if err != nil {
+					report(loc, err)
+					continue
+				}
+
+				if err := validateInstance(inst); err != nil {
+					report(loc, err)
+				}
+			}
+		}
+	}
+
+	period := 1000
+	if raw, ok := sections.Enrichers["period"]; ok {
+		if err := yaml.Unmarshal(raw, &period); err != nil {
+			return confErrs
+		}
+	}
+
+	flavours := map[types.Flavour]string{}
+	for _, name := range sortedKeys(sections.Enrichers) {
+		r, ok := enrichment.LookupEnricher(name)
+		raw := sections.Enrichers[name]
+		if !ok || len(raw) == 0 || skip([]string{"enrichers", name}) {
+			continue
+		}
+
+		loc := []string{"enrichers", name}
+		if other, ok := flavours[r.Flavour]; ok {
+			report(loc, fmt.Errorf("the %s enricher provides the same flavour of information", other))
+		}
+		flavours[r.Flavour] = name
+
+		c, err := r.Decode(raw, period)
+		if err != nil {
+			report(loc, err)
+			continue
+		}
+
+		if err := validateConfig(c); err != nil {
+			report(loc, err)
+		}
+	}
+
+	return confErrs
+}
+
+// rawItems returns the raw configuration of each instance of a plugin or
+// backend configured through a sequence keyed by the index of the item so that
+// a broken instance doesn't hide the problems of the rest. Other layouts are
+// returned as is with an index of -1.
+func rawItems(raw []byte) map[int][]byte {
+	f, err := parser.ParseBytes(raw, 0)
+	if err != nil || len(f.Docs) == 0 {
+		return map[int][]byte{-1: raw}
+	}
+
+	seq, ok := f.Docs[0].Body.(*ast.SequenceNode)
+	if !ok {
+		return map[int][]byte{-1: raw}
+	}
+
+	items := make(map[int][]byte, len(seq.Values))
+	for i, item := range seq.Values {
+		items[i] = []byte(item.String())
+	}
+	return items
+}
+
+// instanceLocation returns the location of the configuration of an instance,
+// which is either the mapping under the plugin's or backend's key or one of
+// the items of the sequence found there instead.
+func instanceLocation(lc *layeredConf, section, typ, name string) []string {
+	loc := []string{section, typ}
+
+	seq, ok := lc.lookup(loc).([]any)
+	if !ok {
+		return loc
+	}
+
+	for i, item := range seq {
+		m, _ := item.(yaml.MapSlice)
+		for _, mi := range m {
+			if fmt.Sprint(mi.Key) == "name" && fmt.Sprint(mi.Value) == name {
+				return append(loc, strconv.Itoa(i))
+			}
+		}
+	}
+
+	return loc
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestValidateConf(t *testing.T) {
+	confErrs, err := ValidateConf("testdata/validate/invalid.yaml")
+	if err != nil {
+		t.Fatalf("error validating the configuration: %v", err)
+	}
+
+	want := []struct {
+		line int
+		msg  string
+	}{
+		{2, `unknown key "unknownSetting"`},
+		{3, "flowTTL: got string, want integer"},
+		{6, "plugins.namedPipe: the maximum number of readers must be positive"},
+		{9, `plugins.namedPipe: unknown key "typo"`},
+		{10, "plugins.iperf3: experimentIDs and activityIDs have different lengths"},
+		{13, `plugins: unknown key "nonExistent"`},
+		{16, `backends.marker: wrong target interface pattern "bond[0-"`},
+		{19, `backends.firefly.0: wrong collector address "not a host!"`},
+		{22, "backends.firefly.1: the queue size of the ff2 backend must be positive"},
+		{27, "backends.firefly.2.filters.include.0.srcPorts.0: '1-x' does not match pattern"},
+		{30, "enrichers.period: minimum: got 0, want 1"},
+	}
+
+	if len(confErrs) != len(want) {
+		for _, e := range confErrs {
+			t.Log(e)
+		}
+		t.Fatalf("got %d errors; want %d", len(confErrs), len(want))
+	}
+
+	for i, w := range want {
+		if confErrs[i].origin.line != w.line || !strings.Contains(confErrs[i].Error(), w.msg) {
+			t.Errorf("got %q; want %q at line %d", confErrs[i], w.msg, w.line)
+		}
+	}
+}
+
+func TestValidateConfValid(t *testing.T) {
+	for _, path := range []string{"../rpm/conf.yaml", "testdata/filters.yaml", "testdata/instances.yaml"} {
+		confErrs, err := ValidateConf(path)
+		if err != nil {
+			t.Fatalf("error validating %s: %v", path, err)
+		}
+
+		for _, e := range confErrs {
+			t.Errorf("%s: %v", path, e)
+		}
+	}
+}
+
+func TestPublishedSchema(t *testing.T) {
+	published, err := os.ReadFile("../rpm/conf.schema.json")
+	if err != nil {
+		t.Fatalf("error reading the published schema: %v", err)
+	}
+
+	s, err := marshalConfSchema()
+	if err != nil {
+		t.Fatalf("error marshaling the schema: %v", err)
+	}
+
+	if !bytes.Equal(published, s) {
+		t.Errorf("the published schema is outdated: run `make schema` to regenerate it")
+	}
+}
@@ -1,7 +1,6 @@
 package enrichment
 
 import (
-	"hash/fnv"
 	"sync"
 	"time"
 
@@ -15,30 +14,31 @@ type Poller struct {
 }
 
 // We could consider using sync.Map, but it doesn't really fit
-// our use case...
+// our use case... Flows are indexed by their key so that no two
+// of them can ever share a poller.
 type FlowCache struct {
 	sync.Mutex
-	cache map[uint64]Poller
+	cache map[types.FlowKey]Poller
 }
 
 func NewFlowCache(cap int) *FlowCache {
-	return &FlowCache{cache: make(map[uint64]Poller, cap)}
+	return &FlowCache{cache: make(map[types.FlowKey]Poller, cap)}
 }
 
-func (fc *FlowCache) Get(key uint64) (Poller, bool) {
+func (fc *FlowCache) Get(key types.FlowKey) (Poller, bool) {
 	fc.Lock()
 	cache, ok := fc.cache[key]
 	fc.Unlock()
 	return cache, ok
 }
 
-func (fc *FlowCache) GetLock(key uint64) (Poller, *sync.Mutex, bool) {
+func (fc *FlowCache) GetLock(key types.FlowKey) (Poller, *sync.Mutex, bool) {
 	fc.Lock()
 	cache, ok := fc.cache[key]
 	return cache, &fc.Mutex, ok
 }
 
-func (fc *FlowCache) Insert(key uint64, ts time.Time) (Poller, bool) {
+func (fc *FlowCache) Insert(key types.FlowKey, ts time.Time) (Poller, bool) {
 	poller := Poller{
 		DoneChan: make(chan struct{}),
 		DataChan: make(chan *types.FlowInfo),
@@ -55,7 +55,7 @@ func (fc *FlowCache) Insert(key uint64, ts time.Time) (Poller, bool) {
 	return poller, ok
 }
 
-func (fc *FlowCache) MarkForRemoval(key uint64) (time.Time, bool) {
+func (fc *FlowCache) MarkForRemoval(key types.FlowKey) (time.Time, bool) {
 	var ts time.Time
 	fc.Lock()
 	poller, ok := fc.cache[key]
@@ -67,20 +67,9 @@ func (fc *FlowCache) MarkForRemoval(key uint64) (time.Time, bool) {
 	return ts, ok
 }
 
-func (fc *FlowCache) Remove(key uint64) {
+func (fc *FlowCache) Remove(key types.FlowKey) {
 	fc.Lock()
 	close(fc.cache[key].DataChan)
 	delete(fc.cache, key)
 	fc.Unlock()
 }
-
-func HashFlowID(flowID types.FlowID) uint64 {
-	// Encoding a flowID will never fail!
-	enc, _ := flowID.MarshalBinary()
-
-	h := fnv.New64a()
-	h.Write(enc)
-	hash := h.Sum64()
-
-	return hash
-}
@@ -0,0 +1,161 @@
+package enrichment
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/scitags/flowd-go/types"
+)
+
+// FanOut distributes the flow information gathered for a single flow by any
+// number of enrichers (i.e. sources, one per flavour) to any number of
+// subscribers. Each subscriber has its own bounded buffer: when it's full the
+// oldest sample is dropped in favour of the new one so that a slow subscriber
+// can never block an enricher. With a buffer of size 1 subscribers just get
+// the latest sample, which is usually what they're interested in.
+//
+// Once a source is closed every subscriber to its flavour is closed too. A
+// subscriber whose done channel is closed is closed and forgotten the next
+// time there's information to deliver to it.
+//
+// Bear in mind every subscriber receives the very same *types.FlowInfo, so
+// subscribers must treat it as read-only.
+type FanOut struct {
+	mu      sync.Mutex
+	sources map[types.Flavour]chan *types.FlowInfo
+	subs    map[types.Flavour][]*subscription
+
+	// Flavours whose source has been closed.
+	closed map[types.Flavour]bool
+
+	// When the latest sample was delivered in Unix nanoseconds.
+	lastSample atomic.Int64
+}
+
+type subscription struct {
+	ch   chan *types.FlowInfo
+	done <-chan struct{}
+}
+
+func NewFanOut(sources map[types.Flavour]chan *types.FlowInfo) *FanOut {
+	return &FanOut{
+		sources: sources,
+		subs:    make(map[types.Flavour][]*subscription, len(sources)),
+		closed:  make(map[types.Flavour]bool, len(sources)),
+	}
+}
+
+// Flavours returns the sorted flavours the FanOut has a source for.
+func (fo *FanOut) Flavours() []types.Flavour {
+	flavours := make([]types.Flavour, 0, len(fo.sources))
+	for t := range fo.sources {
+		flavours = append(flavours, t)
+	}
+	slices.Sort(flavours)
+
+	return flavours
+}
+
+// Subscribe returns a channel delivering the information of the given flavour
+// buffering up to size samples (at least 1). The done channel signals the
+// subscriber is no longer interested. If there's no source for the flavour
+// false is returned. If the source has already been closed the returned
+// channel is closed too. Subscribing is possible at any time, even after
+// calling Run.
+func (fo *FanOut) Subscribe(t types.Flavour, size int, done <-chan struct{}) (chan *types.FlowInfo, bool) {
+	if _, ok := fo.sources[t]; !ok {
+		return nil, false
+	}
+
+	s := &subscription{ch: make(chan *types.FlowInfo, max(size, 1)), done: done}
+
+	fo.mu.Lock()
+	defer fo.mu.Unlock()
+
+	if fo.closed[t] {
+		close(s.ch)
+		return s.ch, true
+	}
+	fo.subs[t] = append(fo.subs[t], s)
+
+	return s.ch, true
+}
+
+// Run distributes the information until every source is closed.
+func (fo *FanOut) Run() {
+	var wg sync.WaitGroup
+	for t, src := range fo.sources {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for fi := range src {
+				fo.deliver(t, fi)
+			}
+			fo.close(t)
+		}()
+	}
+	wg.Wait()
+}
+
+// LastSample returns when the latest sample was delivered, which is the zero
+// time if none has been yet. Enrichers keep on sampling a flow for as long as
+// its socket is around, so samples prove the flow is still alive.
+func (fo *FanOut) LastSample() time.Time {
+	ns := fo.lastSample.Load()
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+func (fo *FanOut) deliver(t types.Flavour, fi *types.FlowInfo) {
+	fo.lastSample.Store(time.Now().UnixNano())
+
+	fo.mu.Lock()
+	defer fo.mu.Unlock()
+
+	live := fo.subs[t][:0]
+	for _, s := range fo.subs[t] {
+		select {
+		case <-s.done:
+			close(s.ch)
+			continue
+		default:
+		}
+
+		s.offer(fi)
+		live = append(live, s)
+	}
+	clear(fo.subs[t][len(live):])
+	fo.subs[t] = live
+}
+
+func (fo *FanOut) close(t types.Flavour) {
+	fo.mu.Lock()
+	defer fo.mu.Unlock()
+
+	for _, s := range fo.subs[t] {
+		close(s.ch)
+	}
+	delete(fo.subs, t)
+	fo.closed[t] = true
+}
+
+// offer enqueues a sample dropping the oldest one if the buffer is full. Note
+// the FanOut is the only sender, so the loop is bound to end.
+func (s *subscription) offer(fi *types.FlowInfo) {
+	for {
+		select {
+		case s.ch <- fi:
+			return
+		default:
+		}
+
+		select {
+		case <-s.ch:
+		default:
+		}
+	}
+}
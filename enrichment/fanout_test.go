@@ -0,0 +1,81 @@
+package enrichment
+
+import (
+	"testing"
+	"time"
+
+	"github.com/scitags/flowd-go/types"
+)
+
+func TestFanOut(t *testing.T) {
+	netlink := make(chan *types.FlowInfo)
+	ebpf := make(chan *types.FlowInfo)
+
+	fo := NewFanOut(map[types.Flavour]chan *types.FlowInfo{types.Netlink: netlink, types.Ebpf: ebpf})
+
+	if _, ok := fo.Subscribe(types.Flavour(123), 1, nil); ok {
+		t.Fatalf("subscribed to a flavour without a source")
+	}
+
+	// A subscriber which never reads can't block the others
+	slow, _ := fo.Subscribe(types.Netlink, 1, nil)
+	fast, _ := fo.Subscribe(types.Netlink, 1, nil)
+	ebpfSub, _ := fo.Subscribe(types.Ebpf, 1, nil)
+
+	go fo.Run()
+
+	for i := range 5 {
+		netlink <- &types.FlowInfo{Mode: string(rune('a' + i))}
+		if fi := <-fast; fi.Mode != string(rune('a'+i)) {
+			t.Errorf("got %q; want %q", fi.Mode, string(rune('a'+i)))
+		}
+	}
+
+	// The slow subscriber only gets the latest sample
+	if fi := <-slow; fi.Mode != "e" {
+		t.Errorf("got %q; want the latest sample", fi.Mode)
+	}
+
+	close(netlink)
+	for _, ch := range []chan *types.FlowInfo{slow, fast} {
+		if _, ok := <-ch; ok {
+			t.Errorf("subscriber not closed after closing the source")
+		}
+	}
+
+	// Subscribing to a closed source yields a closed channel
+	late, _ := fo.Subscribe(types.Netlink, 1, nil)
+	if _, ok := <-late; ok {
+		t.Errorf("late subscriber not closed")
+	}
+
+	// The eBPF source is still alive
+	ebpf <- &types.FlowInfo{Mode: "x"}
+	if fi := <-ebpfSub; fi.Mode != "x" {
+		t.Errorf("got %q; want %q", fi.Mode, "x")
+	}
+	close(ebpf)
+}
+
+func TestFanOutDone(t *testing.T) {
+	src := make(chan *types.FlowInfo)
+	fo := NewFanOut(map[types.Flavour]chan *types.FlowInfo{types.Netlink: src})
+
+	done := make(chan struct{})
+	sub, _ := fo.Subscribe(types.Netlink, 1, done)
+
+	go fo.Run()
+	defer close(src)
+
+	close(done)
+	src <- &types.FlowInfo{}
+
+	select {
+	case _, ok := <-sub:
+		if ok {
+			t.Errorf("got a sample after closing the done channel")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("subscriber not closed after closing its done channel")
+	}
+}
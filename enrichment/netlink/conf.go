@@ -1,12 +1,35 @@
 package netlink
 
 import (
+	"fmt"
+
 	"github.com/goccy/go-yaml"
+	"github.com/scitags/flowd-go/enrichment"
 	"github.com/scitags/flowd-go/types"
 	"golang.org/x/sys/unix"
 )
 
+func init() {
+	enrichment.RegisterEnricher("netlink", types.Netlink, func(c any) (enrichment.Enricher, error) {
+		return NewEnricher(c.(*Config))
+	}, decodeConfig)
+}
+
+// decodeConfig decodes the enricher's configuration, making it poll with the
+// given period (in ms).
+func decodeConfig(raw []byte, period int) (any, error) {
+	c := &Config{}
+	if err := yaml.Unmarshal(raw, c); err != nil {
+		return nil, err
+	}
+	c.Period = period
+
+	return c, nil
+}
+
 type Config struct {
+	// The transport protocol (i.e. unix.IPPROTO_UDP) to query. If 0 each flow
+	// is queried with its own protocol.
 	Protocol      uint8  `yaml:"protocol"`
 	Ext           uint8  `yaml:"ext"`
 	State         uint32 `yaml:"state"`
@@ -15,7 +38,6 @@ type Config struct {
 }
 
 var DefaultConfig = Config{
-	Protocol: unix.IPPROTO_TCP,
 	Ext: 1<<(INET_DIAG_MEMINFO-1) |
 		1<<(INET_DIAG_INFO-1) |
 		1<<(INET_DIAG_VEGASINFO-1) |
@@ -43,3 +65,13 @@ func (c *Config) UnmarshalYAML(b []byte) error {
 
 	return nil
 }
+
+// Validate implements types.Validator.
+func (c *Config) Validate() error {
+	switch c.Protocol {
+	case 0, unix.IPPROTO_TCP, unix.IPPROTO_UDP:
+		return nil
+	}
+	return fmt.Errorf("unsupported protocol %d: only TCP (%d) and UDP (%d) can be queried",
+		c.Protocol, unix.IPPROTO_TCP, unix.IPPROTO_UDP)
+}
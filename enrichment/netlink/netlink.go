@@ -9,7 +9,9 @@ import (
 
 	"github.com/florianl/go-diag"
 	"github.com/scitags/flowd-go/enrichment"
+	"github.com/scitags/flowd-go/internal/telemetry"
 	"github.com/scitags/flowd-go/types"
+	"golang.org/x/sys/unix"
 )
 
 type NetlinkEnricher struct {
@@ -45,6 +47,27 @@ func NewEnricher(config *Config) (*NetlinkEnricher, error) {
 	}, nil
 }
 
+// Protocols implements enrichment.ProtocolAware: sock_diag(7) can dump both
+// TCP and UDP sockets.
+func (e *NetlinkEnricher) Protocols() []types.Protocol {
+	switch e.Protocol {
+	case unix.IPPROTO_TCP:
+		return []types.Protocol{types.TCP}
+	case unix.IPPROTO_UDP:
+		return []types.Protocol{types.UDP}
+	default:
+		return []types.Protocol{types.TCP, types.UDP}
+	}
+}
+
+// protocol returns the transport protocol to query for the given flow.
+func (e *NetlinkEnricher) protocol(flowID types.FlowID) uint8 {
+	if e.Protocol != 0 {
+		return e.Protocol
+	}
+	return flowID.Protocol.Number()
+}
+
 // A simple noop to check synchronisation and adhere to the enrichment.Enricher interface
 func (e *NetlinkEnricher) Run(done <-chan struct{}) {
 	slog.Debug("starting the netlink enricher")
@@ -53,19 +76,19 @@ func (e *NetlinkEnricher) Run(done <-chan struct{}) {
 }
 
 func (e *NetlinkEnricher) WatchFlow(flowID types.FlowID) (*enrichment.Poller, error) {
-	hash := enrichment.HashFlowID(flowID)
-	poller, ok := e.cache.Insert(hash, flowID.StartTs)
+	key := flowID.Key()
+	poller, ok := e.cache.Insert(key, flowID.StartTs)
 	if ok {
-		slog.Warn("an entry for this flowID already existed", "flowID", flowID)
+		slog.Warn("an entry for this flowID already existed", types.LogKeyFlow, flowID)
 	}
 
 	go func() {
-		slog.Debug("entering polling goroutine", "hash", hash)
+		slog.Debug("entering polling goroutine", "flowKey", key)
 		for {
 			select {
 			case <-poller.DoneChan:
-				slog.Debug("cleanly exiting polling goroutine", "hash", hash)
-				e.cache.Remove(hash)
+				slog.Debug("cleanly exiting polling goroutine", "flowKey", key)
+				e.cache.Remove(key)
 				return
 			case <-time.Tick(time.Duration(e.Period) * time.Millisecond):
 				for _, fi := range e.GetFlowInfo(flowID) {
@@ -79,15 +102,15 @@ func (e *NetlinkEnricher) WatchFlow(flowID types.FlowID) (*enrichment.Poller, er
 }
 
 func (e *NetlinkEnricher) ForgetFlow(flowID types.FlowID) (time.Time, bool) {
-	hash := enrichment.HashFlowID(flowID)
-	slog.Debug("marking flow for removal", "hash", hash)
-	return e.cache.MarkForRemoval(hash)
+	key := flowID.Key()
+	slog.Debug("marking flow for removal", "flowKey", key)
+	return e.cache.MarkForRemoval(key)
 }
 
 func (e *NetlinkEnricher) GetFlowInfo(flowID types.FlowID) []types.FlowInfo {
 	res, err := e.conn.NetDump(&diag.NetOption{
 		Family:   uint8(flowID.Family),
-		Protocol: e.Protocol,
+		Protocol: e.protocol(flowID),
 		Ext:      e.Ext,
 		State:    e.State,
 		ID: diag.SockID{
@@ -101,12 +124,14 @@ func (e *NetlinkEnricher) GetFlowInfo(flowID types.FlowID) []types.FlowInfo {
 		},
 	})
 	if err != nil {
-		slog.Warn("error getting TCP information", "err", err)
+		slog.Warn("error getting socket information", "protocol", flowID.Protocol, "err", err)
+		telemetry.EnricherErrors.WithLabelValues("netlink").Inc()
 		return nil
 	}
 
 	if len(res) == 0 {
 		slog.Warn("got no netlink information back")
+		telemetry.EnricherErrors.WithLabelValues("netlink").Inc()
 		return nil
 	}
 
@@ -12,7 +12,7 @@ import (
 type NetlinkEnricher struct {
 }
 
-func NewEnricher(pollingInterval uint64) (*NetlinkEnricher, error) { return nil, nil }
+func NewEnricher(config *Config) (*NetlinkEnricher, error) { return nil, nil }
 
 func (e NetlinkEnricher) String() string {
 	return "netlink enricher stub"
@@ -0,0 +1,66 @@
+package enrichment
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/scitags/flowd-go/types"
+)
+
+// ConfigDecoder decodes the raw YAML configuration of an enricher into the
+// value that will be handed over to its factory. The enrichment period (in
+// ms) shared by every enricher is provided so that it can be folded into the
+// configuration. Decoders are expected to apply any default values.
+type ConfigDecoder func(raw []byte, period int) (any, error)
+
+// Factory creates an enricher given the configuration returned by the
+// ConfigDecoder it was registered with.
+type Factory func(conf any) (Enricher, error)
+
+type Registration struct {
+	Name string
+
+	// The flavour of the information provided by the enricher.
+	Flavour types.Flavour
+
+	Factory Factory
+	Decode  ConfigDecoder
+}
+
+var (
+	registryMu sync.RWMutex
+	enrichers  = map[string]Registration{}
+)
+
+// RegisterEnricher makes an enricher available under the given name, which is
+// the key its configuration is expected to be found under in the enrichers
+// section of the configuration. Just like types.RegisterPlugin, it's meant to
+// be called from the init function of the package implementing the enricher.
+// Registering the same name twice panics.
+func RegisterEnricher(name string, flavour types.Flavour, factory Factory, decode ConfigDecoder) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, ok := enrichers[name]; ok {
+		panic(fmt.Sprintf("enricher %q registered twice", name))
+	}
+	enrichers[name] = Registration{Name: name, Flavour: flavour, Factory: factory, Decode: decode}
+}
+
+func LookupEnricher(name string) (Registration, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	r, ok := enrichers[name]
+	return r, ok
+}
+
+// RegisteredEnrichers returns the sorted names of every registered enricher.
+func RegisteredEnrichers() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	return slices.Sorted(maps.Keys(enrichers))
+}
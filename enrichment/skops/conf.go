@@ -1,11 +1,32 @@
 package skops
 
 import (
+	"fmt"
 	"strings"
 
 	"github.com/goccy/go-yaml"
+	"github.com/scitags/flowd-go/enrichment"
+	"github.com/scitags/flowd-go/types"
 )
 
+func init() {
+	enrichment.RegisterEnricher("skops", types.Ebpf, func(c any) (enrichment.Enricher, error) {
+		return NewEnricher(c.(*Config))
+	}, decodeConfig)
+}
+
+// decodeConfig decodes the enricher's configuration, making the eBPF program
+// poll with the given period (in ms).
+func decodeConfig(raw []byte, period int) (any, error) {
+	c := &Config{}
+	if err := yaml.Unmarshal(raw, c); err != nil {
+		return nil, err
+	}
+	c.PollingInterval = uint64(period) * NS_PER_MS
+
+	return c, nil
+}
+
 //go:generate go tool golang.org/x/tools/cmd/stringer -type=Strategy
 
 type Config struct {
@@ -51,6 +72,12 @@ func (c *Config) UnmarshalYAML(b []byte) error {
 		return err
 	}
 
+	s, ok := ParseStrategy(def.RawStrategy)
+	if !ok {
+		return fmt.Errorf("wrong enrichment strategy %q", def.RawStrategy)
+	}
+	def.Strategy = s
+
 	*c = Config(def)
 
 	return nil
@@ -6,7 +6,11 @@ const NS_PER_MS uint64 = 1_000_000
 // FlowSpec identifies a given socket at L4. It allows us to minimise
 // the information exchange across the user-kernel boundary. We use
 // uint32s instead of uint16s due to 4-byte alignment constraints.
+// Addresses are stored in network byte order (see addrToWords), so
+// IPv4 flows are identified by their IPv4-mapped IPv6 addresses.
 type FlowSpec struct {
 	DstPort uint32
 	SrcPort uint32
+	DstAddr [4]uint32
+	SrcAddr [4]uint32
 }
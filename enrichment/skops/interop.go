@@ -7,6 +7,7 @@ import (
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"net/netip"
 
 	"github.com/josharian/native"
 	"github.com/scitags/flowd-go/types"
@@ -130,7 +131,7 @@ func (s CaState) String() string {
 	return str
 }
 
-const TcpInfoSize = 368
+const TcpInfoSize = 400
 
 // TcpInfo represents all the available data on a struct tcp_sock in the linux kernel.
 // Some of the units shown in the comments accompanying members have been extracted from
@@ -141,6 +142,8 @@ const TcpInfoSize = 368
 type TcpInfo struct {
 	SrcPort uint16
 	DstPort uint16
+	SrcAddr [4]uint32 /* In network byte order */
+	DstAddr [4]uint32 /* In network byte order */
 
 	NewState               uint32
 	State                  uint8
@@ -240,12 +243,34 @@ func (i *TcpInfo) UnmarshalBinary(data []byte) error {
 	return binary.Read(b, native.Endian, i)
 }
 
+// addrToWords lays out an address just like the kernel does in struct bpf_sock_ops,
+// that is, as an IPv6 address in network byte order split into 32-bit words.
+func addrToWords(addr netip.Addr) [4]uint32 {
+	var words [4]uint32
+	raw := addr.As16()
+	for i := range words {
+		words[i] = native.Endian.Uint32(raw[4*i:])
+	}
+	return words
+}
+
+// wordsToAddr is the inverse of addrToWords. IPv4-mapped addresses are unmapped.
+func wordsToAddr(words [4]uint32) netip.Addr {
+	var raw [16]byte
+	for i, word := range words {
+		native.Endian.PutUint32(raw[4*i:], word)
+	}
+	return netip.AddrFrom16(raw).Unmap()
+}
+
 func tcpInfoToFlowInfo(ti TcpInfo) types.FlowInfo {
 	return types.FlowInfo{
 		Socket: &types.Socket{
 			ID: types.SockID{
 				SPort: ti.SrcPort,
 				DPort: ti.DstPort,
+				Src:   ti.SrcAddr,
+				Dst:   ti.DstAddr,
 			},
 		},
 		TCPInfo: &types.TCPInfo{
@@ -22,6 +22,7 @@ import (
 
 	"github.com/scitags/flowd-go/enrichment"
 	"github.com/scitags/flowd-go/internal/progs"
+	"github.com/scitags/flowd-go/internal/telemetry"
 	"github.com/scitags/flowd-go/types"
 )
 
@@ -166,28 +167,28 @@ func (e *EbpfEnricher) Run(done <-chan struct{}) {
 				return
 			}
 			slog.Error("error reading data from the ring buffer", "err", err)
+			telemetry.EnricherErrors.WithLabelValues("skops").Inc()
 			continue
 		}
 
 		if err := tcpInfo.UnmarshalBinary(rec.RawSample); err != nil {
 			slog.Warn("error unmarshaling event", "err", err)
+			telemetry.EnricherErrors.WithLabelValues("skops").Inc()
 		}
 
 		slog.Debug("TCP state info", "oldState", types.State(tcpInfo.State), "newState", types.State(tcpInfo.NewState))
 
 		fi := tcpInfoToFlowInfo(tcpInfo)
 
-		hash := enrichment.HashFlowID(
-			types.FlowID{
-				Src: netip.AddrPortFrom(netip.IPv6Unspecified(), uint16(tcpInfo.SrcPort)),
-				Dst: netip.AddrPortFrom(netip.IPv6Unspecified(), uint16(tcpInfo.DstPort)),
-			},
+		key := types.NewFlowKey(types.TCP,
+			netip.AddrPortFrom(wordsToAddr(tcpInfo.SrcAddr), tcpInfo.SrcPort),
+			netip.AddrPortFrom(wordsToAddr(tcpInfo.DstAddr), tcpInfo.DstPort),
 		)
 
 		// Be sure to unlock m on **every** path...
-		poller, m, ok := e.cache.GetLock(hash)
+		poller, m, ok := e.cache.GetLock(key)
 		if !ok {
-			slog.Warn("got information for nonexistent flow", "hash", hash)
+			slog.Warn("got information for nonexistent flow", "flowKey", key)
 			m.Unlock()
 			continue
 		}
@@ -199,33 +200,31 @@ func (e *EbpfEnricher) Run(done <-chan struct{}) {
 }
 
 func (e *EbpfEnricher) WatchFlow(flowID types.FlowID) (*enrichment.Poller, error) {
-	spec := FlowSpec{DstPort: uint32(flowID.Dst.Port()), SrcPort: uint32(flowID.Src.Port())}
+	spec := FlowSpec{
+		DstPort: uint32(flowID.Dst.Port()),
+		SrcPort: uint32(flowID.Src.Port()),
+		DstAddr: addrToWords(flowID.Dst.Addr()),
+		SrcAddr: addrToWords(flowID.Src.Addr()),
+	}
 	if err := e.coll.Maps[MAP_NAME].Update(spec, byte(0xFF), ebpf.UpdateAny); err != nil {
 		return nil, fmt.Errorf("error inserting flow spec into eBPF map: %w", err)
 	}
 
-	// eBPF samples only contain port numbers (even though they could contain IP addresses too...)
-	// Simply force the IPvX addresses for computing the hashes.
-	hash := enrichment.HashFlowID(
-		types.FlowID{
-			Src: netip.AddrPortFrom(netip.IPv6Unspecified(), flowID.Src.Port()),
-			Dst: netip.AddrPortFrom(netip.IPv6Unspecified(), flowID.Dst.Port()),
-		},
-	)
-	slog.Debug("watching flow", "hash", hash)
-
-	poller, ok := e.cache.Insert(hash, flowID.StartTs)
+	key := flowID.Key()
+	slog.Debug("watching flow", "flowKey", key)
+
+	poller, ok := e.cache.Insert(key, flowID.StartTs)
 	if ok {
-		slog.Warn("an entry for this flowID already existed", "flowID", flowID)
+		slog.Warn("an entry for this flowID already existed", types.LogKeyFlow, flowID)
 	}
 
 	go func() {
-		slog.Debug("entering polling goroutine", "hash", hash)
+		slog.Debug("entering polling goroutine", "flowKey", key)
 		for {
 			select {
 			case <-poller.DoneChan:
-				slog.Debug("cleanly exiting polling goroutine", "hash", hash)
-				e.cache.Remove(hash)
+				slog.Debug("cleanly exiting polling goroutine", "flowKey", key)
+				e.cache.Remove(key)
 				if err := e.coll.Maps[MAP_NAME].Delete(spec); err != nil {
 					slog.Warn("error removing flow spec from eBPF map", "err", err)
 				}
@@ -239,12 +238,7 @@ func (e *EbpfEnricher) WatchFlow(flowID types.FlowID) (*enrichment.Poller, error
 
 // Should we simply wait for an
 func (e *EbpfEnricher) ForgetFlow(flowID types.FlowID) (time.Time, bool) {
-	hash := enrichment.HashFlowID(
-		types.FlowID{
-			Src: netip.AddrPortFrom(netip.IPv6Unspecified(), flowID.Src.Port()),
-			Dst: netip.AddrPortFrom(netip.IPv6Unspecified(), flowID.Dst.Port()),
-		},
-	)
-	slog.Debug("marking flow for removal", "hash", hash)
-	return e.cache.MarkForRemoval(hash)
+	key := flowID.Key()
+	slog.Debug("marking flow for removal", "flowKey", key)
+	return e.cache.MarkForRemoval(key)
 }
@@ -1,6 +1,7 @@
 package enrichment
 
 import (
+	"slices"
 	"time"
 
 	"github.com/scitags/flowd-go/types"
@@ -15,3 +16,18 @@ type Enricher interface {
 	Cleanup() error
 	String() string
 }
+
+// ProtocolAware can be implemented by enrichers to declare the transport
+// protocols of the flows they can watch. Enrichers not implementing it are
+// assumed to only understand TCP connections.
+type ProtocolAware interface {
+	Protocols() []types.Protocol
+}
+
+// Supports checks whether an enricher can watch flows of the given protocol.
+func Supports(e Enricher, proto types.Protocol) bool {
+	if p, ok := e.(ProtocolAware); ok {
+		return slices.Contains(p.Protocols(), proto)
+	}
+	return proto == types.TCP
+}
@@ -0,0 +1,41 @@
+package enrichment
+
+import (
+	"testing"
+	"time"
+
+	"github.com/scitags/flowd-go/types"
+)
+
+type tcpEnricher struct{}
+
+func (tcpEnricher) Run(<-chan struct{})                       {}
+func (tcpEnricher) WatchFlow(types.FlowID) (*Poller, error)   { return nil, nil }
+func (tcpEnricher) ForgetFlow(types.FlowID) (time.Time, bool) { return time.Time{}, false }
+func (tcpEnricher) Cleanup() error                            { return nil }
+func (tcpEnricher) String() string                            { return "tcp" }
+
+type udpEnricher struct{ tcpEnricher }
+
+func (udpEnricher) String() string              { return "udp" }
+func (udpEnricher) Protocols() []types.Protocol { return []types.Protocol{types.UDP} }
+
+func TestSupports(t *testing.T) {
+	tests := []struct {
+		e   Enricher
+		tcp bool
+		udp bool
+	}{
+		{tcpEnricher{}, true, false},
+		{udpEnricher{}, false, true},
+	}
+
+	for _, test := range tests {
+		if got := Supports(test.e, types.TCP); got != test.tcp {
+			t.Errorf("%s: got %t for TCP, want %t", test.e, got, test.tcp)
+		}
+		if got := Supports(test.e, types.UDP); got != test.udp {
+			t.Errorf("%s: got %t for UDP, want %t", test.e, got, test.udp)
+		}
+	}
+}
@@ -65,14 +65,16 @@ rm -rf %{buildroot}
 # Create the necessary directories
 mkdir -p %{buildroot}%{_bindir}
 mkdir -p %{buildroot}%{_unitdir}
-mkdir -p %{buildroot}%{_sysconfdir}/%{name}
+mkdir -p %{buildroot}%{_sysconfdir}/%{name}/conf.d
 mkdir -p %{buildroot}%{_mandir}/man1
+mkdir -p %{buildroot}%{_datadir}/%{name}
 
 # And install the necessary files
 install -m 0775 bin/%{name}         %{buildroot}%{_bindir}/%{name}
 install -m 0644 rpm/conf.yaml       %{buildroot}%{_sysconfdir}/%{name}/conf.yaml
 install -m 0664 rpm/%{name}.service %{buildroot}%{_unitdir}/%{name}.service
 install -m 0664 rpm/%{name}.1.gz    %{buildroot}%{_mandir}/man1/%{name}.1.gz
+install -m 0644 rpm/conf.schema.json %{buildroot}%{_datadir}/%{name}/conf.schema.json
 
 %post
 %systemd_post %{name}.service
@@ -88,8 +90,10 @@ install -m 0664 rpm/%{name}.1.gz    %{buildroot}%{_mandir}/man1/%{name}.1.gz
 %defattr(-,root,root)
 %attr(755, root, root) %{_bindir}/%{name}
 %config(noreplace) %{_sysconfdir}/%{name}/conf.yaml
+%dir %{_sysconfdir}/%{name}/conf.d
 %{_unitdir}/%{name}.service
 %doc %{_mandir}/man1/%{name}.1*
+%{_datadir}/%{name}/conf.schema.json
 
 # Changes introduced with each version
 %changelog
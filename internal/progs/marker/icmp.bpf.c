@@ -42,10 +42,12 @@ static __always_inline int handleICMP(struct __sk_buff *ctx, struct ipv6hdr *l3)
 
 	#ifndef FLOWD_MATCH_ALL
 		// Hardcode the port numbers we'll 'look for': there are none in ICMP!
+		// We'll pretend to be a TCP flow so that flows for ping(8) are easy to define.
 		flowHash.ip6Hi = ipv6DaddrHi;
 		flowHash.ip6Lo = ipv6DaddrLo;
 		flowHash.dPort = 5777;
 		flowHash.sPort = 2345;
+		flowHash.proto = PROTO_TCP;
 	#endif
 
 	// Check if a flow with the above criteria has been defined by flowd-go
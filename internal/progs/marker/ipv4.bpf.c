@@ -0,0 +1,49 @@
+// +build ignore
+
+#include "vmlinux.h"
+#include <bpf/bpf_helpers.h>
+#include <bpf/bpf_endian.h>
+
+#include "marker.bpf.h"
+
+// handleDatagram4 looks IPv4 TCP and UDP datagrams up so that they can be marked. Both
+// the TCP and UDP headers begin with the source and destination ports, so we'll simply
+// rely on struct udphdr to access them regardless of the transport protocol.
+static __always_inline int handleDatagram4(struct __sk_buff *ctx, struct iphdr *l3, void *data_end) {
+	struct udphdr *l4;
+
+	if (l3->protocol != PROTO_TCP && l3->protocol != PROTO_UDP)
+		return TC_ACT_OK;
+
+	// The IHL is the header's length in 32-bit words: it's larger than 5 if there are options
+	if (l3->ihl < 5)
+		return TC_ACT_OK;
+
+	l4 = (void *)l3 + l3->ihl * 4;
+	if ((void *)(l4 + 1) > data_end)
+		return TC_ACT_OK;
+
+	#ifdef FLOWD_DEBUG
+		bpf_printk("flowd-go: IPv4      source port: %d", bpf_ntohs(l4->source));
+		bpf_printk("flowd-go: IPv4 destination port: %d", bpf_ntohs(l4->dest));
+	#endif
+
+	// Declare the struct we'll use to index the map
+	struct fourTuple flowHash;
+	__builtin_memset(&flowHash, 0, sizeof(flowHash));
+
+	#ifndef FLOWD_MATCH_ALL
+		// IPv4 addresses are stored as IPv4-mapped IPv6 addresses on the map
+		flowHash.ip6Hi = 0;
+		flowHash.ip6Lo = ipv4MappedAddrLo(l3->daddr);
+		flowHash.dPort = bpf_ntohs(l4->dest);
+		flowHash.sPort = bpf_ntohs(l4->source);
+		flowHash.proto = l3->protocol;
+	#endif
+
+	#ifdef FLOWD_DEBUG
+		bpf_printk("flowd-go: IPv4 destination address: %pI4", &l3->daddr);
+	#endif
+
+	return markFlow4(ctx, l3, &flowHash);
+}
@@ -0,0 +1,179 @@
+// +build ignore
+
+#include "vmlinux.h"
+#include <bpf/bpf_helpers.h>
+#include <bpf/bpf_endian.h>
+
+#include "marker.bpf.h"
+
+// markFlow looks the flow identified by flowHash up and, if flowd-go has
+// defined it (or one of the rules matches it), marks the datagram and
+// accounts for it on flowStats. It's shared by every transport protocol
+// we handle given marking only ever touches the IPv6 header.
+static __always_inline int markFlow(struct __sk_buff *ctx, struct ipv6hdr *l3, struct fourTuple *flowHash) {
+	// Check if a flow with the given criteria has been defined by flowd-go
+	__u32 *flowTag = bpf_map_lookup_elem(&flowLabels, flowHash);
+
+	// Flows no plugin signalled can still be marked by the rules. We only keep
+	// counters for signalled flows given nobody would ever collect the rest.
+	int signalled = flowTag != NULL;
+	if (!signalled)
+		flowTag = matchRules(flowHash);
+
+	// If there's a flow configured, mark the packet
+	if (flowTag) {
+		#if defined(FLOWD_LABEL)
+
+			#ifdef FLOWD_DEBUG
+				bpf_printk("flowd-go: retrieved flowTag: %x", *flowTag);
+			#endif
+
+			populateFlowLbl(l3->flow_lbl, *flowTag);
+		#endif
+
+		#ifdef FLOWD_DSCP
+			// The flow tag is the DSCP value itself
+			setIPv6DSCP(l3, *flowTag);
+		#endif
+
+		#if defined(FLOWD_HOPBYHOP) || defined(FLOWD_DESTINATION)
+			struct extensionHdr_t extensionHdr;
+
+			// Check we won't go overboard and overwhelm the MTU!
+			// In order to check individual GSO/GRO segments in an sk_buff use the
+			// BPF_MTU_CHK_SEGS as seen on 0:
+			//   0: see https://github.com/xdp-project/bpf-examples/blob/main/MTU-tests/tc_mtu_enforce.c
+			__u32 mtuLen = 0;
+			if(bpf_check_mtu(ctx, 0, &mtuLen, sizeof(extensionHdr), 0)) {
+				#ifdef FLOWD_DEBUG
+					bpf_printk("flowd-go: adding extension headers would overflow the MTU, skipping...");
+				#endif
+
+				if (signalled)
+					accountMTUSkipped(flowHash);
+
+				return TC_ACT_OK;
+			}
+
+			#ifdef FLOWD_DEBUG
+				bpf_printk("flowd-go: IPv6 header size increase: %d bytes", sizeof(extensionHdr));
+				bpf_printk("flowd-go: detected MTU: %d bytes", mtuLen);
+			#endif
+
+			// Initialise the header
+			__builtin_memset(&extensionHdr, 0, sizeof(extensionHdr));
+
+			// Fill in the Hob-by-Hop Header!
+			populateExtensionHdr(&extensionHdr, l3->nexthdr, *flowTag);
+
+			#ifdef FLOWD_HOPBYHOP
+				// Signal the next header is a Hop-by-Hop Extension Header
+				l3->nexthdr = NEXT_HDR_HOP_BY_HOP;
+			#else
+				// Signal the next header is a Destination Options Extension Header
+				l3->nexthdr = NEXT_HDR_DEST_OPTS;
+			#endif
+
+			// Update the payload length
+			l3->payload_len = bpf_htons(bpf_ntohs(l3->payload_len) + sizeof(struct extensionHdr_t));
+
+			// Be sure to check available flags (i.e. BPF_F_ADJ_ROOM_*) on bpf-helpers(7).
+			if (bpf_skb_adjust_room(ctx, sizeof(struct extensionHdr_t), BPF_ADJ_ROOM_NET, 0)) {
+				#ifdef FLOWD_DEBUG
+					bpf_printk("flowd-go: error making room for the extension header");
+				#endif
+
+				return TC_ACT_SHOT;
+			}
+
+			// Be sure to check available flags (i.e. BPF_F_{RECOMPUTE_CSUM,NVALIDATE_HASH}) on bpf-helpers(7).
+			if (bpf_skb_store_bytes(ctx, sizeof(struct ethhdr) + sizeof(struct ipv6hdr), &extensionHdr, sizeof(struct extensionHdr_t), BPF_F_RECOMPUTE_CSUM)) {
+				#ifdef FLOWD_DEBUG
+					bpf_printk("flowd-go: error storing the extension header");
+				#endif
+
+				return TC_ACT_SHOT;
+			}
+		#endif
+
+		#ifdef FLOWD_HOPBYHOPDESTINATION
+			struct compExtensionHdr_t compExtensionHdr;
+
+			__u32 mtuLen = 0;
+			if(bpf_check_mtu(ctx, 0, &mtuLen, sizeof(compExtensionHdr), 0)) {
+				#ifdef FLOWD_DEBUG
+					bpf_printk("flowd-go: adding extension headers would overflow the MTU, skipping...");
+				#endif
+
+				if (signalled)
+					accountMTUSkipped(flowHash);
+
+				return TC_ACT_OK;
+			}
+
+			#ifdef FLOWD_DEBUG
+				bpf_printk("flowd-go: IPv6 header size increase: %d bytes", sizeof(compExtensionHdr));
+				bpf_printk("flowd-go: detected MTU: %d bytes", mtuLen);
+			#endif
+
+			// Initialise the header
+			__builtin_memset(&compExtensionHdr, 0, sizeof(compExtensionHdr));
+
+			// Fill in the Hob-by-Hop and Destination Options Headers!
+			populateCompExtensionHdr(&compExtensionHdr, l3->nexthdr, *flowTag);
+
+			// Signal the next header is a Hop-by-Hop extension header
+			l3->nexthdr = NEXT_HDR_HOP_BY_HOP;
+
+			// Update the payload length
+			l3->payload_len = bpf_htons(bpf_ntohs(l3->payload_len) + sizeof(struct compExtensionHdr_t));
+
+			if (bpf_skb_adjust_room(ctx, sizeof(struct compExtensionHdr_t), BPF_ADJ_ROOM_NET, 0)) {
+				#ifdef FLOWD_DEBUG
+					bpf_printk("flowd-go: error making room for the extension header");
+				#endif
+
+				return TC_ACT_SHOT;
+			}
+
+			if (bpf_skb_store_bytes(ctx, sizeof(struct ethhdr) + sizeof(struct ipv6hdr), &compExtensionHdr, sizeof(struct compExtensionHdr_t), BPF_F_RECOMPUTE_CSUM)) {
+				#ifdef FLOWD_DEBUG
+					bpf_printk("flowd-go: error storing the extension header");
+				#endif
+
+				return TC_ACT_SHOT;
+			}
+		#endif
+
+		if (signalled)
+			accountMarked(ctx, flowHash);
+
+		return TC_ACT_OK;
+	}
+
+	// We can also fall-through to the function's return statement, but
+	// doing so here seems logically much clearer.
+	return TC_ACT_OK;
+}
+
+// markFlow4 is markFlow's IPv4 counterpart. Only the DSCP strategy can mark IPv4
+// datagrams given the rest rely on IPv6 features (i.e. the flow label).
+static __always_inline int markFlow4(struct __sk_buff *ctx, struct iphdr *l3, struct fourTuple *flowHash) {
+	__u32 *flowTag = bpf_map_lookup_elem(&flowLabels, flowHash);
+
+	// Fall back to the rules just like markFlow does
+	int signalled = flowTag != NULL;
+	if (!signalled)
+		flowTag = matchRules(flowHash);
+	if (!flowTag)
+		return TC_ACT_OK;
+
+	#ifdef FLOWD_DSCP
+		setIPv4DSCP(l3, *flowTag);
+	#endif
+
+	if (signalled)
+		accountMarked(ctx, flowHash);
+
+	return TC_ACT_OK;
+}
@@ -28,8 +28,11 @@
 // Include useful functions we defined ourselves. Note these must be
 // included after the above so that all the necessary types are defined.
 #include "utils.bpf.c"
+#include "mark.bpf.c"
 #include "icmp.bpf.c"
 #include "tcp.bpf.c"
+#include "udp.bpf.c"
+#include "ipv4.bpf.c"
 
 static __always_inline int handleDatagram(struct __sk_buff *ctx, struct ipv6hdr *l3, void *data_end) {
 	// If running in debug mode we'll handle ICMP messages as well
@@ -40,11 +43,15 @@ static __always_inline int handleDatagram(struct __sk_buff *ctx, struct ipv6hdr
 			return handleICMP(ctx, l3);
 	#endif
 
-	// We'll only handle TCP traffic flows
+	// We'll only handle TCP and UDP traffic flows
 	if (l3->nexthdr == PROTO_TCP) {
 		return handleTCP(ctx, l3, data_end);
 	}
 
+	if (l3->nexthdr == PROTO_UDP) {
+		return handleUDP(ctx, l3, data_end);
+	}
+
 	// Simply signal that the packet should proceed!
 	return TC_ACT_OK;
 }
@@ -81,6 +88,11 @@ int marker(struct __sk_buff *ctx) {
 	// defined on vmlinux.h.
 	struct ipv6hdr *l3;
 
+	// The pointer to the header of an IPv4 datagram. Only the DSCP strategy marks
+	// these, the rest rely on IPv6-only features. As usual, struct iphdr is defined
+	// on vmlinux.h.
+	struct iphdr *l3v4;
+
 	// Let's check whether the contents of the Ethernet frame are an IPv6 datagram.
 	// We'll also need to be careful with the network's endianness, hence the call
 	// to bpf_htons. This helper function is defined on libbpf's bpf_endian.h.
@@ -112,6 +124,17 @@ int marker(struct __sk_buff *ctx) {
 		if ((void *)(l2Q + 1) > data_end)
 			return TC_ACT_OK;
 
+		#ifdef FLOWD_DSCP
+			// Is the encapsulated protocol IPv4?
+			if (l2Q->h_vlan_encapsulated_proto == bpf_htons(ETH_P_IP)) {
+				l3v4 = (void *)(l2Q + 1);
+				if ((void *)(l3v4 + 1) > data_end)
+					return TC_ACT_OK;
+
+				return handleDatagram4(ctx, l3v4, data_end);
+			}
+		#endif
+
 		// Is the encapsulated protocol IPv6?
 		if (l2Q->h_vlan_encapsulated_proto != bpf_htons(ETH_P_IPV6))
 			return TC_ACT_OK;
@@ -120,6 +143,22 @@ int marker(struct __sk_buff *ctx) {
 		l3 = (void *)(l2Q + 1);
 		if ((void *)(l3 + 1) > data_end)
 			return TC_ACT_OK;
+	#ifdef FLOWD_DSCP
+	} else if (ctx->protocol == bpf_htons(ETH_P_IP)) {
+		#ifdef FLOWD_DEBUG
+			bpf_printk("flowd-go: got an Ethernet frame carrying IPv4");
+		#endif
+
+		l2 = data;
+		if ((void *)(l2 + 1) > data_end)
+			return TC_ACT_OK;
+
+		l3v4 = (void *)(l2 + 1);
+		if ((void *)(l3v4 + 1) > data_end)
+			return TC_ACT_OK;
+
+		return handleDatagram4(ctx, l3v4, data_end);
+	#endif
 	} else {
 		// If we don't have an Ethernet or 802.1Q frame we'll just let the packet through.
 		return TC_ACT_OK;
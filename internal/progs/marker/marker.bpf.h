@@ -32,14 +32,27 @@
 #define NEXT_HDR_HOP_BY_HOP 0x0
 #define NEXT_HDR_DEST_OPTS 60
 
-// The keys for our hash maps. Note ports are encoded as __u32 because the
-// struct itself is 8-byte aligned given the initial __u64s representing the
-// IPv6 address.
+// The upper half of the lower 64 bits of an IPv4-mapped IPv6 address (i.e. ::ffff:0:0/96).
+// IPv4 flows are looked up with these addresses so that we can share a single map. Check
+// RFC 4291 Section 2.5.5.2: https://www.rfc-editor.org/rfc/rfc4291.html#section-2.5.5.2
+#define IPV4_MAPPED_PREFIX 0x0000FFFF00000000ULL
+
+// The largest value the 6-bit DSCP field can hold. Check RFC 2474 Section 3:
+//   https://www.rfc-editor.org/rfc/rfc2474.html#section-3
+#define DSCP_MAX 0x3F
+
+// The keys for our hash maps. Note the struct itself is 8-byte aligned given
+// the initial __u64s representing the IPv6 address, so we explicitly pad it to
+// 24 bytes: that way there are no holes the compiler could leave garbage in.
+// The protocol is an IANA protocol number (i.e. PROTO_TCP or PROTO_UDP) so that
+// TCP and UDP flows sharing addresses and ports are told apart.
 struct fourTuple {
 	__u64 ip6Hi;
 	__u64 ip6Lo;
-	__u32 dPort;
-	__u32 sPort;
+	__u16 dPort;
+	__u16 sPort;
+	__u8  proto;
+	__u8  pad[3];
 };
 
 // Let's define our map. Note it'll be included in
@@ -51,6 +64,63 @@ struct {
 	__type(value, __u32);
 } flowLabels SEC(".maps");
 
+// What marking each flow amounted to: the packets we marked together with the
+// bytes they carried and the packets we skipped as marking them would have
+// overflowed the MTU. Entries share their keys with flowLabels.
+struct flowStats_t {
+	__u64 packets;
+	__u64 bytes;
+	__u64 mtuSkipped;
+};
+
+// The counters are kept per CPU so that we needn't resort to atomic operations
+// when a flow is being handled on several CPUs at once: flowd-go adds them all
+// up when reading them.
+struct {
+	__uint(type, BPF_MAP_TYPE_LRU_PERCPU_HASH);
+	__uint(max_entries, 100000);
+	__type(key, struct fourTuple);
+	__type(value, struct flowStats_t);
+} flowStats SEC(".maps");
+
+// How many port ranges a destination prefix can have rules for. It must match
+// maxRulePorts on flowd-go's side.
+#define MAX_RULE_PORTS 16
+
+// The keys of the LPM trie holding the marking rules: a prefix length followed by
+// the destination address in network byte order. IPv4 destinations are stored as
+// IPv4-mapped IPv6 addresses just like on flowLabels. Check the kernel docs over at
+// https://docs.kernel.org/bpf/map_lpm_trie.html.
+struct ruleKey {
+	__u32 prefixLen;
+	__u32 addr[4];
+};
+
+// A range of destination ports (inclusive) and the flow tag to mark the datagrams
+// of flows within it with.
+struct rulePorts_t {
+	__u16 first;
+	__u16 last;
+	__u32 flowTag;
+};
+
+// The port ranges to check, in order, for flows whose longest matching destination
+// prefix is the key's. flowd-go merges in the ranges of less specific prefixes too.
+struct ruleSet_t {
+	__u32 nPorts;
+	struct rulePorts_t ports[MAX_RULE_PORTS];
+};
+
+// Rules marking flows no plugin signalled (i.e. absent from flowLabels) by their
+// destination. Note LPM tries must be created with BPF_F_NO_PREALLOC.
+struct {
+	__uint(type, BPF_MAP_TYPE_LPM_TRIE);
+	__uint(max_entries, 1024);
+	__type(key, struct ruleKey);
+	__type(value, struct ruleSet_t);
+	__uint(map_flags, BPF_F_NO_PREALLOC);
+} markRules SEC(".maps");
+
 /*
  * Note how despite having different names, the structure and layout of the
  * Hop-by-Hop and Destination Options Extension Headers are exactly the
@@ -82,5 +152,12 @@ static __always_inline __u64 ipv6AddrHi(struct in6_addr addr);
 static __always_inline void populateFlowLbl(__u8 *flowLbl, __u32 flowTag);
 static __always_inline void populateExtensionHdr(struct extensionHdr_t *extHdr, __u8 nextHdr, __u32 flowTag);
 static __always_inline void populateCompExtensionHdr(struct compExtensionHdr_t *compHdr, __u8 nextHdr, __u32 flowTag);
+static __always_inline __u64 ipv4MappedAddrLo(__be32 addr);
+static __always_inline void setIPv6DSCP(struct ipv6hdr *l3, __u32 dscp);
+static __always_inline void setIPv4DSCP(struct iphdr *l3, __u32 dscp);
+static __always_inline struct flowStats_t *getFlowStats(struct fourTuple *flowHash);
+static __always_inline void accountMarked(struct __sk_buff *ctx, struct fourTuple *flowHash);
+static __always_inline void accountMTUSkipped(struct fourTuple *flowHash);
+static __always_inline __u32 *matchRules(struct fourTuple *flowHash);
 
 #endif
\ No newline at end of file
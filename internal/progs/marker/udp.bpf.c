@@ -0,0 +1,49 @@
+// +build ignore
+
+#include "vmlinux.h"
+#include <bpf/bpf_helpers.h>
+#include <bpf/bpf_endian.h>
+
+#include "marker.bpf.h"
+
+static __always_inline int handleUDP(struct __sk_buff *ctx, struct ipv6hdr *l3, void *data_end) {
+	// The pointer to the header of a UDP datagram. As usual, struct udphdr is
+	// defined on vmlinux.h.
+	struct udphdr *l4;
+
+	// Get a hold of the UDP header!
+	l4 = (void *)(l3 + 1);
+	if ((void *)(l4 + 1) > data_end)
+		return TC_ACT_OK;
+
+	#ifdef FLOWD_DEBUG
+		bpf_printk("flowd-go:      UDP source port: %d", bpf_htons(l4->source));
+		bpf_printk("flowd-go: UDP destination port: %d", bpf_htons(l4->dest));
+	#endif
+
+	// Declare the struct we'll use to index the map
+	struct fourTuple flowHash;
+
+	// Initialise the struct with 0s. This is necessary for some reason to do
+	// with compiler padding. Check that's the case...
+	__builtin_memset(&flowHash, 0, sizeof(flowHash));
+
+	#ifndef FLOWD_MATCH_ALL
+		// Populate the lookup based on the incoming datagram's data
+		flowHash.ip6Hi = ipv6AddrHi(l3->daddr);
+		flowHash.ip6Lo = ipv6AddrLo(l3->daddr);
+		flowHash.dPort = bpf_htons(l4->dest);
+		flowHash.sPort = bpf_htons(l4->source);
+		flowHash.proto = PROTO_UDP;
+	#endif
+
+	#ifdef FLOWD_DEBUG
+		bpf_printk("flowd-go: IPv6                 destination address: %pI6", &l3->daddr);
+		bpf_printk("flowd-go:     IPv6 destination address Hi [127:64]: %x", flowHash.ip6Hi);
+		bpf_printk("flowd-go:     IPv6 destination address Lo   [63:0]: %x", flowHash.ip6Lo);
+		bpf_printk("flowd-go: UDP                     destination port: %d", flowHash.dPort);
+		bpf_printk("flowd-go: UDP                          source port: %d", flowHash.sPort);
+	#endif
+
+	return markFlow(ctx, l3, &flowHash);
+}
@@ -18,6 +18,11 @@ static __always_inline __u64 ipv6AddrHi(struct in6_addr addr) {
 	return hi << 32 | bpf_htonl(addr.in6_u.u6_addr32[1]);
 }
 
+// Extract the lower 64 bits of the IPv4-mapped IPv6 address of an IPv4 address
+static __always_inline __u64 ipv4MappedAddrLo(__be32 addr) {
+	return IPV4_MAPPED_PREFIX | bpf_ntohl(addr);
+}
+
 static __always_inline void populateFlowLbl(__u8 *flowLbl, __u32 flowTag) {
 	flowLbl[0] = (flowTag & ( 0xF << 16)) >> 16;
 	flowLbl[1] = (flowTag & (0xFF <<  8)) >>  8;
@@ -104,3 +109,119 @@ static __always_inline void populateCompExtensionHdr(struct compExtensionHdr_t *
 		bpf_printk("flowd-go: compExtensionHeader  destOptHdr.opts[5]: %x", compHdr->destOptsHdr.opts[5]);
 	#endif
 }
+
+/*
+ * The DSCP is the upper 6 bits of the IPv6 header's Traffic Class, whose lower 2 bits
+ * are the ECN codepoint and must be left alone. Given the Traffic Class straddles the
+ * version and the flow label we'll simply rewrite the header's first 32-bit word which
+ * looks like:
+ *
+ *   | version (4) | DSCP (6) | ECN (2) | flow label (20) |
+ *
+ * Check RFC 8200 Section 3: https://www.rfc-editor.org/rfc/rfc8200.html#section-3. Note
+ * there's no checksum to fix up: the IPv6 header has none and the Traffic Class is not
+ * part of the pseudo header TCP and UDP checksums are computed over.
+ */
+static __always_inline void setIPv6DSCP(struct ipv6hdr *l3, __u32 dscp) {
+	__be32 *hdr = (__be32 *) l3;
+
+	*hdr = (*hdr & bpf_htonl(~(DSCP_MAX << 22))) | bpf_htonl((dscp & DSCP_MAX) << 22);
+
+	#ifdef FLOWD_DEBUG
+		bpf_printk("flowd-go: set the IPv6 DSCP to %d", dscp & DSCP_MAX);
+	#endif
+}
+
+/*
+ * The DSCP is the upper 6 bits of the IPv4 header's TOS byte, whose lower 2 bits are the ECN
+ * codepoint and must be left alone. Check RFC 2474 Section 3. Unlike IPv6, the IPv4 header
+ * carries a checksum we must update. Rather than recomputing it we'll incrementally update it
+ * as specified in RFC 1624 Section 3 (i.e. HC' = ~(~HC + ~m + m')) where m is the 16-bit word
+ * holding the version, the IHL and the TOS. One's complement arithmetic is byte order
+ * independent, so we can work with the header's words as they are.
+ *   https://www.rfc-editor.org/rfc/rfc1624.html#section-3
+ */
+static __always_inline void setIPv4DSCP(struct iphdr *l3, __u32 dscp) {
+	__u16 *word = (__u16 *) l3;
+	__u16 oldWord = *word;
+
+	l3->tos = ((dscp & DSCP_MAX) << 2) | (l3->tos & 0x3);
+
+	__u32 csum = (__u16) ~l3->check;
+	csum += (__u16) ~oldWord;
+	csum += *word;
+	csum = (csum & 0xFFFF) + (csum >> 16);
+	csum = (csum & 0xFFFF) + (csum >> 16);
+	l3->check = (__sum16) ~csum;
+
+	#ifdef FLOWD_DEBUG
+		bpf_printk("flowd-go: set the IPv4 DSCP to %d", dscp & DSCP_MAX);
+	#endif
+}
+
+// Get a hold of the flow's counters for the current CPU, creating them if this is the
+// first packet we handle. BPF_NOEXIST avoids resetting the counters should another CPU
+// create them in between. Note we can still get NULL back if the entry is evicted.
+static __always_inline struct flowStats_t *getFlowStats(struct fourTuple *flowHash) {
+	struct flowStats_t *stats = bpf_map_lookup_elem(&flowStats, flowHash);
+	if (stats)
+		return stats;
+
+	struct flowStats_t zeroStats;
+	__builtin_memset(&zeroStats, 0, sizeof(zeroStats));
+	bpf_map_update_elem(&flowStats, flowHash, &zeroStats, BPF_NOEXIST);
+
+	return bpf_map_lookup_elem(&flowStats, flowHash);
+}
+
+// Account for a marked packet. The length is that of the packet as sent, so any
+// extension headers we inserted are included.
+static __always_inline void accountMarked(struct __sk_buff *ctx, struct fourTuple *flowHash) {
+	struct flowStats_t *stats = getFlowStats(flowHash);
+	if (!stats)
+		return;
+
+	stats->packets++;
+	stats->bytes += ctx->len;
+}
+
+// Account for a packet we couldn't mark without overflowing the MTU.
+static __always_inline void accountMTUSkipped(struct fourTuple *flowHash) {
+	struct flowStats_t *stats = getFlowStats(flowHash);
+	if (!stats)
+		return;
+
+	stats->mtuSkipped++;
+}
+
+// Look the flow up on the marking rules, returning the flow tag of the first rule
+// whose port range contains the flow's destination port, if any. The trie yields
+// the rules of the longest prefix matching the destination address.
+static __always_inline __u32 *matchRules(struct fourTuple *flowHash) {
+	struct ruleKey key;
+	key.prefixLen = 128;
+	key.addr[0] = bpf_htonl(flowHash->ip6Hi >> 32);
+	key.addr[1] = bpf_htonl(flowHash->ip6Hi & 0xFFFFFFFF);
+	key.addr[2] = bpf_htonl(flowHash->ip6Lo >> 32);
+	key.addr[3] = bpf_htonl(flowHash->ip6Lo & 0xFFFFFFFF);
+
+	struct ruleSet_t *rules = bpf_map_lookup_elem(&markRules, &key);
+	if (!rules)
+		return NULL;
+
+	// The loop is bounded so that the verifier can check it terminates
+	for (int i = 0; i < MAX_RULE_PORTS; i++) {
+		if (i >= rules->nPorts)
+			break;
+
+		if (flowHash->dPort >= rules->ports[i].first && flowHash->dPort <= rules->ports[i].last) {
+			#ifdef FLOWD_DEBUG
+				bpf_printk("flowd-go: flow matched rule port range #%d", i);
+			#endif
+
+			return &rules->ports[i].flowTag;
+		}
+	}
+
+	return NULL;
+}
@@ -55,6 +55,16 @@ static __always_inline int handleOp(struct bpf_sock_ops *ctx, bool ignorePollThr
 	fSpec.dPort = bpf_ntohl(ctx->remote_port);
 	fSpec.sPort = ctx->local_port;
 
+	// Context fields can only be read one word at a time, so no memcpy()...
+	fSpec.dAddr[0] = ctx->remote_ip6[0];
+	fSpec.dAddr[1] = ctx->remote_ip6[1];
+	fSpec.dAddr[2] = ctx->remote_ip6[2];
+	fSpec.dAddr[3] = ctx->remote_ip6[3];
+	fSpec.sAddr[0] = ctx->local_ip6[0];
+	fSpec.sAddr[1] = ctx->local_ip6[1];
+	fSpec.sAddr[2] = ctx->local_ip6[2];
+	fSpec.sAddr[3] = ctx->local_ip6[3];
+
 	// Check if a flow with the above criteria has been defined by flowd-go
 	__u32 *dummy = bpf_map_lookup_elem(&flowsToFollow, &fSpec);
 
@@ -127,6 +137,8 @@ static __always_inline int handleOp(struct bpf_sock_ops *ctx, bool ignorePollThr
 
 	tcpi->src_port = (__u16) ctx->local_port;
 	tcpi->dst_port = (__u16) bpf_ntohl(ctx->remote_port);
+	__builtin_memcpy(tcpi->src_addr, fSpec.sAddr, sizeof(fSpec.sAddr));
+	__builtin_memcpy(tcpi->dst_addr, fSpec.dAddr, sizeof(fSpec.dAddr));
 
 	// #ifdef FLOWD_DEBUG
 	// 	print_flowd_tcp_info(tcpi);
@@ -5,12 +5,17 @@
 #include <bpf/bpf_helpers.h>
 
 /*
- * Specification (i.e. {src,dst} port) of a given flow at the transport layer. Note
- * how both ports are encoded as 32-bit quantities in the definition of struct bpf_sock_ops!
+ * Specification (i.e. {src,dst} address and port) of a given flow at the transport layer. Note
+ * how both ports are encoded as 32-bit quantities in the definition of struct bpf_sock_ops! The
+ * addresses are kept in network byte order just like struct bpf_sock_ops' {local,remote}_ip6
+ * members so that they can be copied verbatim. IPv4 flows on dual-stack sockets are identified
+ * by their IPv4-mapped IPv6 addresses.
  */
 struct flowSpec {
 	__u32 dPort;
 	__u32 sPort;
+	__u32 dAddr[4];
+	__u32 sAddr[4];
 };
 
 #ifdef FLOWD_POLL
@@ -197,6 +202,8 @@ struct dctcp {
  struct flowd_tcp_info {
 	__u16 src_port;
 	__u16 dst_port;
+	__u32 src_addr[4]; /* (in network byte order) */
+	__u32 dst_addr[4]; /* (in network byte order) */
 
 	__u32 tcpi_new_state; /* (we use a __u64 for 8-byte alignment) */
 
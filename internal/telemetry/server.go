@@ -0,0 +1,67 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Server serves the self-telemetry metrics on /metrics. Further handlers can
+// be added through Handle before calling Start.
+type Server struct {
+	mux *http.ServeMux
+	srv *http.Server
+	ln  net.Listener
+}
+
+func NewServer(c *Config) *Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry}))
+
+	return &Server{
+		mux: mux,
+		srv: &http.Server{
+			Addr:    net.JoinHostPort(c.BindAddress, fmt.Sprint(c.BindPort)),
+			Handler: mux,
+		},
+	}
+}
+
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// Start binds the server's address and begins serving in the background.
+// Binding is done right away so that errors can be reported to the caller.
+func (s *Server) Start() error {
+	ln, err := net.Listen("tcp", s.srv.Addr)
+	if err != nil {
+		return fmt.Errorf("error binding to %s: %w", s.srv.Addr, err)
+	}
+	s.ln = ln
+
+	go func() {
+		if err := s.srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			slog.Error("telemetry server stopped", "err", err)
+		}
+	}()
+
+	return nil
+}
+
+// Addr returns the address the server is listening on.
+func (s *Server) Addr() net.Addr {
+	return s.ln.Addr()
+}
+
+func (s *Server) Shutdown() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return s.srv.Shutdown(ctx)
+}
@@ -0,0 +1,50 @@
+package telemetry
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestServer(t *testing.T) {
+	s := NewServer(&Config{BindAddress: "127.0.0.1", BindPort: 0})
+	if err := s.Start(); err != nil {
+		t.Fatalf("error starting the server: %v", err)
+	}
+	defer s.Shutdown()
+
+	unregister := RegisterQueue("test", func() float64 { return 3 }, func() float64 { return 7 }, func() float64 { return 1 })
+	ActiveFlows.Set(2)
+
+	scrape := func() string {
+		res, err := http.Get("http://" + s.Addr().String() + "/metrics")
+		if err != nil {
+			t.Fatalf("error scraping the metrics: %v", err)
+		}
+		defer res.Body.Close()
+
+		body, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatalf("error reading the metrics: %v", err)
+		}
+		return string(body)
+	}
+
+	body := scrape()
+	for _, want := range []string{
+		"flowd_go_active_flows 2",
+		`flowd_go_backend_queue_depth{backend="test"} 3`,
+		`flowd_go_backend_queue_drops_total{backend="test"} 7`,
+		`flowd_go_backend_queue_lifecycle_drops_total{backend="test"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("couldn't find %q in the metrics", want)
+		}
+	}
+
+	unregister()
+	if strings.Contains(scrape(), "flowd_go_backend_queue_depth") {
+		t.Errorf("the queue metrics are still around after unregistering them")
+	}
+}
@@ -0,0 +1,155 @@
+// Package telemetry holds the metrics flowd-go exposes about itself, as
+// opposed to the per-flow metrics exported by the prometheus backend. They
+// let operators check whether flowIDs are flowing through the pipeline and
+// whether marking, enriching or sending fireflies is failing. Metrics are
+// registered on a dedicated registry served on its own endpoint.
+package telemetry
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "flowd_go"
+
+// Registry holds every self-telemetry metric.
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+var (
+	PluginFlowIDs = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "plugin_flowids_total",
+		Help:      "FlowIDs received from each plugin.",
+	}, []string{"plugin", "state"})
+
+	BackendFlowIDs = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "backend_flowids_total",
+		Help:      "FlowIDs dispatched to each backend's queue.",
+	}, []string{"backend", "state"})
+
+	DispatchLatency = factory.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "dispatch_duration_seconds",
+		Help:      "Time taken to dispatch a flowID to every backend.",
+		Buckets:   prometheus.ExponentialBuckets(1e-6, 4, 10),
+	})
+
+	ActiveFlows = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_flows",
+		Help:      "Flows which have STARTed but not ENDed yet.",
+	})
+
+	EnricherErrors = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "enricher_errors_total",
+		Help:      "Failures to gather flow information.",
+	}, []string{"enricher"})
+
+	MarkerMapErrors = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "marker_map_errors_total",
+		Help:      "Failures to update or delete entries of the marker's eBPF map.",
+	}, []string{"backend", "op"})
+
+	MarkerPackets = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "marker_marked_packets_total",
+		Help:      "Packets marked by the marker's eBPF program, accounted for as flows END.",
+	}, []string{"backend"})
+
+	MarkerBytes = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "marker_marked_bytes_total",
+		Help:      "Bytes carried by the packets marked by the marker's eBPF program, accounted for as flows END.",
+	}, []string{"backend"})
+
+	MarkerMTUSkipped = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "marker_mtu_skipped_packets_total",
+		Help:      "Packets left unmarked because marking them would have overflowed the MTU, accounted for as flows END.",
+	}, []string{"backend"})
+
+	FireflySendErrors = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "firefly_send_errors_total",
+		Help:      "Failures to send fireflies either to the flow's destination or to the collector.",
+	}, []string{"backend", "target"})
+
+	FireflyEnrichmentFallbacks = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "firefly_enrichment_fallbacks_total",
+		Help:      "Periodic fireflies whose enrichment was degraded to fit within the maximum payload size by the mode eventually used.",
+	}, []string{"backend", "mode"})
+
+	FireflyOversized = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "firefly_oversized_total",
+		Help:      "Fireflies exceeding the maximum payload size even without enrichment.",
+	}, []string{"backend"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{Namespace: namespace}),
+	)
+}
+
+// RegisterQueue exposes the depth and number of drops of a backend's dispatch
+// queue, counting dropped STARTs and ENDs on their own. The returned function
+// unregisters them.
+func RegisterQueue(backend string, depth, drops, lifecycleDrops func() float64) func() {
+	labels := prometheus.Labels{"backend": backend}
+
+	d := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Name:        "backend_queue_depth",
+		Help:        "FlowIDs waiting on each backend's dispatch queue.",
+		ConstLabels: labels,
+	}, depth)
+
+	dr := prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Namespace:   namespace,
+		Name:        "backend_queue_drops_total",
+		Help:        "FlowIDs dropped by each backend's dispatch queue.",
+		ConstLabels: labels,
+	}, drops)
+
+	ldr := prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Namespace:   namespace,
+		Name:        "backend_queue_lifecycle_drops_total",
+		Help:        "START and END flowIDs discarded by each backend's dispatch queue when stopping the backend.",
+		ConstLabels: labels,
+	}, lifecycleDrops)
+
+	Registry.MustRegister(d, dr, ldr)
+
+	return func() {
+		Registry.Unregister(d)
+		Registry.Unregister(dr)
+		Registry.Unregister(ldr)
+	}
+}
+
+// ForgetPlugin removes every series belonging to a plugin instance.
+func ForgetPlugin(name string) {
+	PluginFlowIDs.DeletePartialMatch(prometheus.Labels{"plugin": name})
+}
+
+// ForgetBackend removes every series belonging to a backend instance.
+func ForgetBackend(name string) {
+	labels := prometheus.Labels{"backend": name}
+	BackendFlowIDs.DeletePartialMatch(labels)
+	MarkerMapErrors.DeletePartialMatch(labels)
+	MarkerPackets.DeletePartialMatch(labels)
+	MarkerBytes.DeletePartialMatch(labels)
+	MarkerMTUSkipped.DeletePartialMatch(labels)
+	FireflySendErrors.DeletePartialMatch(labels)
+	FireflyEnrichmentFallbacks.DeletePartialMatch(labels)
+	FireflyOversized.DeletePartialMatch(labels)
+}
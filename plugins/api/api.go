@@ -13,23 +13,26 @@ import (
 type ApiPlugin struct {
 	Config
 
+	name   string
+	logger *slog.Logger
 	server *echo.Echo
 }
 
 func (p *ApiPlugin) String() string {
-	return "api"
+	return "api (" + p.name + ")"
 }
 
-func NewApiPlugin(c *Config) (*ApiPlugin, error) {
-	p := ApiPlugin{Config: *c}
+func NewApiPlugin(name string, c *Config) (*ApiPlugin, error) {
+	p := ApiPlugin{Config: *c, name: name, logger: slog.Default().With(glowdTypes.LogKeyPlugin, name)}
 
-	slog.Debug("initialising the api plugin")
+	p.logger.Debug("initialising the api plugin")
 	p.server = echo.New()
 
 	// Configure the methods for each path
 	p.server.GET("/", handleRoot)
 	p.server.GET("/dummy/start", handleDummyStartFlow)
 	p.server.GET("/dummy/end", handleDummyEndFlow)
+	p.server.GET("/schema", handleSchema)
 	p.server.POST("/flow", handleFlow)
 
 	// Prevent the banner from showing up in the log
@@ -40,7 +43,7 @@ func NewApiPlugin(c *Config) (*ApiPlugin, error) {
 }
 
 func (p *ApiPlugin) Run(done <-chan struct{}, outChan chan<- glowdTypes.FlowID) {
-	slog.Debug("running the api plugin")
+	p.logger.Debug("running the api plugin")
 
 	// Configure the middleware for extending the context of the
 	// different handlers. We defer that to this point so that we
@@ -53,17 +56,17 @@ func (p *ApiPlugin) Run(done <-chan struct{}, outChan chan<- glowdTypes.FlowID)
 
 	go func() {
 		if err := p.server.Start(fmt.Sprintf("%s:%d", p.BindAddress, p.BindPort)); err != http.ErrServerClosed {
-			slog.Error("couldn't start the API server", "err", err)
+			p.logger.Error("couldn't start the API server", "err", err)
 		}
 	}()
 
 	// Simply wait until we're done
 	<-done
-	slog.Debug("cleanly exiting the api plugin")
+	p.logger.Debug("cleanly exiting the api plugin")
 }
 
 func (p *ApiPlugin) Cleanup() error {
-	slog.Debug("cleaning up the api plugin")
+	p.logger.Debug("cleaning up the api plugin")
 	if err := p.server.Shutdown(context.TODO()); err != nil {
 		return fmt.Errorf("error shutting down the API server: %w", err)
 	}
@@ -2,8 +2,15 @@ package api
 
 import (
 	"github.com/goccy/go-yaml"
+	"github.com/scitags/flowd-go/types"
 )
 
+func init() {
+	types.RegisterPlugin("api", func(name string, c any) (types.Plugin, error) {
+		return NewApiPlugin(name, c.(*Config))
+	}, types.YAMLDecoder[Config]())
+}
+
 type Config struct {
 	BindAddress string `yaml:"bindAddress"`
 	BindPort    uint16 `yaml:"bindPort"`
@@ -1,6 +1,8 @@
 package api
 
 import (
+	"errors"
+	"io"
 	"net/http"
 	"net/netip"
 	"time"
@@ -36,7 +38,7 @@ func handleDummyStartFlow(c echo.Context) error {
 	tmp.StartTs = time.Now()
 
 	cc.flowChannel <- tmp
-	return c.JSONPretty(http.StatusOK, &tmp, JSON_PRETTY_INDENT)
+	return c.JSONPretty(http.StatusOK, types.NewFlowEvent(tmp), JSON_PRETTY_INDENT)
 }
 
 func handleDummyEndFlow(c echo.Context) error {
@@ -47,18 +49,33 @@ func handleDummyEndFlow(c echo.Context) error {
 	tmp.EndTs = time.Now()
 
 	cc.flowChannel <- tmp
-	return c.JSONPretty(http.StatusOK, &tmp, JSON_PRETTY_INDENT)
+	return c.JSONPretty(http.StatusOK, types.NewFlowEvent(tmp), JSON_PRETTY_INDENT)
+}
+
+func handleSchema(c echo.Context) error {
+	return c.Blob(http.StatusOK, "application/schema+json", types.FlowEventSchema)
 }
 
 func handleFlow(c echo.Context) error {
 	cc := c.(*extendedContext)
 
-	flowID := types.FlowID{}
-	if err := cc.Bind(&flowID); err != nil {
-		return c.JSONPretty(http.StatusBadRequest, err, JSON_PRETTY_INDENT)
+	body, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return c.JSONPretty(http.StatusBadRequest, &errorResponse{
+			Errors: types.FlowEventErrors{{Reason: "couldn't read the request body: " + err.Error()}},
+		}, JSON_PRETTY_INDENT)
+	}
+
+	flowID, err := types.ParseFlowEvent(body)
+	if err != nil {
+		var errs types.FlowEventErrors
+		if !errors.As(err, &errs) {
+			errs = types.FlowEventErrors{{Reason: err.Error()}}
+		}
+		return c.JSONPretty(http.StatusBadRequest, &errorResponse{Errors: errs}, JSON_PRETTY_INDENT)
 	}
 
 	cc.flowChannel <- flowID
 
-	return c.JSONPretty(http.StatusOK, &flowID, JSON_PRETTY_INDENT)
+	return c.JSONPretty(http.StatusOK, types.NewFlowEvent(flowID), JSON_PRETTY_INDENT)
 }
@@ -13,6 +13,11 @@ type rootResponse struct {
 	ApiRoutes []*echo.Route
 }
 
+// errorResponse lists every problem found with a rejected flow event.
+type errorResponse struct {
+	Errors glowdTypes.FlowEventErrors `json:"errors"`
+}
+
 type extendedContext struct {
 	echo.Context
 	apiRoutes   []*echo.Route
@@ -2,8 +2,15 @@ package fireflyp
 
 import (
 	"github.com/goccy/go-yaml"
+	"github.com/scitags/flowd-go/types"
 )
 
+func init() {
+	types.RegisterPlugin("firefly", func(name string, c any) (types.Plugin, error) {
+		return NewFireflyPlugin(name, c.(*Config))
+	}, types.YAMLDecoder[Config]())
+}
+
 const (
 	minRecvBufferSize uint32 = 2048
 )
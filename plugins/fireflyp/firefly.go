@@ -14,17 +14,19 @@ import (
 type FireflyPlugin struct {
 	Config
 
+	name     string
+	logger   *slog.Logger
 	listener *net.UDPConn
 }
 
 func (p *FireflyPlugin) String() string {
-	return "firefly"
+	return "firefly (" + p.name + ")"
 }
 
-func NewFireflyPlugin(c *Config) (*FireflyPlugin, error) {
-	p := FireflyPlugin{Config: *c}
+func NewFireflyPlugin(name string, c *Config) (*FireflyPlugin, error) {
+	p := FireflyPlugin{Config: *c, name: name, logger: slog.Default().With(glowdTypes.LogKeyPlugin, name)}
 
-	slog.Debug("initialising the firefly plugin")
+	p.logger.Debug("initialising the firefly plugin")
 	if p.BufferSize < minRecvBufferSize {
 		return nil, fmt.Errorf("UDP receive buffer size (%d) is too small, make it at least 2048 bytes", p.BufferSize)
 	}
@@ -48,12 +50,12 @@ func NewFireflyPlugin(c *Config) (*FireflyPlugin, error) {
 }
 
 func (p *FireflyPlugin) Run(done <-chan struct{}, outChan chan<- glowdTypes.FlowID) {
-	slog.Debug("running the firefly plugin")
+	p.logger.Debug("running the firefly plugin")
 
 	for {
 		select {
 		case <-done:
-			slog.Debug("cleanly exiting the firefly plugin")
+			p.logger.Debug("cleanly exiting the firefly plugin")
 			return
 		default:
 			recvBuffer := make([]byte, p.BufferSize)
@@ -66,21 +68,22 @@ func (p *FireflyPlugin) Run(done <-chan struct{}, outChan chan<- glowdTypes.Flow
 			n, addr, err := p.listener.ReadFromUDP(recvBuffer)
 			if err != nil {
 				if errors.Is(err, os.ErrDeadlineExceeded) {
-					slog.Debug("deadline exceeded...")
+					p.logger.Debug("deadline exceeded...")
 					continue
 				}
-				slog.Error("error reading from UDP", "err", err)
+				p.logger.Error("error reading from UDP", "err", err)
 				continue
 			}
-			slog.Debug("read from UDP", "n", n, "from", *addr)
+			p.logger.Debug("read from UDP", "n", n, "from", *addr)
 
 			go func(msg []byte) {
-				slog.Debug("serving an incoming UDP firefly")
+				p.logger.Debug("serving an incoming UDP firefly")
 
 				auxFirefly := glowdTypes.SlimFirefly{}
 				if err := auxFirefly.Parse(msg); err != nil {
-					slog.Error("couldn't parse the incoming firefly", "err", err,
+					p.logger.Error("couldn't parse the incoming firefly", "err", err,
 						"hasSyslogHeader", p.HasSyslogHeader)
+					return
 				}
 
 				outChan <- auxFirefly.FlowID
@@ -91,9 +94,9 @@ func (p *FireflyPlugin) Run(done <-chan struct{}, outChan chan<- glowdTypes.Flow
 }
 
 func (p *FireflyPlugin) Cleanup() error {
-	slog.Debug("cleaning up the firefly plugin")
+	p.logger.Debug("cleaning up the firefly plugin")
 	if err := p.listener.Close(); err != nil {
-		slog.Error("error closing the UDP listener", "err", err)
+		p.logger.Error("error closing the UDP listener", "err", err)
 	}
 	return nil
 }
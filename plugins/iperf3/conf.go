@@ -1,6 +1,18 @@
 package iperf3
 
-import "github.com/goccy/go-yaml"
+import (
+	"errors"
+	"fmt"
+
+	"github.com/goccy/go-yaml"
+	"github.com/scitags/flowd-go/types"
+)
+
+func init() {
+	types.RegisterPlugin("iperf3", func(name string, c any) (types.Plugin, error) {
+		return NewIperf3Plugin(name, c.(*Config))
+	}, types.YAMLDecoder[Config]())
+}
 
 type Config struct {
 	MinSourcePort int `yaml:"minSourcePort"`
@@ -14,9 +26,12 @@ type Config struct {
 
 	DebugMode bool `yaml:"debugMode"`
 
-	RandomIDs     bool  `yaml:"randomIDs"`
-	ActivityIDs   []int `yaml:"activityIDs"`
-	ExperimentIDs []int `yaml:"experimentIDs"`
+	// The experiments and activities to mark flows with, either by ID or by
+	// name (i.e. atlas) as defined in the SciTags registry. They're paired
+	// by index.
+	RandomIDs     bool                  `yaml:"randomIDs"`
+	ActivityIDs   []types.ActivityRef   `yaml:"activityIDs"`
+	ExperimentIDs []types.ExperimentRef `yaml:"experimentIDs"`
 }
 
 func (c *Config) UnmarshalYAML(b []byte) error {
@@ -36,8 +51,8 @@ func (c *Config) UnmarshalYAML(b []byte) error {
 		DebugMode: false,
 
 		RandomIDs:     false,
-		ActivityIDs:   []int{0, 1, 2},
-		ExperimentIDs: []int{0, 1, 2},
+		ActivityIDs:   []types.ActivityRef{{ID: 0}, {ID: 1}, {ID: 2}},
+		ExperimentIDs: []types.ExperimentRef{{ID: 0}, {ID: 1}, {ID: 2}},
 	}
 
 	if err := yaml.Unmarshal(b, def); err != nil {
@@ -48,3 +63,67 @@ func (c *Config) UnmarshalYAML(b []byte) error {
 
 	return nil
 }
+
+// Validate implements types.Validator.
+func (c *Config) Validate() error {
+	errs := []error{}
+
+	if len(c.ExperimentIDs) != len(c.ActivityIDs) {
+		errs = append(errs, fmt.Errorf("experimentIDs and activityIDs have different lengths (%d and %d)",
+			len(c.ExperimentIDs), len(c.ActivityIDs)))
+	}
+
+	if len(c.ExperimentIDs) == 0 || len(c.ActivityIDs) == 0 {
+		errs = append(errs, fmt.Errorf("experimentIDs or activityIDs are empty"))
+	}
+
+	if _, _, err := c.resolve(types.CurrentRegistry()); err != nil {
+		errs = append(errs, err)
+	}
+
+	for _, r := range []struct {
+		name     string
+		min, max int
+	}{
+		{"source", c.MinSourcePort, c.MaxSourcePort},
+		{"destination", c.MinDestinationPort, c.MaxDestinationPort},
+	} {
+		if r.min < 0 || r.min > 65535 || r.max < 0 || r.max > 65535 {
+			errs = append(errs, fmt.Errorf("the %s port range [%d, %d] is out of bounds", r.name, r.min, r.max))
+			continue
+		}
+
+		// Bounds set to 0 are disabled
+		if r.min != 0 && r.max != 0 && r.min > r.max {
+			errs = append(errs, fmt.Errorf("the minimum %s port %d is larger than the maximum %d", r.name, r.min, r.max))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// resolve returns the IDs of the configured experiments and activities. Pairs
+// beyond the length of the shortest list are ignored.
+func (c *Config) resolve(r *types.Registry) ([]uint32, []uint32, error) {
+	n := min(len(c.ExperimentIDs), len(c.ActivityIDs))
+	exps, acts := make([]uint32, n), make([]uint32, n)
+
+	errs := []error{}
+	for i := range n {
+		exp, err := c.ExperimentIDs[i].Resolve(r)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("wrong experiment at index %d: %w", i, err))
+			continue
+		}
+
+		act, err := c.ActivityIDs[i].Resolve(r, exp)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("wrong activity at index %d: %w", i, err))
+			continue
+		}
+
+		exps[i], acts[i] = exp, act
+	}
+
+	return exps, acts, errors.Join(errs...)
+}
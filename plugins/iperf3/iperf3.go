@@ -36,27 +36,34 @@ type flowSpec struct {
 type Iperf3Plugin struct {
 	Config
 
+	name   string
+	logger *slog.Logger
 	coll   *ebpf.Collection
 	link   link.Link
 	reader *ringbuf.Reader
+
+	// The resolved IDs of the configured experiments and activities.
+	experiments []uint32
+	activities  []uint32
 }
 
 func (p *Iperf3Plugin) String() string {
-	return "iperf3"
+	return "iperf3 (" + p.name + ")"
 }
 
-func NewIperf3Plugin(c *Config) (*Iperf3Plugin, error) {
-	p := Iperf3Plugin{Config: *c}
+func NewIperf3Plugin(name string, c *Config) (*Iperf3Plugin, error) {
+	p := Iperf3Plugin{Config: *c, name: name, logger: slog.Default().With(types.LogKeyPlugin, name)}
 
-	if len(c.ExperimentIDs) != len(c.ActivityIDs) {
-		return nil, fmt.Errorf("experimentIDs and activityIDs have different lengths")
+	if err := c.Validate(); err != nil {
+		return nil, err
 	}
 
-	if len(c.ExperimentIDs) == 0 || len(c.ActivityIDs) == 0 {
-		return nil, fmt.Errorf("experimentIDs or activityIDs are empty")
+	var err error
+	p.experiments, p.activities, err = c.resolve(types.CurrentRegistry())
+	if err != nil {
+		return nil, err
 	}
 
-	var err error
 	cgroupPath := ""
 	if c.CgroupPath != "" {
 		cgroupPath = c.CgroupPath
@@ -70,7 +77,7 @@ func NewIperf3Plugin(c *Config) (*Iperf3Plugin, error) {
 
 	var prog []byte
 	if c.ProgramPath != "" {
-		slog.Debug("loading the provided eBPF program", "path", c.ProgramPath)
+		p.logger.Debug("loading the provided eBPF program", "path", c.ProgramPath)
 		prog, err = os.ReadFile(c.ProgramPath)
 		if err != nil {
 			return nil, fmt.Errorf("error reading user provided program: %w", err)
@@ -99,7 +106,7 @@ func NewIperf3Plugin(c *Config) (*Iperf3Plugin, error) {
 	}
 	p.reader = rd
 
-	slog.Debug("attaching program", "cgroup", cgroupPath)
+	p.logger.Debug("attaching program", "cgroup", cgroupPath)
 	link, err := link.AttachCgroup(link.CgroupOptions{
 		Path:    cgroupPath,
 		Program: p.coll.Programs[PROG_NAME],
@@ -113,9 +120,9 @@ func NewIperf3Plugin(c *Config) (*Iperf3Plugin, error) {
 
 	info, err := link.Info()
 	if err != nil {
-		slog.Warn("error getting link info", "err", err)
+		p.logger.Warn("error getting link info", "err", err)
 	} else {
-		slog.Debug("link", "info", info, "iinfo", info.ID)
+		p.logger.Debug("link", "info", info, "iinfo", info.ID)
 	}
 
 	return &p, nil
@@ -123,7 +130,7 @@ func NewIperf3Plugin(c *Config) (*Iperf3Plugin, error) {
 
 func (p *Iperf3Plugin) closeBuffer(done <-chan struct{}) {
 	<-done
-	slog.Debug("closing the ring buffer")
+	p.logger.Debug("closing the ring buffer")
 	p.reader.Close()
 }
 
@@ -147,7 +154,7 @@ func parseIP(f types.Family, r []byte) (netip.Addr, bool) {
 }
 
 func (p *Iperf3Plugin) Run(done <-chan struct{}, outChan chan<- types.FlowID) {
-	slog.Debug("running the iperf3 plugin")
+	p.logger.Debug("running the iperf3 plugin")
 
 	var rec ringbuf.Record
 
@@ -156,7 +163,7 @@ func (p *Iperf3Plugin) Run(done <-chan struct{}, outChan chan<- types.FlowID) {
 
 	idIndex := -1
 	if p.RandomIDs {
-		idIndex = rand.IntN(len(p.ExperimentIDs))
+		idIndex = rand.IntN(len(p.experiments))
 	}
 
 	for {
@@ -168,12 +175,12 @@ func (p *Iperf3Plugin) Run(done <-chan struct{}, outChan chan<- types.FlowID) {
 				log.Println("received signal, exiting..")
 				return
 			}
-			slog.Error("error reading data from the ring buffer", "err", err)
+			p.logger.Error("error reading data from the ring buffer", "err", err)
 			continue
 		}
 
 		if len(rec.RawSample) != 64 {
-			slog.Warn("received data length is not correct", "len", len(rec.RawSample))
+			p.logger.Warn("received data length is not correct", "len", len(rec.RawSample))
 			continue
 		}
 
@@ -182,28 +189,28 @@ func (p *Iperf3Plugin) Run(done <-chan struct{}, outChan chan<- types.FlowID) {
 		case types.TCP_ESTABLISHED:
 			s = types.START
 			if p.RandomIDs {
-				idIndex = rand.IntN(len(p.ExperimentIDs))
+				idIndex = rand.IntN(len(p.experiments))
 			} else {
-				idIndex = (idIndex + 1) % len(p.ExperimentIDs)
+				idIndex = (idIndex + 1) % len(p.experiments)
 			}
 		case types.TCP_CLOSE:
 			s = types.END
 		default:
-			slog.Warn("unexpected state", "s", rec.RawSample[56])
+			p.logger.Warn("unexpected state", "s", rec.RawSample[56])
 			continue
 		}
 
-		slog.Debug("idIndex", "index", idIndex, "len", len(p.ExperimentIDs), "mod", idIndex%len(p.ExperimentIDs))
+		p.logger.Debug("idIndex", "index", idIndex, "len", len(p.experiments), "mod", idIndex%len(p.experiments))
 
 		family := types.Family(rec.RawSample[0])
 		srcIP, ok := parseIP(family, rec.RawSample[8:24])
 		if !ok {
-			slog.Warn("couldn't parse the source address")
+			p.logger.Warn("couldn't parse the source address")
 			continue
 		}
 		dstIP, ok := parseIP(family, rec.RawSample[32:48])
 		if !ok {
-			slog.Warn("couldn't parse the destination address")
+			p.logger.Warn("couldn't parse the destination address")
 			continue
 		}
 
@@ -212,18 +219,18 @@ func (p *Iperf3Plugin) Run(done <-chan struct{}, outChan chan<- types.FlowID) {
 			Family:      family,
 			Src:         netip.AddrPortFrom(srcIP, parsePort(rec.RawSample[24:32])),
 			Dst:         netip.AddrPortFrom(dstIP, parsePort(rec.RawSample[48:56])),
-			Experiment:  uint32(p.ExperimentIDs[idIndex]),
-			Activity:    uint32(p.ActivityIDs[idIndex]),
+			Experiment:  p.experiments[idIndex],
+			Activity:    p.activities[idIndex],
 			Application: types.SYSLOG_APP_NAME,
 		}
-		slog.Debug("crafted flowID", "flowID", f)
+		p.logger.Debug("crafted flowID", types.LogKeyFlow, f)
 
 		outChan <- f
 	}
 }
 
 func (p *Iperf3Plugin) Cleanup() error {
-	slog.Debug("cleaning up the iperf3 plugin")
+	p.logger.Debug("cleaning up the iperf3 plugin")
 	// Note the ringbuff reader is closed by CloseBuffer
 	err := p.link.Close()
 	p.coll.Close()
@@ -31,15 +31,15 @@ func init() {
 }
 
 func TestIntegration(t *testing.T) {
-	p, err := NewIperf3Plugin(&Config{
+	p, err := NewIperf3Plugin("iperf3", &Config{
 		MinSourcePort: 2340,
 		MaxSourcePort: 2350,
 
 		MinDestinationPort: 5200,
 		MaxDestinationPort: 5210,
 
-		ActivityIDs:   []int{0, 1, 2},
-		ExperimentIDs: []int{0, 1, 2},
+		ActivityIDs:   []types.ActivityRef{{ID: 0}, {ID: 1}, {ID: 2}},
+		ExperimentIDs: []types.ExperimentRef{{ID: 0}, {ID: 1}, {ID: 2}},
 
 		DebugMode: true,
 	})
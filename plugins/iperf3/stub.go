@@ -10,14 +10,16 @@ import (
 
 type Iperf3Plugin struct {
 	Config
+
+	name string
 }
 
-func NewIperf3Plugin(c *Config) (*Iperf3Plugin, error) {
+func NewIperf3Plugin(name string, c *Config) (*Iperf3Plugin, error) {
 	return nil, nil
 }
 
 func (p *Iperf3Plugin) String() string {
-	return "iperf3"
+	return "iperf3 (" + p.name + ")"
 }
 
 func (p *Iperf3Plugin) Init() error {
@@ -1,6 +1,18 @@
 package np
 
-import "github.com/goccy/go-yaml"
+import (
+	"errors"
+	"fmt"
+
+	"github.com/goccy/go-yaml"
+	"github.com/scitags/flowd-go/types"
+)
+
+func init() {
+	types.RegisterPlugin("namedPipe", func(name string, c any) (types.Plugin, error) {
+		return NewNamedPipePlugin(name, c.(*Config))
+	}, types.YAMLDecoder[Config]())
+}
 
 type Config struct {
 	MaxReaders int    `yaml:"maxReaders"`
@@ -26,3 +38,22 @@ func (c *Config) UnmarshalYAML(b []byte) error {
 
 	return nil
 }
+
+// Validate implements types.Validator.
+func (c *Config) Validate() error {
+	errs := []error{}
+
+	if c.MaxReaders <= 0 {
+		errs = append(errs, fmt.Errorf("the maximum number of readers must be positive, got %d", c.MaxReaders))
+	}
+
+	if c.BuffSize <= 0 {
+		errs = append(errs, fmt.Errorf("the buffer size must be positive, got %d", c.BuffSize))
+	}
+
+	if c.PipePath == "" {
+		errs = append(errs, fmt.Errorf("the pipe path can't be empty"))
+	}
+
+	return errors.Join(errs...)
+}
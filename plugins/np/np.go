@@ -17,19 +17,22 @@ import (
 
 type NamedPipePlugin struct {
 	Config
+
+	name   string
+	logger *slog.Logger
 }
 
 func (p *NamedPipePlugin) String() string {
-	return "named pipe"
+	return "named pipe (" + p.name + ")"
 }
 
-func NewNamedPipePlugin(c *Config) (*NamedPipePlugin, error) {
-	p := NamedPipePlugin{Config: *c}
+func NewNamedPipePlugin(name string, c *Config) (*NamedPipePlugin, error) {
+	p := NamedPipePlugin{Config: *c, name: name, logger: slog.Default().With(types.LogKeyPlugin, name)}
 
-	slog.Debug("initialising the named pipe plugin")
+	p.logger.Debug("initialising the named pipe plugin")
 
 	if _, err := os.Stat(p.PipePath); !errors.Is(err, os.ErrNotExist) {
-		slog.Debug("it looks like the named pipe exists!")
+		p.logger.Debug("it looks like the named pipe exists!")
 		return &p, nil
 	}
 
@@ -44,7 +47,7 @@ func NewNamedPipePlugin(c *Config) (*NamedPipePlugin, error) {
 }
 
 func (p *NamedPipePlugin) Run(done <-chan struct{}, outChan chan<- types.FlowID) {
-	slog.Debug("running the named pipe plugin")
+	p.logger.Debug("running the named pipe plugin")
 
 	// If we open the FIFO (i.e. named pipe) only for reading, the call will block
 	// until there's at least a writer. This basically means we need to write once
@@ -58,7 +61,7 @@ func (p *NamedPipePlugin) Run(done <-chan struct{}, outChan chan<- types.FlowID)
 	// on what O_NONBLOCK implies in terms of behaviour when opening files.
 	pipe, err := os.OpenFile(p.PipePath, os.O_RDWR, os.ModeNamedPipe)
 	if err != nil {
-		slog.Error("couldn't open the named pipe", "err", err)
+		p.logger.Error("couldn't open the named pipe", "err", err)
 		close(outChan)
 	}
 	defer close(outChan)
@@ -80,27 +83,27 @@ func (p *NamedPipePlugin) Run(done <-chan struct{}, outChan chan<- types.FlowID)
 			case notify.Write:
 				n, err := pipe.Read(buff)
 				if err != nil {
-					slog.Warn("error reading pipe", "err", err)
+					p.logger.Warn("error reading pipe", "err", err)
 				}
-				slog.Debug("read pipe", "n", n, "buff", buff[:n])
+				p.logger.Debug("read pipe", "n", n, "buff", buff[:n])
 				parsedEvents := parseEvents(string(buff[:n]))
 				for i, parsedEvent := range parsedEvents {
-					slog.Debug("pushing event onto channel", "i", i)
+					p.logger.Debug("pushing event onto channel", "i", i)
 					outChan <- parsedEvent
 				}
 			case notify.Remove:
-				slog.Error("the named pipe was removed from under us!")
+				p.logger.Error("the named pipe was removed from under us!")
 				return
 			}
 		case <-done:
-			slog.Debug("cleanly exiting the np plugin")
+			p.logger.Debug("cleanly exiting the np plugin")
 			return
 		}
 	}
 }
 
 func (p *NamedPipePlugin) Cleanup() error {
-	slog.Debug("cleaning up the named pipe plugin")
+	p.logger.Debug("cleaning up the named pipe plugin")
 	if err := os.Remove(p.PipePath); err != nil {
 		return fmt.Errorf("error removing named pipe: %w", err)
 	}
@@ -113,6 +116,17 @@ func parseEvents(rawEvents string) []types.FlowID {
 
 	// Drop the last entry as it'll always be empty...
 	for _, rawEvent := range rawEventsSlice[:len(rawEventsSlice)-1] {
+		// The extended format is just a flow event on a single line
+		if strings.HasPrefix(strings.TrimSpace(rawEvent), "{") {
+			flowID, err := types.ParseFlowEvent([]byte(rawEvent))
+			if err != nil {
+				slog.Warn("wrong flow event", "rawEvent", rawEvent, "err", err)
+				continue
+			}
+			flowIDs = append(flowIDs, flowID)
+			continue
+		}
+
 		fields := strings.Fields(rawEvent)
 		if len(fields) != 8 {
 			slog.Warn("wrong number of fields", "rawEvent", rawEvent)
@@ -155,15 +169,17 @@ func parseEvents(rawEvents string) []types.FlowID {
 			continue
 		}
 
-		experimentId, err := strconv.ParseUint(fields[6], 10, 32)
+		// Experiments and activities can also be given by name
+		registry := types.CurrentRegistry()
+		experimentId, err := registry.ParseExperiment(fields[6])
 		if err != nil {
-			slog.Warn("wrong experiment ID", "experimentId", fields[6])
+			slog.Warn("wrong experiment ID", "experimentId", fields[6], "err", err)
 			continue
 		}
 
-		activityId, err := strconv.ParseUint(fields[7], 10, 32)
+		activityId, err := registry.ParseActivity(experimentId, fields[7])
 		if err != nil {
-			slog.Warn("wrong activity ID", "activityId", fields[7])
+			slog.Warn("wrong activity ID", "activityId", fields[7], "err", err)
 			continue
 		}
 
@@ -182,8 +198,8 @@ func parseEvents(rawEvents string) []types.FlowID {
 			}(),
 			Src:         netip.AddrPortFrom(srcIP, uint16(srcPort)),
 			Dst:         netip.AddrPortFrom(dstIP, uint16(dstPort)),
-			Experiment:  uint32(experimentId),
-			Activity:    uint32(activityId),
+			Experiment:  experimentId,
+			Activity:    activityId,
 			Application: types.SYSLOG_APP_NAME,
 		}
 
@@ -33,6 +33,7 @@ func init() {
 func TestStartFlow(t *testing.T) {
 	pipePath := "./np"
 	np, err := NewNamedPipePlugin(
+		"namedPipe",
 		&Config{
 			MaxReaders: 5,
 			BuffSize:   1000,
@@ -73,3 +74,18 @@ func TestStartFlow(t *testing.T) {
 		t.Logf("got a flowID: %v", flowID)
 	}
 }
+
+func TestParseEventsExtended(t *testing.T) {
+	rawEvents := "start tcp 192.168.0.1 2345 127.0.0.1 5777 1 2\n" +
+		`{"version": 1, "state": "end", "protocol": "udp", "src-ip": "::1", "src-port": 2345, "dst-ip": "::1", "dst-port": 443, "experiment": 1, "activity": 2}` + "\n" +
+		`{"version": 1, "state": "end", "protocol": "udp", "src-ip": "::1", "src-port": 2345}` + "\n"
+
+	flowIDs := parseEvents(rawEvents)
+	if len(flowIDs) != 2 {
+		t.Fatalf("got %d flowIDs, want 2: %v", len(flowIDs), flowIDs)
+	}
+
+	if got := flowIDs[1]; got.State != types.END || got.Protocol != types.UDP || got.Family != types.IPv6 || got.Dst.Port() != 443 {
+		t.Errorf("wrong flowID parsed from the extended format: %v", got)
+	}
+}
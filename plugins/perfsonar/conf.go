@@ -1,10 +1,21 @@
 package perfsonar
 
-import "github.com/goccy/go-yaml"
+import (
+	"github.com/goccy/go-yaml"
+	"github.com/scitags/flowd-go/types"
+)
+
+func init() {
+	types.RegisterPlugin("perfsonar", func(name string, c any) (types.Plugin, error) {
+		return NewPerfsonarPlugin(name, c.(*Config))
+	}, types.YAMLDecoder[Config]())
+}
 
 type Config struct {
-	ExperimentId int `yaml:"experimentId"`
-	ActivityId   int `yaml:"activityId"`
+	// The experiment and activity to mark packets with, either by ID or
+	// by name (i.e. atlas) as defined in the SciTags registry.
+	ExperimentId types.ExperimentRef `yaml:"experimentId"`
+	ActivityId   types.ActivityRef   `yaml:"activityId"`
 }
 
 func (c *Config) UnmarshalYAML(b []byte) error {
@@ -12,8 +23,8 @@ func (c *Config) UnmarshalYAML(b []byte) error {
 	type config Config
 
 	def := &config{
-		ExperimentId: 0,
-		ActivityId:   0,
+		ExperimentId: types.ExperimentRef{ID: 0},
+		ActivityId:   types.ActivityRef{ID: 0},
 	}
 
 	if err := yaml.Unmarshal(b, def); err != nil {
@@ -24,3 +35,24 @@ func (c *Config) UnmarshalYAML(b []byte) error {
 
 	return nil
 }
+
+// Validate implements types.Validator.
+func (c *Config) Validate() error {
+	_, _, err := c.resolve(types.CurrentRegistry())
+	return err
+}
+
+// resolve returns the IDs of the configured experiment and activity.
+func (c *Config) resolve(r *types.Registry) (uint32, uint32, error) {
+	exp, err := c.ExperimentId.Resolve(r)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	act, err := c.ActivityId.Resolve(r, exp)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return exp, act, nil
+}
@@ -9,19 +9,32 @@ import (
 
 type PerfsonarPlugin struct {
 	Config
+
+	name   string
+	logger *slog.Logger
+
+	// The resolved IDs of the configured experiment and activity.
+	experiment uint32
+	activity   uint32
 }
 
 func (p *PerfsonarPlugin) String() string {
-	return "perfSONAR"
+	return "perfSONAR (" + p.name + ")"
 }
 
-func NewPerfsonarPlugin(c *Config) (*PerfsonarPlugin, error) {
-	p := PerfsonarPlugin{Config: *c}
+func NewPerfsonarPlugin(name string, c *Config) (*PerfsonarPlugin, error) {
+	exp, act, err := c.resolve(types.CurrentRegistry())
+	if err != nil {
+		return nil, err
+	}
+
+	p := PerfsonarPlugin{Config: *c, name: name, logger: slog.Default().With(types.LogKeyPlugin, name),
+		experiment: exp, activity: act}
 	return &p, nil
 }
 
 func (p *PerfsonarPlugin) Run(done <-chan struct{}, outChan chan<- types.FlowID) {
-	slog.Debug("running the perfSONAR plugin")
+	p.logger.Debug("running the perfSONAR plugin")
 
 	/*
 	 * We just need to trigger marking once, so we'll do it with dummy addresses.
@@ -29,24 +42,24 @@ func (p *PerfsonarPlugin) Run(done <-chan struct{}, outChan chan<- types.FlowID)
 	 * every IPv6 datagram will be marked. That is, source and destination port
 	 * 0 disables checks within the eBPF program.
 	 */
-	slog.Debug("kicking off packet marking")
+	p.logger.Debug("kicking off packet marking")
 	outChan <- types.FlowID{
 		State:       types.START,
 		Family:      types.IPv6,
 		Src:         netip.AddrPortFrom(netip.IPv6Unspecified(), 0),
 		Dst:         netip.AddrPortFrom(netip.IPv6Unspecified(), 0),
-		Experiment:  uint32(p.ExperimentId),
-		Activity:    uint32(p.ActivityId),
+		Experiment:  p.experiment,
+		Activity:    p.activity,
 		Application: types.SYSLOG_APP_NAME,
 	}
 
 	// Simply block until the done channel is closed so that we can exit
 	<-done
 
-	slog.Debug("cleanly exiting the perfSONAR plugin")
+	p.logger.Debug("cleanly exiting the perfSONAR plugin")
 }
 
 func (p *PerfsonarPlugin) Cleanup() error {
-	slog.Debug("cleaning up the perfSONAR plugin")
+	p.logger.Debug("cleaning up the perfSONAR plugin")
 	return nil
 }
@@ -5,6 +5,7 @@ import (
 	"fmt"
 
 	"github.com/fatih/structs"
+	"github.com/fxamacker/cbor/v2"
 )
 
 //go:generate go tool golang.org/x/tools/cmd/stringer -type=Flavour
@@ -23,6 +24,13 @@ var validTags = map[string]struct{}{
 	"compatible": {},
 }
 
+// IsValidMode returns whether a FlowInfo can be marshaled with the given mode.
+// An empty mode selects the default (i.e. full) output.
+func IsValidMode(mode string) bool {
+	_, ok := validTags[mode]
+	return ok || mode == ""
+}
+
 // Flavour encodes the enrichment source (i.e. eBPF or netlink).
 type Flavour uint8
 
@@ -38,16 +46,16 @@ const (
 // particular flow. The addition of several struct tags allows for a precise
 // control over what fields are marshalled.
 type FlowInfo struct {
-	Mode      string      `structs:"-" lean:"-"`
-	TCPInfo   *TCPInfo    `structs:"tcpInfo" lean:"tcpInfo"`
-	Cong      *Cong       `structs:"cong,omitempty" lean:"cong,omitempty"`
-	Socket    *Socket     `structs:"skBuff,omitempty" lean:"-"`
-	BBRInfo   *TCPBBRInfo `structs:"bbr,omitempty" lean:"-"`
-	TOS       *TOS        `structs:"tos,omitempty" lean:"-"`
-	MemInfo   *MemInfo    `structs:"memInfo,omitempty" lean:"-"`
-	SkMemInfo *SkMemInfo  `structs:"skMemInfo,omitempty" lean:"-"`
-	VegasInfo *VegasInfo  `structs:"vegasInfo,omitempty" lean:"-"`
-	DCTCPInfo *DCTCPInfo  `structs:"dctcpInfo,omitempty" lean:"-"`
+	Mode      string      `structs:"-" cbor:"-" lean:"-"`
+	TCPInfo   *TCPInfo    `structs:"tcpInfo" cbor:"tcpInfo" lean:"tcpInfo"`
+	Cong      *Cong       `structs:"cong,omitempty" cbor:"cong,omitempty" lean:"cong,omitempty"`
+	Socket    *Socket     `structs:"skBuff,omitempty" cbor:"skBuff,omitempty" lean:"-"`
+	BBRInfo   *TCPBBRInfo `structs:"bbr,omitempty" cbor:"bbr,omitempty" lean:"-"`
+	TOS       *TOS        `structs:"tos,omitempty" cbor:"tos,omitempty" lean:"-"`
+	MemInfo   *MemInfo    `structs:"memInfo,omitempty" cbor:"memInfo,omitempty" lean:"-"`
+	SkMemInfo *SkMemInfo  `structs:"skMemInfo,omitempty" cbor:"skMemInfo,omitempty" lean:"-"`
+	VegasInfo *VegasInfo  `structs:"vegasInfo,omitempty" cbor:"vegasInfo,omitempty" lean:"-"`
+	DCTCPInfo *DCTCPInfo  `structs:"dctcpInfo,omitempty" cbor:"dctcpInfo,omitempty" lean:"-"`
 }
 
 // MarshalJSON implements the json.Marshaler interface. We'll simply leverage
@@ -61,20 +69,85 @@ type FlowInfo struct {
 // validTags map contains embedded comments explaining what the purpose of each
 // tag is.
 func (e *FlowInfo) MarshalJSON() ([]byte, error) {
+	return json.Marshal(e.encodable())
+}
+
+// MarshalCBOR implements the cbor.Marshaler interface so that CBOR-encoded
+// fireflies honour the very same modes (and keys) as JSON-encoded ones. Unlike
+// MarshalJSON it encodes typed structs as given by their cbor struct tags, so
+// no maps are built through reflection on every sample.
+func (e *FlowInfo) MarshalCBOR() ([]byte, error) {
+	switch e.Mode {
+	case "compatible":
+		return cbor.Marshal(NewCompatibilityEnrichment(e))
+	case "lean":
+		return cbor.Marshal(newLeanFlowInfo(e))
+	}
+
+	// Needed to break recursive calls into MarshalCBOR
+	type flowInfo FlowInfo
+
+	return cbor.Marshal((*flowInfo)(e))
+}
+
+// leanFlowInfo is what a FlowInfo is CBOR-encoded as in lean mode: the fields
+// with a lean tag alone, keyed just like the JSON encoding keys them. Note how
+// fields lacking a lean tag (i.e. Retransmits) are keyed by their name.
+type leanFlowInfo struct {
+	TCPInfo *leanTCPInfo `cbor:"tcpInfo"`
+	Cong    *Cong        `cbor:"cong,omitempty"`
+}
+
+type leanTCPInfo struct {
+	Retransmits   uint8  `cbor:"Retransmits"`
+	Snd_mss       uint32 `cbor:"sndMss"`
+	Pmtu          uint32 `cbor:"pMtu"`
+	Rtt           uint32 `cbor:"rtt"`
+	Rttvar        uint32 `cbor:"rttVar"`
+	Snd_ssthresh  uint32 `cbor:"sndSsThresh"`
+	Snd_cwnd      uint32 `cbor:"sndCwnd"`
+	Advmss        uint32 `cbor:"advMss"`
+	Min_rtt       uint32 `cbor:"minRtt"`
+	Delivery_rate uint64 `cbor:"deliveryRate"`
+	Bytes_sent    uint64 `cbor:"bytesSent"`
+}
+
+func newLeanFlowInfo(e *FlowInfo) leanFlowInfo {
+	lean := leanFlowInfo{Cong: e.Cong}
+	if i := e.TCPInfo; i != nil {
+		lean.TCPInfo = &leanTCPInfo{
+			Retransmits:   i.Retransmits,
+			Snd_mss:       i.Snd_mss,
+			Pmtu:          i.Pmtu,
+			Rtt:           i.Rtt,
+			Rttvar:        i.Rttvar,
+			Snd_ssthresh:  i.Snd_ssthresh,
+			Snd_cwnd:      i.Snd_cwnd,
+			Advmss:        i.Advmss,
+			Min_rtt:       i.Min_rtt,
+			Delivery_rate: i.Delivery_rate,
+			Bytes_sent:    i.Bytes_sent,
+		}
+	}
+	return lean
+}
+
+// encodable returns what the FlowInfo should be JSON-encoded as given its mode.
+func (e *FlowInfo) encodable() any {
 	s := structs.New(e)
 
 	if e.Mode != "" {
 		_, ok := validTags[e.Mode]
 		if ok {
 			if e.Mode == "compatible" {
-				return json.Marshal(NewCompatibilityEnrichment(e))
+				return NewCompatibilityEnrichment(e)
 			} else {
 				s.TagName = e.Mode
 			}
 		}
 	}
 
-	return json.Marshal(s.Map())
+	return s.Map()
 }
 
 // SockID mirrors diag.SockID so as to add struct tags for marshalling.
@@ -82,36 +155,36 @@ func (e *FlowInfo) MarshalJSON() ([]byte, error) {
 // along some other identifiable information.
 type SockID struct {
 	// Source port in network byte order (i.e. use Ntohs())
-	SPort uint16 `structs:"srcPort" lean:"-"`
+	SPort uint16 `structs:"srcPort" cbor:"srcPort" lean:"-"`
 
 	// Destination port in network byte order (i.e. use Ntohs())
-	DPort uint16 `structs:"dstPort" lean:"-"`
+	DPort uint16 `structs:"dstPort" cbor:"dstPort" lean:"-"`
 
 	// Source IPv{4,6} address
-	Src [4]uint32 `structs:"srcIP" lean:"-"`
+	Src [4]uint32 `structs:"srcIP" cbor:"srcIP" lean:"-"`
 
 	// Destination IPv{4,6} address
-	Dst [4]uint32 `structs:"dstIP" lean:"-"`
+	Dst [4]uint32 `structs:"dstIP" cbor:"dstIP" lean:"-"`
 
 	// Interface identifier
-	If uint32 `structs:"ifId" lean:"-"`
+	If uint32 `structs:"ifId" cbor:"ifId" lean:"-"`
 
 	// An array of opaque identifiers. See sock_diag(7)
-	Cookie [2]uint32 `structs:"cookie" lean:"-"`
+	Cookie [2]uint32 `structs:"cookie" cbor:"cookie" lean:"-"`
 }
 
 // Socket mirrors diag.Socket so as to add struct tags for marshalling.
 type Socket struct {
-	Family  uint8  `structs:"family" lean:"-"`
-	State   uint8  `structs:"state" lean:"-"`
-	Timer   uint8  `structs:"timer" lean:"-"`
-	Retrans uint8  `structs:"retrans" lean:"-"`
-	ID      SockID `structs:"id" lean:"-"`
-	Expires uint32 `structs:"expires" lean:"-"`
-	RQueue  uint32 `structs:"rQueue" lean:"-"`
-	WQueue  uint32 `structs:"wQueue" lean:"-"`
-	UID     uint32 `structs:"uid" lean:"-"`
-	INode   uint32 `structs:"iNode" lean:"-"`
+	Family  uint8  `structs:"family" cbor:"family" lean:"-"`
+	State   uint8  `structs:"state" cbor:"state" lean:"-"`
+	Timer   uint8  `structs:"timer" cbor:"timer" lean:"-"`
+	Retrans uint8  `structs:"retrans" cbor:"retrans" lean:"-"`
+	ID      SockID `structs:"id" cbor:"id" lean:"-"`
+	Expires uint32 `structs:"expires" cbor:"expires" lean:"-"`
+	RQueue  uint32 `structs:"rQueue" cbor:"rQueue" lean:"-"`
+	WQueue  uint32 `structs:"wQueue" cbor:"wQueue" lean:"-"`
+	UID     uint32 `structs:"uid" cbor:"uid" lean:"-"`
+	INode   uint32 `structs:"iNode" cbor:"iNode" lean:"-"`
 }
 
 func (i *Socket) String() string {
@@ -124,132 +197,132 @@ func (i *Socket) String() string {
 //
 // 0: https://git.kernel.org/pub/scm/linux/kernel/git/torvalds/linux.git/tree/include/uapi/linux/tcp.h
 type TCPInfo struct {
-	State    uint8 `structs:"state" lean:"-"`
-	Ca_state uint8 `structs:"caState" lean:"-"`
+	State    uint8 `structs:"state" cbor:"state" lean:"-"`
+	Ca_state uint8 `structs:"caState" cbor:"caState" lean:"-"`
 
 	// Retransmitted packets out
-	Retransmits uint8 `structs:"retransmits"`
-	Probes      uint8 `structs:"probes" lean:"-"`
-	Backoff     uint8 `structs:"backoff" lean:"-"`
+	Retransmits uint8 `structs:"retransmits" cbor:"retransmits"`
+	Probes      uint8 `structs:"probes" cbor:"probes" lean:"-"`
+	Backoff     uint8 `structs:"backoff" cbor:"backoff" lean:"-"`
 
 	// See https://elixir.bootlin.com/linux/v5.14/source/include/uapi/linux/tcp.h#L166
 	// for a list of possible values.
-	Options                   uint8 `structs:"options" lean:"-"`
-	Snd_wscale                uint8 `structs:"sndWscale" lean:"-"` // no uint4
-	Rcv_wscale                uint8 `structs:"rcvdWscale" lean:"-"`
-	Delivery_rate_app_limited uint8 `structs:"deliveryRateAppLimited" lean:"-"`
-	Fastopen_client_fail      uint8 `structs:"fastOpenClientFail" lean:"-"`
+	Options                   uint8 `structs:"options" cbor:"options" lean:"-"`
+	Snd_wscale                uint8 `structs:"sndWscale" cbor:"sndWscale" lean:"-"` // no uint4
+	Rcv_wscale                uint8 `structs:"rcvdWscale" cbor:"rcvdWscale" lean:"-"`
+	Delivery_rate_app_limited uint8 `structs:"deliveryRateAppLimited" cbor:"deliveryRateAppLimited" lean:"-"`
+	Fastopen_client_fail      uint8 `structs:"fastOpenClientFail" cbor:"fastOpenClientFail" lean:"-"`
 
 	// Retransmit timeout
-	Rto uint32 `structs:"rto" lean:"-"`
+	Rto uint32 `structs:"rto" cbor:"rto" lean:"-"`
 
 	// Predicted tick of soft clock for the delayed ACK (whatever that is!)
-	Ato uint32 `structs:"ato" lean:"-"`
+	Ato uint32 `structs:"ato" cbor:"ato" lean:"-"`
 
 	// Cached effective mss, not including SACKS (i.e. sender's MSS)
-	Snd_mss uint32 `structs:"sndMss" lean:"sndMss"`
+	Snd_mss uint32 `structs:"sndMss" cbor:"sndMss" lean:"sndMss"`
 
 	// MSS used for delayed ACK decisions
-	Rcv_mss uint32 `structs:"rcvMss" lean:"-"`
-	Unacked uint32 `structs:"unAcked" lean:"-"`
-	Sacked  uint32 `structs:"sAcked" lean:"-"`
-	Lost    uint32 `structs:"lost" lean:"-"`
-	Retrans uint32 `structs:"retrans" lean:"-"`
-	Fackets uint32 `structs:"fAckets" lean:"-"`
+	Rcv_mss uint32 `structs:"rcvMss" cbor:"rcvMss" lean:"-"`
+	Unacked uint32 `structs:"unAcked" cbor:"unAcked" lean:"-"`
+	Sacked  uint32 `structs:"sAcked" cbor:"sAcked" lean:"-"`
+	Lost    uint32 `structs:"lost" cbor:"lost" lean:"-"`
+	Retrans uint32 `structs:"retrans" cbor:"retrans" lean:"-"`
+	Fackets uint32 `structs:"fAckets" cbor:"fAckets" lean:"-"`
 
 	// Now - timestamp of last sent data packet (for restart window) [ms]
-	Last_data_sent uint32 `structs:"lastDataSent" lean:"-"`
+	Last_data_sent uint32 `structs:"lastDataSent" cbor:"lastDataSent" lean:"-"`
 
 	// Not present in Linunx v5.14?
-	Last_ack_sent uint32 `structs:"lastAckSent" lean:"-"`
+	Last_ack_sent uint32 `structs:"lastAckSent" cbor:"lastAckSent" lean:"-"`
 
 	// Now - timestamp of last received data packet [ms]
-	Last_data_recv uint32 `structs:"lastDataRecv" lean:"-"`
+	Last_data_recv uint32 `structs:"lastDataRecv" cbor:"lastDataRecv" lean:"-"`
 
 	// Now - timestamp of last received ACK (for keepalives) [ms]
-	Last_ack_recv uint32 `structs:"lastAckRecv" lean:"-"`
+	Last_ack_recv uint32 `structs:"lastAckRecv" cbor:"lastAckRecv" lean:"-"`
 
 	// Last pmtu seen by socket
-	Pmtu uint32 `structs:"pMtu" lean:"pMtu"`
+	Pmtu uint32 `structs:"pMtu" cbor:"pMtu" lean:"pMtu"`
 
 	// Current window clamp
-	Rcv_ssthresh uint32 `structs:"rcvSsThresh" lean:"-"`
+	Rcv_ssthresh uint32 `structs:"rcvSsThresh" cbor:"rcvSsThresh" lean:"-"`
 
 	// Smoothed round trip time << 3 in usecs
-	Rtt uint32 `structs:"rtt" lean:"rtt"`
+	Rtt uint32 `structs:"rtt" cbor:"rtt" lean:"rtt"`
 
 	// Medium deviation in us
-	Rttvar       uint32 `structs:"rttVar" lean:"rttVar"`
-	Snd_ssthresh uint32 `structs:"sndSsThresh" lean:"sndSsThresh"`
+	Rttvar       uint32 `structs:"rttVar" cbor:"rttVar" lean:"rttVar"`
+	Snd_ssthresh uint32 `structs:"sndSsThresh" cbor:"sndSsThresh" lean:"sndSsThresh"`
 
 	// Sending congestion window
-	Snd_cwnd uint32 `structs:"sndCwnd" lean:"sndCwnd"`
+	Snd_cwnd uint32 `structs:"sndCwnd" cbor:"sndCwnd" lean:"sndCwnd"`
 
 	// Advertised MSS
-	Advmss     uint32 `structs:"advMss" lean:"advMss"`
-	Reordering uint32 `structs:"reordering" lean:"-"`
-	Rcv_rtt    uint32 `structs:"rcvRtt" lean:"-"`
+	Advmss     uint32 `structs:"advMss" cbor:"advMss" lean:"advMss"`
+	Reordering uint32 `structs:"reordering" cbor:"reordering" lean:"-"`
+	Rcv_rtt    uint32 `structs:"rcvRtt" cbor:"rcvRtt" lean:"-"`
 
 	// Receiver queue space
-	Rcv_space     uint32 `structs:"rcvSpace" lean:"-"`
-	Total_retrans uint32 `structs:"totalRetrans" lean:"-"`
+	Rcv_space     uint32 `structs:"rcvSpace" cbor:"rcvSpace" lean:"-"`
+	Total_retrans uint32 `structs:"totalRetrans" cbor:"totalRetrans" lean:"-"`
 
 	// Pacing rate in bytes per second
-	Pacing_rate     uint64 `structs:"pacingRate" lean:"-"`
-	Max_pacing_rate uint64 `structs:"maxPacingRate" lean:"-"`
+	Pacing_rate     uint64 `structs:"pacingRate" cbor:"pacingRate" lean:"-"`
+	Max_pacing_rate uint64 `structs:"maxPacingRate" cbor:"maxPacingRate" lean:"-"`
 
 	// RFC4898 tcpEStatsAppHCThruOctetsAcked: sum(delta(snd_una)), or
 	// how many bytes were acked.
-	Bytes_acked uint64 `structs:"bytesAcked" lean:"-"`
+	Bytes_acked uint64 `structs:"bytesAcked" cbor:"bytesAcked" lean:"-"`
 
 	// RFC4898 tcpEStatsAppHCThruOctetsReceived: sum(delta(rcv_nxt)), or
 	// how many bytes were acked.
-	Bytes_received uint64 `structs:"bytesRecv" lean:"-"`
+	Bytes_received uint64 `structs:"bytesRecv" cbor:"bytesRecv" lean:"-"`
 
 	// RFC4898 tcpEStatsPerfSegsOut: The total number of segments sent.
-	Segs_out uint32 `structs:"segsOut" lean:"-"`
+	Segs_out uint32 `structs:"segsOut" cbor:"segsOut" lean:"-"`
 
 	// RFC4898 tcpEStatsPerfSegsIn: total number of segments in.
-	Segs_in uint32 `structs:"segsIn" lean:"-"`
+	Segs_in uint32 `structs:"segsIn" cbor:"segsIn" lean:"-"`
 
-	Notsent_bytes uint32 `structs:"notsentBytes" lean:"-"`
-	Min_rtt       uint32 `structs:"minRtt" lean:"minRtt"`
+	Notsent_bytes uint32 `structs:"notsentBytes" cbor:"notsentBytes" lean:"-"`
+	Min_rtt       uint32 `structs:"minRtt" cbor:"minRtt" lean:"minRtt"`
 
 	// RFC4898 tcpEStatsDataSegsIn: total number of data segments in.
-	Data_segs_in uint32 `structs:"dataSegsIn" lean:"-"`
+	Data_segs_in uint32 `structs:"dataSegsIn" cbor:"dataSegsIn" lean:"-"`
 
 	// RFC4898 tcpEStatsDataSegsOut: total number of data segments sent.
-	Data_segs_out uint32 `structs:"dataSegsOut" lean:"-"`
+	Data_segs_out uint32 `structs:"dataSegsOut" cbor:"dataSegsOut" lean:"-"`
 
 	// (saved rate sample: packets delivered) * MSS / (saved rate sample: time elapsed [us]) [Bps]
-	Delivery_rate uint64 `structs:"deliveryRate" lean:"deliveryRate"`
+	Delivery_rate uint64 `structs:"deliveryRate" cbor:"deliveryRate" lean:"deliveryRate"`
 
 	// Time (usec) busy sending data or stalled
-	Busy_time uint64 `structs:"busyTime" lean:"-"`
+	Busy_time uint64 `structs:"busyTime" cbor:"busyTime" lean:"-"`
 
 	// Time (usec) limited by receive window
-	Rwnd_limited uint64 `structs:"rwndLimited" lean:"-"`
+	Rwnd_limited uint64 `structs:"rwndLimited" cbor:"rwndLimited" lean:"-"`
 
 	// Time (usec) limited by send buffer
-	Sndbuf_limited uint64 `structs:"sndBufLimited" lean:"-"`
+	Sndbuf_limited uint64 `structs:"sndBufLimited" cbor:"sndBufLimited" lean:"-"`
 
 	// Total data packets delivered incl. rexmits
-	Delivered    uint32 `structs:"delivered" lean:"-"`
-	Delivered_ce uint32 `structs:"deliveredCe" lean:"-"`
+	Delivered    uint32 `structs:"delivered" cbor:"delivered" lean:"-"`
+	Delivered_ce uint32 `structs:"deliveredCe" cbor:"deliveredCe" lean:"-"`
 
 	// RFC4898 tcpEStatsPerfHCDataOctetsOut: total number of data bytes sent
-	Bytes_sent uint64 `structs:"bytesSent" lean:"bytesSent"`
+	Bytes_sent uint64 `structs:"bytesSent" cbor:"bytesSent" lean:"bytesSent"`
 	/* RFC4898 tcpEStatsPerfOctetsRetrans */
-	Bytes_retrans uint64 `structs:"bytesRetrans" lean:"-"`
+	Bytes_retrans uint64 `structs:"bytesRetrans" cbor:"bytesRetrans" lean:"-"`
 	/* RFC4898 tcpEStatsStackDSACKDups */
-	Dsack_dups uint32 `structs:"dsAckDups" lean:"-"`
+	Dsack_dups uint32 `structs:"dsAckDups" cbor:"dsAckDups" lean:"-"`
 	/* reordering events seen */
-	Reord_seen uint32 `structs:"reordSeen" lean:"-"`
+	Reord_seen uint32 `structs:"reordSeen" cbor:"reordSeen" lean:"-"`
 	/* Out-of-order packets received */
-	Rcv_ooopack uint32 `structs:"rcvOooPack" lean:"-"`
+	Rcv_ooopack uint32 `structs:"rcvOooPack" cbor:"rcvOooPack" lean:"-"`
 
 	// Peer's advertised receive window after scaling (bytes)
-	Snd_wnd uint32 `structs:"sndWnd" lean:"-"`
+	Snd_wnd uint32 `structs:"sndWnd" cbor:"sndWnd" lean:"-"`
 }
 
 func (i *TCPInfo) String() string {
@@ -259,7 +332,7 @@ func (i *TCPInfo) String() string {
 // Cong encodes the TCP Congestion Avoidance (CA) algorithm
 // in use by a given TCP socket.
 type Cong struct {
-	Algorithm string `structs:"algorithm" lean:"algorithm"`
+	Algorithm string `structs:"algorithm" cbor:"algorithm" lean:"algorithm"`
 }
 
 func (i *Cong) String() string {
@@ -284,10 +357,10 @@ func (x State) String() string {
 // linux struct tcpvegas_info [0].
 // 0: https://elixir.bootlin.com/linux/v5.14/source/include/uapi/linux/inet_diag.h
 type VegasInfo struct {
-	Enabled  uint32 `structs:"enabled" lean:"-"`
-	RTTCount uint32 `structs:"rttCount" lean:"-"`
-	RTT      uint32 `structs:"rtt" lean:"-"`
-	MinRTT   uint32 `structs:"minRtt" lean:"-"`
+	Enabled  uint32 `structs:"enabled" cbor:"enabled" lean:"-"`
+	RTTCount uint32 `structs:"rttCount" cbor:"rttCount" lean:"-"`
+	RTT      uint32 `structs:"rtt" cbor:"rtt" lean:"-"`
+	MinRTT   uint32 `structs:"minRtt" cbor:"minRtt" lean:"-"`
 }
 
 func (i *VegasInfo) String() string {
@@ -298,11 +371,11 @@ func (i *VegasInfo) String() string {
 // linux struct tcp_dctcp_info [0].
 // 0: https://elixir.bootlin.com/linux/v5.14/source/include/uapi/linux/inet_diag.h
 type DCTCPInfo struct {
-	Enabled uint16 `structs:"enabled" lean:"-"`
-	CEState uint16 `structs:"ceState" lean:"-"`
-	Alpha   uint32 `structs:"alpha" lean:"-"`
-	ABEcn   uint32 `structs:"abeCn" lean:"-"`
-	ABTot   uint32 `structs:"abTot" lean:"-"`
+	Enabled uint16 `structs:"enabled" cbor:"enabled" lean:"-"`
+	CEState uint16 `structs:"ceState" cbor:"ceState" lean:"-"`
+	Alpha   uint32 `structs:"alpha" cbor:"alpha" lean:"-"`
+	ABEcn   uint32 `structs:"abeCn" cbor:"abeCn" lean:"-"`
+	ABTot   uint32 `structs:"abTot" cbor:"abTot" lean:"-"`
 }
 
 func (i *DCTCPInfo) String() string {
@@ -311,7 +384,7 @@ func (i *DCTCPInfo) String() string {
 
 // TOS encodes the the TOS information associated with INET_DIAG_TOS.
 type TOS struct {
-	TOS uint8 `structs:"tos" lean:"-"`
+	TOS uint8 `structs:"tos" cbor:"tos" lean:"-"`
 }
 
 func (i *TOS) String() string {
@@ -321,31 +394,31 @@ func (i *TOS) String() string {
 // SkMemInfo encodes the socket memory information set forth in sock_diag(7).
 type SkMemInfo struct {
 	// The amount of data in receive queue.
-	RMemAlloc uint32 `structs:"rMemAlloc" lean:"-"`
+	RMemAlloc uint32 `structs:"rMemAlloc" cbor:"rMemAlloc" lean:"-"`
 
 	// The receive socket buffer as set by SO_RCVBUF.
-	RcvBuff uint32 `structs:"rcvBuff" lean:"-"`
+	RcvBuff uint32 `structs:"rcvBuff" cbor:"rcvBuff" lean:"-"`
 
 	// The amount of data in send queue.
-	WMemAlloc uint32 `structs:"WMemAlloc" lean:"-"`
+	WMemAlloc uint32 `structs:"WMemAlloc" cbor:"WMemAlloc" lean:"-"`
 
 	// The send socket buffer as set by SO_SNDBUF.
-	SndBuff uint32 `structs:"sndBuff" lean:"-"`
+	SndBuff uint32 `structs:"sndBuff" cbor:"sndBuff" lean:"-"`
 
 	// The amount of memory scheduled for future use (TCP only).
-	FwdAlloc uint32 `structs:"fwdAlloc" lean:"-"`
+	FwdAlloc uint32 `structs:"fwdAlloc" cbor:"fwdAlloc" lean:"-"`
 
 	// The amount of data queued by TCP, but not yet sent.
-	WMemQueued uint32 `structs:"wMemQueued" lean:"-"`
+	WMemQueued uint32 `structs:"wMemQueued" cbor:"wMemQueued" lean:"-"`
 
 	// The amount of memory allocated for the socket's service needs (e.g., socket filter).
-	OptMem uint32 `structs:"optMem" lean:"-"`
+	OptMem uint32 `structs:"optMem" cbor:"optMem" lean:"-"`
 
 	// The amount of packets in the backlog (not yet processed).
-	Backlog uint32 `structs:"backlog" lean:"-"`
+	Backlog uint32 `structs:"backlog" cbor:"backlog" lean:"-"`
 
 	// Check https://manpages.debian.org/stretch/manpages/sock_diag.7.en.html
-	Drops uint32 `structs:"drops" lean:"-"`
+	Drops uint32 `structs:"drops" cbor:"drops" lean:"-"`
 }
 
 func (i *SkMemInfo) String() string {
@@ -356,10 +429,10 @@ func (i *SkMemInfo) String() string {
 // linux struct inet_diag_meminfo [0].
 // 0: https://elixir.bootlin.com/linux/v5.14/source/include/uapi/linux/inet_diag.h
 type MemInfo struct {
-	RMem uint32 `structs:"rMem" lean:"-"`
-	WMem uint32 `structs:"wMem" lean:"-"`
-	FMem uint32 `structs:"fMem" lean:"-"`
-	TMem uint32 `structs:"tMem" lean:"-"`
+	RMem uint32 `structs:"rMem" cbor:"rMem" lean:"-"`
+	WMem uint32 `structs:"wMem" cbor:"wMem" lean:"-"`
+	FMem uint32 `structs:"fMem" cbor:"fMem" lean:"-"`
+	TMem uint32 `structs:"tMem" cbor:"tMem" lean:"-"`
 }
 
 func (i *MemInfo) String() string {
@@ -371,16 +444,16 @@ func (i *MemInfo) String() string {
 // 0: https://elixir.bootlin.com/linux/v5.14/source/include/uapi/linux/inet_diag.h
 type TCPBBRInfo struct {
 	// Max-filtered BW (app throughput) estimate in bytes/second
-	BBRBW uint64 `structs:"bbrBW" lean:"-"`
+	BBRBW uint64 `structs:"bbrBW" cbor:"bbrBW" lean:"-"`
 
 	// Min-filtered RTT in uSec
-	BBRMinRTT uint32 `structs:"bbrMinRTT" lean:"-"`
+	BBRMinRTT uint32 `structs:"bbrMinRTT" cbor:"bbrMinRTT" lean:"-"`
 
 	// Pacing gain shifted left 8 bits
-	BBRPacingGain uint32 `structs:"bbrPacingGain" lean:"-"`
+	BBRPacingGain uint32 `structs:"bbrPacingGain" cbor:"bbrPacingGain" lean:"-"`
 
 	// Cwnd gain shifted left 8 bits
-	BBRCwndGain uint32 `structs:"bbrCwndGain" lean:"-"`
+	BBRCwndGain uint32 `structs:"bbrCwndGain" cbor:"bbrCwndGain" lean:"-"`
 }
 
 func (i *TCPBBRInfo) String() string {
@@ -107,8 +107,15 @@ type CompatibilityEnrichment struct {
 // are those that can be derived from the `info_refine_tabl` dictionary [0]
 // present in flowd's implementation.
 //
+// Given all these fields come from TCP information, flows lacking it (i.e.
+// UDP flows) yield an empty compatibilityEnrichment.
+//
 // 0: https://github.com/scitags/flowd/blob/v1.1.7/scitags/netlink/pyroute_tcp.py
 func NewCompatibilityEnrichment(e *FlowInfo) CompatibilityEnrichment {
+	if e.TCPInfo == nil {
+		return CompatibilityEnrichment{}
+	}
+
 	to1k := func(og uint32) uint32 { return og / 1000 }
 
 	processState := func(s uint8) string {
@@ -1,6 +1,7 @@
 package types
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"log/slog"
@@ -8,6 +9,8 @@ import (
 	"os"
 	"strings"
 	"time"
+
+	"github.com/fxamacker/cbor/v2"
 )
 
 const (
@@ -22,6 +25,7 @@ const (
 	SYSLOG_VERSION                int    = 1
 	SYSLOG_PROC_ID                string = "-"
 	SYSLOG_MSG_ID                 string = "firefly-json"
+	SYSLOG_CBOR_MSG_ID            string = "firefly-cbor"
 	SYSLOG_STRUCT_DATA            string = "-"
 )
 
@@ -30,7 +34,8 @@ var (
 	SYSLOG_APP_NAME string
 
 	// Initialised in an init function
-	SYSLOG_HEADER string
+	SYSLOG_HEADER      string
+	SYSLOG_CBOR_HEADER string
 )
 
 func init() {
@@ -39,13 +44,48 @@ func init() {
 		hostName = "no idea!"
 	}
 
-	SYSLOG_HEADER = fmt.Sprintf("<%d>%d %%s %s %s %s %s %s ",
-		SYSLOG_PRIORITY, SYSLOG_VERSION, hostName,
-		SYSLOG_APP_NAME, SYSLOG_PROC_ID, SYSLOG_MSG_ID,
-		SYSLOG_STRUCT_DATA,
-	)
+	syslogHeader := func(msgID string) string {
+		return fmt.Sprintf("<%d>%d %%s %s %s %s %s %s ",
+			SYSLOG_PRIORITY, SYSLOG_VERSION, hostName,
+			SYSLOG_APP_NAME, SYSLOG_PROC_ID, msgID,
+			SYSLOG_STRUCT_DATA,
+		)
+	}
+
+	SYSLOG_HEADER = syslogHeader(SYSLOG_MSG_ID)
+	SYSLOG_CBOR_HEADER = syslogHeader(SYSLOG_CBOR_MSG_ID)
+}
+
+// FireflyEncoding is how fireflies are encoded on the wire.
+type FireflyEncoding string
+
+const (
+	// The JSON encoding defined by the SciTags specification.
+	FireflyJSON FireflyEncoding = "json"
+
+	// A compact binary encoding (RFC 8949) with the same keys as the JSON
+	// encoding. It's only understood by collectors we control.
+	FireflyCBOR FireflyEncoding = "cbor"
+)
+
+// IsValid returns whether the encoding is a known one.
+func (e FireflyEncoding) IsValid() bool {
+	return e == FireflyJSON || e == FireflyCBOR
+}
+
+func (FireflyEncoding) JSONSchema() map[string]any {
+	return map[string]any{
+		"type":        "string",
+		"enum":        []string{string(FireflyJSON), string(FireflyCBOR)},
+		"description": "How fireflies are encoded on the wire.",
+	}
 }
 
+// cborMagic is the self-described CBOR tag (RFC 8949, section 3.4.6) every
+// CBOR-encoded firefly starts with. Given it's not valid UTF-8 it can never
+// show up in a JSON firefly, which lets us tell both encodings apart.
+var cborMagic = []byte{0xd9, 0xd9, 0xf7}
+
 // A firefly represents a given flow's characteristics. It's meant to be
 // a UDP datagram's payload and it should always fit within a given MTU
 // which for practical purposes is 1500 bytes. The contents of the firefly
@@ -73,6 +113,10 @@ type Firefly struct {
 	} `json:"context"`
 	Netlink *FlowInfo `json:"netlink,omitempty"`
 	SkOps   *FlowInfo `json:"skOps,omitempty"`
+
+	// Marking is not part of the SciTags specification: it's only included
+	// in END fireflies of flows we marked packets for.
+	Marking *MarkingStats `json:"marking,omitempty"`
 }
 
 func NewFirefly(flowID FlowID, nlInfo, skOps *FlowInfo) Firefly {
@@ -98,19 +142,46 @@ func NewFirefly(flowID FlowID, nlInfo, skOps *FlowInfo) Firefly {
 	ff.Netlink = nlInfo
 	ff.SkOps = skOps
 
+	if flowID.State == END {
+		ff.Marking = flowID.Marking
+	}
+
 	// TODO: If src IP address is private, get one through STUN!
 
 	return ff
 }
 
+// Payload returns the JSON-encoded firefly, optionally prepending a syslog
+// header.
 func (ff *Firefly) Payload(withSyslog bool) ([]byte, error) {
-	payload, err := json.Marshal(ff)
+	return ff.Encode(FireflyJSON, withSyslog)
+}
+
+// Encode returns the firefly encoded as requested, optionally prepending a
+// syslog header whose MSGID identifies the encoding.
+func (ff *Firefly) Encode(enc FireflyEncoding, withSyslog bool) ([]byte, error) {
+	var (
+		payload []byte
+		err     error
+		header  = SYSLOG_HEADER
+	)
+
+	switch enc {
+	case FireflyJSON:
+		payload, err = json.Marshal(ff)
+	case FireflyCBOR:
+		payload, err = cbor.Marshal(ff)
+		payload = append(bytes.Clone(cborMagic), payload...)
+		header = SYSLOG_CBOR_HEADER
+	default:
+		return nil, fmt.Errorf("unknown firefly encoding %q", enc)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("error marshalling firefly: %w", err)
 	}
 
 	if withSyslog {
-		syslogHeader := []byte(fmt.Sprintf(SYSLOG_HEADER, ff.FlowLifecycle.CurrentTime))
+		syslogHeader := []byte(fmt.Sprintf(header, ff.FlowLifecycle.CurrentTime))
 		payload = append(syslogHeader, payload...)
 	}
 
@@ -121,13 +192,13 @@ func (f *Firefly) PopulateTimeStamps(flowID FlowID) {
 	if !flowID.StartTs.IsZero() {
 		f.FlowLifecycle.StartTime = flowID.StartTs.Format(TIME_FORMAT)
 	} else if flowID.StartTs.IsZero() && flowID.State == START {
-		slog.Error("flowID has no start time", "flowID", flowID)
+		slog.Error("flowID has no start time", LogKeyFlow, flowID)
 	}
 
 	if !flowID.EndTs.IsZero() {
 		f.FlowLifecycle.EndTime = flowID.EndTs.Format(TIME_FORMAT)
 	} else if flowID.EndTs.IsZero() && flowID.State == END {
-		slog.Error("flowID has no end time", "flowID", flowID)
+		slog.Error("flowID has no end time", LogKeyFlow, flowID)
 	}
 
 	if flowID.State == ONGOING {
@@ -143,11 +214,17 @@ type SlimFirefly struct {
 	FlowID FlowID `json:"flow-id"`
 }
 
-// Method Parse parses an incoming firefly (with and without a syslog header). Note
-// how json.Unmarshal doesn't work with fireflies containing a syslog header because
-// the data itself is not a valid JSON document. This implies an error is returned
-// before UnmarshalJSON is even called...
+// Method Parse parses an incoming firefly (with and without a syslog header),
+// be it JSON or CBOR-encoded. Note how json.Unmarshal doesn't work with
+// fireflies containing a syslog header because the data itself is not a valid
+// JSON document. This implies an error is returned before UnmarshalJSON is
+// even called... CBOR-encoded fireflies are told apart by the self-described
+// CBOR tag they start with.
 func (f *SlimFirefly) Parse(in []byte) error {
+	if cborIndex := bytes.Index(in, cborMagic); cborIndex != -1 {
+		return f.UnmarshalCBOR(in[cborIndex+len(cborMagic):])
+	}
+
 	jsonIndex := strings.Index(string(in), "{")
 	if jsonIndex == -1 {
 		return fmt.Errorf("couldn't find the JSON start token '{'")
@@ -162,6 +239,25 @@ func (f *SlimFirefly) UnmarshalJSON(in []byte) error {
 		return fmt.Errorf("error unmarshaling the raw firefly: %w", err)
 	}
 
+	return f.fromFirefly(rawFirefly)
+}
+
+func (f *SlimFirefly) UnmarshalCBOR(in []byte) error {
+	// We don't care about the enrichment: shadow it so that it's skipped
+	rawFirefly := struct {
+		Firefly
+		Netlink cbor.RawMessage `json:"netlink,omitempty"`
+		SkOps   cbor.RawMessage `json:"skOps,omitempty"`
+	}{}
+	if err := cbor.Unmarshal(in, &rawFirefly); err != nil {
+		return fmt.Errorf("error unmarshaling the raw CBOR firefly: %w", err)
+	}
+
+	return f.fromFirefly(rawFirefly.Firefly)
+}
+
+// fromFirefly populates the flowID given a decoded firefly.
+func (f *SlimFirefly) fromFirefly(rawFirefly Firefly) error {
 	flowState, ok := ParseFlowState(rawFirefly.FlowLifecycle.State)
 	if !ok {
 		return fmt.Errorf("wrong state %s specified", rawFirefly.FlowLifecycle.State)
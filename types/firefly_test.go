@@ -2,13 +2,17 @@ package types
 
 import (
 	"bytes"
+	"encoding/hex"
+	"encoding/json"
 	"net/netip"
 	"os"
 	"path"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/fxamacker/cbor/v2"
 	"github.com/santhosh-tekuri/jsonschema/v6"
 )
 
@@ -103,6 +107,24 @@ func TestFireflyValidation(t *testing.T) {
 			nil,
 			false,
 		},
+		{
+			FlowID{
+				State:       END,
+				Protocol:    TCP,
+				Family:      IPv6,
+				Src:         netip.AddrPortFrom(netip.MustParseAddr("::1"), 1234),
+				Dst:         netip.AddrPortFrom(netip.MustParseAddr("::1"), 4321),
+				StartTs:     time.Now(),
+				EndTs:       time.Now(),
+				Activity:    0,
+				Experiment:  0,
+				Application: sampleApplication,
+				Marking:     &MarkingStats{Packets: 10, Bytes: 15000, MTUSkipped: 1},
+			},
+			nil,
+			nil,
+			false,
+		},
 		{
 			FlowID{
 				State:       START,
@@ -151,6 +173,22 @@ func TestFireflyValidation(t *testing.T) {
 			&FlowInfo{Mode: "lean", Cong: &Cong{Algorithm: "vegas"}},
 			false,
 		},
+		{
+			FlowID{
+				State:       START,
+				Protocol:    UDP,
+				Family:      IPv6,
+				Src:         netip.AddrPortFrom(netip.MustParseAddr("::1"), 1234),
+				Dst:         netip.AddrPortFrom(netip.MustParseAddr("::1"), 443),
+				StartTs:     time.Now(),
+				Activity:    0,
+				Experiment:  0,
+				Application: sampleApplication,
+			},
+			&FlowInfo{Mode: "compatible"},
+			nil,
+			false,
+		},
 	}
 
 	for i, test := range tests {
@@ -170,3 +208,159 @@ func TestFireflyValidation(t *testing.T) {
 		}
 	}
 }
+
+func TestFireflyProtocol(t *testing.T) {
+	for _, proto := range []Protocol{TCP, UDP} {
+		t.Run(proto.String(), func(t *testing.T) {
+			flowID := FlowID{
+				State:    START,
+				Protocol: proto,
+				Family:   IPv6,
+				Src:      netip.AddrPortFrom(netip.MustParseAddr("2001:db8::1"), 1234),
+				Dst:      netip.AddrPortFrom(netip.MustParseAddr("2001:db8::2"), 443),
+				StartTs:  time.Now(),
+			}
+
+			ff := NewFirefly(flowID, nil, nil)
+			pl, err := ff.Payload(true)
+			if err != nil {
+				t.Fatalf("error generating the payload: %v", err)
+			}
+
+			if !bytes.Contains(pl, []byte(`"protocol":"`+proto.String()+`"`)) {
+				t.Errorf("payload doesn't report protocol %s: %s", proto, pl)
+			}
+
+			sFirefly := SlimFirefly{}
+			if err := sFirefly.Parse(pl); err != nil {
+				t.Fatalf("error parsing the payload: %v", err)
+			}
+
+			if sFirefly.FlowID.Protocol != proto {
+				t.Errorf("got protocol %s, want %s", sFirefly.FlowID.Protocol, proto)
+			}
+		})
+	}
+}
+
+func TestFireflyCBOR(t *testing.T) {
+	flowID := FlowID{
+		State:       END,
+		Protocol:    TCP,
+		Family:      IPv4,
+		Src:         netip.AddrPortFrom(netip.MustParseAddr("192.0.2.1"), 1234),
+		Dst:         netip.AddrPortFrom(netip.MustParseAddr("192.0.2.2"), 443),
+		Experiment:  2,
+		Activity:    3,
+		StartTs:     time.Now().Add(-time.Minute),
+		EndTs:       time.Now(),
+		Application: "flowd-go",
+	}
+	info := &FlowInfo{Mode: "lean", TCPInfo: &TCPInfo{Pmtu: 1500, State: 1}, Cong: &Cong{Algorithm: "bbr"}}
+
+	for _, withSyslog := range []bool{false, true} {
+		ff := NewFirefly(flowID, info, nil)
+
+		jsonPl, err := ff.Encode(FireflyJSON, withSyslog)
+		if err != nil {
+			t.Fatalf("error generating the JSON payload: %v", err)
+		}
+
+		cborPl, err := ff.Encode(FireflyCBOR, withSyslog)
+		if err != nil {
+			t.Fatalf("error generating the CBOR payload: %v", err)
+		}
+
+		if len(cborPl) >= len(jsonPl) {
+			t.Errorf("the CBOR payload (%d bytes) isn't smaller than the JSON one (%d bytes)", len(cborPl), len(jsonPl))
+		}
+
+		if withSyslog && !bytes.Contains(cborPl, []byte(" "+SYSLOG_CBOR_MSG_ID+" ")) {
+			t.Errorf("the syslog header doesn't identify the CBOR encoding: %q", cborPl)
+		}
+
+		sFirefly := SlimFirefly{}
+		if err := sFirefly.Parse(cborPl); err != nil {
+			t.Fatalf("error parsing the CBOR payload (syslog: %t): %v", withSyslog, err)
+		}
+
+		got := sFirefly.FlowID
+		if got.String() != flowID.String() || got.Protocol != flowID.Protocol || got.Experiment != flowID.Experiment ||
+			got.Activity != flowID.Activity || got.Application != flowID.Application || got.EndTs.IsZero() {
+			t.Errorf("got %+v, want %+v", got, flowID)
+		}
+	}
+
+	ff := NewFirefly(flowID, nil, nil)
+	if _, err := ff.Encode("xml", false); err == nil {
+		t.Errorf("no error encoding with an unknown encoding")
+	}
+}
+
+func TestFireflyCBORRoundTrip(t *testing.T) {
+	flowID := FlowID{
+		State:    ONGOING,
+		Protocol: TCP,
+		Family:   IPv6,
+		Src:      netip.AddrPortFrom(netip.MustParseAddr("2001:db8::1"), 1234),
+		Dst:      netip.AddrPortFrom(netip.MustParseAddr("2001:db8::2"), 443),
+		StartTs:  time.Now().Add(-time.Minute),
+	}
+	info := &FlowInfo{
+		TCPInfo: &TCPInfo{Pmtu: 1500, State: 1, Retransmits: 2, Bytes_sent: 1 << 40},
+		Cong:    &Cong{Algorithm: "bbr"},
+		Socket:  &Socket{Family: 10, ID: SockID{SPort: 1234, Src: [4]uint32{1, 2, 3, 4}}},
+		TOS:     &TOS{TOS: 0x20},
+	}
+
+	ff := NewFirefly(flowID, info, nil)
+	payload, err := ff.Encode(FireflyCBOR, false)
+	if err != nil {
+		t.Fatalf("error generating the CBOR payload: %v", err)
+	}
+	if got := hex.EncodeToString(payload[:3]); got != "d9d9f7" {
+		t.Fatalf("the CBOR payload starts with %s instead of the self-described CBOR tag", got)
+	}
+
+	decoded := Firefly{}
+	if err := cbor.Unmarshal(payload[3:], &decoded); err != nil {
+		t.Fatalf("error decoding the CBOR payload: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, ff) {
+		t.Errorf("got %+v, want %+v", decoded, ff)
+	}
+
+	// Every mode must produce the very same document as the JSON encoding
+	dm, err := cbor.DecOptions{DefaultMapType: reflect.TypeOf(map[string]any{})}.DecMode()
+	if err != nil {
+		t.Fatalf("error creating the CBOR decoder: %v", err)
+	}
+	for _, mode := range []string{"", "lean", "compatible"} {
+		info.Mode = mode
+
+		rawCBOR, err := cbor.Marshal(info)
+		if err != nil {
+			t.Fatalf("%q: error encoding as CBOR: %v", mode, err)
+		}
+		var fromCBOR any
+		if err := dm.Unmarshal(rawCBOR, &fromCBOR); err != nil {
+			t.Fatalf("%q: error decoding the CBOR enrichment: %v", mode, err)
+		}
+		gotJSON, err := json.Marshal(fromCBOR)
+		if err != nil {
+			t.Fatalf("%q: error re-encoding the CBOR enrichment: %v", mode, err)
+		}
+
+		wantJSON, err := json.Marshal(info)
+		if err != nil {
+			t.Fatalf("%q: error encoding as JSON: %v", mode, err)
+		}
+		var fromJSON any
+		if err := json.Unmarshal(wantJSON, &fromJSON); err != nil {
+			t.Fatalf("%q: error decoding the JSON enrichment: %v", mode, err)
+		}
+		if wantJSON, _ = json.Marshal(fromJSON); !bytes.Equal(gotJSON, wantJSON) {
+			t.Errorf("%q: the CBOR encoding differs from the JSON one:\n%s\n%s", mode, gotJSON, wantJSON)
+		}
+	}
+}
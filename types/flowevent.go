@@ -0,0 +1,287 @@
+package types
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+)
+
+// FLOW_EVENT_VERSION is the version of the flow event wire format. It must be
+// bumped whenever a change to FlowEvent breaks existing clients.
+const FLOW_EVENT_VERSION int = 1
+
+// FlowEventSchema is the JSON Schema flow events must adhere to. It's served
+// by the API plugin so that clients can validate their events beforehand.
+//
+//go:embed flowevent.schema.json
+var FlowEventSchema []byte
+
+// A FlowEvent is how the start or end of a flow is announced to flowd-go as a
+// JSON document, be it through the API plugin or the named pipe plugin. Unlike
+// FlowID it's meant to be handed around between processes, so states,
+// protocols and addresses are strings and internal fields are left out. Check
+// flowevent.schema.json for the details. The family of the flow is implied by
+// its addresses, which must agree.
+type FlowEvent struct {
+	Version     int           `json:"version"`
+	State       string        `json:"state"`
+	Protocol    string        `json:"protocol"`
+	SrcIP       string        `json:"src-ip"`
+	SrcPort     uint16        `json:"src-port"`
+	DstIP       string        `json:"dst-ip"`
+	DstPort     uint16        `json:"dst-port"`
+	Experiment  ExperimentRef `json:"experiment"`
+	Activity    ActivityRef   `json:"activity"`
+	Application string        `json:"application,omitempty"`
+	StartTime   string        `json:"start-time,omitempty"`
+	EndTime     string        `json:"end-time,omitempty"`
+}
+
+// NewFlowEvent returns the flow event describing a flowID.
+func NewFlowEvent(f FlowID) FlowEvent {
+	ev := FlowEvent{
+		Version:     FLOW_EVENT_VERSION,
+		State:       f.State.String(),
+		Protocol:    f.Protocol.String(),
+		SrcIP:       f.Src.Addr().String(),
+		SrcPort:     f.Src.Port(),
+		DstIP:       f.Dst.Addr().String(),
+		DstPort:     f.Dst.Port(),
+		Experiment:  ExperimentRef{ID: f.Experiment},
+		Activity:    ActivityRef{ID: f.Activity},
+		Application: f.Application,
+	}
+
+	if !f.StartTs.IsZero() {
+		ev.StartTime = f.StartTs.Format(time.RFC3339Nano)
+	}
+	if !f.EndTs.IsZero() {
+		ev.EndTime = f.EndTs.Format(time.RFC3339Nano)
+	}
+
+	return ev
+}
+
+// FlowEventError describes a problem with one of the fields of a flow event.
+// The field is empty for problems with the event as a whole.
+type FlowEventError struct {
+	Field  string `json:"field,omitempty"`
+	Reason string `json:"reason"`
+}
+
+func (e FlowEventError) Error() string {
+	if e.Field == "" {
+		return e.Reason
+	}
+	return e.Field + ": " + e.Reason
+}
+
+// FlowEventErrors are all the problems found with a flow event, which are
+// reported at once so that clients can fix them in one go.
+type FlowEventErrors []FlowEventError
+
+func (errs FlowEventErrors) Error() string {
+	msgs := make([]string, 0, len(errs))
+	for _, e := range errs {
+		msgs = append(msgs, e.Error())
+	}
+	return "invalid flow event: " + strings.Join(msgs, "; ")
+}
+
+var (
+	flowEventRequired = []string{"version", "state", "protocol", "src-ip", "src-port", "dst-ip", "dst-port", "experiment", "activity"}
+
+	// What each field is expected to look like when it can't be decoded.
+	flowEventTypes = map[string]string{
+		"version":     "an integer",
+		"state":       "a string",
+		"protocol":    "a string",
+		"src-ip":      "a string",
+		"src-port":    "an integer between 1 and 65535",
+		"dst-ip":      "a string",
+		"dst-port":    "an integer between 1 and 65535",
+		"experiment":  "an ID or a name",
+		"activity":    "an ID or a name",
+		"application": "a string",
+		"start-time":  "a string",
+		"end-time":    "a string",
+	}
+)
+
+// ParseFlowEvent decodes and validates a JSON flow event, returning the flowID
+// it describes. Unknown and missing fields are rejected. Experiment and
+// activity names are resolved against the current registry. Missing start and
+// end times for start and end events respectively default to now. Any
+// validation problem is reported through FlowEventErrors.
+func ParseFlowEvent(raw []byte) (FlowID, error) {
+	doc := map[string]json.RawMessage{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return FlowID{}, FlowEventErrors{{Reason: "not a JSON object: " + err.Error()}}
+	}
+
+	ev := FlowEvent{}
+	fields := map[string]any{
+		"version":     &ev.Version,
+		"state":       &ev.State,
+		"protocol":    &ev.Protocol,
+		"src-ip":      &ev.SrcIP,
+		"src-port":    &ev.SrcPort,
+		"dst-ip":      &ev.DstIP,
+		"dst-port":    &ev.DstPort,
+		"experiment":  &ev.Experiment,
+		"activity":    &ev.Activity,
+		"application": &ev.Application,
+		"start-time":  &ev.StartTime,
+		"end-time":    &ev.EndTime,
+	}
+
+	// Fields we couldn't decode are not validated any further
+	errs := FlowEventErrors{}
+	decoded := map[string]bool{}
+	for _, key := range slices.Sorted(maps.Keys(doc)) {
+		dst, ok := fields[key]
+		if !ok {
+			errs = append(errs, FlowEventError{key, "unknown field"})
+			continue
+		}
+
+		if err := json.Unmarshal(doc[key], dst); err != nil {
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &typeErr) {
+				errs = append(errs, FlowEventError{key, fmt.Sprintf("got %s, want %s", typeErr.Value, flowEventTypes[key])})
+			} else {
+				errs = append(errs, FlowEventError{key, err.Error()})
+			}
+			continue
+		}
+		decoded[key] = true
+	}
+
+	for _, key := range flowEventRequired {
+		if _, ok := doc[key]; !ok {
+			errs = append(errs, FlowEventError{key, "missing required field"})
+		}
+	}
+
+	flowID, valErrs := ev.flowID(decoded)
+	if errs = append(errs, valErrs...); len(errs) > 0 {
+		return FlowID{}, errs
+	}
+
+	return flowID, nil
+}
+
+// flowID validates the successfully decoded fields of a flow event and
+// builds the flowID it describes.
+func (ev FlowEvent) flowID(decoded map[string]bool) (FlowID, FlowEventErrors) {
+	errs := FlowEventErrors{}
+	flowID := FlowID{Application: SYSLOG_APP_NAME}
+
+	if decoded["version"] && ev.Version != FLOW_EVENT_VERSION {
+		errs = append(errs, FlowEventError{"version", fmt.Sprintf("unsupported version %d, want %d", ev.Version, FLOW_EVENT_VERSION)})
+	}
+
+	if decoded["state"] {
+		state, ok := ParseFlowState(ev.State)
+		if !ok || state == ONGOING || ev.State != strings.ToLower(ev.State) {
+			errs = append(errs, FlowEventError{"state", fmt.Sprintf("got %q, want one of start or end", ev.State)})
+		}
+		flowID.State = state
+	}
+
+	if decoded["protocol"] {
+		proto, ok := ParseProtocol(ev.Protocol)
+		if !ok || ev.Protocol != strings.ToLower(ev.Protocol) {
+			errs = append(errs, FlowEventError{"protocol", fmt.Sprintf("got %q, want one of tcp or udp", ev.Protocol)})
+		}
+		flowID.Protocol = proto
+	}
+
+	parseAddrPort := func(ipField, portField, rawIP string, port uint16) (netip.AddrPort, bool) {
+		valid := true
+		ip, err := netip.ParseAddr(rawIP)
+		if decoded[ipField] && (err != nil || ip.Zone() != "") {
+			errs = append(errs, FlowEventError{ipField, fmt.Sprintf("%q is not an IPv4 or IPv6 address", rawIP)})
+			valid = false
+		}
+		if decoded[portField] && port == 0 {
+			errs = append(errs, FlowEventError{portField, "port 0 is reserved"})
+		}
+		// IPv4-mapped IPv6 addresses identify IPv4 flows
+		return netip.AddrPortFrom(ip.Unmap(), port), valid && decoded[ipField]
+	}
+
+	var srcOk, dstOk bool
+	flowID.Src, srcOk = parseAddrPort("src-ip", "src-port", ev.SrcIP, ev.SrcPort)
+	flowID.Dst, dstOk = parseAddrPort("dst-ip", "dst-port", ev.DstIP, ev.DstPort)
+	if srcOk && dstOk {
+		if flowID.Src.Addr().Is4() != flowID.Dst.Addr().Is4() {
+			errs = append(errs, FlowEventError{"dst-ip", "the source and destination addresses belong to different families"})
+		}
+	}
+	flowID.Family = IPv6
+	if flowID.Src.Addr().Is4() {
+		flowID.Family = IPv4
+	}
+
+	// Names are resolved through the registry, but unregistered IDs are
+	// accepted just like the named pipe plugin always has.
+	r := CurrentRegistry()
+	expOk := false
+	if decoded["experiment"] {
+		if ev.Experiment.Name == "" {
+			flowID.Experiment, expOk = ev.Experiment.ID, true
+		} else if id, err := ev.Experiment.Resolve(r); err != nil {
+			errs = append(errs, FlowEventError{"experiment", err.Error()})
+		} else {
+			flowID.Experiment, expOk = id, true
+		}
+	}
+	if decoded["activity"] {
+		if ev.Activity.Name == "" {
+			flowID.Activity = ev.Activity.ID
+		} else if expOk {
+			id, err := ev.Activity.Resolve(r, flowID.Experiment)
+			if err != nil {
+				errs = append(errs, FlowEventError{"activity", err.Error()})
+			}
+			flowID.Activity = id
+		}
+	}
+
+	if ev.Application != "" {
+		flowID.Application = ev.Application
+	}
+
+	parseTs := func(field, rawTs string) time.Time {
+		if !decoded[field] || rawTs == "" {
+			return time.Time{}
+		}
+		ts, err := time.Parse(time.RFC3339Nano, rawTs)
+		if err != nil {
+			errs = append(errs, FlowEventError{field, fmt.Sprintf("%q is not an RFC 3339 date-time", rawTs)})
+		}
+		return ts
+	}
+
+	flowID.StartTs = parseTs("start-time", ev.StartTime)
+	flowID.EndTs = parseTs("end-time", ev.EndTime)
+	if !flowID.StartTs.IsZero() && !flowID.EndTs.IsZero() && flowID.EndTs.Before(flowID.StartTs) {
+		errs = append(errs, FlowEventError{"end-time", "the flow ends before it starts"})
+	}
+
+	if flowID.State == START && flowID.StartTs.IsZero() {
+		flowID.StartTs = time.Now()
+	}
+	if flowID.State == END && flowID.EndTs.IsZero() {
+		flowID.EndTs = time.Now()
+	}
+
+	return flowID, errs
+}
@@ -0,0 +1,202 @@
+package types
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/netip"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+func TestFlowEventParse(t *testing.T) {
+	r, err := LoadRegistry("testdata/scitags.json")
+	if err != nil {
+		t.Fatalf("error loading the registry: %v", err)
+	}
+	SetRegistry(r)
+	defer SetRegistry(nil)
+
+	c := jsonschema.NewCompiler()
+	c.AssertFormat()
+	sch, err := c.Compile("flowevent.schema.json")
+	if err != nil {
+		t.Fatalf("error compiling the schema: %v", err)
+	}
+
+	tests := []struct {
+		file string
+		want FlowID
+	}{
+		{"start.flowevent", FlowID{
+			State:       START,
+			Protocol:    TCP,
+			Family:      IPv6,
+			Src:         netip.MustParseAddrPort("[2001:db8::1]:2345"),
+			Dst:         netip.MustParseAddrPort("[2001:db8::2]:5777"),
+			Experiment:  2,
+			Activity:    3,
+			Application: "fts",
+		}},
+		{"end.flowevent", FlowID{
+			State:       END,
+			Protocol:    UDP,
+			Family:      IPv4,
+			Src:         netip.MustParseAddrPort("192.0.2.1:2345"),
+			Dst:         netip.MustParseAddrPort("192.0.2.2:443"),
+			Experiment:  2,
+			Activity:    3,
+			Application: SYSLOG_APP_NAME,
+		}},
+	}
+
+	for _, test := range tests {
+		raw, err := os.ReadFile(path.Join("testdata", test.file))
+		if err != nil {
+			t.Fatalf("couldn't read %q: %v", test.file, err)
+		}
+
+		inst, err := jsonschema.UnmarshalJSON(bytes.NewReader(raw))
+		if err != nil {
+			t.Fatalf("error unmarshalling %q: %v", test.file, err)
+		}
+		if err := sch.Validate(inst); err != nil {
+			t.Errorf("error validating %q: %v", test.file, err)
+		}
+
+		got, err := ParseFlowEvent(raw)
+		if err != nil {
+			t.Errorf("error parsing %q: %v", test.file, err)
+			continue
+		}
+
+		if got.StartTs.IsZero() && got.EndTs.IsZero() {
+			t.Errorf("%q: no timestamp has been set", test.file)
+		}
+		got.StartTs, got.EndTs = time.Time{}, time.Time{}
+		if got.String() != test.want.String() || got.Experiment != test.want.Experiment ||
+			got.Activity != test.want.Activity || got.Application != test.want.Application ||
+			got.Protocol != test.want.Protocol {
+			t.Errorf("%q: got %+v, want %+v", test.file, got, test.want)
+		}
+	}
+}
+
+func TestFlowEventRoundTrip(t *testing.T) {
+	want := FlowID{
+		State:       END,
+		Protocol:    TCP,
+		Family:      IPv4,
+		Src:         netip.MustParseAddrPort("192.0.2.1:2345"),
+		Dst:         netip.MustParseAddrPort("192.0.2.2:443"),
+		Experiment:  9,
+		Activity:    1,
+		StartTs:     time.Date(2025, 2, 6, 12, 0, 0, 0, time.UTC),
+		EndTs:       time.Date(2025, 2, 6, 13, 0, 0, 1234, time.UTC),
+		Application: "xrootd",
+	}
+
+	raw, err := json.Marshal(NewFlowEvent(want))
+	if err != nil {
+		t.Fatalf("error marshaling the flow event: %v", err)
+	}
+
+	got, err := ParseFlowEvent(raw)
+	if err != nil {
+		t.Fatalf("error parsing %s: %v", raw, err)
+	}
+
+	if got.String() != want.String() || !got.StartTs.Equal(want.StartTs) || !got.EndTs.Equal(want.EndTs) ||
+		got.Experiment != want.Experiment || got.Activity != want.Activity || got.Application != want.Application {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestFlowEventErrors(t *testing.T) {
+	valid := map[string]any{
+		"version":    1,
+		"state":      "start",
+		"protocol":   "tcp",
+		"src-ip":     "192.0.2.1",
+		"src-port":   2345,
+		"dst-ip":     "192.0.2.2",
+		"dst-port":   443,
+		"experiment": 2,
+		"activity":   3,
+	}
+
+	tests := []struct {
+		name   string
+		set    map[string]any
+		drop   []string
+		fields []string
+	}{
+		{"valid", nil, nil, nil},
+		{"version", map[string]any{"version": 2}, nil, []string{"version"}},
+		{"ongoing", map[string]any{"state": "ongoing"}, nil, []string{"state"}},
+		{"enumState", map[string]any{"state": 2}, nil, []string{"state"}},
+		{"upperCase", map[string]any{"protocol": "TCP"}, nil, []string{"protocol"}},
+		{"badIP", map[string]any{"src-ip": "192.0.2.256"}, nil, []string{"src-ip"}},
+		{"mixedFamilies", map[string]any{"dst-ip": "2001:db8::1"}, nil, []string{"dst-ip"}},
+		{"bigPort", map[string]any{"dst-port": 70000}, nil, []string{"dst-port"}},
+		{"zeroPort", map[string]any{"src-port": 0}, nil, []string{"src-port"}},
+		{"noRegistry", map[string]any{"experiment": "atlas"}, nil, []string{"experiment"}},
+		{"badTime", map[string]any{"start-time": "yesterday"}, nil, []string{"start-time"}},
+		{"backwards", map[string]any{"start-time": "2025-02-06T13:00:00Z", "end-time": "2025-02-06T12:00:00Z"}, nil, []string{"end-time"}},
+		{"internal", map[string]any{"FlowInfoChans": nil}, nil, []string{"FlowInfoChans"}},
+		{"missing", nil, []string{"activity", "src-port"}, []string{"activity", "src-port"}},
+		{"several", map[string]any{"state": "foo", "protocol": "sctp"}, nil, []string{"protocol", "state"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ev := map[string]any{}
+			for k, v := range valid {
+				ev[k] = v
+			}
+			for k, v := range test.set {
+				ev[k] = v
+			}
+			for _, k := range test.drop {
+				delete(ev, k)
+			}
+
+			raw, err := json.Marshal(ev)
+			if err != nil {
+				t.Fatalf("error marshaling the flow event: %v", err)
+			}
+
+			_, err = ParseFlowEvent(raw)
+			if test.fields == nil {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			var errs FlowEventErrors
+			if !errors.As(err, &errs) {
+				t.Fatalf("got error %v, want FlowEventErrors", err)
+			}
+
+			fields := []string{}
+			for _, e := range errs {
+				fields = append(fields, e.Field)
+			}
+			slices.Sort(fields)
+			if !slices.Equal(fields, test.fields) {
+				t.Errorf("got errors for %v, want %v: %v", fields, test.fields, err)
+			}
+		})
+	}
+
+	if _, err := ParseFlowEvent([]byte("start tcp ::1 1 ::1 2 1 1")); err == nil || !strings.Contains(err.Error(), "JSON") {
+		t.Errorf("got %v parsing plain text, want a JSON error", err)
+	}
+}
@@ -0,0 +1,43 @@
+package types
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+// FlowKey identifies a flow. Unlike FlowID it's a comparable type holding
+// nothing but the flow's identity, so it can be used as a map key directly
+// without resorting to hashing and the collisions that come with it. Every
+// component keeping track of flows should index them with a FlowKey so that
+// flows are told apart consistently across the codebase.
+type FlowKey struct {
+	Protocol Protocol
+	Family   Family
+	Src      netip.AddrPort
+	Dst      netip.AddrPort
+}
+
+// NewFlowKey returns the key of a flow given its protocol and endpoints.
+// IPv4-mapped IPv6 addresses (i.e. those of IPv4 flows on dual-stack sockets)
+// are unmapped so that they identify the very same flow as plain IPv4 ones.
+// The family is implied by the source address.
+func NewFlowKey(proto Protocol, src, dst netip.AddrPort) FlowKey {
+	src = netip.AddrPortFrom(src.Addr().Unmap(), src.Port())
+	dst = netip.AddrPortFrom(dst.Addr().Unmap(), dst.Port())
+
+	family := IPv6
+	if src.Addr().Is4() {
+		family = IPv4
+	}
+
+	return FlowKey{Protocol: proto, Family: family, Src: src, Dst: dst}
+}
+
+// Key returns the key identifying the flow.
+func (f FlowID) Key() FlowKey {
+	return NewFlowKey(f.Protocol, f.Src, f.Dst)
+}
+
+func (k FlowKey) String() string {
+	return fmt.Sprintf("%s %s->%s", k.Protocol, k.Src, k.Dst)
+}
@@ -0,0 +1,52 @@
+package types
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestFlowKey(t *testing.T) {
+	base := FlowID{
+		Protocol: TCP,
+		Family:   IPv6,
+		Src:      netip.MustParseAddrPort("[2001:db8::1]:2345"),
+		Dst:      netip.MustParseAddrPort("[2001:db8::2]:5777"),
+	}
+
+	// Flows told apart by a single field each, ports included
+	distinct := []FlowID{base}
+	for _, mod := range []func(*FlowID){
+		func(f *FlowID) { f.Protocol = UDP },
+		func(f *FlowID) { f.Src = netip.MustParseAddrPort("[2001:db8::3]:2345") },
+		func(f *FlowID) { f.Dst = netip.MustParseAddrPort("[2001:db8::3]:5777") },
+		func(f *FlowID) { f.Src = netip.MustParseAddrPort("[2001:db8::1]:2346") },
+		func(f *FlowID) { f.Dst = netip.MustParseAddrPort("[2001:db8::2]:5778") },
+		func(f *FlowID) { f.Src, f.Dst = f.Dst, f.Src },
+	} {
+		f := base
+		mod(&f)
+		distinct = append(distinct, f)
+	}
+
+	keys := map[FlowKey]FlowID{}
+	for _, f := range distinct {
+		if other, ok := keys[f.Key()]; ok {
+			t.Errorf("flows %v and %v share key %v", f, other, f.Key())
+		}
+		keys[f.Key()] = f
+	}
+
+	// Whatever isn't part of a flow's identity is ignored
+	same := base
+	same.State, same.Experiment, same.Activity = END, 2, 3
+	if same.Key() != base.Key() {
+		t.Errorf("got different keys %v and %v for the same flow", same.Key(), base.Key())
+	}
+
+	// IPv4-mapped addresses identify IPv4 flows
+	v4 := NewFlowKey(TCP, netip.MustParseAddrPort("192.0.2.1:2345"), netip.MustParseAddrPort("192.0.2.2:5777"))
+	mapped := NewFlowKey(TCP, netip.MustParseAddrPort("[::ffff:192.0.2.1]:2345"), netip.MustParseAddrPort("[::ffff:192.0.2.2]:5777"))
+	if v4 != mapped || mapped.Family != IPv4 {
+		t.Errorf("got key %v (family %s) for an IPv4-mapped flow, want %v", mapped, mapped.Family, v4)
+	}
+}
@@ -11,3 +11,36 @@ const (
 	LevelWarn  = slog.LevelWarn
 	LevelError = slog.LevelError
 )
+
+// Attribute keys every component should use so that log entries can be
+// reliably queried (i.e. for a given flow) once ingested.
+const (
+	LogKeyFlow    = "flow"
+	LogKeyPlugin  = "plugin"
+	LogKeyBackend = "backend"
+	LogKeyFlavour = "flavour"
+)
+
+// LogValue implements slog.LogValuer so that flowIDs are logged as a group of
+// attributes rather than as an opaque string. The names of the experiment and
+// activity are included too if they can be found in the current registry.
+func (f FlowID) LogValue() slog.Value {
+	attrs := []slog.Attr{
+		slog.String("state", f.State.String()),
+		slog.String("protocol", f.Protocol.String()),
+		slog.String("src", f.Src.String()),
+		slog.String("dst", f.Dst.String()),
+		slog.Uint64("experiment", uint64(f.Experiment)),
+		slog.Uint64("activity", uint64(f.Activity)),
+	}
+
+	r := CurrentRegistry()
+	if name, ok := r.ExperimentName(f.Experiment); ok {
+		attrs = append(attrs, slog.String("experimentName", name))
+	}
+	if name, ok := r.ActivityName(f.Experiment, f.Activity); ok {
+		attrs = append(attrs, slog.String("activityName", name))
+	}
+
+	return slog.GroupValue(attrs...)
+}
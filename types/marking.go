@@ -0,0 +1,24 @@
+package types
+
+// MarkingStats holds what marking a flow amounted to: the packets (and the
+// bytes they carried) that were actually marked as well as the packets left
+// unmarked because marking them would have overflowed the MTU. Together they
+// prove whether every packet of a flow was marked.
+type MarkingStats struct {
+	Packets    uint64 `json:"packets"`
+	Bytes      uint64 `json:"bytes"`
+	MTUSkipped uint64 `json:"mtu-skipped"`
+}
+
+// Add accumulates the given stats, which is handy for summing the per-CPU
+// values kept by the kernel or those of several backends.
+func (s *MarkingStats) Add(o MarkingStats) {
+	s.Packets += o.Packets
+	s.Bytes += o.Bytes
+	s.MTUSkipped += o.MTUSkipped
+}
+
+// Complete returns whether every packet seen for the flow was marked.
+func (s MarkingStats) Complete() bool {
+	return s.MTUSkipped == 0
+}
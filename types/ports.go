@@ -0,0 +1,70 @@
+package types
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+)
+
+// PortRange is an inclusive range of ports configured either as a single port
+// (i.e. 443) or as a range (i.e. "1024-65535").
+type PortRange struct {
+	First uint16
+	Last  uint16
+}
+
+func (pr *PortRange) UnmarshalYAML(b []byte) error {
+	// Single ports are decoded as integers, ranges as strings
+	var v any
+	if err := yaml.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	raw := fmt.Sprint(v)
+
+	first, last, isRange := strings.Cut(raw, "-")
+	if !isRange {
+		last = first
+	}
+
+	f, err := strconv.ParseUint(strings.TrimSpace(first), 10, 16)
+	if err != nil {
+		return fmt.Errorf("wrong port range %q: %w", raw, err)
+	}
+
+	l, err := strconv.ParseUint(strings.TrimSpace(last), 10, 16)
+	if err != nil {
+		return fmt.Errorf("wrong port range %q: %w", raw, err)
+	}
+
+	if f > l {
+		return fmt.Errorf("wrong port range %q: %d is larger than %d", raw, f, l)
+	}
+
+	*pr = PortRange{First: uint16(f), Last: uint16(l)}
+
+	return nil
+}
+
+func (pr PortRange) MarshalText() ([]byte, error) {
+	if pr.First == pr.Last {
+		return []byte(strconv.Itoa(int(pr.First))), nil
+	}
+	return []byte(fmt.Sprintf("%d-%d", pr.First, pr.Last)), nil
+}
+
+func (PortRange) JSONSchema() map[string]any {
+	return map[string]any{
+		"type":        []string{"integer", "string"},
+		"minimum":     0,
+		"maximum":     65535,
+		"pattern":     `^\s*[0-9]+\s*(-\s*[0-9]+\s*)?$`,
+		"description": "A single port (i.e. 443) or an inclusive range of ports (i.e. 1024-65535).",
+	}
+}
+
+// Contains checks whether the port is within the range.
+func (pr PortRange) Contains(port uint16) bool {
+	return pr.First <= port && port <= pr.Last
+}
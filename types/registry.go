@@ -0,0 +1,116 @@
+package types
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/goccy/go-yaml"
+)
+
+// ConfigDecoder decodes the raw YAML configuration of a plugin or backend
+// into the value that will be handed over to its factory. Decoders are
+// expected to apply any default values.
+type ConfigDecoder func(raw []byte) (any, error)
+
+// PluginFactory creates a plugin instance given its name and the configuration
+// returned by the ConfigDecoder it was registered with. The instance name is
+// expected to show up in the plugin's String() and in its log entries so that
+// several instances of the same plugin can be told apart.
+type PluginFactory func(name string, conf any) (Plugin, error)
+
+// BackendFactory creates a backend instance given its name and the
+// configuration returned by the ConfigDecoder it was registered with. Check
+// PluginFactory for the details.
+type BackendFactory func(name string, conf any) (Backend, error)
+
+type PluginRegistration struct {
+	Name    string
+	Factory PluginFactory
+	Decode  ConfigDecoder
+}
+
+type BackendRegistration struct {
+	Name    string
+	Factory BackendFactory
+	Decode  ConfigDecoder
+}
+
+var (
+	registryMu sync.RWMutex
+	plugins    = map[string]PluginRegistration{}
+	backends   = map[string]BackendRegistration{}
+)
+
+// RegisterPlugin makes a plugin available under the given name, which is the
+// key its configuration (i.e. that of its instances) is expected to be found
+// under in the plugins section of the configuration. It's meant to be called from the init function of the
+// package implementing the plugin so that simply importing the package wires
+// it in. Registering the same name twice panics.
+func RegisterPlugin(name string, factory PluginFactory, decode ConfigDecoder) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, ok := plugins[name]; ok {
+		panic(fmt.Sprintf("plugin %q registered twice", name))
+	}
+	plugins[name] = PluginRegistration{Name: name, Factory: factory, Decode: decode}
+}
+
+// RegisterBackend makes a backend available under the given name. Check
+// RegisterPlugin for the details.
+func RegisterBackend(name string, factory BackendFactory, decode ConfigDecoder) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, ok := backends[name]; ok {
+		panic(fmt.Sprintf("backend %q registered twice", name))
+	}
+	backends[name] = BackendRegistration{Name: name, Factory: factory, Decode: decode}
+}
+
+func LookupPlugin(name string) (PluginRegistration, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	r, ok := plugins[name]
+	return r, ok
+}
+
+func LookupBackend(name string) (BackendRegistration, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	r, ok := backends[name]
+	return r, ok
+}
+
+// RegisteredPlugins returns the sorted names of every registered plugin.
+func RegisteredPlugins() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	return slices.Sorted(maps.Keys(plugins))
+}
+
+// RegisteredBackends returns the sorted names of every registered backend.
+func RegisteredBackends() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	return slices.Sorted(maps.Keys(backends))
+}
+
+// YAMLDecoder returns a ConfigDecoder unmarshaling the raw configuration into
+// a *T. Defaults should be applied by implementing yaml.BytesUnmarshaler on *T
+// as done throughout flowd-go.
+func YAMLDecoder[T any]() ConfigDecoder {
+	return func(raw []byte) (any, error) {
+		c := new(T)
+		if err := yaml.Unmarshal(raw, c); err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+}
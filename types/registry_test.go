@@ -0,0 +1,62 @@
+package types
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/goccy/go-yaml"
+)
+
+type dummyConf struct {
+	Foo int `yaml:"foo"`
+}
+
+func (c *dummyConf) UnmarshalYAML(b []byte) error {
+	type conf dummyConf
+	def := conf{Foo: 1234}
+	if err := yaml.Unmarshal(b, &def); err != nil {
+		return err
+	}
+	*c = dummyConf(def)
+	return nil
+}
+
+func TestRegistry(t *testing.T) {
+	RegisterPlugin("dummy", func(name string, conf any) (Plugin, error) { return nil, nil }, YAMLDecoder[dummyConf]())
+
+	if !slices.Contains(RegisteredPlugins(), "dummy") {
+		t.Fatalf("the dummy plugin hasn't been registered: %v", RegisteredPlugins())
+	}
+
+	if _, ok := LookupBackend("dummy"); ok {
+		t.Errorf("the dummy plugin has been registered as a backend")
+	}
+
+	r, ok := LookupPlugin("dummy")
+	if !ok {
+		t.Fatalf("couldn't look the dummy plugin up")
+	}
+
+	tests := map[string]int{
+		"{}":        1234,
+		"foo: 4321": 4321,
+	}
+
+	for raw, want := range tests {
+		c, err := r.Decode([]byte(raw))
+		if err != nil {
+			t.Fatalf("error decoding %q: %v", raw, err)
+		}
+
+		if got := c.(*dummyConf).Foo; got != want {
+			t.Errorf("decoding %q: got %d; want %d", raw, got, want)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("registering the dummy plugin twice didn't panic")
+		}
+	}()
+	RegisterPlugin("dummy", nil, nil)
+}
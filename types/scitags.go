@@ -0,0 +1,459 @@
+package types
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/goccy/go-yaml"
+)
+
+// Registry maps the experiment and activity IDs flowIDs carry to the names
+// they've been given by SciTags. It's parsed from the JSON document published
+// at https://www.scitags.org/api.json, which looks like:
+//
+//	{"experiments": [
+//		{"expName": "atlas", "expId": 2, "activities": [
+//			{"activityName": "perfsonar", "activityId": 2},
+//			...
+//		]},
+//		...
+//	]}
+//
+// Names are looked up regardless of their case. Every method can be called on
+// a nil registry, which knows about no experiment or activity at all.
+type Registry struct {
+	experiments map[uint32]*registryExperiment
+	byName      map[string]uint32
+}
+
+type registryExperiment struct {
+	name       string
+	activities map[uint32]string
+	byName     map[string]uint32
+}
+
+// ParseRegistry parses a SciTags registry JSON document.
+func ParseRegistry(raw []byte) (*Registry, error) {
+	doc := struct {
+		Experiments []struct {
+			Name       string `json:"expName"`
+			ID         uint32 `json:"expId"`
+			Activities []struct {
+				Name string `json:"activityName"`
+				ID   uint32 `json:"activityId"`
+			} `json:"activities"`
+		} `json:"experiments"`
+	}{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("error unmarshaling the registry: %w", err)
+	}
+
+	if len(doc.Experiments) == 0 {
+		return nil, fmt.Errorf("the registry defines no experiments")
+	}
+
+	r := &Registry{experiments: map[uint32]*registryExperiment{}, byName: map[string]uint32{}}
+	for _, e := range doc.Experiments {
+		if _, ok := r.experiments[e.ID]; ok {
+			return nil, fmt.Errorf("experiment ID %d is defined more than once", e.ID)
+		}
+
+		exp := &registryExperiment{name: e.Name, activities: map[uint32]string{}, byName: map[string]uint32{}}
+		for _, a := range e.Activities {
+			if _, ok := exp.activities[a.ID]; ok {
+				return nil, fmt.Errorf("activity ID %d is defined more than once for experiment %q", a.ID, e.Name)
+			}
+			exp.activities[a.ID] = a.Name
+			exp.byName[strings.ToLower(a.Name)] = a.ID
+		}
+
+		r.experiments[e.ID] = exp
+		r.byName[strings.ToLower(e.Name)] = e.ID
+	}
+
+	return r, nil
+}
+
+// LoadRegistry reads and parses the SciTags registry stored at path.
+func LoadRegistry(path string) (*Registry, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := ParseRegistry(raw)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+
+	return r, nil
+}
+
+// Len returns the number of experiments in the registry.
+func (r *Registry) Len() int {
+	if r == nil {
+		return 0
+	}
+	return len(r.experiments)
+}
+
+// ExperimentName returns the name of an experiment given its ID.
+func (r *Registry) ExperimentName(exp uint32) (string, bool) {
+	if r == nil {
+		return "", false
+	}
+	e, ok := r.experiments[exp]
+	if !ok {
+		return "", false
+	}
+	return e.name, true
+}
+
+// ActivityName returns the name of an activity given its ID and that of the
+// experiment it belongs to.
+func (r *Registry) ActivityName(exp, act uint32) (string, bool) {
+	if r == nil {
+		return "", false
+	}
+	e, ok := r.experiments[exp]
+	if !ok {
+		return "", false
+	}
+	name, ok := e.activities[act]
+	return name, ok
+}
+
+// ExperimentID returns the ID of an experiment given its name.
+func (r *Registry) ExperimentID(name string) (uint32, bool) {
+	if r == nil {
+		return 0, false
+	}
+	id, ok := r.byName[strings.ToLower(name)]
+	return id, ok
+}
+
+// ActivityID returns the ID of an activity given its name and the ID of the
+// experiment it belongs to.
+func (r *Registry) ActivityID(exp uint32, name string) (uint32, bool) {
+	if r == nil {
+		return 0, false
+	}
+	e, ok := r.experiments[exp]
+	if !ok {
+		return 0, false
+	}
+	id, ok := e.byName[strings.ToLower(name)]
+	return id, ok
+}
+
+// HasActivity checks whether any experiment defines an activity with the
+// given name.
+func (r *Registry) HasActivity(name string) bool {
+	if r == nil {
+		return false
+	}
+	for _, e := range r.experiments {
+		if _, ok := e.byName[strings.ToLower(name)]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks whether an experiment and activity are both registered.
+func (r *Registry) Validate(exp, act uint32) error {
+	if r == nil {
+		return fmt.Errorf("no SciTags registry has been loaded")
+	}
+
+	e, ok := r.experiments[exp]
+	if !ok {
+		return fmt.Errorf("unknown experiment ID %d", exp)
+	}
+
+	if _, ok := e.activities[act]; !ok {
+		return fmt.Errorf("unknown activity ID %d for experiment %q", act, e.name)
+	}
+
+	return nil
+}
+
+// ParseExperiment parses an experiment given either as an ID or as a name.
+func (r *Registry) ParseExperiment(s string) (uint32, error) {
+	if id, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return uint32(id), nil
+	}
+
+	id, ok := r.ExperimentID(s)
+	if !ok {
+		return 0, fmt.Errorf("unknown experiment %q", s)
+	}
+	return id, nil
+}
+
+// ParseActivity parses an activity of the given experiment given either as an
+// ID or as a name.
+func (r *Registry) ParseActivity(exp uint32, s string) (uint32, error) {
+	if id, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return uint32(id), nil
+	}
+
+	id, ok := r.ActivityID(exp, s)
+	if !ok {
+		return 0, fmt.Errorf("unknown activity %q for experiment %d", s, exp)
+	}
+	return id, nil
+}
+
+// The registry currently in use. It can be swapped at any time (i.e. when it's
+// refreshed) so it must only ever be accessed through CurrentRegistry.
+var currentRegistry atomic.Pointer[Registry]
+
+// SetRegistry sets the registry returned by CurrentRegistry. A nil registry
+// unsets it.
+func SetRegistry(r *Registry) {
+	currentRegistry.Store(r)
+}
+
+// CurrentRegistry returns the registry currently in use, which is nil if none
+// has been loaded.
+func CurrentRegistry() *Registry {
+	return currentRegistry.Load()
+}
+
+// ExperimentRef is an experiment configured either by ID (i.e. 2) or by name
+// (i.e. atlas). Names are resolved against the registry when needed, which
+// allows decoding configurations before the registry is loaded.
+type ExperimentRef struct {
+	ID   uint32
+	Name string
+}
+
+func (e *ExperimentRef) UnmarshalYAML(b []byte) error {
+	id, name, err := unmarshalRef(b)
+	if err != nil {
+		return fmt.Errorf("wrong experiment: %w", err)
+	}
+	*e = ExperimentRef{ID: id, Name: name}
+	return nil
+}
+
+func (e ExperimentRef) MarshalText() ([]byte, error) {
+	return marshalRef(e.ID, e.Name), nil
+}
+
+func (ExperimentRef) JSONSchema() map[string]any {
+	return refSchema("experiment")
+}
+
+// Resolve returns the ID of the experiment. If a registry is given the
+// experiment must be registered.
+func (e ExperimentRef) Resolve(r *Registry) (uint32, error) {
+	if e.Name == "" {
+		if r != nil {
+			if _, ok := r.ExperimentName(e.ID); !ok {
+				return 0, fmt.Errorf("unknown experiment ID %d", e.ID)
+			}
+		}
+		return e.ID, nil
+	}
+
+	if r == nil {
+		return 0, fmt.Errorf("can't resolve experiment %q without a SciTags registry", e.Name)
+	}
+
+	id, ok := r.ExperimentID(e.Name)
+	if !ok {
+		return 0, fmt.Errorf("unknown experiment %q", e.Name)
+	}
+	return id, nil
+}
+
+// Matches checks whether the experiment is the one with the given ID.
+func (e ExperimentRef) Matches(r *Registry, exp uint32) bool {
+	if e.Name == "" {
+		return e.ID == exp
+	}
+	id, ok := r.ExperimentID(e.Name)
+	return ok && id == exp
+}
+
+// ActivityRef is an activity configured either by ID or by name. Given
+// activities are defined per experiment, names can only be resolved together
+// with the experiment the activity belongs to.
+type ActivityRef struct {
+	ID   uint32
+	Name string
+}
+
+func (a *ActivityRef) UnmarshalYAML(b []byte) error {
+	id, name, err := unmarshalRef(b)
+	if err != nil {
+		return fmt.Errorf("wrong activity: %w", err)
+	}
+	*a = ActivityRef{ID: id, Name: name}
+	return nil
+}
+
+func (a ActivityRef) MarshalText() ([]byte, error) {
+	return marshalRef(a.ID, a.Name), nil
+}
+
+func (ActivityRef) JSONSchema() map[string]any {
+	return refSchema("activity")
+}
+
+// Resolve returns the ID of the activity within the given experiment. If a
+// registry is given the activity must be registered.
+func (a ActivityRef) Resolve(r *Registry, exp uint32) (uint32, error) {
+	if a.Name == "" {
+		if r != nil {
+			if err := r.Validate(exp, a.ID); err != nil {
+				return 0, err
+			}
+		}
+		return a.ID, nil
+	}
+
+	if r == nil {
+		return 0, fmt.Errorf("can't resolve activity %q without a SciTags registry", a.Name)
+	}
+
+	id, ok := r.ActivityID(exp, a.Name)
+	if !ok {
+		return 0, fmt.Errorf("unknown activity %q for experiment %d", a.Name, exp)
+	}
+	return id, nil
+}
+
+// Matches checks whether the activity is the one with the given ID within the
+// given experiment.
+func (a ActivityRef) Matches(r *Registry, exp, act uint32) bool {
+	if a.Name == "" {
+		return a.ID == act
+	}
+	id, ok := r.ActivityID(exp, a.Name)
+	return ok && id == act
+}
+
+// unmarshalRef decodes either an unsigned integer or a name. Numeric strings
+// are taken as IDs too so that marshaled references can be decoded back.
+func unmarshalRef(b []byte) (uint32, string, error) {
+	var v any
+	if err := yaml.Unmarshal(b, &v); err != nil {
+		return 0, "", err
+	}
+
+	switch v := v.(type) {
+	case uint64:
+		if v > 1<<32-1 {
+			return 0, "", fmt.Errorf("ID %d is out of bounds", v)
+		}
+		return uint32(v), "", nil
+
+	case int64:
+		if v < 0 || v > 1<<32-1 {
+			return 0, "", fmt.Errorf("ID %d is out of bounds", v)
+		}
+		return uint32(v), "", nil
+
+	case string:
+		if v == "" {
+			return 0, "", fmt.Errorf("empty name")
+		}
+		if id, err := strconv.ParseUint(v, 10, 32); err == nil {
+			return uint32(id), "", nil
+		}
+		return 0, v, nil
+	}
+
+	return 0, "", fmt.Errorf("expected an ID or a name, got %v", v)
+}
+
+func marshalRef(id uint32, name string) []byte {
+	if name != "" {
+		return []byte(name)
+	}
+	return []byte(strconv.FormatUint(uint64(id), 10))
+}
+
+func refSchema(what string) map[string]any {
+	return map[string]any{
+		"type":        []string{"integer", "string"},
+		"minimum":     0,
+		"maximum":     uint64(1)<<32 - 1,
+		"minLength":   1,
+		"description": "An " + what + " ID or its name as defined in the SciTags registry.",
+	}
+}
+
+// UnmarshalJSON lets experiments be given either as an ID or as a name within
+// JSON documents such as flow events.
+func (e *ExperimentRef) UnmarshalJSON(b []byte) error {
+	id, name, err := unmarshalJSONRef(b)
+	if err != nil {
+		return fmt.Errorf("wrong experiment: %w", err)
+	}
+	*e = ExperimentRef{ID: id, Name: name}
+	return nil
+}
+
+// MarshalJSON marshals IDs as numbers and names as strings.
+func (e ExperimentRef) MarshalJSON() ([]byte, error) {
+	return marshalJSONRef(e.ID, e.Name)
+}
+
+func (a *ActivityRef) UnmarshalJSON(b []byte) error {
+	id, name, err := unmarshalJSONRef(b)
+	if err != nil {
+		return fmt.Errorf("wrong activity: %w", err)
+	}
+	*a = ActivityRef{ID: id, Name: name}
+	return nil
+}
+
+func (a ActivityRef) MarshalJSON() ([]byte, error) {
+	return marshalJSONRef(a.ID, a.Name)
+}
+
+// unmarshalJSONRef is the JSON counterpart of unmarshalRef.
+func unmarshalJSONRef(b []byte) (uint32, string, error) {
+	var v any
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	if err := d.Decode(&v); err != nil {
+		return 0, "", err
+	}
+
+	switch v := v.(type) {
+	case json.Number:
+		id, err := strconv.ParseUint(v.String(), 10, 32)
+		if err != nil {
+			return 0, "", fmt.Errorf("ID %s is not an unsigned 32-bit integer", v)
+		}
+		return uint32(id), "", nil
+
+	case string:
+		if v == "" {
+			return 0, "", fmt.Errorf("empty name")
+		}
+		if id, err := strconv.ParseUint(v, 10, 32); err == nil {
+			return uint32(id), "", nil
+		}
+		return 0, v, nil
+	}
+
+	return 0, "", fmt.Errorf("expected an ID or a name, got %v", v)
+}
+
+func marshalJSONRef(id uint32, name string) ([]byte, error) {
+	if name != "" {
+		return json.Marshal(name)
+	}
+	return strconv.AppendUint(nil, uint64(id), 10), nil
+}
@@ -0,0 +1,135 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/goccy/go-yaml"
+)
+
+func TestScitagsRegistry(t *testing.T) {
+	r, err := LoadRegistry("testdata/scitags.json")
+	if err != nil {
+		t.Fatalf("error loading the registry: %v", err)
+	}
+
+	if r.Len() != 3 {
+		t.Errorf("got %d experiments, want 3", r.Len())
+	}
+
+	if name, ok := r.ExperimentName(2); !ok || name != "atlas" {
+		t.Errorf("got experiment name %q (%t), want atlas", name, ok)
+	}
+
+	if name, ok := r.ActivityName(3, 7); !ok || name != "datachallenge" {
+		t.Errorf("got activity name %q (%t), want datachallenge", name, ok)
+	}
+
+	if id, ok := r.ExperimentID("CMS"); !ok || id != 3 {
+		t.Errorf("got experiment ID %d (%t), want 3", id, ok)
+	}
+
+	// Activities are defined per experiment
+	for exp, want := range map[uint32]uint32{2: 3, 3: 7} {
+		if id, ok := r.ActivityID(exp, "datachallenge"); !ok || id != want {
+			t.Errorf("got activity ID %d (%t) for experiment %d, want %d", id, ok, exp, want)
+		}
+	}
+
+	if err := r.Validate(2, 2); err != nil {
+		t.Errorf("unexpected error validating a registered context: %v", err)
+	}
+	if err := r.Validate(2, 7); err == nil {
+		t.Errorf("unregistered activity validated")
+	}
+	if err := r.Validate(4, 1); err == nil {
+		t.Errorf("unregistered experiment validated")
+	}
+
+	if _, err := ParseRegistry([]byte(`{"experiments": [{"expName": "a", "expId": 1}, {"expName": "b", "expId": 1}]}`)); err == nil {
+		t.Errorf("registry with duplicate experiment IDs parsed")
+	}
+}
+
+func TestNilScitagsRegistry(t *testing.T) {
+	var r *Registry
+
+	if _, ok := r.ExperimentName(2); ok {
+		t.Errorf("a nil registry knows about experiments")
+	}
+
+	if id, err := r.ParseExperiment("2"); err != nil || id != 2 {
+		t.Errorf("got experiment %d (%v), want 2", id, err)
+	}
+
+	if _, err := r.ParseExperiment("atlas"); err == nil {
+		t.Errorf("a nil registry resolved an experiment name")
+	}
+}
+
+func TestScitagsRefs(t *testing.T) {
+	r, err := LoadRegistry("testdata/scitags.json")
+	if err != nil {
+		t.Fatalf("error loading the registry: %v", err)
+	}
+
+	conf := struct {
+		Experiments []ExperimentRef `yaml:"experiments"`
+		Activities  []ActivityRef   `yaml:"activities"`
+	}{}
+	if err := yaml.Unmarshal([]byte("experiments: [2, cms, \"3\"]\nactivities: [perfsonar, datachallenge, 1]\n"), &conf); err != nil {
+		t.Fatalf("error unmarshaling the references: %v", err)
+	}
+
+	want := [][2]uint32{{2, 2}, {3, 7}, {3, 1}}
+	for i, w := range want {
+		exp, err := conf.Experiments[i].Resolve(r)
+		if err != nil {
+			t.Errorf("error resolving experiment %d: %v", i, err)
+			continue
+		}
+
+		act, err := conf.Activities[i].Resolve(r, exp)
+		if err != nil {
+			t.Errorf("error resolving activity %d: %v", i, err)
+			continue
+		}
+
+		if exp != w[0] || act != w[1] {
+			t.Errorf("got (%d, %d) at index %d, want (%d, %d)", exp, act, i, w[0], w[1])
+		}
+
+		if !conf.Experiments[i].Matches(r, w[0]) || !conf.Activities[i].Matches(r, w[0], w[1]) {
+			t.Errorf("references at index %d don't match (%d, %d)", i, w[0], w[1])
+		}
+	}
+
+	if _, err := (ExperimentRef{Name: "lhcb"}).Resolve(r); err == nil {
+		t.Errorf("unknown experiment name resolved")
+	}
+	if _, err := (ExperimentRef{ID: 9}).Resolve(r); err == nil {
+		t.Errorf("unknown experiment ID resolved")
+	}
+	if _, err := (ExperimentRef{Name: "atlas"}).Resolve(nil); err == nil {
+		t.Errorf("experiment name resolved without a registry")
+	}
+	if id, err := (ExperimentRef{ID: 9}).Resolve(nil); err != nil || id != 9 {
+		t.Errorf("got experiment %d (%v) without a registry, want 9", id, err)
+	}
+
+	b, err := yaml.Marshal(conf)
+	if err != nil {
+		t.Fatalf("error marshaling the references: %v", err)
+	}
+	if err := yaml.Unmarshal(b, &conf); err != nil {
+		t.Fatalf("error unmarshaling the marshaled references: %v\n%s", err, b)
+	}
+	if conf.Experiments[1].Name != "cms" || conf.Experiments[2].ID != 3 {
+		t.Errorf("references didn't survive a round trip: %+v", conf.Experiments)
+	}
+
+	for _, raw := range []string{"-1", "4294967296", "\"\"", "[1]"} {
+		if err := yaml.Unmarshal([]byte(raw), &ExperimentRef{}); err == nil {
+			t.Errorf("wrong experiment %s unmarshaled", raw)
+		}
+	}
+}
@@ -23,6 +23,16 @@ type FlowID struct {
 	Info        FlowInfo
 	Application string
 
+	// How the flow was marked. It's only ever populated on END flowIDs
+	// when a backend implementing MarkingReporter is configured.
+	Marking *MarkingStats
+
+	// Whether this is the START of a flow that was already active and is
+	// being replayed after a restart (i.e. from a snapshot or to a backend
+	// restarted on a reload). Backends should re-arm whatever state they
+	// keep for the flow without announcing it again (i.e. by a firefly).
+	Replayed bool
+
 	// Internal communication fields
 	FlowInfoChans map[Flavour]chan *FlowInfo
 }
@@ -92,6 +102,17 @@ func (p Protocol) String() string {
 	return locotorpMap[p]
 }
 
+// Number returns the protocol's number as assigned by IANA (i.e. 6 for TCP),
+// which is what the kernel identifies it with.
+func (p Protocol) Number() uint8 {
+	switch p {
+	case UDP:
+		return unix.IPPROTO_UDP
+	default:
+		return unix.IPPROTO_TCP
+	}
+}
+
 func ParseProtocol(proto string) (Protocol, bool) {
 	p, ok := protocolMap[strings.ToUpper(proto)]
 	return p, ok
@@ -121,6 +142,43 @@ type Backend interface {
 	String() string
 }
 
+// EnrichmentConsumer can be implemented by backends to choose the flavours of
+// enrichment they want to receive through FlowID.FlowInfoChans. Backends not
+// implementing it are subscribed to every available flavour.
+type EnrichmentConsumer interface {
+	Flavours() []Flavour
+}
+
+// FlowTagger can be implemented by backends generating flow tags (i.e. the
+// flow labels used when marking) so that they can be persisted and handed
+// back after a restart. That way flows keep the very same tag throughout.
+type FlowTagger interface {
+	// FlowTag returns the tag currently assigned to a flow, if any.
+	FlowTag(FlowID) (uint32, bool)
+
+	// RestoreFlowTag makes the backend assign the given tag to the flow
+	// on its next START instead of generating a new one.
+	RestoreFlowTag(FlowID, uint32)
+}
+
+// MarkingReporter can be implemented by backends marking packets so that the
+// packets they marked for a flow are reported to every other backend on its
+// END (i.e. on the END firefly) through FlowID.Marking.
+type MarkingReporter interface {
+	// MarkingStats returns the marking stats of a flow, if any.
+	MarkingStats(FlowID) (MarkingStats, bool)
+}
+
+// Validator can be implemented by the configuration of plugins, backends and
+// enrichers to check constraints decoding alone can't catch (i.e. fields that
+// must agree with each other). Validation happens before instances are created
+// as well as on `flowd-go conf validate`, so it must neither touch the kernel
+// (i.e. load eBPF programs) nor the network. Several problems should be
+// reported at once through errors.Join.
+type Validator interface {
+	Validate() error
+}
+
 type Plugin interface {
 	Run(<-chan struct{}, chan<- FlowID)
 	Cleanup() error